@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid"
+)
+
+// signUpForm creates an account with the username and password.
+type signUpForm struct {
+	Username        string `form:"username" json:"username" validate:"required,email,max=255"`
+	Password        string `form:"password" json:"password" validate:"required"`
+	PasswordConfirm string `form:"password_confirm" json:"password_confirm" validate:"required,eqfield=Password"`
+}
+
+// changePasswordForm replaces the password of the signed in user.
+type changePasswordForm struct {
+	CurrentPassword    string `form:"current_password" json:"current_password" validate:"required"`
+	NewPassword        string `form:"new_password" json:"new_password" validate:"required"`
+	NewPasswordConfirm string `form:"new_password_confirm" json:"new_password_confirm" validate:"required,eqfield=NewPassword"`
+}
+
+// deleteAccountForm confirms the deletion of the account with the password.
+type deleteAccountForm struct {
+	Password string `form:"password" json:"password" validate:"required"`
+}
+
+var (
+	errUsernameTaken = &formError{"username", "the username is taken"}
+	errWrongPassword = &formError{"password", "the password is incorrect"}
+	errPasswordLock  = &formError{"password", "too many failed attempts, try again in 15 minutes"}
+)
+
+// formMessage is the message shown on a page for an error of a submitted form,
+// ok is false for an error that is not the user's.
+func formMessage(err error) (message string, ok bool) {
+	var validationErrs validator.ValidationErrors
+	var formErr *formError
+	switch {
+	case errors.As(err, &validationErrs):
+		return fieldErrorMessage(validationErrs[0]), true
+	case errors.As(err, &formErr):
+		return formErr.Message, true
+	}
+	return "", false
+}
+
+// checkPassword verifies the password of the signed in user, the failures count
+// against the sign in limiter like a failed sign in.
+func (s *Server) checkPassword(r *http.Request, user *User, password string) error {
+	ip := clientIP(r)
+	if !s.signInLimiter.lockedUntil(user.Username, ip).IsZero() {
+		return errPasswordLock
+	}
+	if CheckPasswordHash(user.PasswordSalt, password, user.PasswordHash) != nil {
+		s.signInLimiter.failed(user.Username, ip)
+		return errWrongPassword
+	}
+	return nil
+}
+
+// renderSignUp renders the sign up page with the message of a rejected form.
+func (s *Server) renderSignUp(w http.ResponseWriter, r *http.Request, status int, username, formError string) {
+	data := HomePageState{
+		CSRFToken: getCSRFToken(w, r),
+		Username:  username,
+		FormError: formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "sign_up.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// signUpPage shows the form to create an account.
+func (s *Server) signUpPage(w http.ResponseWriter, r *http.Request) {
+	if s.isSignedIn(r) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	s.renderSignUp(w, r, http.StatusOK, "", "")
+}
+
+// signUp creates the account and signs it in on the device.
+func (s *Server) signUp(w http.ResponseWriter, r *http.Request) {
+	var f signUpForm
+	err := s.decodeForm(r, &f)
+	if err == nil {
+		err = checkPasswordPolicy(f.Username, f.Password)
+		if err != nil {
+			err = &formError{"password", err.Error()}
+		}
+	}
+	if err == nil {
+		_, err = s.repository.GetUser(f.Username)
+		switch {
+		case err == nil:
+			err = errUsernameTaken
+		case errors.Is(err, ErrNotFound):
+			err = nil
+		}
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to sign up", err)
+			return
+		}
+		s.renderSignUp(w, r, http.StatusUnprocessableEntity, f.Username, message)
+		return
+	}
+
+	hash, salt, err := newPasswordHash(f.Password)
+	if err != nil {
+		s.internalError(w, "unable to hash password", err)
+		return
+	}
+	userID, _ := uuid.NewV4()
+	if err = s.repository.AddUser(userID, f.Username, hash, salt); err != nil {
+		s.internalError(w, "unable to add user", err)
+		return
+	}
+	user := &User{ID: userID, Username: f.Username, PasswordHash: hash, PasswordSalt: salt}
+	if err = s.startSession(w, r, user); err != nil {
+		s.internalError(w, "creating sign in", err)
+		return
+	}
+	s.logger.Info().Msgf("signed up user %s with id %s", f.Username, userID)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// changePassword replaces the password and signs the user out on the other
+// devices.
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	var f changePasswordForm
+	err = s.decodeForm(r, &f)
+	if err == nil {
+		err = s.checkPassword(r, user, f.CurrentPassword)
+	}
+	if err == nil {
+		if err = checkPasswordPolicy(user.Username, f.NewPassword); err != nil {
+			err = &formError{"new_password", err.Error()}
+		}
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to change password", err)
+			return
+		}
+		s.renderSettings(w, r, http.StatusUnprocessableEntity, message, "")
+		return
+	}
+
+	hash, salt, err := newPasswordHash(f.NewPassword)
+	if err != nil {
+		s.internalError(w, "unable to hash password", err)
+		return
+	}
+	if err = s.repository.UpdatePassword(user.ID, hash, salt); err != nil {
+		s.internalError(w, "unable to update password", err)
+		return
+	}
+	if err = s.repository.DeleteSessions(user.ID); err != nil {
+		s.internalError(w, "unable to delete sessions", err)
+		return
+	}
+	if err = s.startSession(w, r, user); err != nil {
+		s.internalError(w, "creating sign in", err)
+		return
+	}
+	s.logger.Info().Msgf("changed the password of user %s", user.ID)
+	http.Redirect(w, r, "/settings?notice=password-changed", http.StatusFound)
+}
+
+// deleteAccount deletes the user with everything the user created.
+func (s *Server) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	var f deleteAccountForm
+	err = s.decodeForm(r, &f)
+	if err == nil {
+		err = s.checkPassword(r, user, f.Password)
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to delete account", err)
+			return
+		}
+		s.renderSettings(w, r, http.StatusUnprocessableEntity, message, "")
+		return
+	}
+
+	if err = s.repository.DeleteUser(user.ID); err != nil {
+		s.internalError(w, "unable to delete user", err)
+		return
+	}
+	s.logger.Info().Msgf("deleted user %s", user.ID)
+	clearBrowserSession(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"correct horse battery", true},
+		{"short1", false},
+		{strings.Repeat("ab1", 20), false},
+		{"my password is long", false},
+		{"alice-rocks-2024", false},
+		{"aaaaaaaaaaaa", false},
+		{"1111122222", false},
+		{"Tr0ub4dor&3x", true},
+	}
+	for _, tt := range tests {
+		err := checkPasswordPolicy("alice@example.com", tt.password)
+		if (err == nil) != tt.valid {
+			t.Errorf("checkPasswordPolicy(%q) = %v, want valid %t", tt.password, err, tt.valid)
+		}
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/sign-up", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	forms := formsWithAction(parseForms(t, recorder.Body.String()), "/sign-up")
+	ensureInt(t, len(forms), 1)
+	csrfToken := forms[0].Inputs["csrf-token"]
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("username", "new@prototype.proto")
+	form.Set("password", "sunny meadow 42")
+	form.Set("password_confirm", "sunny meadow 4")
+	recorder = serve(t, server, jar, "POST", "/sign-up", form)
+	ensureCode(t, recorder, http.StatusUnprocessableEntity)
+	if !strings.Contains(recorder.Body.String(), "password_confirm does not match") {
+		t.Error("the page does not show the mismatched password")
+	}
+
+	form.Set("password", "password123")
+	form.Set("password_confirm", "password123")
+	recorder = serve(t, server, jar, "POST", "/sign-up", form)
+	ensureCode(t, recorder, http.StatusUnprocessableEntity)
+	if !strings.Contains(recorder.Body.String(), "the password is too common") {
+		t.Error("the page does not show the password policy")
+	}
+
+	form.Set("username", testUsername)
+	form.Set("password", "sunny meadow 42")
+	form.Set("password_confirm", "sunny meadow 42")
+	recorder = serve(t, server, jar, "POST", "/sign-up", form)
+	ensureCode(t, recorder, http.StatusUnprocessableEntity)
+	if !strings.Contains(recorder.Body.String(), "the username is taken") {
+		t.Error("the page does not show the taken username")
+	}
+
+	form.Set("username", "new@prototype.proto")
+	recorder = serve(t, server, jar, "POST", "/sign-up", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+	ensureCode(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusOK)
+	user, err := repository.GetUser("new@prototype.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckPasswordHash(user.PasswordSalt, "sunny meadow 42", user.PasswordHash) != nil {
+		t.Error("the password of the new user does not verify")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	server, _ := newTestServer(t)
+	laptop, csrfToken := signIn(t, server, testPassword)
+	phone, _ := signIn(t, server, testPassword)
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("current_password", "wrong")
+	form.Set("new_password", "sunny meadow 42")
+	form.Set("new_password_confirm", "sunny meadow 42")
+	recorder := serve(t, server, laptop, "POST", "/settings/password", form)
+	ensureCode(t, recorder, http.StatusUnprocessableEntity)
+	if !strings.Contains(recorder.Body.String(), "the password is incorrect") {
+		t.Error("the page does not show the wrong password")
+	}
+
+	form.Set("current_password", testPassword)
+	form.Set("new_password", "short")
+	form.Set("new_password_confirm", "short")
+	ensureCode(t, serve(t, server, laptop, "POST", "/settings/password", form), http.StatusUnprocessableEntity)
+
+	form.Set("new_password", "sunny meadow 42")
+	form.Set("new_password_confirm", "sunny meadow 42")
+	recorder = serve(t, server, laptop, "POST", "/settings/password", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/settings?notice=password-changed")
+	recorder = serve(t, server, laptop, "GET", "/settings?notice=password-changed", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), passwordChangedNotice) {
+		t.Error("the settings page does not confirm the change")
+	}
+	ensureRedirect(t, serve(t, server, phone, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+
+	jar, _ := signIn(t, server, testPassword)
+	ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+	jar, _ = signIn(t, server, "sunny meadow 42")
+	ensureCode(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusOK)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, csrfToken := signIn(t, server, testPassword)
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "Household")
+	form.Set("start_balance", "1000")
+	form.Set("horizon_months", "12")
+	form.Set("currency", "USD")
+	recorder := serve(t, server, jar, "POST", "/planners/create", form)
+	ensureCode(t, recorder, http.StatusFound)
+	plannerURL := recorder.Result().Header.Get("Location")
+
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Salary")
+	form.Set("income_or_expense", "income")
+	form.Set("amount", "100")
+	form.Set("recurrence_start", time.Now().AddDate(0, 0, 1).Format(time.DateOnly))
+	form.Set("recurrence_end", time.Now().AddDate(0, 3, 0).Format(time.DateOnly))
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_interval", "1")
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/add-range-transaction", form), http.StatusFound)
+
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planners, err := repository.ListPlanners(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(planners), 1)
+	txns, err := repository.ListExpandedTransactions(user.ID, planners[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) == 0 {
+		t.Fatal("the range transaction was not expanded")
+	}
+
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("password", "wrong")
+	ensureCode(t, serve(t, server, jar, "POST", "/settings/delete-account", form), http.StatusUnprocessableEntity)
+
+	form.Set("password", testPassword)
+	recorder = serve(t, server, jar, "POST", "/settings/delete-account", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+	ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+
+	if _, err = repository.GetUser(testUsername); err != ErrNotFound {
+		t.Errorf("GetUser after the deletion = %v, want ErrNotFound", err)
+	}
+	for _, table := range []string{"planners", "range_transactions", "expanded_transactions", "sessions"} {
+		if n := countRows(t, repository, table); n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+
+	jar, _ = signIn(t, server, testPassword)
+	ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+}
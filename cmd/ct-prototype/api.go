@@ -0,0 +1,829 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid"
+)
+
+// The JSON API under apiPrefix mirrors the HTML form handlers for scripts and apps.
+// It authenticates with an API token instead of the session cookie and decodes the
+// same forms, so a request is valid exactly when the HTML form would be.
+
+const (
+	apiPrefix = "/api/v1"
+	// maxAPIBodySize caps the size of a request body
+	maxAPIBodySize = 1 << 20
+)
+
+// APIError is the body of every error response of the API.
+type APIError struct {
+	Status  int             `json:"-"`
+	Message string          `json:"error"`
+	Fields  []APIFieldError `json:"fields,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// APIFieldError is a request field that failed the validation. Tag and Param are
+// the failed validator rule, e.g. max and 255.
+type APIFieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "oneof":
+		return fe.Field() + " must be one of " + fe.Param()
+	case "min", "gte":
+		return fe.Field() + " must be at least " + fe.Param()
+	case "max", "lte":
+		return fe.Field() + " must be at most " + fe.Param()
+	case "gt":
+		return fe.Field() + " must be greater than " + fe.Param()
+	case "lt":
+		return fe.Field() + " must be less than " + fe.Param()
+	case "email":
+		return fe.Field() + " must be an email address"
+	case "eqfield":
+		return fe.Field() + " does not match"
+	}
+	return fmt.Sprintf("%s failed the %s validation", fe.Field(), fe.Tag())
+}
+
+// apiError converts an error of a handler to the response sent for it.
+func apiError(err error) *APIError {
+	var apiErr *APIError
+	var validationErrs validator.ValidationErrors
+	var formErr *formError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &validationErrs):
+		e := &APIError{Status: http.StatusUnprocessableEntity, Message: "validation failed"}
+		for _, fe := range validationErrs {
+			e.Fields = append(e.Fields, APIFieldError{
+				Field:   fe.Field(),
+				Tag:     fe.Tag(),
+				Param:   fe.Param(),
+				Message: fieldErrorMessage(fe),
+			})
+		}
+		return e
+	case errors.As(err, &formErr):
+		return &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  []APIFieldError{{Field: formErr.Field, Message: formErr.Message}},
+		}
+	case errors.Is(err, ErrNotFound):
+		return &APIError{Status: http.StatusNotFound, Message: "not found"}
+	case errors.Is(err, ErrForbidden):
+		return &APIError{Status: http.StatusForbidden, Message: "not allowed for your role on the planner"}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Message: "internal error"}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON decodes the request body into the form v and validates it like the
+// HTML form.
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxAPIBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &APIError{Status: http.StatusBadRequest, Message: "invalid JSON body: " + err.Error()}
+	}
+	if err := newValidator().Struct(v); err != nil {
+		return err
+	}
+	if f, ok := v.(interface{ check() error }); ok {
+		return f.check()
+	}
+	return nil
+}
+
+// apiUser returns the user of the bearer token of the request.
+func (s *Server) apiUser(r *http.Request) (*User, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, &APIError{Status: http.StatusUnauthorized, Message: "missing bearer token"}
+	}
+	user, err := s.repository.GetAPITokenUser(hashToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return nil, &APIError{Status: http.StatusUnauthorized, Message: "invalid bearer token"}
+	}
+	return user, err
+}
+
+// apiHandler returns the result of a request or an error.
+type apiHandler func(r *http.Request, user *User) (any, error)
+
+// apiPlannerHandler handles a request for the planner in the path.
+type apiPlannerHandler func(r *http.Request, user *User, planner *Planner) (any, error)
+
+// withPlanner looks up the planner with the ID in the path for h.
+func (s *Server) withPlanner(h apiPlannerHandler) apiHandler {
+	return func(r *http.Request, user *User) (any, error) {
+		plannerID, err := uuid.FromString(r.PathValue("id"))
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		planner, err := s.repository.GetPlanner(user.ID, plannerID)
+		if err != nil {
+			return nil, err
+		}
+		return h(r, user, planner)
+	}
+}
+
+// serveAPI authenticates the request and writes the result of the route as JSON.
+func (s *Server) serveAPI(route apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.apiUser(r)
+		var result any
+		if err == nil {
+			result, err = route.handle(r, user)
+		}
+		if err != nil {
+			e := apiError(err)
+			if e.Status == http.StatusInternalServerError {
+				s.logger.Err(err).Msgf("api request %s %s failed", r.Method, r.URL.Path)
+			}
+			if e.Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			err = writeJSON(w, e.Status, e)
+		} else if route.status == http.StatusNoContent {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			err = writeJSON(w, route.status, result)
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Msg("unable to write the api response")
+		}
+	}
+}
+
+// apiRoute is an endpoint of the API. The routes are served and documented in
+// the OpenAPI document from the same table.
+type apiRoute struct {
+	method  string
+	path    string
+	summary string
+	// request and response are values of the body types, nil without a body
+	request  any
+	response any
+	status   int
+	query    []apiParameter
+	handle   apiHandler
+}
+
+// apiParameter is a query parameter of a route.
+type apiParameter struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+func (s *Server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			method: "GET", path: "/planners", summary: "List the planners",
+			response: []APIPlanner{}, status: http.StatusOK, handle: s.apiListPlanners,
+		},
+		{
+			method: "POST", path: "/planners", summary: "Create a planner",
+			request: plannerForm{}, response: APIPlanner{}, status: http.StatusCreated, handle: s.apiCreatePlanner,
+		},
+		{
+			method: "GET", path: "/planners/{id}", summary: "Get a planner",
+			response: APIPlanner{}, status: http.StatusOK, handle: s.withPlanner(s.apiGetPlanner),
+		},
+		{
+			method: "PATCH", path: "/planners/{id}", summary: "Rename a planner",
+			request: plannerNameForm{}, response: APIPlanner{}, status: http.StatusOK, handle: s.withPlanner(s.apiRenamePlanner),
+		},
+		{
+			method: "POST", path: "/planners/{id}/duplicate", summary: "Copy a planner with its transactions",
+			request: duplicatePlannerForm{}, response: APIPlanner{}, status: http.StatusCreated, handle: s.withPlanner(s.apiDuplicatePlanner),
+		},
+		{
+			method: "DELETE", path: "/planners/{id}", summary: "Delete a planner with its transactions",
+			status: http.StatusNoContent, handle: s.withPlanner(s.apiDeletePlanner),
+		},
+		{
+			method: "GET", path: "/planners/{id}/range-transactions", summary: "List the range transactions",
+			response: []APIRangeTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiListRangeTransactions),
+		},
+		{
+			method: "POST", path: "/planners/{id}/range-transactions", summary: "Add a range transaction",
+			request: rangeTransactionForm{}, response: APIRangeTransaction{}, status: http.StatusCreated, handle: s.withPlanner(s.apiAddRangeTransaction),
+		},
+		{
+			method: "GET", path: "/planners/{id}/range-transactions/{txID}", summary: "Get a range transaction",
+			response: APIRangeTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiGetRangeTransaction),
+		},
+		{
+			method: "PUT", path: "/planners/{id}/range-transactions/{txID}", summary: "Update a range transaction and its occurrences",
+			request: rangeTransactionForm{}, response: APIRangeTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiUpdateRangeTransaction),
+		},
+		{
+			method: "DELETE", path: "/planners/{id}/range-transactions/{txID}", summary: "Delete a range transaction and its occurrences",
+			status: http.StatusNoContent, handle: s.withPlanner(s.apiDeleteRangeTransaction),
+		},
+		{
+			method: "GET", path: "/planners/{id}/one-time-transactions", summary: "List the one-time transactions",
+			response: []APIExpandedTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiListOneTimeTransactions),
+		},
+		{
+			method: "POST", path: "/planners/{id}/one-time-transactions", summary: "Add a one-time transaction",
+			request: oneTimeTransactionForm{}, response: APIExpandedTransaction{}, status: http.StatusCreated, handle: s.withPlanner(s.apiAddOneTimeTransaction),
+		},
+		{
+			method: "GET", path: "/planners/{id}/one-time-transactions/{txID}", summary: "Get a one-time transaction or an occurrence of a range transaction",
+			response: APIExpandedTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiGetOneTimeTransaction),
+		},
+		{
+			method: "PUT", path: "/planners/{id}/one-time-transactions/{txID}", summary: "Update a one-time transaction or override an occurrence",
+			request: oneTimeTransactionForm{}, response: APIExpandedTransaction{}, status: http.StatusOK, handle: s.withPlanner(s.apiUpdateOneTimeTransaction),
+		},
+		{
+			method: "DELETE", path: "/planners/{id}/one-time-transactions/{txID}", summary: "Delete a one-time transaction or an occurrence",
+			status: http.StatusNoContent, handle: s.withPlanner(s.apiDeleteOneTimeTransaction),
+		},
+		{
+			method: "GET", path: "/planners/{id}/series", summary: "Get the transactions with the balance after each of them",
+			response: APISeries{}, status: http.StatusOK, handle: s.withPlanner(s.apiSeries),
+		},
+		{
+			method: "GET", path: "/planners/{id}/insights", summary: "Get the risks and opportunities found in the cash flow",
+			response: []APIInsight{}, status: http.StatusOK, handle: s.withPlanner(s.apiInsights),
+		},
+		{
+			method: "GET", path: "/planners/{id}/backup", summary: "Export a planner with its accounts and transactions",
+			response: PlannerBackup{}, status: http.StatusOK, handle: s.withPlanner(s.apiPlannerBackup),
+		},
+		{
+			method: "POST", path: "/planners/restore", summary: "Add a planner from a backup",
+			request: PlannerBackup{}, response: APIPlanner{}, status: http.StatusCreated, handle: s.apiRestorePlanner,
+		},
+		{
+			method: "GET", path: "/planners/{id}/simulation", summary: "Simulate the balance with the uncertainty of the transactions",
+			response: SimulationResult{}, status: http.StatusOK, handle: s.withPlanner(s.apiSimulation),
+			query: []apiParameter{
+				{Name: "trials", Type: "integer", Description: fmt.Sprintf("number of trials, %d by default and at most %d", defaultSimulationTrials, maxSimulationTrials)},
+				{Name: "seed", Type: "integer", Description: "seed of the random numbers to repeat a run"},
+			},
+		},
+	}
+}
+
+func (s *Server) addAPIRoutes() {
+	for _, route := range s.apiRoutes() {
+		s.mux.HandleFunc(route.method+" "+apiPrefix+route.path, s.serveAPI(route))
+	}
+	s.mux.HandleFunc("GET "+apiPrefix+"/openapi.json", s.openAPI)
+}
+
+//
+// Planners
+//
+
+// APIPlanner is a planner in the API.
+type APIPlanner struct {
+	ID            uuid.UUID `json:"id"`
+	Name          string    `json:"name"`
+	StartBalance  float64   `json:"start_balance"`
+	HorizonMonths int       `json:"horizon_months"`
+	Currency      string    `json:"currency"`
+	// Role is the role of the token's user on the planner
+	Role string `json:"role"`
+	// End is the last day covered by the planner from today
+	End       Date      `json:"end"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAPIPlanner(p *Planner) APIPlanner {
+	return APIPlanner{
+		ID:            p.ID,
+		Name:          p.Name,
+		StartBalance:  p.StartBalance,
+		HorizonMonths: p.HorizonMonths,
+		Currency:      p.Currency,
+		Role:          p.Role,
+		End:           Date{truncateDay(p.End(time.Now()))},
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+}
+
+type duplicatePlannerForm struct {
+	// Name is the name of the copy, the name of the planner with " (copy)" by default
+	Name string `json:"name" validate:"max=255"`
+}
+
+func (s *Server) apiListPlanners(r *http.Request, user *User) (any, error) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]APIPlanner, 0, len(planners))
+	for i := range planners {
+		result = append(result, newAPIPlanner(&planners[i]))
+	}
+	return result, nil
+}
+
+func (s *Server) apiCreatePlanner(r *http.Request, user *User) (any, error) {
+	var form plannerForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	planner := form.planner(user.ID)
+	if err := s.repository.AddPlanner(planner); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("added planner with id %s", planner.ID)
+	return s.apiGetPlanner(r, user, planner)
+}
+
+func (s *Server) apiGetPlanner(r *http.Request, user *User, planner *Planner) (any, error) {
+	planner, err := s.repository.GetPlanner(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIPlanner(planner), nil
+}
+
+func (s *Server) apiRenamePlanner(r *http.Request, user *User, planner *Planner) (any, error) {
+	var form plannerNameForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if err := s.repository.RenamePlanner(user.ID, planner.ID, form.Name); err != nil {
+		return nil, err
+	}
+	return s.apiGetPlanner(r, user, planner)
+}
+
+func (s *Server) apiDuplicatePlanner(r *http.Request, user *User, planner *Planner) (any, error) {
+	var form duplicatePlannerForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if form.Name == "" {
+		form.Name = planner.Name + " (copy)"
+	}
+	newUUID, _ := uuid.NewV4()
+	if err := s.repository.DuplicatePlanner(user.ID, planner.ID, newUUID, form.Name); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("duplicated planner %s to %s", planner.ID, newUUID)
+	return s.apiGetPlanner(r, user, &Planner{ID: newUUID})
+}
+
+func (s *Server) apiDeletePlanner(r *http.Request, user *User, planner *Planner) (any, error) {
+	if err := s.repository.DeletePlanner(user.ID, planner.ID); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("deleted planner with id %s", planner.ID)
+	return nil, nil
+}
+
+//
+// Range transactions
+//
+
+// APIRangeTransaction is a range transaction in the API with the fields of its form.
+type APIRangeTransaction struct {
+	ID uuid.UUID `json:"id"`
+	rangeTransactionForm
+	Source    string    `json:"source"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newAPIRangeTransaction(rtx *RangeTransaction) APIRangeTransaction {
+	return APIRangeTransaction{
+		ID: rtx.ID,
+		rangeTransactionForm: rangeTransactionForm{
+			Title:                     rtx.Title,
+			IncomeOrExpense:           rtx.IncomeOrExpense,
+			Category:                  rtx.Category,
+			Notes:                     rtx.Notes,
+			Amount:                    rtx.Amount,
+			RecurrenceEveryDays:       rtx.RecurrenceEveryDays,
+			RecurrenceStart:           Date{rtx.RecurrenceStart},
+			RecurrenceEnd:             Date{rtx.RecurrenceEnd},
+			RecurrenceFreq:            rtx.Recurrence.Freq,
+			RecurrenceInterval:        rtx.Recurrence.Interval,
+			RecurrenceByWeekday:       rtx.Recurrence.ByWeekday,
+			RecurrenceByMonthDay:      rtx.Recurrence.ByMonthDay,
+			RecurrenceLastBusinessDay: rtx.Recurrence.LastBusinessDay,
+			RecurrenceExceptionDates:  rtx.Recurrence.ExceptionDates,
+			GrowthAnnualPercent:       rtx.Growth.AnnualPercent,
+			GrowthMode:                rtx.Growth.Mode,
+			accountsForm:              accountsForm{rtx.AccountID, rtx.ToAccountID},
+			uncertaintyForm:           newUncertaintyForm(rtx.Uncertainty),
+		},
+		Source:    rtx.Source,
+		CreatedAt: rtx.CreatedAt,
+		UpdatedAt: rtx.UpdatedAt,
+	}
+}
+
+func newUncertaintyForm(u Uncertainty) uncertaintyForm {
+	return uncertaintyForm{
+		AmountStdDevPercent: u.AmountStdDevPercent,
+		SkipPercent:         u.SkipPercent,
+		DateJitterDays:      u.DateJitterDays,
+	}
+}
+
+// getRangeTransaction returns the range transaction with the txID in the path.
+func (s *Server) getRangeTransaction(r *http.Request, user *User, planner *Planner) (*RangeTransaction, error) {
+	id, err := uuid.FromString(r.PathValue("txID"))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return s.repository.GetRangeTransaction(user.ID, planner.ID, id)
+}
+
+func (s *Server) apiListRangeTransactions(r *http.Request, user *User, planner *Planner) (any, error) {
+	txns, err := s.repository.ListRangeTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIRangeTransactions(txns), nil
+}
+
+func newAPIRangeTransactions(txns []RangeTransaction) []APIRangeTransaction {
+	result := make([]APIRangeTransaction, 0, len(txns))
+	for i := range txns {
+		result = append(result, newAPIRangeTransaction(&txns[i]))
+	}
+	return result
+}
+
+func (s *Server) apiAddRangeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	var form rangeTransactionForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm); err != nil {
+		return nil, err
+	}
+	transaction := form.rangeTransaction()
+	transaction.ID, _ = uuid.NewV4()
+	transaction.PlannerID = planner.ID
+	transaction.UserID = user.ID
+	transaction.Source = "planner"
+	if err := s.repository.AddRangeTransaction(transaction); err != nil {
+		return nil, err
+	}
+	return s.apiGetRangeTransaction(withPathValue(r, "txID", transaction.ID.String()), user, planner)
+}
+
+func (s *Server) apiGetRangeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	rtx, err := s.getRangeTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIRangeTransaction(rtx), nil
+}
+
+func (s *Server) apiUpdateRangeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	rtx, err := s.getRangeTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	form := rangeTransactionForm{storedStart: rtx.RecurrenceStart}
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm); err != nil {
+		return nil, err
+	}
+	transaction := form.rangeTransaction()
+	transaction.PlannerID = planner.ID
+	transaction.UserID = user.ID
+	if err = s.repository.UpdateRangeTransaction(rtx.ID, transaction); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("updated range transaction with id %s", rtx.ID)
+	return s.apiGetRangeTransaction(r, user, planner)
+}
+
+func (s *Server) apiDeleteRangeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	rtx, err := s.getRangeTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repository.DeleteRangeTransaction(user.ID, planner.ID, rtx.ID); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("deleted range transaction with id %s", rtx.ID)
+	return nil, nil
+}
+
+//
+// One-time transactions
+//
+
+// APIExpandedTransaction is a one-time transaction or an occurrence of a range
+// transaction in the API with the fields of its form.
+type APIExpandedTransaction struct {
+	ID uuid.UUID `json:"id"`
+	// RangeTransactionID is set for an occurrence of a range transaction
+	RangeTransactionID *uuid.UUID `json:"range_transaction_id,omitempty"`
+	oneTimeTransactionForm
+	OccurrenceDate Date      `json:"occurrence_date"`
+	IsOverride     bool      `json:"is_override"`
+	Source         string    `json:"source"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func newAPIExpandedTransaction(etx *ExpandedTransaction) APIExpandedTransaction {
+	result := APIExpandedTransaction{
+		ID: etx.ID,
+		oneTimeTransactionForm: oneTimeTransactionForm{
+			Title:           etx.Title,
+			IncomeOrExpense: etx.IncomeOrExpense,
+			Category:        etx.Category,
+			Amount:          etx.Amount,
+			TransactionDate: Date{etx.TransactionDate},
+			accountsForm:    accountsForm{etx.AccountID, etx.ToAccountID},
+			uncertaintyForm: newUncertaintyForm(etx.Uncertainty),
+		},
+		OccurrenceDate: Date{etx.OccurrenceDate},
+		IsOverride:     etx.IsOverride,
+		Source:         etx.Source,
+		CreatedAt:      etx.CreatedAt,
+		UpdatedAt:      etx.UpdatedAt,
+	}
+	if etx.IsOccurrence() {
+		result.RangeTransactionID = &etx.RangeTransactionID
+	}
+	return result
+}
+
+// getExpandedTransaction returns the expanded transaction with the txID in the path.
+func (s *Server) getExpandedTransaction(r *http.Request, user *User, planner *Planner) (*ExpandedTransaction, error) {
+	id, err := uuid.FromString(r.PathValue("txID"))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return s.repository.GetExpandedTransaction(user.ID, planner.ID, id)
+}
+
+func (s *Server) apiListOneTimeTransactions(r *http.Request, user *User, planner *Planner) (any, error) {
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := []APIExpandedTransaction{}
+	for i := range txns {
+		if !txns[i].IsOccurrence() {
+			result = append(result, newAPIExpandedTransaction(&txns[i]))
+		}
+	}
+	return result, nil
+}
+
+func (s *Server) apiAddOneTimeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	var form oneTimeTransactionForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm); err != nil {
+		return nil, err
+	}
+	transaction := form.expandedTransaction()
+	transaction.ID, _ = uuid.NewV4()
+	transaction.UserID = user.ID
+	transaction.PlannerID = planner.ID
+	if err := s.repository.AddExpandedTransaction(transaction); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("added one-time transaction with id %s", transaction.ID)
+	return s.apiGetOneTimeTransaction(withPathValue(r, "txID", transaction.ID.String()), user, planner)
+}
+
+func (s *Server) apiGetOneTimeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	etx, err := s.getExpandedTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIExpandedTransaction(etx), nil
+}
+
+func (s *Server) apiUpdateOneTimeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	etx, err := s.getExpandedTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	var form oneTimeTransactionForm
+	if err := decodeJSON(r, &form); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm); err != nil {
+		return nil, err
+	}
+	transaction := form.expandedTransaction()
+	transaction.UserID = user.ID
+	transaction.PlannerID = planner.ID
+	if err = s.repository.UpdateExpandedTransaction(etx.ID, transaction); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("updated one-time transaction with id %s", etx.ID)
+	return s.apiGetOneTimeTransaction(r, user, planner)
+}
+
+func (s *Server) apiDeleteOneTimeTransaction(r *http.Request, user *User, planner *Planner) (any, error) {
+	etx, err := s.getExpandedTransaction(r, user, planner)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repository.DeleteExpandedTransaction(user.ID, planner.ID, etx.ID); err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("deleted one-time transaction with id %s", etx.ID)
+	return nil, nil
+}
+
+// withPathValue returns the request with the path value set, so a handler can
+// respond with the record it created.
+func withPathValue(r *http.Request, name, value string) *http.Request {
+	r = r.Clone(r.Context())
+	r.SetPathValue(name, value)
+	return r
+}
+
+//
+// Computed series
+//
+
+// APISeries is the cash flow of a planner until its end.
+type APISeries struct {
+	// StartBalance is the total of the opening balances of the accounts
+	StartBalance float64 `json:"start_balance"`
+	End          Date    `json:"end"`
+	// Accounts are in the order of the balances of the points, the main account
+	// with the nil ID first
+	Accounts []APISeriesAccount `json:"accounts"`
+	Points   []APISeriesPoint   `json:"points"`
+}
+
+// APISeriesAccount is an account of the series.
+type APISeriesAccount struct {
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Kind           string    `json:"kind"`
+	OpeningBalance float64   `json:"opening_balance"`
+}
+
+// APISeriesPoint is a transaction of the cash flow with the balance after it.
+type APISeriesPoint struct {
+	Date                  Date       `json:"date"`
+	ExpandedTransactionID uuid.UUID  `json:"expanded_transaction_id"`
+	RangeTransactionID    *uuid.UUID `json:"range_transaction_id,omitempty"`
+	Title                 string     `json:"title"`
+	IncomeOrExpense       string     `json:"income_or_expense"`
+	AccountID             uuid.UUID  `json:"account_id"`
+	ToAccountID           uuid.UUID  `json:"to_account_id"`
+	Category              string     `json:"category"`
+	Amount                float64    `json:"amount"`
+	NetCash               float64    `json:"net_cash"`
+	// Balances are the balances of the accounts after the transaction
+	Balances []float64 `json:"balances"`
+}
+
+func (s *Server) apiSeries(r *http.Request, user *User, planner *Planner) (any, error) {
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newAPISeries(planner, accounts, txns, time.Now()), nil
+}
+
+// newAPISeries returns the cash flow of the planner from now to its end.
+func newAPISeries(planner *Planner, accounts []Account, txns []ExpandedTransaction, now time.Time) APISeries {
+	end := planner.End(now)
+	series := APISeries{
+		StartBalance: openingBalance(planner, accounts),
+		End:          Date{truncateDay(end)},
+		Points:       []APISeriesPoint{},
+	}
+	for _, account := range plannerAccounts(planner, accounts) {
+		series.Accounts = append(series.Accounts, APISeriesAccount{
+			ID:             account.ID,
+			Name:           account.Name,
+			Kind:           account.Kind,
+			OpeningBalance: account.OpeningBalance,
+		})
+	}
+	for _, stx := range cashFlow(planner, accounts, txns, now, end) {
+		point := APISeriesPoint{
+			Date:                  Date{stx.TransactionDate},
+			ExpandedTransactionID: stx.ExpandedTransactionID,
+			Title:                 stx.Title,
+			IncomeOrExpense:       stx.IncomeOrExpense,
+			AccountID:             stx.AccountID,
+			ToAccountID:           stx.ToAccountID,
+			Category:              stx.Category,
+			Amount:                stx.Amount,
+			NetCash:               stx.NetCash,
+			Balances:              stx.Balances,
+		}
+		if stx.RangeTransactionID != uuid.Nil {
+			point.RangeTransactionID = &stx.RangeTransactionID
+		}
+		series.Points = append(series.Points, point)
+	}
+	return series
+}
+
+func (s *Server) apiInsights(r *http.Request, user *User, planner *Planner) (any, error) {
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	segTxns := cashFlow(planner, accounts, txns, now, planner.End(now))
+	return newAPIInsights(ComputeInsights(segTxns, defaultInsightOptions)), nil
+}
+
+func (s *Server) apiPlannerBackup(r *http.Request, user *User, planner *Planner) (any, error) {
+	rangeTxns, err := s.repository.ListRangeTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newPlannerBackup(planner, accounts, rangeTxns, txns, time.Now()), nil
+}
+
+// apiRestorePlanner reads the backup itself, a backup can be larger than the
+// other request bodies.
+func (s *Server) apiRestorePlanner(r *http.Request, user *User) (any, error) {
+	backup, err := decodeBackup(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	planner, err := s.restoreBackup(user, backup)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIPlanner(planner), nil
+}
+
+func (s *Server) apiSimulation(r *http.Request, user *User, planner *Planner) (any, error) {
+	trials, seed, err := simulationParams(r.URL.Query())
+	if err != nil {
+		return nil, &APIError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return nil, err
+	}
+	return simulatePlanner(planner, accounts, txns, time.Now(), trials, seed), nil
+}
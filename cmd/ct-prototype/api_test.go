@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// createAPIToken signs in and creates an API token on the settings page.
+func createAPIToken(t *testing.T, server *Server) string {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, _ := signInWithPlanner(t, server, jar)
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "script")
+	recorder := serve(t, server, jar, "POST", "/settings/api-tokens", form)
+	ensureCode(t, recorder, http.StatusOK)
+	match := regexp.MustCompile(`<code id="new-api-token">(ctp_[0-9a-f]+)</code>`).FindStringSubmatch(recorder.Body.String())
+	if match == nil {
+		t.Fatalf("the new token is not shown:\n%s", recorder.Body.String())
+	}
+	return match[1]
+}
+
+// serveAPI records a request to the API with the token and decodes the JSON
+// response into result.
+func serveAPI(t *testing.T, server *Server, token, method, path string, body any, result any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(method, "http://localhost"+apiPrefix+path, reader)
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, r)
+	if result != nil && recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatalf("decoding %s: %v", recorder.Body.String(), err)
+		}
+	}
+	return recorder
+}
+
+func ensureFieldErrors(t *testing.T, got APIError, fields ...string) {
+	t.Helper()
+	var names []string
+	for _, f := range got.Fields {
+		if f.Message == "" {
+			t.Errorf("field %s has no message", f.Field)
+		}
+		names = append(names, f.Field)
+	}
+	slices.Sort(names)
+	slices.Sort(fields)
+	if !slices.Equal(names, fields) {
+		t.Errorf("got field errors %v, want %v", names, fields)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	server, repository := newTestServer(t)
+	token := createAPIToken(t, server)
+
+	// The API needs a valid token
+	{
+		var e APIError
+		recorder := serveAPI(t, server, "", "GET", "/planners", nil, &e)
+		ensureCode(t, recorder, http.StatusUnauthorized)
+		ensureString(t, recorder.Result().Header.Get("WWW-Authenticate"), "Bearer")
+		ensureString(t, e.Message, "missing bearer token")
+
+		recorder = serveAPI(t, server, token+"0", "GET", "/planners", nil, &e)
+		ensureCode(t, recorder, http.StatusUnauthorized)
+	}
+
+	var planner APIPlanner
+	{
+		recorder := serveAPI(t, server, token, "POST", "/planners",
+			map[string]any{"name": "Scripted", "currency": "USD", "start_balance": 1000}, &planner)
+		ensureCode(t, recorder, http.StatusCreated)
+		ensureString(t, planner.Name, "Scripted")
+		ensureInt(t, planner.HorizonMonths, defaultPlannerHorizonMonths)
+
+		var e APIError
+		recorder = serveAPI(t, server, token, "POST", "/planners", map[string]any{"currency": "dollars"}, &e)
+		ensureCode(t, recorder, http.StatusUnprocessableEntity)
+		ensureFieldErrors(t, e, "name", "currency")
+
+		var planners []APIPlanner
+		recorder = serveAPI(t, server, token, "GET", "/planners", nil, &planners)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureInt(t, len(planners), 2)
+	}
+	plannerPath := "/planners/" + planner.ID.String()
+
+	// Invalid transactions return the failed fields
+	start := time.Now().AddDate(0, 0, 1)
+	groceries := map[string]any{
+		"title":             "Groceries",
+		"income_or_expense": "expense",
+		"amount":            300,
+		"recurrence_freq":   "weekly",
+		"recurrence_start":  start.Format(time.DateOnly),
+		"recurrence_end":    start.AddDate(0, 0, 20).Format(time.DateOnly),
+	}
+	{
+		var e APIError
+		recorder := serveAPI(t, server, token, "POST", plannerPath+"/range-transactions",
+			map[string]any{"recurrence_freq": "hourly"}, &e)
+		ensureCode(t, recorder, http.StatusUnprocessableEntity)
+		ensureFieldErrors(t, e, "title", "income_or_expense", "amount", "recurrence_freq")
+		for _, f := range e.Fields {
+			if f.Field == "recurrence_freq" && (f.Tag != "oneof" || f.Param != "daily weekly monthly yearly") {
+				t.Errorf("unexpected field error %+v", f)
+			}
+		}
+
+		past := map[string]any{}
+		for k, v := range groceries {
+			past[k] = v
+		}
+		past["recurrence_start"] = "2020-01-01"
+		recorder = serveAPI(t, server, token, "POST", plannerPath+"/range-transactions", past, &e)
+		ensureCode(t, recorder, http.StatusUnprocessableEntity)
+		ensureFieldErrors(t, e, "recurrence_start")
+
+		recorder = serveAPI(t, server, token, "POST", plannerPath+"/range-transactions", `{"title": "x", "colour": "red"}`, &e)
+		ensureCode(t, recorder, http.StatusBadRequest)
+	}
+
+	// Add a weekly expense and a one-time income like the HTML forms
+	var rangeTx APIRangeTransaction
+	var bonus APIExpandedTransaction
+	{
+		recorder := serveAPI(t, server, token, "POST", plannerPath+"/range-transactions", groceries, &rangeTx)
+		ensureCode(t, recorder, http.StatusCreated)
+		ensureString(t, rangeTx.Source, "planner")
+		ensureString(t, rangeTx.RecurrenceStart.Format(time.DateOnly), start.Format(time.DateOnly))
+
+		recorder = serveAPI(t, server, token, "POST", plannerPath+"/one-time-transactions", map[string]any{
+			"title":                    "Bonus",
+			"income_or_expense":        "income",
+			"amount":                   400,
+			"transaction_date":         start.AddDate(0, 0, 10).Format(time.DateOnly),
+			"uncertainty_skip_percent": 20,
+		}, &bonus)
+		ensureCode(t, recorder, http.StatusCreated)
+		if bonus.RangeTransactionID != nil || bonus.SkipPercent != 20 {
+			t.Errorf("unexpected one-time transaction %+v", bonus)
+		}
+
+		recorder = serveAPI(t, server, token, "PUT", plannerPath+"/one-time-transactions/"+bonus.ID.String(), map[string]any{
+			"title":             "Bonus",
+			"income_or_expense": "income",
+			"amount":            500,
+			"transaction_date":  start.AddDate(0, 0, 10).Format(time.DateOnly),
+		}, &bonus)
+		ensureCode(t, recorder, http.StatusOK)
+		if bonus.Amount != 500 || bonus.SkipPercent != 0 {
+			t.Errorf("unexpected updated transaction %+v", bonus)
+		}
+
+		var oneTime []APIExpandedTransaction
+		recorder = serveAPI(t, server, token, "GET", plannerPath+"/one-time-transactions", nil, &oneTime)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureInt(t, len(oneTime), 1)
+	}
+
+	// The series has the balance after each transaction
+	{
+		var series APISeries
+		recorder := serveAPI(t, server, token, "GET", plannerPath+"/series", nil, &series)
+		ensureCode(t, recorder, http.StatusOK)
+		var netCash []float64
+		for _, p := range series.Points {
+			netCash = append(netCash, p.NetCash)
+		}
+		if !slices.Equal(netCash, []float64{700, 400, 900, 600}) {
+			t.Fatalf("got net cash %v", netCash)
+		}
+		occurrence := series.Points[0]
+		if occurrence.RangeTransactionID == nil || *occurrence.RangeTransactionID != rangeTx.ID {
+			t.Errorf("the first point is not an occurrence of %s: %+v", rangeTx.ID, occurrence)
+		}
+
+		var etx APIExpandedTransaction
+		recorder = serveAPI(t, server, token, "GET", plannerPath+"/one-time-transactions/"+occurrence.ExpandedTransactionID.String(), nil, &etx)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureString(t, etx.Title, "Groceries")
+
+		var result SimulationResult
+		recorder = serveAPI(t, server, token, "GET", plannerPath+"/simulation?trials=50", nil, &result)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureInt(t, result.Trials, 50)
+	}
+
+	// Deleting the range transaction removes its occurrences
+	{
+		recorder := serveAPI(t, server, token, "DELETE", plannerPath+"/range-transactions/"+rangeTx.ID.String(), nil, nil)
+		ensureCode(t, recorder, http.StatusNoContent)
+
+		recorder = serveAPI(t, server, token, "GET", plannerPath+"/range-transactions/"+rangeTx.ID.String(), nil, nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+
+		var series APISeries
+		serveAPI(t, server, token, "GET", plannerPath+"/series", nil, &series)
+		ensureInt(t, len(series.Points), 1)
+	}
+
+	// The planners of another user are not found
+	{
+		otherID, _ := uuid.NewV4()
+		if err := repository.AddUser(otherID, "other@prototype.proto", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		otherToken, hash := newAPIToken()
+		tokenID, _ := uuid.NewV4()
+		if err := repository.AddAPIToken(&APIToken{ID: tokenID, UserID: otherID, Name: "other", TokenHash: hash}); err != nil {
+			t.Fatal(err)
+		}
+		recorder := serveAPI(t, server, otherToken, "GET", plannerPath, nil, nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+		recorder = serveAPI(t, server, otherToken, "DELETE", plannerPath+"/one-time-transactions/"+bonus.ID.String(), nil, nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+	}
+
+	// Rename, duplicate and delete the planner
+	{
+		var renamed APIPlanner
+		recorder := serveAPI(t, server, token, "PATCH", plannerPath, map[string]any{"name": "Renamed"}, &renamed)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureString(t, renamed.Name, "Renamed")
+
+		var copied APIPlanner
+		recorder = serveAPI(t, server, token, "POST", plannerPath+"/duplicate", map[string]any{}, &copied)
+		ensureCode(t, recorder, http.StatusCreated)
+		ensureString(t, copied.Name, "Renamed (copy)")
+
+		recorder = serveAPI(t, server, token, "DELETE", plannerPath, nil, nil)
+		ensureCode(t, recorder, http.StatusNoContent)
+		recorder = serveAPI(t, server, token, "GET", plannerPath, nil, nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+	}
+
+	// A revoked token is rejected
+	{
+		user, err := repository.GetUser(testUsername)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err := repository.ListAPITokens(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureInt(t, len(tokens), 1)
+		if tokens[0].LastUsedAt.IsZero() {
+			t.Error("the token was used but has no last use")
+		}
+		if err = repository.DeleteAPIToken(user.ID, tokens[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		recorder := serveAPI(t, server, token, "GET", "/planners", nil, nil)
+		ensureCode(t, recorder, http.StatusUnauthorized)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server, _ := newTestServer(t)
+	var doc struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                  `json:"required"`
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	recorder := serveAPI(t, server, "", "GET", "/openapi.json", nil, &doc)
+	ensureCode(t, recorder, http.StatusOK)
+
+	for _, route := range server.apiRoutes() {
+		if doc.Paths[apiPrefix+route.path][strings.ToLower(route.method)] == nil {
+			t.Errorf("%s %s is not documented", route.method, route.path)
+		}
+	}
+
+	form := doc.Components.Schemas["RangeTransactionForm"]
+	for _, name := range []string{"title", "income_or_expense", "amount"} {
+		if !slices.Contains(form.Required, name) {
+			t.Errorf("%s is not required in %v", name, form.Required)
+		}
+	}
+	if freq := form.Properties["recurrence_freq"]; len(freq["enum"].([]any)) != 4 {
+		t.Errorf("unexpected recurrence_freq schema %v", freq)
+	}
+	if start := form.Properties["recurrence_start"]; start["format"] != "date" {
+		t.Errorf("unexpected recurrence_start schema %v", start)
+	}
+	if skip := form.Properties["uncertainty_skip_percent"]; skip["maximum"] != 100.0 {
+		t.Errorf("the embedded uncertainty fields are missing: %v", skip)
+	}
+	if _, ok := doc.Components.Schemas["APIRangeTransaction"].Properties["recurrence_every"]; !ok {
+		t.Error("the response does not have the fields of the form")
+	}
+}
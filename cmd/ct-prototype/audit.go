@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Actions of the audit entries.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditImport = "import"
+	AuditUndo   = "undo"
+)
+
+// Entities of the audit entries.
+const (
+	EntityPlanner             = "planner"
+	EntityAccount             = "account"
+	EntityRangeTransaction    = "range_transaction"
+	EntityExpandedTransaction = "expanded_transaction"
+)
+
+// AuditState is the rows a change touched, before or after it. A range
+// transaction comes with its occurrences.
+type AuditState struct {
+	Planner              *Planner              `json:"planner,omitempty"`
+	Accounts             []Account             `json:"accounts,omitempty"`
+	RangeTransactions    []RangeTransaction    `json:"range_transactions,omitempty"`
+	ExpandedTransactions []ExpandedTransaction `json:"expanded_transactions,omitempty"`
+}
+
+// rowIDs returns the IDs of the rows of the state.
+func (s *AuditState) rowIDs() []uuid.UUID {
+	var ids []uuid.UUID
+	if s.Planner != nil {
+		ids = append(ids, s.Planner.ID)
+	}
+	for _, a := range s.Accounts {
+		ids = append(ids, a.ID)
+	}
+	for _, rt := range s.RangeTransactions {
+		ids = append(ids, rt.ID)
+	}
+	for _, etx := range s.ExpandedTransactions {
+		ids = append(ids, etx.ID)
+	}
+	return ids
+}
+
+// newAuditEntry returns the entry of a change by the user from before to after.
+func newAuditEntry(userID, plannerID uuid.UUID, action, entity string, entityID uuid.UUID, title string, before, after AuditState) (*AuditEntry, error) {
+	b, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := uuid.NewV4()
+	return &AuditEntry{
+		ID:        id,
+		PlannerID: plannerID,
+		UserID:    userID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Title:     title,
+		Before:    string(b),
+		After:     string(a),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// states decodes the rows before and after the change of the entry.
+func (e *AuditEntry) states() (before, after AuditState, err error) {
+	if err = json.Unmarshal([]byte(e.Before), &before); err != nil {
+		return before, after, fmt.Errorf("decoding audit entry %s: %w", e.ID, err)
+	}
+	if err = json.Unmarshal([]byte(e.After), &after); err != nil {
+		return before, after, fmt.Errorf("decoding audit entry %s: %w", e.ID, err)
+	}
+	return before, after, nil
+}
+
+// undoEntry returns the entry that reverts the entry and the rows to restore. An
+// entry is undone once and after the later changes of the same rows are undone,
+// an undo cannot be undone. entries are all the entries of the planner, the
+// newest first.
+func undoEntry(userID uuid.UUID, entries []AuditEntry, entryID uuid.UUID) (*AuditEntry, AuditState, AuditState, error) {
+	i := slices.IndexFunc(entries, func(e AuditEntry) bool { return e.ID == entryID })
+	if i < 0 {
+		return nil, AuditState{}, AuditState{}, ErrNotFound
+	}
+	entry := entries[i]
+	undone := map[uuid.UUID]bool{}
+	for _, e := range entries {
+		if e.UndoOf != uuid.Nil {
+			undone[e.UndoOf] = true
+		}
+	}
+	if entry.Action == AuditUndo || undone[entry.ID] {
+		return nil, AuditState{}, AuditState{}, ErrConflict
+	}
+	before, after, err := entry.states()
+	if err != nil {
+		return nil, AuditState{}, AuditState{}, err
+	}
+	rows := append(before.rowIDs(), after.rowIDs()...)
+	for _, later := range entries[:i] {
+		if later.Action == AuditUndo || undone[later.ID] {
+			continue
+		}
+		laterBefore, laterAfter, err := later.states()
+		if err != nil {
+			return nil, AuditState{}, AuditState{}, err
+		}
+		laterRows := append(laterBefore.rowIDs(), laterAfter.rowIDs()...)
+		if slices.ContainsFunc(rows, func(id uuid.UUID) bool { return slices.Contains(laterRows, id) }) {
+			return nil, AuditState{}, AuditState{}, ErrConflict
+		}
+	}
+	undo, err := newAuditEntry(userID, entry.PlannerID, AuditUndo, entry.Entity, entry.EntityID, entry.Title, after, before)
+	if err != nil {
+		return nil, AuditState{}, AuditState{}, err
+	}
+	undo.UndoOf = entry.ID
+	return undo, before, after, nil
+}
+
+// markUndone sets Undone on the entries an undo reverted.
+func markUndone(entries []AuditEntry) {
+	undone := map[uuid.UUID]bool{}
+	for _, e := range entries {
+		if e.UndoOf != uuid.Nil {
+			undone[e.UndoOf] = true
+		}
+	}
+	for i := range entries {
+		entries[i].Undone = undone[entries[i].ID]
+	}
+}
+
+var auditVerbs = map[string]string{
+	AuditCreate: "Added",
+	AuditUpdate: "Changed",
+	AuditDelete: "Deleted",
+	AuditImport: "Imported",
+	AuditUndo:   "Undid the change of",
+}
+
+var auditEntityNames = map[string]string{
+	EntityPlanner:             "planner",
+	EntityAccount:             "account",
+	EntityRangeTransaction:    "recurring transaction",
+	EntityExpandedTransaction: "transaction",
+}
+
+// Description says what the change was for the history page.
+func (e *AuditEntry) Description() string {
+	if e.Action == AuditImport {
+		return "Imported " + e.Title
+	}
+	return fmt.Sprintf("%s %s %s", auditVerbs[e.Action], auditEntityNames[e.Entity], e.Title)
+}
+
+// CanUndo reports if the entry shows an undo button, a later change of the same
+// rows can still make the undo fail.
+func (e *AuditEntry) CanUndo() bool {
+	return e.Action != AuditUndo && !e.Undone
+}
+
+// plannerHistoryPage lists the changes of the planner with an undo for each.
+func (s *Server) plannerHistoryPage(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	s.renderPlannerHistory(w, r, user, planner, http.StatusOK, "")
+}
+
+// renderPlannerHistory renders the changes of the planner with the message of an
+// undo that failed.
+func (s *Server) renderPlannerHistory(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, status int, formError string) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	entries, err := s.repository.ListAuditEntries(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list the history", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:    getCSRFToken(w, r),
+		IsLoggedIn:   true,
+		PlannerID:    planner.ID,
+		Planner:      planner,
+		Planners:     planners,
+		Username:     user.Username,
+		UserID:       user.ID,
+		AuditEntries: entries,
+		FormError:    formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "history.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// undoPlannerChange reverts a change of the planner. htmx gets the updated
+// planner page sections, the history page is shown again otherwise.
+func (s *Server) undoPlannerChange(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("entryID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.UndoAuditEntry(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		message := "A later change of the same records must be undone first, see the history."
+		if isHTMX(r) {
+			// htmx only swaps a successful response into the message of the planner
+			fmt.Fprintf(w, "<h2>%s</h2>", message)
+			return
+		}
+		s.renderPlannerHistory(w, r, user, planner, http.StatusConflict, message)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to undo the change", err)
+		return
+	}
+	s.logger.Info().Msgf("undid change %s of planner %s", id, planner.ID)
+	if isHTMX(r) {
+		// the undo is recorded as a change of the same record
+		entry, err := s.repository.GetAuditEntry(user.ID, planner.ID, id)
+		if err != nil {
+			s.internalError(w, "unable to find the change", err)
+			return
+		}
+		s.plannerChanged(w, r, user, planner, entry.EntityID)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/history", http.StatusFound)
+}
+
+func accountIDs(accounts []Account) []uuid.UUID {
+	ids := make([]uuid.UUID, len(accounts))
+	for i := range accounts {
+		ids[i] = accounts[i].ID
+	}
+	return ids
+}
+
+func rangeTransactionIDs(rangeTxns []RangeTransaction) []uuid.UUID {
+	ids := make([]uuid.UUID, len(rangeTxns))
+	for i := range rangeTxns {
+		ids[i] = rangeTxns[i].ID
+	}
+	return ids
+}
+
+func expandedTransactionIDs(txns []ExpandedTransaction) []uuid.UUID {
+	ids := make([]uuid.UUID, len(txns))
+	for i := range txns {
+		ids[i] = txns[i].ID
+	}
+	return ids
+}
+
+// importedTitle is the title of the entry of an import.
+func importedTitle(added int) string {
+	if added == 1 {
+		return "1 transaction"
+	}
+	return fmt.Sprintf("%d transactions", added)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestAuditUndo(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cashFlow := func() [][]string {
+		return parseCashFlow(t, serve(t, server, jar, "GET", plannerURL+"?view=all", nil).Body.String())
+	}
+	undoForm := func(entry AuditEntry) (string, url.Values) {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		return plannerURL + "/history/" + entry.ID.String() + "/undo", form
+	}
+
+	start := time.Now().AddDate(0, 0, 1)
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Rent")
+	form.Set("income_or_expense", "expense")
+	form.Set("amount", "900")
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_start", start.Format(time.DateOnly))
+	form.Set("recurrence_end", start.AddDate(0, 1, 0).Format(time.DateOnly))
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/add-range-transaction", form), http.StatusFound)
+	rangeTxns, err := repository.ListRangeTransactions(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(rangeTxns), 1)
+	rangeID := rangeTxns[0].ID.String()
+
+	form.Set("range_transaction_id", rangeID)
+	form.Set("amount", "1000")
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/update-range-transaction", form), http.StatusFound)
+	ensureCashFlow(t, cashFlow(), []string{"Rent", "Rent"}, []string{"-1000", "-2000"})
+
+	// The planner page offers to undo the delete that was just made
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("range_transaction_id", rangeID)
+	recorder := serveHTMX(t, server, jar, plannerURL+"?view=all", plannerURL+"/delete-range-transaction", form)
+	ensureCode(t, recorder, http.StatusOK)
+	body := recorder.Body.String()
+	if !strings.Contains(body, "Deleted recurring transaction Rent.") {
+		t.Fatalf("the partials do not show the delete:\n%s", body)
+	}
+	ensureInt(t, len(parseCashFlow(t, body)), 0)
+
+	entries, err := repository.ListAuditEntries(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(entries), 3)
+	ensureString(t, entries[0].Action, AuditDelete)
+	ensureString(t, entries[1].Action, AuditUpdate)
+	ensureString(t, entries[2].Action, AuditCreate)
+	deleteURL, _ := undoForm(entries[0])
+	ensureInt(t, len(formsWithAction(parseForms(t, body), deleteURL)), 1)
+
+	// The update cannot be undone before the delete that came after it
+	{
+		path, form := undoForm(entries[1])
+		recorder := serve(t, server, jar, "POST", path, form)
+		ensureCode(t, recorder, http.StatusConflict)
+		if !strings.Contains(recorder.Body.String(), "A later change of the same records must be undone first") {
+			t.Fatal("no message for a conflicting undo")
+		}
+	}
+
+	// Undoing the delete restores the series with its occurrences
+	{
+		path, form := undoForm(entries[0])
+		recorder := serveHTMX(t, server, jar, plannerURL+"?view=all", path, form)
+		ensureCode(t, recorder, http.StatusOK)
+		body := recorder.Body.String()
+		ensureCashFlow(t, parseCashFlow(t, body), []string{"Rent", "Rent"}, []string{"-1000", "-2000"})
+		if !strings.Contains(body, "Undid the change of recurring transaction Rent.") {
+			t.Fatalf("the partials do not show the undo:\n%s", body)
+		}
+		txns, err := repository.ListExpandedTransactions(user.ID, plannerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureInt(t, len(txns), 2)
+		// an entry is undone once
+		ensureCode(t, serve(t, server, jar, "POST", path, form), http.StatusConflict)
+	}
+
+	// Then the update is undone
+	{
+		path, form := undoForm(entries[1])
+		ensureRedirect(t, serve(t, server, jar, "POST", path, form), http.StatusFound, plannerURL+"/history")
+		ensureCashFlow(t, cashFlow(), []string{"Rent", "Rent"}, []string{"-900", "-1800"})
+	}
+
+	// The history lists every change, the undone ones without an undo
+	{
+		recorder := serve(t, server, jar, "GET", plannerURL+"/history", nil)
+		ensureCode(t, recorder, http.StatusOK)
+		body := recorder.Body.String()
+		for _, change := range []string{
+			"Added recurring transaction Rent",
+			"Changed recurring transaction Rent",
+			"Deleted recurring transaction Rent",
+			"Undid the change of recurring transaction Rent",
+		} {
+			if !strings.Contains(body, change) {
+				t.Errorf("the history has no %q", change)
+			}
+		}
+		ensureInt(t, strings.Count(body, "(undone)"), 2)
+		forms := parseForms(t, body)
+		for _, entry := range entries[:2] {
+			path, _ := undoForm(entry)
+			ensureInt(t, len(formsWithAction(forms, path)), 0)
+		}
+		path, _ := undoForm(entries[2])
+		ensureInt(t, len(formsWithAction(forms, path)), 1)
+	}
+
+	// A viewer reads the history but does not undo
+	{
+		viewer, viewerJar, viewerCSRF := signInNewUser(t, server, repository, "viewer@prototype.proto")
+		err := repository.SetPlannerMember(user.ID, &PlannerMember{PlannerID: plannerID, UserID: viewer.ID, Role: RoleViewer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureCode(t, serve(t, server, viewerJar, "GET", plannerURL+"/history", nil), http.StatusOK)
+		path, form := undoForm(entries[2])
+		form.Set("csrf-token", viewerCSRF)
+		ensureCode(t, serve(t, server, viewerJar, "POST", path, form), http.StatusForbidden)
+	}
+}
+
+func TestLatestAuditEntry(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	_, plannerURL := signInWithPlanner(t, server, jar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	owner, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, _, _ := signInNewUser(t, server, repository, "editor@prototype.proto")
+	err = repository.SetPlannerMember(owner.ID, &PlannerMember{PlannerID: plannerID, UserID: editor.ID, Role: RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTransaction := func(user *User, title string) uuid.UUID {
+		id, _ := uuid.NewV4()
+		err := repository.AddExpandedTransaction(&ExpandedTransaction{
+			ID: id, UserID: user.ID, PlannerID: plannerID, Title: title, IncomeOrExpense: "expense",
+			Amount: 10, TransactionDate: time.Now().AddDate(0, 0, 1),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// the editor changes the planner right after the owner
+	gym := addTransaction(owner, "Gym")
+	books := addTransaction(editor, "Books")
+	entries, err := repository.ListAuditEntries(owner.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureString(t, entries[0].Title, "Books")
+
+	entry, err := repository.LatestAuditEntry(owner.ID, plannerID, gym)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureString(t, entry.Title, "Gym")
+	ensureString(t, entry.UserID.String(), owner.ID.String())
+	if _, err = repository.LatestAuditEntry(owner.ID, plannerID, books); !errors.Is(err, ErrNotFound) {
+		t.Errorf("found a change of the owner to the transaction of the editor: %v", err)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/hex"
-	"math/rand"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -21,7 +25,7 @@ func csrf(h http.HandlerFunc) http.HandlerFunc {
 		}
 		token := r.FormValue("csrf-token")
 		cookie, err := r.Cookie("csrf-token")
-		if os.Getenv("BYPASS_LOGIN") != "true" && (err != nil || token != cookie.Value) {
+		if err != nil || token != cookie.Value {
 			http.Error(w, "invalid CSRF token or cookie", http.StatusBadRequest)
 			return
 		}
@@ -49,29 +53,37 @@ func getCSRFToken(w http.ResponseWriter, r *http.Request) string {
 	return token
 }
 
-// set cookies for session token and username
+// sessionCookie holds the token of the session of the browser
+const sessionCookie = "session_token"
+
+// sessionDuration is how long a device stays signed in
+const sessionDuration = 30 * 24 * time.Hour
+
+// initBrowserSession sets the session cookie that expires with the session.
 func initBrowserSession(
 	w http.ResponseWriter,
 	r *http.Request,
-	username,
 	token string,
+	expires time.Time,
 ) {
-	// TODO set expiry
 	cookie := &http.Cookie{
-		Name:     "session_token",
+		Name:     sessionCookie,
 		Value:    token,
-		MaxAge:   24 * 60 * 60,
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
 		Path:     "/",
 		Secure:   r.URL.Scheme == "https",
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 	}
 	http.SetCookie(w, cookie)
+}
 
-	cookie = &http.Cookie{
-		Name:     "username",
-		Value:    username,
-		MaxAge:   24 * 60 * 60,
+// clearBrowserSession removes the session cookie.
+func clearBrowserSession(w http.ResponseWriter, r *http.Request) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		MaxAge:   -1,
 		Path:     "/",
 		Secure:   r.URL.Scheme == "https",
 		HttpOnly: true,
@@ -80,21 +92,13 @@ func initBrowserSession(
 	http.SetCookie(w, cookie)
 }
 
-type UserLogin struct {
-	SessionToken string
-	Username     string
-}
-
-func extractUserLogin(r *http.Request) (UserLogin, error) {
-	token, err := r.Cookie("session_token")
-	if err != nil {
-		return UserLogin{}, err
-	}
-	username, err := r.Cookie("username")
+// clientIP is the address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return UserLogin{}, err
+		return r.RemoteAddr
 	}
-	return UserLogin{token.Value, username.Value}, nil
+	return host
 }
 
 func generateSecureToken(nBytes int) string {
@@ -120,3 +124,51 @@ func GeneratePasswordHash(password, salt string) (string, error) {
 	b, err := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.DefaultCost)
 	return string(b), err
 }
+
+const (
+	minPasswordLength = 10
+	// bcrypt only reads 72 bytes and the salt takes 16 of them
+	maxPasswordLength = 56
+)
+
+// commonPasswords are rejected even when they are long enough
+var commonPasswords = []string{
+	"password", "passw0rd", "123456", "qwerty", "abc123", "letmein",
+	"welcome", "iloveyou", "admin", "monkey", "dragon", "sunshine", "changeme",
+}
+
+// checkPasswordPolicy returns why the password cannot be used by the user, the
+// error message is shown on the form.
+func checkPasswordPolicy(username, password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("the password needs at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("the password can have at most %d bytes", maxPasswordLength)
+	}
+	lower := strings.ToLower(password)
+	name, _, _ := strings.Cut(strings.ToLower(username), "@")
+	if len(name) >= 3 && strings.Contains(lower, name) {
+		return errors.New("the password cannot contain the username")
+	}
+	for _, common := range commonPasswords {
+		if strings.Contains(lower, common) {
+			return errors.New("the password is too common")
+		}
+	}
+	distinct := map[rune]bool{}
+	for _, c := range password {
+		distinct[c] = true
+	}
+	if len(distinct) < 5 {
+		return errors.New("the password needs at least 5 different characters")
+	}
+	return nil
+}
+
+// newPasswordHash returns the hash of the password with a new salt.
+func newPasswordHash(password string) (hash, salt string, err error) {
+	salt = generateSecureToken(8)
+	hash, err = GeneratePasswordHash(password, salt)
+	return hash, salt, err
+}
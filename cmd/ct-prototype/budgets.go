@@ -0,0 +1,347 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// uncategorized is the row of the expenses without a category
+const uncategorized = "Uncategorized"
+
+// BudgetCell is the planned spending of a category in a month against its budget.
+type BudgetCell struct {
+	Month   time.Time
+	Planned float64
+	// Budget is 0 when the category has no budget
+	Budget float64
+}
+
+// Variance is what is left of the budget, negative when it is overspent.
+func (c BudgetCell) Variance() float64 {
+	return c.Budget - c.Planned
+}
+
+// Overspent reports if more is planned than budgeted.
+func (c BudgetCell) Overspent() bool {
+	return c.Budget > 0 && c.Planned > c.Budget
+}
+
+// BudgetRow is a category with a cell per month of the report. A transaction
+// category without a row in the categories table gets a row of its own without a
+// budget.
+type BudgetRow struct {
+	// CategoryID is uuid.Nil for a category that is not in the table
+	CategoryID uuid.UUID
+	Name       string
+	Color      string
+	// Depth is the number of parents of the category
+	Depth  int
+	Budget float64
+	Cells  []BudgetCell
+}
+
+// Indent is the left padding of the name in em, children sit under their parent.
+func (r *BudgetRow) Indent() float64 {
+	return 0.5 + 1.5*float64(r.Depth)
+}
+
+// Planned is the planned spending of all the months.
+func (r *BudgetRow) Planned() float64 {
+	total := 0.0
+	for _, c := range r.Cells {
+		total += c.Planned
+	}
+	return total
+}
+
+// AveragePlanned is the planned spending of an average month.
+func (r *BudgetRow) AveragePlanned() float64 {
+	if len(r.Cells) == 0 {
+		return 0
+	}
+	return r.Planned() / float64(len(r.Cells))
+}
+
+// OverspentMonths is the number of months more is planned than budgeted.
+func (r *BudgetRow) OverspentMonths() int {
+	n := 0
+	for _, c := range r.Cells {
+		if c.Overspent() {
+			n++
+		}
+	}
+	return n
+}
+
+// BudgetOverspend lists the categories that break their budget in a month.
+type BudgetOverspend struct {
+	Month time.Time
+	// Date and NetCash are of the last transaction of the month, where the chart
+	// marks the overspend
+	Date       time.Time
+	NetCash    float64
+	Categories []string
+	Amount     float64
+}
+
+// Label describes the overspend in the chart tooltip.
+func (o BudgetOverspend) Label() string {
+	return "Over budget: " + strings.Join(o.Categories, ", ")
+}
+
+// BudgetReport compares the planned expenses with the budgets per category and
+// month.
+type BudgetReport struct {
+	Months []time.Time
+	// Rows are in tree order, each parent followed by its children
+	Rows []BudgetRow
+	// Total sums the top level rows, the budget of a category without one is the
+	// sum of the budgets of its children
+	Total      BudgetRow
+	Overspends []BudgetOverspend
+}
+
+// HasBudgets reports if any category of the report has a budget.
+func (b *BudgetReport) HasBudgets() bool {
+	for _, row := range b.Rows {
+		if row.Budget > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Budgeted are the rows with a budget.
+func (b *BudgetReport) Budgeted() []BudgetRow {
+	var rows []BudgetRow
+	for _, row := range b.Rows {
+		if row.Budget > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// months returns the first days of the months from start to end.
+func months(start, end time.Time) []time.Time {
+	var ms []time.Time
+	for m := monthStart(start); !m.After(end); m = m.AddDate(0, 1, 0) {
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// categoryKey matches a transaction category with a category name.
+func categoryKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// budgetReport aggregates the planned expenses of the cash flow from start to
+// end by category and month. The expenses of a category count towards its parents,
+// transfers between accounts are not expenses.
+func budgetReport(categories []Category, txns []*SegmentedTransaction, start, end time.Time) *BudgetReport {
+	report := &BudgetReport{Months: months(start, end)}
+	if len(report.Months) == 0 {
+		return report
+	}
+	monthIndex := map[time.Time]int{}
+	for i, m := range report.Months {
+		monthIndex[m] = i
+	}
+
+	byID := map[uuid.UUID]*Category{}
+	byKey := map[string]*Category{}
+	children := map[uuid.UUID][]*Category{}
+	for i := range categories {
+		c := &categories[i]
+		byID[c.ID] = c
+		byKey[categoryKey(c.Name)] = c
+	}
+	for _, c := range byID {
+		parent := c.ParentID
+		if byID[parent] == nil {
+			parent = uuid.Nil
+		}
+		children[parent] = append(children[parent], c)
+	}
+	for _, cs := range children {
+		sort.Slice(cs, func(i, j int) bool { return cs[i].Name < cs[j].Name })
+	}
+
+	// planned spending of each category by itself and of the names without one
+	planned := map[uuid.UUID][]float64{}
+	unknown := map[string][]float64{}
+	var unknownNames []string
+	lastOfMonth := map[int]*SegmentedTransaction{}
+	for _, stx := range txns {
+		if stx.TransactionDate.Before(start) || stx.TransactionDate.After(end) {
+			continue
+		}
+		i := monthIndex[monthStart(stx.TransactionDate)]
+		lastOfMonth[i] = stx
+		if stx.IncomeOrExpense == "income" || stx.IsTransfer() {
+			continue
+		}
+		if c := byKey[categoryKey(stx.Category)]; c != nil {
+			if planned[c.ID] == nil {
+				planned[c.ID] = make([]float64, len(report.Months))
+			}
+			planned[c.ID][i] += stx.Amount
+			continue
+		}
+		name := strings.TrimSpace(stx.Category)
+		if name == "" {
+			name = uncategorized
+		}
+		if unknown[name] == nil {
+			unknown[name] = make([]float64, len(report.Months))
+			unknownNames = append(unknownNames, name)
+		}
+		unknown[name][i] += stx.Amount
+	}
+
+	report.Total = BudgetRow{Name: "Total", Cells: make([]BudgetCell, len(report.Months))}
+	for i, m := range report.Months {
+		report.Total.Cells[i].Month = m
+	}
+	// addTree appends the rows of the category and its children and returns their
+	// planned spending by month and the budget that covers them
+	visited := map[uuid.UUID]bool{}
+	var addTree func(c *Category, depth int) ([]float64, float64)
+	addTree = func(c *Category, depth int) ([]float64, float64) {
+		visited[c.ID] = true
+		at := len(report.Rows)
+		report.Rows = append(report.Rows, BudgetRow{})
+		spending := make([]float64, len(report.Months))
+		copy(spending, planned[c.ID])
+		childBudgets := 0.0
+		for _, child := range children[c.ID] {
+			if visited[child.ID] {
+				continue
+			}
+			childSpending, childBudget := addTree(child, depth+1)
+			for i := range spending {
+				spending[i] += childSpending[i]
+			}
+			childBudgets += childBudget
+		}
+		row := BudgetRow{
+			CategoryID: c.ID,
+			Name:       c.Name,
+			Color:      c.Color,
+			Depth:      depth,
+			Budget:     c.MonthlyBudget,
+			Cells:      make([]BudgetCell, len(report.Months)),
+		}
+		for i, m := range report.Months {
+			row.Cells[i] = BudgetCell{Month: m, Planned: spending[i], Budget: c.MonthlyBudget}
+		}
+		report.Rows[at] = row
+		if c.MonthlyBudget > 0 {
+			return spending, c.MonthlyBudget
+		}
+		return spending, childBudgets
+	}
+	addToTotal := func(spending []float64, budget float64) {
+		report.Total.Budget += budget
+		for i := range report.Total.Cells {
+			report.Total.Cells[i].Planned += spending[i]
+			report.Total.Cells[i].Budget += budget
+		}
+	}
+	for _, c := range children[uuid.Nil] {
+		addToTotal(addTree(c, 0))
+	}
+	// a cycle of parents never reaches the top level, show its categories there
+	for i := range categories {
+		if !visited[categories[i].ID] {
+			addToTotal(addTree(byID[categories[i].ID], 0))
+		}
+	}
+	sort.Strings(unknownNames)
+	for _, name := range unknownNames {
+		row := BudgetRow{Name: name, Cells: make([]BudgetCell, len(report.Months))}
+		for i, m := range report.Months {
+			row.Cells[i] = BudgetCell{Month: m, Planned: unknown[name][i]}
+		}
+		report.Rows = append(report.Rows, row)
+		addToTotal(unknown[name], 0)
+	}
+
+	for i, m := range report.Months {
+		overspend := BudgetOverspend{Month: m}
+		for _, row := range report.Rows {
+			if row.Cells[i].Overspent() {
+				overspend.Categories = append(overspend.Categories, row.Name)
+				overspend.Amount += -row.Cells[i].Variance()
+			}
+		}
+		if len(overspend.Categories) == 0 || lastOfMonth[i] == nil {
+			continue
+		}
+		overspend.Date = lastOfMonth[i].TransactionDate
+		overspend.NetCash = lastOfMonth[i].NetCash
+		report.Overspends = append(report.Overspends, overspend)
+	}
+	return report
+}
+
+// plannerBudget returns the budget report of the planner from the current month
+// to its end.
+func (s *Server) plannerBudget(userID uuid.UUID, txns []*SegmentedTransaction, now, end time.Time) (*BudgetReport, []Category, error) {
+	categories, err := s.repository.ListCategories(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return budgetReport(categories, txns, monthStart(now), end), categories, nil
+}
+
+// budgetPage shows the planned expenses against the budgets per category and month.
+func (s *Server) budgetPage(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	plannerEnd := planner.End(now)
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	expandedTransactions, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list transactions", err)
+		return
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list accounts", err)
+		return
+	}
+	segTxns := cashFlow(planner, accounts, expandedTransactions, now, plannerEnd)
+	budget, categories, err := s.plannerBudget(user.ID, segTxns, now, plannerEnd)
+	if err != nil {
+		s.internalError(w, "unable to list categories", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		PlannerID:  planner.ID,
+		PlannerEnd: plannerEnd,
+		Planner:    planner,
+		Planners:   planners,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Categories: categories,
+		Budget:     budget,
+	}
+	if err := StaticResources.ExecuteTemplate(w, "budget.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
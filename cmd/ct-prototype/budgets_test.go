@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestBudgetReport(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	housing, _ := uuid.NewV4()
+	rent, _ := uuid.NewV4()
+	utilities, _ := uuid.NewV4()
+	dining, _ := uuid.NewV4()
+	categories := []Category{
+		{ID: dining, Name: "Dining", MonthlyBudget: 150},
+		{ID: housing, Name: "Housing"},
+		{ID: rent, ParentID: housing, Name: "Rent", MonthlyBudget: 1200},
+		{ID: utilities, ParentID: housing, Name: "Utilities", MonthlyBudget: 100},
+	}
+	txns := []*SegmentedTransaction{
+		{TransactionDate: day(1, 1), IncomeOrExpense: "income", Category: "Salary", Amount: 3000},
+		{TransactionDate: day(1, 5), IncomeOrExpense: "expense", Category: "dining", Amount: 100},
+		{TransactionDate: day(1, 10), IncomeOrExpense: "expense", Category: "Rent", Amount: 1200},
+		{TransactionDate: day(1, 12), IncomeOrExpense: "expense", Category: "Utilities", Amount: 80},
+		{TransactionDate: day(2, 5), IncomeOrExpense: "expense", Category: "Dining", Amount: 200},
+		{TransactionDate: day(2, 10), IncomeOrExpense: "expense", Category: "Rent", Amount: 1300},
+		{TransactionDate: day(2, 15), IncomeOrExpense: "expense", Category: "Travel", Amount: 500},
+		{TransactionDate: day(2, 20), IncomeOrExpense: "expense", Amount: 40},
+		{TransactionDate: day(4, 1), IncomeOrExpense: "expense", Category: "Dining", Amount: 999},
+	}
+	netCash := 0.0
+	for _, stx := range txns {
+		if stx.IncomeOrExpense == "income" {
+			netCash += stx.Amount
+		} else {
+			netCash -= stx.Amount
+		}
+		stx.NetCash = netCash
+	}
+
+	report := budgetReport(categories, txns, day(1, 1), day(3, 31))
+	ensureInt(t, len(report.Months), 3)
+
+	type wantRow struct {
+		name    string
+		depth   int
+		planned [3]float64
+		budget  float64
+	}
+	wants := []wantRow{
+		{"Dining", 0, [3]float64{100, 200, 0}, 150},
+		{"Housing", 0, [3]float64{1280, 1300, 0}, 0},
+		{"Rent", 1, [3]float64{1200, 1300, 0}, 1200},
+		{"Utilities", 1, [3]float64{80, 0, 0}, 100},
+		{"Travel", 0, [3]float64{0, 500, 0}, 0},
+		{uncategorized, 0, [3]float64{0, 40, 0}, 0},
+	}
+	if len(report.Rows) != len(wants) {
+		t.Fatalf("got %d rows, want %d: %+v", len(report.Rows), len(wants), report.Rows)
+	}
+	for i, want := range wants {
+		row := report.Rows[i]
+		if row.Name != want.name || row.Depth != want.depth || row.Budget != want.budget {
+			t.Errorf("row %d: got %s at depth %d with budget %v, want %+v", i, row.Name, row.Depth, row.Budget, want)
+		}
+		for m, cell := range row.Cells {
+			if cell.Planned != want.planned[m] {
+				t.Errorf("%s in %s: planned %v, want %v", row.Name, cell.Month.Format("Jan"), cell.Planned, want.planned[m])
+			}
+		}
+	}
+
+	// Housing has no budget of its own and is covered by its children
+	ensureFloat(t, report.Total.Budget, 150+1200+100)
+	ensureFloat(t, report.Total.Cells[0].Planned, 1380)
+	ensureFloat(t, report.Total.Cells[1].Planned, 2040)
+	ensureInt(t, report.Rows[0].OverspentMonths(), 1)
+	ensureFloat(t, report.Rows[0].Cells[1].Variance(), -50)
+
+	if len(report.Overspends) != 1 {
+		t.Fatalf("got %d overspends, want 1: %+v", len(report.Overspends), report.Overspends)
+	}
+	overspend := report.Overspends[0]
+	if !overspend.Date.Equal(day(2, 20)) || overspend.NetCash != netCash+999 {
+		t.Errorf("overspend marked on %s at %v", overspend.Date, overspend.NetCash)
+	}
+	ensureString(t, overspend.Label(), "Over budget: Dining, Rent")
+	ensureFloat(t, overspend.Amount, 150)
+}
+
+func TestCheckCategory(t *testing.T) {
+	parent, _ := uuid.NewV4()
+	child, _ := uuid.NewV4()
+	categories := []Category{
+		{ID: parent, Name: "Housing"},
+		{ID: child, ParentID: parent, Name: "Rent"},
+	}
+	tests := []struct {
+		name     string
+		category Category
+		want     error
+	}{
+		{"new", Category{Name: "Dining"}, nil},
+		{"same name", Category{Name: "housing "}, errCategoryExists},
+		{"rename", Category{ID: child, ParentID: parent, Name: "Mortgage"}, nil},
+		{"own parent", Category{ID: parent, ParentID: parent, Name: "Housing"}, errCategoryParent},
+		{"child as parent", Category{ID: parent, ParentID: child, Name: "Housing"}, errCategoryParent},
+	}
+	for _, tt := range tests {
+		category := tt.category
+		if err := checkCategory(&category, categories); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCategories(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, csrfToken := signIn(t, server, testPassword)
+
+	ensureCode(t, serve(t, server, jar, "GET", "/categories", nil), http.StatusOK)
+	addCategory := func(name, parentID, budget string) *http.Response {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("name", name)
+		form.Set("parent_id", parentID)
+		form.Set("color", "#ff0000")
+		form.Set("monthly_budget", budget)
+		return serve(t, server, jar, "POST", "/categories", form).Result()
+	}
+	if code := addCategory("Housing", "", "0").StatusCode; code != http.StatusFound {
+		t.Fatalf("adding a category: %d", code)
+	}
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	categories, err := repository.ListCategories(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(categories), 1)
+	housing := categories[0].ID
+	ensureInt(t, addCategory("Rent", housing.String(), "500").StatusCode, http.StatusFound)
+	ensureInt(t, addCategory("rent", "", "0").StatusCode, http.StatusUnprocessableEntity)
+	ensureInt(t, addCategory("Dining", "", "-1").StatusCode, http.StatusUnprocessableEntity)
+
+	// A planner spending more on rent than the budget
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "Household")
+	form.Set("start_balance", "1000")
+	form.Set("horizon_months", "12")
+	form.Set("currency", "USD")
+	recorder := serve(t, server, jar, "POST", "/planners/create", form)
+	plannerURL := recorder.Result().Header.Get("Location")
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Apartment")
+	form.Set("income_or_expense", "expense")
+	form.Set("category", "RENT")
+	form.Set("amount", "750")
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_start", time.Now().AddDate(0, 0, 1).Format(time.DateOnly))
+	form.Set("recurrence_end", time.Now().AddDate(0, 3, 0).Format(time.DateOnly))
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-range-transaction", form), http.StatusFound, plannerURL)
+
+	recorder = serve(t, server, jar, "GET", plannerURL+"/budget", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), "overspent") {
+		t.Error("the budget page does not highlight the overspend")
+	}
+	recorder = serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	body := recorder.Body.String()
+	if !strings.Contains(body, "Over budget: Rent") {
+		t.Error("the chart does not mark the overspend")
+	}
+	if !strings.Contains(body, `<option value="Rent">`) {
+		t.Error("the category input does not suggest the categories")
+	}
+
+	// A category cannot become a child of its child
+	categories, _ = repository.ListCategories(user.ID)
+	rent := categories[1].ID
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "Housing")
+	form.Set("parent_id", rent.String())
+	form.Set("monthly_budget", "0")
+	ensureCode(t, serve(t, server, jar, "POST", "/categories/"+housing.String()+"/update", form), http.StatusUnprocessableEntity)
+
+	form.Set("parent_id", "")
+	form.Set("monthly_budget", "2000")
+	ensureRedirect(t, serve(t, server, jar, "POST", "/categories/"+housing.String()+"/update", form), http.StatusFound, "/categories")
+
+	// Deleting the parent moves the child to the top level
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	ensureRedirect(t, serve(t, server, jar, "POST", "/categories/"+housing.String()+"/delete", form), http.StatusFound, "/categories")
+	ensureCode(t, serve(t, server, jar, "POST", "/categories/"+housing.String()+"/delete", form), http.StatusNotFound)
+	categories, _ = repository.ListCategories(user.ID)
+	ensureInt(t, len(categories), 1)
+	if categories[0].ParentID != uuid.Nil {
+		t.Errorf("the child kept the deleted parent %s", categories[0].ParentID)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// calendarTokenPrefix tells the calendar feed tokens apart from the API tokens,
+// a feed token only reads the upcoming transactions
+const calendarTokenPrefix = "ctc_"
+
+// calendarEvent is an all day event of the calendar feed.
+type calendarEvent struct {
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	// Category is PAYDAY or BILL
+	Category string
+}
+
+// calendarEvents returns the paydays and bills of the planner from the day of now
+// to its end. Transfers stay within the planner and are left out.
+func calendarEvents(planner *Planner, txns []ExpandedTransaction, now time.Time) []calendarEvent {
+	today, end := truncateDay(now), planner.End(now)
+	var events []calendarEvent
+	for i := range txns {
+		etx := &txns[i]
+		if etx.TransactionDate.Before(today) || etx.TransactionDate.After(end) || etx.IncomeOrExpense == "transfer" {
+			continue
+		}
+		sign, category := "-", "BILL"
+		if etx.IncomeOrExpense == "income" {
+			sign, category = "+", "PAYDAY"
+		}
+		description := planner.Name
+		if etx.Category != "" {
+			description += ", " + etx.Category
+		}
+		events = append(events, calendarEvent{
+			UID:         etx.ID.String() + "@ct-prototype",
+			Date:        etx.TransactionDate,
+			Summary:     fmt.Sprintf("%s %s%s%.2f", etx.Title, sign, currencySymbol(planner.Currency), etx.Amount),
+			Description: description,
+			Category:    category,
+		})
+	}
+	return events
+}
+
+// icsText escapes a text value of an iCalendar property.
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeICSLine writes a content line folded to 75 octets without splitting a
+// UTF-8 character, as RFC 5545 asks.
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// the leading space of the next line counts
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// writeCalendar writes the events as an iCalendar document.
+func writeCalendar(w io.Writer, events []calendarEvent, now time.Time) error {
+	var b strings.Builder
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//ct-prototype//cash flow planner//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Bills and paydays",
+		"REFRESH-INTERVAL;VALUE=DURATION:PT12H",
+		"X-PUBLISHED-TTL:PT12H",
+	} {
+		writeICSLine(&b, line)
+	}
+	for _, e := range events {
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+e.UID)
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART;VALUE=DATE:"+e.Date.Format("20060102"))
+		writeICSLine(&b, "DTEND;VALUE=DATE:"+e.Date.AddDate(0, 0, 1).Format("20060102"))
+		writeICSLine(&b, "SUMMARY:"+icsText(e.Summary))
+		writeICSLine(&b, "DESCRIPTION:"+icsText(e.Description))
+		writeICSLine(&b, "CATEGORIES:"+icsText(e.Category))
+		writeICSLine(&b, "TRANSP:TRANSPARENT")
+		writeICSLine(&b, "END:VEVENT")
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// requestOrigin is the scheme and host the request was sent to.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// calendarFeedURL is the address calendar apps subscribe to.
+func calendarFeedURL(r *http.Request, token string) string {
+	return fmt.Sprintf("%s/calendar/%s.ics", requestOrigin(r), token)
+}
+
+// calendarFeed serves the upcoming bills and paydays of every planner of the user
+// with the token in the path. The token is the only authentication so calendar
+// apps can subscribe to the feed.
+func (s *Server) calendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(r.PathValue("token"), ".ics")
+	if !strings.HasPrefix(token, calendarTokenPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	user, err := s.repository.GetCalendarTokenUser(hashToken(token))
+	if err != nil {
+		// an unknown token gets the same response as a missing feed
+		if !errors.Is(err, ErrNotFound) {
+			s.logger.Err(err).Msg("unable to look up the calendar token")
+		}
+		http.NotFound(w, r)
+		return
+	}
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	now := time.Now()
+	var events []calendarEvent
+	for i := range planners {
+		txns, err := s.repository.ListExpandedTransactions(user.ID, planners[i].ID)
+		if err != nil {
+			s.internalError(w, "unable to list expanded transactions", err)
+			return
+		}
+		events = append(events, calendarEvents(&planners[i], txns, now)...)
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeCalendar(w, events, now); err != nil {
+		s.logger.Error().Err(err).Msg("unable to write the calendar feed")
+	}
+}
+
+// createCalendarToken replaces the calendar feed token of the user and shows the
+// URL of the feed once.
+func (s *Server) createCalendarToken(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	token := calendarTokenPrefix + generateSecureToken(32)
+	if err := s.repository.SetCalendarToken(user.ID, hashToken(token)); err != nil {
+		s.internalError(w, "unable to set calendar token", err)
+		return
+	}
+	user.CalendarTokenHash = hashToken(token)
+	s.logger.Info().Msgf("created calendar feed for user %s", user.ID)
+	s.renderAPITokensPage(w, r, user, "", calendarFeedURL(r, token))
+}
+
+// deleteCalendarToken turns the calendar feed of the user off.
+func (s *Server) deleteCalendarToken(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	if err := s.repository.SetCalendarToken(user.ID, ""); err != nil {
+		s.internalError(w, "unable to delete calendar token", err)
+		return
+	}
+	s.logger.Info().Msgf("deleted calendar feed of user %s", user.ID)
+	http.Redirect(w, r, "/settings/api-tokens", http.StatusFound)
+}
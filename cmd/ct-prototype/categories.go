@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// categoryForm creates or updates a category.
+type categoryForm struct {
+	Name string `form:"name" json:"name" validate:"required,max=255"`
+	// ParentID is empty for a top level category
+	ParentID      uuid.UUID `form:"parent_id" json:"parent_id"`
+	Color         string    `form:"color" json:"color" validate:"omitempty,hexcolor"`
+	MonthlyBudget float64   `form:"monthly_budget" json:"monthly_budget" validate:"gte=0"`
+}
+
+var (
+	errCategoryExists = &formError{"name", "a category with the name exists"}
+	errCategoryParent = &formError{"parent_id", "the parent cannot be the category or one of its children"}
+)
+
+// category returns the category of the form for the user.
+func (f *categoryForm) category(userID uuid.UUID) *Category {
+	return &Category{
+		UserID:        userID,
+		ParentID:      f.ParentID,
+		Name:          strings.TrimSpace(f.Name),
+		Color:         f.Color,
+		MonthlyBudget: f.MonthlyBudget,
+	}
+}
+
+// checkCategory returns why the category cannot be saved next to the other
+// categories of the user.
+func checkCategory(category *Category, categories []Category) error {
+	byID := map[uuid.UUID]*Category{}
+	for i := range categories {
+		c := &categories[i]
+		byID[c.ID] = c
+		if c.ID != category.ID && categoryKey(c.Name) == categoryKey(category.Name) {
+			return errCategoryExists
+		}
+	}
+	if category.ParentID == uuid.Nil {
+		return nil
+	}
+	if byID[category.ParentID] == nil {
+		return &formError{"parent_id", "the parent category does not exist"}
+	}
+	// walk up from the parent, reaching the category would make a cycle
+	for id, steps := category.ParentID, 0; id != uuid.Nil && steps <= len(categories); steps++ {
+		if id == category.ID {
+			return errCategoryParent
+		}
+		parent := byID[id]
+		if parent == nil {
+			break
+		}
+		id = parent.ParentID
+	}
+	return nil
+}
+
+// renderCategories renders the categories page with the message of a rejected
+// form.
+func (s *Server) renderCategories(w http.ResponseWriter, r *http.Request, status int, formError string) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	categories, err := s.repository.ListCategories(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list categories", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Planners:   planners,
+		Categories: categories,
+		FormError:  formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "categories.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// categoriesPage lists the categories of the user with their budgets.
+func (s *Server) categoriesPage(w http.ResponseWriter, r *http.Request) {
+	s.renderCategories(w, r, http.StatusOK, "")
+}
+
+// saveCategory checks the category of the form and adds it, or updates it when
+// it has an ID.
+func (s *Server) saveCategory(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	var f categoryForm
+	var category *Category
+	err = s.decodeForm(r, &f)
+	if err == nil {
+		category = f.category(user.ID)
+		category.ID = id
+		var categories []Category
+		if categories, err = s.repository.ListCategories(user.ID); err == nil {
+			err = checkCategory(category, categories)
+		}
+	}
+	if err == nil {
+		if id == uuid.Nil {
+			category.ID, _ = uuid.NewV4()
+			err = s.repository.AddCategory(category)
+		} else {
+			err = s.repository.UpdateCategory(category)
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to save category", err)
+			return
+		}
+		s.renderCategories(w, r, http.StatusUnprocessableEntity, message)
+		return
+	}
+	http.Redirect(w, r, "/categories", http.StatusFound)
+}
+
+func (s *Server) createCategory(w http.ResponseWriter, r *http.Request) {
+	s.saveCategory(w, r, uuid.Nil)
+}
+
+func (s *Server) updateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.FromString(r.PathValue("categoryID"))
+	if err != nil || id == uuid.Nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.saveCategory(w, r, id)
+}
+
+// deleteCategory deletes the category, its children move to the top level and
+// the transactions keep the name.
+func (s *Server) deleteCategory(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("categoryID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeleteCategory(user.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to delete category", err)
+		return
+	}
+	http.Redirect(w, r, "/categories", http.StatusFound)
+}
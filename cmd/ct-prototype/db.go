@@ -1,24 +1,43 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/rs/zerolog"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
-type PostgresDB struct {
+// ErrNotFound is returned when the record does not exist or belongs to a planner
+// the user is not a member of
+var ErrNotFound = errors.New("record not found")
+
+// ErrForbidden is returned when the role of the user on the planner does not
+// allow the change
+var ErrForbidden = errors.New("not allowed for the role on the planner")
+
+// ErrConflict is returned when a change cannot be undone before a later change of
+// the same rows
+var ErrConflict = errors.New("a later change of the same records must be undone first")
+
+// GormDB is the Repository on Postgres, or on SQLite for local development and the tests.
+type GormDB struct {
 	db     *gorm.DB
 	logger *zerolog.Logger
 }
 
-func NewPostgresDB(logger *zerolog.Logger) (*PostgresDB, error) {
+func NewPostgresDB(logger *zerolog.Logger) (*GormDB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=America/Los_Angeles",
 		"db-dev",
@@ -27,27 +46,148 @@ func NewPostgresDB(logger *zerolog.Logger) (*PostgresDB, error) {
 		"gops_db",
 		5432,
 	)
+	return OpenPostgresDB(dsn, logger)
+}
+
+// OpenPostgresDB connects to the database and migrates the schema.
+func OpenPostgresDB(dsn string, logger *zerolog.Logger) (*GormDB, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
-	err = db.AutoMigrate(
+	return newGormDB(db, logger)
+}
+
+// OpenSQLiteDB opens the SQLite database file, ":memory:" for a database that is
+// removed on exit, and migrates the schema.
+func OpenSQLiteDB(path string, logger *zerolog.Logger) (*GormDB, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	// times are read back in the local zone like on Postgres
+	sqlDB, err := sql.Open("sqlite3", path+sep+"_loc=auto&_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" || strings.Contains(path, "mode=memory") {
+		// an in-memory database only lives as long as its connection
+		sqlDB.SetMaxOpenConns(1)
+	}
+	db, err := gorm.Open(sqlite.Dialector{Conn: &utcConnPool{sqlDB}}, &gorm.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	logger.Info().Msgf("opened sqlite database %s", path)
+	return newGormDB(db, logger)
+}
+
+func newGormDB(db *gorm.DB, logger *zerolog.Logger) (*GormDB, error) {
+	err := db.AutoMigrate(
 		&User{},
+		&Planner{},
 		&RangeTransaction{},
 		&ExpandedTransaction{},
+		&Session{},
+		&APIToken{},
+		&Category{},
+		&Account{},
+		&PlannerMember{},
+		&PlannerInvite{},
+		&AuditEntry{},
 	)
 	if err != nil {
 		return nil, err
 	}
+	// planners created before they could be shared are owned by their user,
+	// the WHERE keeps SQLite from reading ON CONFLICT as a join constraint
+	err = db.Exec(`INSERT INTO planner_members (planner_id, user_id, role, created_at)
+		SELECT id, user_id, ?, created_at FROM planners WHERE true ON CONFLICT DO NOTHING`, RoleOwner).Error
+	if err != nil {
+		return nil, err
+	}
 	dbname := db.Migrator().CurrentDatabase()
 	tables, _ := db.Migrator().GetTables()
 	logger.Info().Strs("tables", tables).Msgf("connected to database %s", dbname)
+	return &GormDB{db, logger}, nil
+}
+
+// sqliteTimeFormat has a fixed width so the times SQLite keeps as text sort like
+// the instants they are when they are all in UTC.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// utcArgs writes the time arguments of a statement in UTC with sqliteTimeFormat.
+// SQLite compares the stored times as text, so times written in the local zone
+// would compare wrong across offsets such as a DST change.
+func utcArgs(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		v := arg
+		if valuer, ok := arg.(driver.Valuer); ok {
+			v, _ = valuer.Value()
+		}
+		switch t := v.(type) {
+		case time.Time:
+			out[i] = t.UTC().Format(sqliteTimeFormat)
+		case *time.Time:
+			if t != nil {
+				out[i] = t.UTC().Format(sqliteTimeFormat)
+			}
+		}
+	}
+	return out
+}
+
+// utcConnPool is the SQLite connection pool of gorm with the times of every
+// statement written by utcArgs.
+type utcConnPool struct {
+	*sql.DB
+}
+
+func (p *utcConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.DB.ExecContext(ctx, query, utcArgs(args)...)
+}
+
+func (p *utcConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.DB.QueryContext(ctx, query, utcArgs(args)...)
+}
+
+func (p *utcConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.DB.QueryRowContext(ctx, query, utcArgs(args)...)
+}
+
+func (p *utcConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &utcTx{tx}, nil
+}
+
+func (p *utcConnPool) GetDBConn() (*sql.DB, error) {
+	return p.DB, nil
+}
+
+// utcTx is a transaction of utcConnPool.
+type utcTx struct {
+	*sql.Tx
+}
+
+func (tx *utcTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, query, utcArgs(args)...)
+}
+
+func (tx *utcTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, query, utcArgs(args)...)
+}
 
-	db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp";`)
-	return &PostgresDB{db, logger}, nil
+func (tx *utcTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, query, utcArgs(args)...)
 }
 
-func (r *PostgresDB) AddUser(ID uuid.UUID, username, passwordHash, passwordSalt string) error {
+func (r *GormDB) AddUser(ID uuid.UUID, username, passwordHash, passwordSalt string) error {
 	newUser := &User{
 		ID:           ID,
 		Username:     username,
@@ -57,235 +197,931 @@ func (r *PostgresDB) AddUser(ID uuid.UUID, username, passwordHash, passwordSalt
 	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(newUser).Error
 }
 
-func (r *PostgresDB) GetUser(username string) (*User, error) {
+func (r *GormDB) GetUser(username string) (*User, error) {
 	var user User
 	result := r.db.Where("username = ?", username).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &user, nil
 }
 
-func (r *PostgresDB) CreateSignInSession(username string) (string, error) {
-	token := generateSecureToken(32)
-	result := r.db.Model(&User{}).
-		Where(
-			"username = ?",
-			username,
-		).Update("login_session_token", token)
+func (r *GormDB) UpdatePassword(userID uuid.UUID, passwordHash, passwordSalt string) error {
+	result := r.db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password_hash": passwordHash,
+		"password_salt": passwordSalt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormDB) SetCalendarToken(userID uuid.UUID, tokenHash string) error {
+	result := r.db.Model(&User{}).Where("id = ?", userID).Update("calendar_token_hash", tokenHash)
 	if result.Error != nil {
-		return "", result.Error
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormDB) GetCalendarTokenUser(tokenHash string) (*User, error) {
+	if tokenHash == "" {
+		return nil, ErrNotFound
 	}
-	return token, nil
+	var user User
+	err := r.db.Where("calendar_token_hash = ?", tokenHash).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *GormDB) DeleteUser(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		owned := tx.Model(&Planner{}).Select("id").Where("user_id = ?", userID)
+		for _, model := range []interface{}{
+			&ExpandedTransaction{},
+			&RangeTransaction{},
+			&Account{},
+			&PlannerMember{},
+			&PlannerInvite{},
+			&AuditEntry{},
+		} {
+			if err := tx.Where("planner_id IN (?)", owned).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{
+			&Planner{},
+			&PlannerMember{},
+			&Session{},
+			&APIToken{},
+			&Category{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Where("id = ?", userID).Delete(&User{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *GormDB) AddSession(session *Session) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND expires_at <= ?", session.UserID, time.Now()).
+			Delete(&Session{})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(session).Error
+	})
 }
 
-func (r *PostgresDB) IsSignInTokenValid(username string, token string) (bool, error) {
+func (r *GormDB) GetSession(tokenHash string, now time.Time) (*Session, *User, error) {
+	var session Session
 	var user User
-	result := r.db.Where(
-		"username = ? AND login_session_token = ?",
-		username,
-		token,
-	).First(&user)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("token_hash = ? AND expires_at > ?", tokenHash, now).First(&session)
+		if result.Error != nil {
+			return result.Error
+		}
+		session.LastSeenAt = now
+		result = tx.Model(&session).Update("last_seen_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("id = ?", session.UserID).First(&user).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &session, &user, nil
+}
+
+func (r *GormDB) ListSessions(userID uuid.UUID) ([]Session, error) {
+	var sessions []Session
+	result := r.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
 	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			return false, nil
-		}
-		return false, result.Error
-	}
-	return true, nil
-}
-
-func (r *PostgresDB) DeleteSignInToken(username string) error {
-	result := r.db.Model(&User{}).
-		Where("username = ?", username).
-		Update("login_session_token", "")
-	return result.Error
-}
-
-func (r *PostgresDB) addExpandedTransactionsForRangeTransaction(rtx *RangeTransaction) error {
-	// FIXME memory error & use transaction+rollback
-	// add the respective expanded transactions
-	var expandedTransactions []ExpandedTransaction
-	query := `
-		SELECT *
-		FROM (
-			SELECT 
-				uuid_generate_v4() as id, 
-				id as range_transaction_id, 
-				user_id, 
-				planner_id,
-				title,
-				generate_series(
-					date_trunc('day', recurrence_start),
-					date_trunc('day', recurrence_end),
-					'1 day'::interval * recurrence_every_days
-				)::date AS transaction_date,
-				income_or_expense,
-				category, 
-				amount,
-				NOW() as created_at, 
-				NOW() as updated_at
-			FROM 
-				range_transactions
-			WHERE
-				id = ?
-		) expanded_txns
-		ORDER BY 
-			expanded_txns.transaction_date ASC
-	`
-	if err := r.db.Raw(query, rtx.ID).Scan(&expandedTransactions).Error; err != nil {
-		return err
+		return nil, result.Error
+	}
+	return sessions, nil
+}
+
+func (r *GormDB) DeleteSession(userID, sessionID uuid.UUID) error {
+	result := r.db.Where("id = ? AND user_id = ?", sessionID, userID).
+		Delete(&Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
 	}
+	return nil
+}
+
+func (r *GormDB) DeleteSessions(userID uuid.UUID) error {
+	return r.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+func (r *GormDB) AddAPIToken(token *APIToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *GormDB) GetAPITokenUser(tokenHash string) (*User, error) {
+	var user User
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var token APIToken
+		result := tx.Where("token_hash = ?", tokenHash).First(&token)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Model(&token).Update("last_used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("id = ?", token.UserID).First(&user).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
 
-	result := r.db.Clauses().
-		Create(expandedTransactions)
+func (r *GormDB) ListAPITokens(userID uuid.UUID) ([]APIToken, error) {
+	var tokens []APIToken
+	result := r.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
 
+func (r *GormDB) DeleteAPIToken(userID, tokenID uuid.UUID) error {
+	result := r.db.Where("id = ? AND user_id = ?", tokenID, userID).
+		Delete(&APIToken{})
 	if result.Error != nil {
 		return result.Error
 	}
-	r.logger.Info().Msgf("added %d expanded transactions for range txn %s",
-		len(expandedTransactions), rtx.ID)
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
-func (r *PostgresDB) AddRangeTransaction(rtx *RangeTransaction) error {
-	result := r.db.First(rtx, "id = ?", rtx.ID)
-	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		r.logger.Warn().Msgf("ignoring insert of range txn with id %s because it exists", rtx.ID)
-		return nil
+func (r *GormDB) AddCategory(category *Category) error {
+	return r.db.Create(category).Error
+}
+
+func (r *GormDB) ListCategories(userID uuid.UUID) ([]Category, error) {
+	var categories []Category
+	result := r.db.Where("user_id = ?", userID).
+		Order("name").
+		Find(&categories)
+	if result.Error != nil {
+		return nil, result.Error
 	}
-	result = r.db.Create(rtx)
+	return categories, nil
+}
+
+func (r *GormDB) UpdateCategory(category *Category) error {
+	result := r.db.Model(&Category{}).
+		Where("id = ? AND user_id = ?", category.ID, category.UserID).
+		Updates(map[string]interface{}{
+			"parent_id":      category.ParentID,
+			"name":           category.Name,
+			"color":          category.Color,
+			"monthly_budget": category.MonthlyBudget,
+		})
 	if result.Error != nil {
 		return result.Error
 	}
-	return r.addExpandedTransactionsForRangeTransaction(rtx)
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *GormDB) DeleteCategory(userID, categoryID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Category{}).
+			Where("parent_id = ? AND user_id = ?", categoryID, userID).
+			Update("parent_id", uuid.Nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Where("id = ? AND user_id = ?", categoryID, userID).Delete(&Category{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
 }
 
-func (db *PostgresDB) UpdateRangeTransaction(rangeTransactionID uuid.UUID, newValue *RangeTransaction) error {
-	tx := db.db.Begin()
+func (r *GormDB) AddPlanner(p *Planner) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return createPlanner(tx, p)
+	})
+}
 
-	if err := tx.Error; err != nil {
+// createPlanner adds the planner with its user as the owner.
+func createPlanner(tx *gorm.DB, p *Planner) error {
+	if err := tx.Create(p).Error; err != nil {
 		return err
 	}
+	p.Role = RoleOwner
+	return tx.Create(&PlannerMember{PlannerID: p.ID, UserID: p.UserID, Role: RoleOwner}).Error
+}
 
-	var rangeTx RangeTransaction
+// requireRole returns the role of the user on the planner. It returns ErrNotFound
+// when the user is not a member of the planner and ErrForbidden when the role is
+// not one of roles.
+func (r *GormDB) requireRole(tx *gorm.DB, userID, plannerID uuid.UUID, roles []string) (string, error) {
+	var member PlannerMember
+	err := tx.Where("planner_id = ? AND user_id = ?", plannerID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !slices.Contains(roles, member.Role) {
+		return "", ErrForbidden
+	}
+	return member.Role, nil
+}
 
-	if err := tx.Where("id = ?", rangeTransactionID).First(&rangeTx).Error; err != nil {
-		tx.Rollback()
-		return err
+func (r *GormDB) GetPlanner(userID, plannerID uuid.UUID) (*Planner, error) {
+	role, err := r.requireRole(r.db, userID, plannerID, readRoles)
+	if err != nil {
+		return nil, err
+	}
+	var planner Planner
+	result := r.db.Where("id = ?", plannerID).First(&planner)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	planner.Role = role
+	return &planner, nil
+}
+
+func (r *GormDB) ListPlanners(userID uuid.UUID) ([]Planner, error) {
+	var members []PlannerMember
+	if err := r.db.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := map[uuid.UUID]string{}
+	plannerIDs := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		roles[m.PlannerID] = m.Role
+		plannerIDs = append(plannerIDs, m.PlannerID)
+	}
+	var planners []Planner
+	result := r.db.Where("id IN ?", plannerIDs).
+		Order("updated_at DESC").
+		Find(&planners)
+	if result.Error != nil {
+		return nil, result.Error
 	}
+	for i := range planners {
+		planners[i].Role = roles[planners[i].ID]
+	}
+	return planners, nil
+}
+
+func (r *GormDB) RenamePlanner(userID, plannerID uuid.UUID, name string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		var before Planner
+		if err := tx.First(&before, "id = ?", plannerID).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Planner{}).Where("id = ?", plannerID).Update("name", name)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no record updated")
+		}
+		var after Planner
+		if err := tx.First(&after, "id = ?", plannerID).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, userID, plannerID, AuditUpdate, EntityPlanner, plannerID, name,
+			AuditState{Planner: &before}, AuditState{Planner: &after})
+	})
+}
 
-	rangeTx.Title = newValue.Title
-	rangeTx.IncomeOrExpense = newValue.IncomeOrExpense
-	rangeTx.Category = newValue.Category
-	rangeTx.Notes = newValue.Notes
-	rangeTx.RecurrenceEveryDays = newValue.RecurrenceEveryDays
-	rangeTx.RecurrenceStart = newValue.RecurrenceStart
-	rangeTx.RecurrenceEnd = newValue.RecurrenceEnd
-	rangeTx.Amount = newValue.Amount
+// DuplicatePlanner copies the planner and all of its transactions to a new planner
+// with the given ID and name. Any member can copy a planner and owns the copy.
+func (r *GormDB) DuplicatePlanner(userID, plannerID, newPlannerID uuid.UUID, name string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return r.copyPlanner(tx, userID, plannerID, newPlannerID, name, false)
+	})
+}
+
+func (r *GormDB) ForkPlanner(userID, plannerID, scenarioID uuid.UUID, name string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return r.copyPlanner(tx, userID, plannerID, scenarioID, name, true)
+	})
+}
 
-	if err := tx.Save(&rangeTx).Error; err != nil {
-		tx.Rollback()
+// copyPlanner copies the planner with its accounts and transactions, a scenario
+// remembers the planner and the range transactions it copied.
+func (r *GormDB) copyPlanner(tx *gorm.DB, userID, plannerID, newPlannerID uuid.UUID, name string, scenario bool) error {
+	if _, err := r.requireRole(tx, userID, plannerID, readRoles); err != nil {
 		return err
 	}
-
-	// remove old expanded transactions
-	if err := tx.Where(
-		"range_transaction_id = ?",
-		rangeTransactionID,
-	).Delete(&ExpandedTransaction{}).Error; err != nil {
-		tx.Rollback()
+	var planner Planner
+	if err := tx.Where("id = ?", plannerID).First(&planner).Error; err != nil {
+		return err
+	}
+	planner.ID = newPlannerID
+	planner.UserID = userID
+	planner.Name = name
+	planner.ParentID = uuid.Nil
+	if scenario {
+		planner.ParentID = plannerID
+	}
+	planner.CreatedAt = time.Time{}
+	planner.UpdatedAt = time.Time{}
+	if err := createPlanner(tx, &planner); err != nil {
 		return err
 	}
 
-	// TODO use a transaction everywhere
-	if tx.Commit().Error != nil {
-		err := db.addExpandedTransactionsForRangeTransaction(&rangeTx)
-		if err != nil {
+	var accounts []Account
+	if err := tx.Where("planner_id = ?", plannerID).Find(&accounts).Error; err != nil {
+		return err
+	}
+	newAccountIDs := duplicateAccounts(accounts, newPlannerID)
+	for i := range accounts {
+		accounts[i].UserID = userID
+	}
+	if len(accounts) > 0 {
+		if err := tx.Create(&accounts).Error; err != nil {
 			return err
 		}
-		return nil
 	}
-	return tx.Commit().Error
-}
 
-func (r *PostgresDB) DeleteRangeTransaction(userID, plannerID, rangeTransactionID uuid.UUID) error {
-	tx := r.db.Begin()
-	if err := tx.Error; err != nil {
+	var rangeTxns []RangeTransaction
+	if err := tx.Where("planner_id = ?", plannerID).Find(&rangeTxns).Error; err != nil {
 		return err
 	}
+	newRangeIDs := map[uuid.UUID]uuid.UUID{}
+	for i := range rangeTxns {
+		rangeTxns[i].ForkedFromID = uuid.Nil
+		if scenario {
+			rangeTxns[i].ForkedFromID = rangeTxns[i].ID
+		}
+		newID, _ := uuid.NewV4()
+		newRangeIDs[rangeTxns[i].ID] = newID
+		rangeTxns[i].ID = newID
+		rangeTxns[i].PlannerID = newPlannerID
+		rangeTxns[i].UserID = userID
+		rangeTxns[i].AccountID = newAccountIDs[rangeTxns[i].AccountID]
+		rangeTxns[i].ToAccountID = newAccountIDs[rangeTxns[i].ToAccountID]
+	}
+	if len(rangeTxns) > 0 {
+		if err := tx.Create(&rangeTxns).Error; err != nil {
+			return err
+		}
+	}
 
-	if err := tx.Where(
-		"id = ? AND user_id = ? AND planner_id = ?",
-		rangeTransactionID, userID, plannerID,
-	).Delete(&RangeTransaction{}).Error; err != nil {
-		tx.Rollback()
+	var expandedTxns []ExpandedTransaction
+	if err := tx.Where("planner_id = ?", plannerID).Find(&expandedTxns).Error; err != nil {
 		return err
 	}
+	for i := range expandedTxns {
+		expandedTxns[i].ID, _ = uuid.NewV4()
+		expandedTxns[i].PlannerID = newPlannerID
+		expandedTxns[i].UserID = userID
+		expandedTxns[i].AccountID = newAccountIDs[expandedTxns[i].AccountID]
+		expandedTxns[i].ToAccountID = newAccountIDs[expandedTxns[i].ToAccountID]
+		if expandedTxns[i].RangeTransactionID != uuid.Nil {
+			expandedTxns[i].RangeTransactionID = newRangeIDs[expandedTxns[i].RangeTransactionID]
+		}
+	}
+	if len(expandedTxns) > 0 {
+		if err := tx.CreateInBatches(&expandedTxns, 500).Error; err != nil {
+			return err
+		}
+	}
+	r.logger.Info().Msgf("copied planner %s to %s with %d range and %d expanded transactions",
+		plannerID, newPlannerID, len(rangeTxns), len(expandedTxns))
+	return nil
+}
+
+func (r *GormDB) RestorePlanner(p *Planner, accounts []Account, rangeTxns []RangeTransaction, txns []ExpandedTransaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := createPlanner(tx, p); err != nil {
+			return err
+		}
+		if len(accounts) > 0 {
+			if err := tx.Create(&accounts).Error; err != nil {
+				return err
+			}
+		}
+		if len(txns) > 0 {
+			if err := tx.CreateInBatches(&txns, 500).Error; err != nil {
+				return err
+			}
+		}
+		for i := range rangeTxns {
+			if err := tx.Create(&rangeTxns[i]).Error; err != nil {
+				return err
+			}
+			if err := r.addExpandedTransactionsForRangeTransaction(tx, &rangeTxns[i]); err != nil {
+				return err
+			}
+		}
+		r.logger.Info().Msgf("restored planner %s with %d range and %d one-time or edited transactions",
+			p.ID, len(rangeTxns), len(txns))
+		return nil
+	})
+}
+
+// DeletePlanner removes the planner with all of its transactions and members.
+func (r *GormDB) DeletePlanner(userID, plannerID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, ownerRoles); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&ExpandedTransaction{},
+			&RangeTransaction{},
+			&Account{},
+			&PlannerMember{},
+			&PlannerInvite{},
+			&AuditEntry{},
+		} {
+			if err := tx.Where("planner_id = ?", plannerID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Where("id = ?", plannerID).Delete(&Planner{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no record deleted")
+		}
+		return nil
+	})
+}
 
-	if err := tx.Where(
-		"range_transaction_id = ?",
-		rangeTransactionID,
-	).Delete(&ExpandedTransaction{}).Error; err != nil {
-		tx.Rollback()
+// addExpandedTransactionsForRangeTransaction adds the occurrences of the range transaction
+// with tx. Dates with an override keep it and overrides for dates no longer in the
+// series are removed.
+func (r *GormDB) addExpandedTransactionsForRangeTransaction(tx *gorm.DB, rtx *RangeTransaction) error {
+	var overrides []ExpandedTransaction
+	if err := tx.Where("range_transaction_id = ? AND is_override", rtx.ID).Find(&overrides).Error; err != nil {
 		return err
 	}
+	generated, stale := rtx.ExpandWithOverrides(overrides)
+	for _, o := range stale {
+		if err := tx.Delete(&ExpandedTransaction{}, "id = ?", o.ID).Error; err != nil {
+			return err
+		}
+	}
+	if len(generated) > 0 {
+		if err := tx.CreateInBatches(&generated, 500).Error; err != nil {
+			return err
+		}
+	}
+	r.logger.Info().Msgf("added %d expanded transactions for range txn %s",
+		len(generated), rtx.ID)
+	return nil
+}
 
-	return tx.Commit().Error
+func (r *GormDB) AddAccount(account *Account) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, account.UserID, account.PlannerID, editRoles); err != nil {
+			return err
+		}
+		if err := tx.Create(account).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, account.UserID, account.PlannerID, AuditCreate, EntityAccount, account.ID, account.Name,
+			AuditState{}, AuditState{Accounts: []Account{*account}})
+	})
 }
 
-func (r *PostgresDB) ListRangeTransactions(userID, plannerID uuid.UUID) ([]RangeTransaction, error) {
-	var rangeTransactions []RangeTransaction
-	result := r.db.Where("user_id = ? AND planner_id = ?", userID, plannerID).
-		Find(&rangeTransactions).
-		Order("updated_at DESC")
+func (r *GormDB) ListAccounts(userID, plannerID uuid.UUID) ([]Account, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	result := r.db.Where("planner_id = ?", plannerID).
+		Order("created_at").
+		Find(&accounts)
 	if result.Error != nil {
 		return nil, result.Error
 	}
-	return rangeTransactions, nil
+	return accounts, nil
 }
 
-func (r *PostgresDB) AddExpandedTransaction(etx *ExpandedTransaction) error {
-	return r.db.Save(etx).Error
+func (r *GormDB) UpdateAccount(account *Account) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, account.UserID, account.PlannerID, editRoles); err != nil {
+			return err
+		}
+		var before []Account
+		if err := tx.Where("id = ? AND planner_id = ?", account.ID, account.PlannerID).Find(&before).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Account{}).
+			Where("id = ? AND planner_id = ?", account.ID, account.PlannerID).
+			Updates(map[string]interface{}{
+				"name":               account.Name,
+				"kind":               account.Kind,
+				"opening_balance":    account.OpeningBalance,
+				"statement_day":      account.StatementDay,
+				"payment_due_days":   account.PaymentDueDays,
+				"payment_account_id": account.PaymentAccountID,
+				"interest_percent":   account.InterestPercent,
+				"compounding":        account.Compounding,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		var after []Account
+		if err := tx.Where("id = ?", account.ID).Find(&after).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, account.UserID, account.PlannerID, AuditUpdate, EntityAccount, account.ID, account.Name,
+			AuditState{Accounts: before}, AuditState{Accounts: after})
+	})
 }
 
-func (r *PostgresDB) UpdateExpandedTransaction(expandedTransactionID uuid.UUID, newValue *ExpandedTransaction) error {
-	result := r.db.Model(&ExpandedTransaction{}).
-		Where("id = ?", expandedTransactionID).
-		Updates(map[string]interface{}{
-			"title":             newValue.Title,
-			"transaction_date":  newValue.TransactionDate,
-			"income_or_expense": newValue.IncomeOrExpense,
-			"category":          newValue.Category,
-			"amount":            newValue.Amount,
-			"updated_at":        time.Now(),
-		})
+func (r *GormDB) DeleteAccount(userID, plannerID, accountID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		before, err := accountState(tx, plannerID, accountID)
+		if err != nil {
+			return err
+		}
+		if len(before.Accounts) == 0 || before.Accounts[0].ID != accountID {
+			return ErrNotFound
+		}
+		for _, model := range []interface{}{&RangeTransaction{}, &ExpandedTransaction{}} {
+			for _, column := range []string{"account_id", "to_account_id"} {
+				result := tx.Model(model).
+					Where(column+" = ? AND planner_id = ?", accountID, plannerID).
+					Update(column, uuid.Nil)
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+		}
+		result := tx.Model(&Account{}).
+			Where("payment_account_id = ? AND planner_id = ?", accountID, plannerID).
+			Update("payment_account_id", uuid.Nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Where("id = ? AND planner_id = ?", accountID, plannerID).
+			Delete(&Account{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		after, err := reloadState(tx, before)
+		if err != nil {
+			return err
+		}
+		return r.audit(tx, userID, plannerID, AuditDelete, EntityAccount, accountID, before.Accounts[0].Name, before, after)
+	})
+}
 
-	if result.Error != nil {
-		return result.Error
+// AddRangeTransaction saves the range transaction and its occurrences in one
+// database transaction so a failed expansion does not leave a range behind.
+func (r *GormDB) AddRangeTransaction(rtx *RangeTransaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, rtx.UserID, rtx.PlannerID, editRoles); err != nil {
+			return err
+		}
+		result := tx.First(&RangeTransaction{}, "id = ?", rtx.ID)
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			r.logger.Warn().Msgf("ignoring insert of range txn with id %s because it exists", rtx.ID)
+			return result.Error
+		}
+		if err := tx.Create(rtx).Error; err != nil {
+			return err
+		}
+		if err := r.addExpandedTransactionsForRangeTransaction(tx, rtx); err != nil {
+			return err
+		}
+		after, err := rangeTransactionState(tx, rtx.PlannerID, rtx.ID)
+		if err != nil {
+			return err
+		}
+		return r.audit(tx, rtx.UserID, rtx.PlannerID, AuditCreate, EntityRangeTransaction, rtx.ID, rtx.Title, AuditState{}, after)
+	})
+}
+
+func (r *GormDB) GetRangeTransaction(userID, plannerID, rangeTransactionID uuid.UUID) (*RangeTransaction, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("no record updated")
+	var rangeTx RangeTransaction
+	result := r.db.Where("id = ? AND planner_id = ?", rangeTransactionID, plannerID).First(&rangeTx)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
 	}
-	return nil
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &rangeTx, nil
+}
+
+// UpdateRangeTransaction saves the new values of the range transaction, the
+// UserID of newValue is the user making the change. The occurrences are
+// regenerated when the recurrence changes, otherwise the generated occurrences
+// get the new values. Edited occurrences are kept in both cases.
+func (r *GormDB) UpdateRangeTransaction(rangeTransactionID uuid.UUID, newValue *RangeTransaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, newValue.UserID, newValue.PlannerID, editRoles); err != nil {
+			return err
+		}
+		before, err := rangeTransactionState(tx, newValue.PlannerID, rangeTransactionID)
+		if err != nil {
+			return err
+		}
+		var rangeTx RangeTransaction
+		if err := tx.Where(
+			"id = ? AND planner_id = ?",
+			rangeTransactionID, newValue.PlannerID,
+		).First(&rangeTx).Error; err != nil {
+			return err
+		}
+		recurrenceChanged := !rangeTx.recurrenceEqual(newValue)
+
+		rangeTx.Title = newValue.Title
+		rangeTx.IncomeOrExpense = newValue.IncomeOrExpense
+		rangeTx.AccountID = newValue.AccountID
+		rangeTx.ToAccountID = newValue.ToAccountID
+		rangeTx.Category = newValue.Category
+		rangeTx.Notes = newValue.Notes
+		rangeTx.RecurrenceEveryDays = newValue.RecurrenceEveryDays
+		rangeTx.RecurrenceStart = newValue.RecurrenceStart
+		rangeTx.RecurrenceEnd = newValue.RecurrenceEnd
+		rangeTx.Recurrence = newValue.Recurrence
+		rangeTx.Amount = newValue.Amount
+		rangeTx.Growth = newValue.Growth
+		rangeTx.Uncertainty = newValue.Uncertainty
+
+		if err := tx.Save(&rangeTx).Error; err != nil {
+			return err
+		}
+
+		generated := tx.Where("range_transaction_id = ? AND NOT is_override", rangeTransactionID)
+		if !recurrenceChanged {
+			err = generated.Model(&ExpandedTransaction{}).Updates(map[string]interface{}{
+				"title":                              rangeTx.Title,
+				"income_or_expense":                  rangeTx.IncomeOrExpense,
+				"account_id":                         rangeTx.AccountID,
+				"to_account_id":                      rangeTx.ToAccountID,
+				"category":                           rangeTx.Category,
+				"amount":                             rangeTx.Amount,
+				"uncertainty_amount_std_dev_percent": rangeTx.Uncertainty.AmountStdDevPercent,
+				"uncertainty_skip_percent":           rangeTx.Uncertainty.SkipPercent,
+				"uncertainty_date_jitter_days":       rangeTx.Uncertainty.DateJitterDays,
+				"updated_at":                         time.Now(),
+			}).Error
+		} else if err = generated.Delete(&ExpandedTransaction{}).Error; err == nil {
+			err = r.addExpandedTransactionsForRangeTransaction(tx, &rangeTx)
+		}
+		if err != nil {
+			return err
+		}
+		after, err := rangeTransactionState(tx, newValue.PlannerID, rangeTransactionID)
+		if err != nil {
+			return err
+		}
+		return r.audit(tx, newValue.UserID, newValue.PlannerID, AuditUpdate, EntityRangeTransaction, rangeTransactionID,
+			rangeTx.Title, before, after)
+	})
 }
 
-func (db *PostgresDB) DeleteExpandedTransaction(userID, plannerID, expandedTransactionID uuid.UUID) error {
-	result := db.db.Where(`id = ?`, expandedTransactionID).
-		Delete(&ExpandedTransaction{})
+func (r *GormDB) DeleteRangeTransaction(userID, plannerID, rangeTransactionID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		before, err := rangeTransactionState(tx, plannerID, rangeTransactionID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where(
+			"id = ? AND planner_id = ?",
+			rangeTransactionID, plannerID,
+		).Delete(&RangeTransaction{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where(
+			"range_transaction_id = ? AND planner_id = ?",
+			rangeTransactionID, plannerID,
+		).Delete(&ExpandedTransaction{}).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, userID, plannerID, AuditDelete, EntityRangeTransaction, rangeTransactionID,
+			before.RangeTransactions[0].Title, before, AuditState{})
+	})
+}
 
+func (r *GormDB) ListRangeTransactions(userID, plannerID uuid.UUID) ([]RangeTransaction, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var rangeTransactions []RangeTransaction
+	result := r.db.Where("planner_id = ?", plannerID).
+		Order("updated_at DESC").
+		Find(&rangeTransactions)
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("no record deleted")
+	return rangeTransactions, nil
+}
+
+// AddExpandedTransaction adds the one-time transaction. An ID that is taken fails
+// rather than replacing the row, which may belong to another planner.
+func (r *GormDB) AddExpandedTransaction(etx *ExpandedTransaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, etx.UserID, etx.PlannerID, editRoles); err != nil {
+			return err
+		}
+		if err := tx.Create(etx).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, etx.UserID, etx.PlannerID, AuditCreate, EntityExpandedTransaction, etx.ID, etx.Title,
+			AuditState{}, AuditState{ExpandedTransactions: []ExpandedTransaction{*etx}})
+	})
+}
+
+func (r *GormDB) GetExpandedTransaction(userID, plannerID, expandedTransactionID uuid.UUID) (*ExpandedTransaction, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
 	}
-	return nil
+	var etx ExpandedTransaction
+	result := r.db.Where("id = ? AND planner_id = ?", expandedTransactionID, plannerID).First(&etx)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &etx, nil
+}
+
+// UpdateExpandedTransaction saves the new values of the one-time transaction or
+// series occurrence, the UserID of newValue is the user making the change. An
+// edited occurrence becomes an override of its series date.
+func (r *GormDB) UpdateExpandedTransaction(expandedTransactionID uuid.UUID, newValue *ExpandedTransaction) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, newValue.UserID, newValue.PlannerID, editRoles); err != nil {
+			return err
+		}
+		var before []ExpandedTransaction
+		if err := tx.Where("id = ? AND planner_id = ?", expandedTransactionID, newValue.PlannerID).Find(&before).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&ExpandedTransaction{}).
+			Where("id = ? AND planner_id = ?", expandedTransactionID, newValue.PlannerID).
+			Updates(map[string]interface{}{
+				"title":                              newValue.Title,
+				"transaction_date":                   newValue.TransactionDate,
+				"income_or_expense":                  newValue.IncomeOrExpense,
+				"account_id":                         newValue.AccountID,
+				"to_account_id":                      newValue.ToAccountID,
+				"category":                           newValue.Category,
+				"amount":                             newValue.Amount,
+				"uncertainty_amount_std_dev_percent": newValue.Uncertainty.AmountStdDevPercent,
+				"uncertainty_skip_percent":           newValue.Uncertainty.SkipPercent,
+				"uncertainty_date_jitter_days":       newValue.Uncertainty.DateJitterDays,
+				"is_override":                        gorm.Expr("range_transaction_id <> ?", uuid.Nil),
+				"updated_at":                         time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no record updated")
+		}
+		var after []ExpandedTransaction
+		if err := tx.Where("id = ?", expandedTransactionID).Find(&after).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, newValue.UserID, newValue.PlannerID, AuditUpdate, EntityExpandedTransaction, expandedTransactionID,
+			newValue.Title, AuditState{ExpandedTransactions: before}, AuditState{ExpandedTransactions: after})
+	})
+}
+
+func (db *GormDB) DeleteExpandedTransaction(userID, plannerID, expandedTransactionID uuid.UUID) error {
+	return db.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := db.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		var before []ExpandedTransaction
+		if err := tx.Where("id = ? AND planner_id = ?", expandedTransactionID, plannerID).Find(&before).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND planner_id = ?", expandedTransactionID, plannerID).
+			Delete(&ExpandedTransaction{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no record deleted")
+		}
+		return db.audit(tx, userID, plannerID, AuditDelete, EntityExpandedTransaction, expandedTransactionID,
+			before[0].Title, AuditState{ExpandedTransactions: before}, AuditState{})
+	})
+}
+
+// AddImportedTransactions adds the transactions whose ImportID is not in the
+// planner yet and returns how many were added.
+func (r *GormDB) AddImportedTransactions(userID, plannerID uuid.UUID, txns []ExpandedTransaction) (int, error) {
+	var added int
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		importIDs := make([]string, 0, len(txns))
+		for _, etx := range txns {
+			importIDs = append(importIDs, etx.ImportID)
+		}
+		var existing []string
+		if err := tx.Model(&ExpandedTransaction{}).
+			Where("planner_id = ? AND import_id IN ?", plannerID, importIDs).
+			Pluck("import_id", &existing).Error; err != nil {
+			return err
+		}
+		newTxns := slices.DeleteFunc(slices.Clone(txns), func(etx ExpandedTransaction) bool {
+			return slices.Contains(existing, etx.ImportID)
+		})
+		added = len(newTxns)
+		if added == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(&newTxns, 500).Error; err != nil {
+			return err
+		}
+		return r.audit(tx, userID, plannerID, AuditImport, EntityExpandedTransaction, uuid.Nil,
+			importedTitle(added), AuditState{}, AuditState{ExpandedTransactions: newTxns})
+	})
+	return added, err
 }
 
-func (db *PostgresDB) ListExpandedTransactions(userID, plannerID uuid.UUID) ([]ExpandedTransaction, error) {
+func (db *GormDB) ListExpandedTransactions(userID, plannerID uuid.UUID) ([]ExpandedTransaction, error) {
+	if _, err := db.requireRole(db.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
 	var transactions []ExpandedTransaction
-	result := db.db.Where("user_id = ? AND planner_id = ?", userID, plannerID).
+	result := db.db.Where("planner_id = ?", plannerID).
 		Find(&transactions)
 
 	if result.Error != nil {
@@ -293,3 +1129,390 @@ func (db *PostgresDB) ListExpandedTransactions(userID, plannerID uuid.UUID) ([]E
 	}
 	return transactions, nil
 }
+
+func (db *GormDB) ListExpandedTransactionsBetween(userID, plannerID uuid.UUID, start, end time.Time) ([]ExpandedTransaction, error) {
+	if _, err := db.requireRole(db.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var transactions []ExpandedTransaction
+	result := db.db.Where(
+		"planner_id = ? AND transaction_date >= ? AND transaction_date < ?",
+		plannerID, truncateDay(start), truncateDay(end).AddDate(0, 0, 1),
+	).Order("transaction_date").Find(&transactions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return transactions, nil
+}
+
+func (db *GormDB) ExpandedTransactionTotalsBefore(userID, plannerID uuid.UUID, before time.Time) (map[uuid.UUID]float64, error) {
+	if _, err := db.requireRole(db.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var sums []struct {
+		AccountID       uuid.UUID
+		ToAccountID     uuid.UUID
+		IncomeOrExpense string
+		Amount          float64
+	}
+	result := db.db.Model(&ExpandedTransaction{}).
+		Select("account_id, to_account_id, income_or_expense, SUM(amount) AS amount").
+		Where("planner_id = ? AND transaction_date < ?", plannerID, truncateDay(before)).
+		Group("account_id, to_account_id, income_or_expense").
+		Scan(&sums)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	totals := map[uuid.UUID]float64{}
+	for _, sum := range sums {
+		addAccountTotal(totals, sum.AccountID, sum.ToAccountID, sum.IncomeOrExpense, sum.Amount)
+	}
+	return totals, nil
+}
+
+func (r *GormDB) ListPlannerMembers(userID, plannerID uuid.UUID) ([]PlannerMember, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var members []PlannerMember
+	result := r.db.Table("planner_members").
+		Select("planner_members.*, users.username").
+		Joins("JOIN users ON users.id = planner_members.user_id").
+		Where("planner_members.planner_id = ?", plannerID).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL: "planner_members.role <> ?, planner_members.created_at", Vars: []interface{}{RoleOwner},
+		}}).
+		Scan(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+func (r *GormDB) SetPlannerMember(userID uuid.UUID, member *PlannerMember) error {
+	if !slices.Contains(memberRoles, member.Role) {
+		return fmt.Errorf("invalid member role %q", member.Role)
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, member.PlannerID, ownerRoles); err != nil {
+			return err
+		}
+		if member.UserID == userID {
+			return ErrForbidden
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "planner_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).Create(member).Error
+	})
+}
+
+func (r *GormDB) DeletePlannerMember(userID, plannerID, memberID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		role, err := r.requireRole(tx, userID, plannerID, readRoles)
+		if err != nil {
+			return err
+		}
+		// the owner cannot leave its planner and the others can only leave
+		if (role == RoleOwner) == (memberID == userID) {
+			return ErrForbidden
+		}
+		result := tx.Where("planner_id = ? AND user_id = ?", plannerID, memberID).Delete(&PlannerMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *GormDB) AddPlannerInvite(userID uuid.UUID, invite *PlannerInvite) error {
+	if !slices.Contains(memberRoles, invite.Role) {
+		return fmt.Errorf("invalid member role %q", invite.Role)
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, invite.PlannerID, ownerRoles); err != nil {
+			return err
+		}
+		invite.CreatedBy = userID
+		return tx.Create(invite).Error
+	})
+}
+
+func (r *GormDB) ListPlannerInvites(userID, plannerID uuid.UUID) ([]PlannerInvite, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, ownerRoles); err != nil {
+		return nil, err
+	}
+	var invites []PlannerInvite
+	if err := r.db.Where("planner_id = ?", plannerID).Order("created_at").Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+func (r *GormDB) DeletePlannerInvite(userID, plannerID, inviteID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, ownerRoles); err != nil {
+			return err
+		}
+		result := tx.Where("id = ? AND planner_id = ?", inviteID, plannerID).Delete(&PlannerInvite{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *GormDB) GetPlannerInvite(tokenHash string, now time.Time) (*PlannerInvite, *Planner, error) {
+	var invite PlannerInvite
+	err := r.db.Where("token_hash = ? AND expires_at > ?", tokenHash, now).First(&invite).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var planner Planner
+	err = r.db.Where("id = ?", invite.PlannerID).First(&planner).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &invite, &planner, nil
+}
+
+func (r *GormDB) AcceptPlannerInvite(userID uuid.UUID, tokenHash string, now time.Time) (*Planner, error) {
+	var planner Planner
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var invite PlannerInvite
+		err := tx.Where("token_hash = ? AND expires_at > ?", tokenHash, now).First(&invite).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// the delete only succeeds once when the link is accepted twice at the same time
+		result := tx.Delete(&PlannerInvite{}, "id = ?", invite.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if _, err = r.requireRole(tx, userID, invite.PlannerID, readRoles); errors.Is(err, ErrNotFound) {
+			err = tx.Create(&PlannerMember{PlannerID: invite.PlannerID, UserID: userID, Role: invite.Role}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Where("id = ?", invite.PlannerID).First(&planner).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &planner, nil
+}
+
+// rangeTransactionState returns the range transaction with its occurrences.
+func rangeTransactionState(tx *gorm.DB, plannerID, rangeTransactionID uuid.UUID) (AuditState, error) {
+	var state AuditState
+	err := tx.Where("id = ? AND planner_id = ?", rangeTransactionID, plannerID).Find(&state.RangeTransactions).Error
+	if err != nil {
+		return state, err
+	}
+	if len(state.RangeTransactions) == 0 {
+		return state, ErrNotFound
+	}
+	err = tx.Where("range_transaction_id = ? AND planner_id = ?", rangeTransactionID, plannerID).
+		Order("transaction_date").
+		Find(&state.ExpandedTransactions).Error
+	return state, err
+}
+
+// accountState returns the account, first, with the accounts paid from it and
+// the transactions of the planner that refer to it.
+func accountState(tx *gorm.DB, plannerID, accountID uuid.UUID) (AuditState, error) {
+	var state AuditState
+	err := tx.Where("planner_id = ? AND (id = ? OR payment_account_id = ?)", plannerID, accountID, accountID).
+		Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "id <> ?", Vars: []interface{}{accountID}}}).
+		Find(&state.Accounts).Error
+	if err != nil {
+		return state, err
+	}
+	refersTo := "planner_id = ? AND (account_id = ? OR to_account_id = ?)"
+	if err = tx.Where(refersTo, plannerID, accountID, accountID).Find(&state.RangeTransactions).Error; err != nil {
+		return state, err
+	}
+	err = tx.Where(refersTo, plannerID, accountID, accountID).Find(&state.ExpandedTransactions).Error
+	return state, err
+}
+
+// reloadState returns the rows of the state as they are now, without the rows
+// that were deleted.
+func reloadState(tx *gorm.DB, state AuditState) (AuditState, error) {
+	var reloaded AuditState
+	if state.Planner != nil {
+		var planners []Planner
+		if err := tx.Where("id = ?", state.Planner.ID).Find(&planners).Error; err != nil {
+			return reloaded, err
+		}
+		if len(planners) > 0 {
+			reloaded.Planner = &planners[0]
+		}
+	}
+	if ids := accountIDs(state.Accounts); len(ids) > 0 {
+		if err := tx.Where("id IN ?", ids).Find(&reloaded.Accounts).Error; err != nil {
+			return reloaded, err
+		}
+	}
+	if ids := rangeTransactionIDs(state.RangeTransactions); len(ids) > 0 {
+		if err := tx.Where("id IN ?", ids).Find(&reloaded.RangeTransactions).Error; err != nil {
+			return reloaded, err
+		}
+	}
+	if ids := expandedTransactionIDs(state.ExpandedTransactions); len(ids) > 0 {
+		if err := tx.Where("id IN ?", ids).Find(&reloaded.ExpandedTransactions).Error; err != nil {
+			return reloaded, err
+		}
+	}
+	return reloaded, nil
+}
+
+// audit adds the entry of a change from before to after.
+func (r *GormDB) audit(tx *gorm.DB, userID, plannerID uuid.UUID, action, entity string, entityID uuid.UUID, title string, before, after AuditState) error {
+	entry, err := newAuditEntry(userID, plannerID, action, entity, entityID, title, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(entry).Error
+}
+
+func auditEntries(tx *gorm.DB, plannerID uuid.UUID) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := tx.Where("planner_id = ?", plannerID).Order("created_at DESC").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]uuid.UUID, 0, len(entries))
+	for _, e := range entries {
+		userIDs = append(userIDs, e.UserID)
+	}
+	var users []User
+	if err := tx.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usernames := map[uuid.UUID]string{}
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+	for i := range entries {
+		entries[i].Username = usernames[entries[i].UserID]
+	}
+	markUndone(entries)
+	return entries, nil
+}
+
+func (r *GormDB) ListAuditEntries(userID, plannerID uuid.UUID) ([]AuditEntry, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	return auditEntries(r.db, plannerID)
+}
+
+func (r *GormDB) GetAuditEntry(userID, plannerID, entryID uuid.UUID) (*AuditEntry, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var entry AuditEntry
+	err := r.db.Where("id = ? AND planner_id = ?", entryID, plannerID).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *GormDB) LatestAuditEntry(userID, plannerID, entityID uuid.UUID) (*AuditEntry, error) {
+	if _, err := r.requireRole(r.db, userID, plannerID, readRoles); err != nil {
+		return nil, err
+	}
+	var entry AuditEntry
+	err := r.db.Where("planner_id = ? AND user_id = ? AND entity_id = ?", plannerID, userID, entityID).
+		Order("created_at DESC").First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *GormDB) UndoAuditEntry(userID, plannerID, entryID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := r.requireRole(tx, userID, plannerID, editRoles); err != nil {
+			return err
+		}
+		entries, err := auditEntries(tx, plannerID)
+		if err != nil {
+			return err
+		}
+		undo, before, after, err := undoEntry(userID, entries, entryID)
+		if err != nil {
+			return err
+		}
+		if p := before.Planner; p != nil {
+			err := tx.Model(&Planner{}).Where("id = ?", plannerID).Updates(map[string]interface{}{
+				"name":           p.Name,
+				"start_balance":  p.StartBalance,
+				"horizon_months": p.HorizonMonths,
+				"currency":       p.Currency,
+				"updated_at":     time.Now(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, rows := range []struct {
+			model interface{}
+			ids   []uuid.UUID
+		}{
+			{&Account{}, append(accountIDs(before.Accounts), accountIDs(after.Accounts)...)},
+			{&RangeTransaction{}, append(rangeTransactionIDs(before.RangeTransactions), rangeTransactionIDs(after.RangeTransactions)...)},
+			{&ExpandedTransaction{}, append(expandedTransactionIDs(before.ExpandedTransactions), expandedTransactionIDs(after.ExpandedTransactions)...)},
+		} {
+			if len(rows.ids) == 0 {
+				continue
+			}
+			if err := tx.Where("id IN ? AND planner_id = ?", rows.ids, plannerID).Delete(rows.model).Error; err != nil {
+				return err
+			}
+		}
+		if len(before.Accounts) > 0 {
+			if err := tx.Create(&before.Accounts).Error; err != nil {
+				return err
+			}
+		}
+		if len(before.RangeTransactions) > 0 {
+			if err := tx.Create(&before.RangeTransactions).Error; err != nil {
+				return err
+			}
+		}
+		if len(before.ExpandedTransactions) > 0 {
+			if err := tx.CreateInBatches(&before.ExpandedTransactions, 500).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(undo).Error
+	})
+}
@@ -58,6 +58,20 @@ var bankRangeTxns = []RangeTransaction{
 	},
 }
 
+var (
+	housingID, _ = uuid.FromString("5b0f8a4e-3c2d-4e57-9a61-2f7c1d9e8b34")
+	rentID, _    = uuid.FromString("a7c3e921-6b4f-4d8a-b2e5-91f0c3d4e6a8")
+)
+
+// demoCategories budget the demo transactions, rent and the business break
+// their budgets
+var demoCategories = []Category{
+	{ID: housingID, Name: "Housing", Color: "#3949ab", MonthlyBudget: 900},
+	{ID: rentID, ParentID: housingID, Name: "Rent", Color: "#5c6bc0", MonthlyBudget: 700},
+	{Name: "Business expenses", Color: "#f57c00", MonthlyBudget: 400},
+	{Name: "Salary", Color: "#43a047"},
+}
+
 var bankOneTimeTxns = []ExpandedTransaction{
 	{
 		ID:              u4,
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// backupVersion is the version of the backup format written by the export
+	backupVersion = 1
+	// maxBackupSize caps the size of an uploaded backup
+	maxBackupSize = 10 << 20
+)
+
+// APIInsight is an insight of the cash flow in the API and the exports.
+type APIInsight struct {
+	Kind     InsightKind     `json:"kind"`
+	Severity InsightSeverity `json:"severity"`
+	Date     Date            `json:"date"`
+	Amount   float64         `json:"amount"`
+	// Category and ChangePercent are set for a category spike
+	Category      string `json:"category,omitempty"`
+	ChangePercent int    `json:"change_percent,omitempty"`
+}
+
+func newAPIInsights(insights Insights) []APIInsight {
+	result := make([]APIInsight, 0, len(insights))
+	for _, in := range insights {
+		result = append(result, APIInsight{
+			Kind:          in.Kind,
+			Severity:      in.Severity,
+			Date:          Date{in.Date},
+			Amount:        in.Amount,
+			Category:      in.Category,
+			ChangePercent: in.ChangePercent,
+		})
+	}
+	return result
+}
+
+// PlannerBackup holds everything needed to restore a planner on another instance.
+// The occurrences of the range transactions are expanded again on restore, only
+// the edited ones are kept.
+type PlannerBackup struct {
+	Version           int                   `json:"version"`
+	ExportedAt        time.Time             `json:"exported_at"`
+	Planner           plannerForm           `json:"planner"`
+	Accounts          []BackupAccount       `json:"accounts"`
+	RangeTransactions []APIRangeTransaction `json:"range_transactions"`
+	// Transactions are the one-time transactions and the edited occurrences
+	Transactions []BackupTransaction `json:"transactions"`
+}
+
+// BackupAccount is an account of a backup, the IDs link it to its transactions.
+type BackupAccount struct {
+	ID uuid.UUID `json:"id"`
+	accountForm
+}
+
+// BackupTransaction is a one-time transaction or an edited occurrence of a backup.
+type BackupTransaction struct {
+	APIExpandedTransaction
+	// ImportID keeps a re-import of the same statement from adding the line again
+	ImportID string `json:"import_id,omitempty"`
+}
+
+// newPlannerBackup returns the backup of the planner with its accounts and
+// transactions.
+func newPlannerBackup(planner *Planner, accounts []Account, rangeTxns []RangeTransaction, txns []ExpandedTransaction, now time.Time) *PlannerBackup {
+	backup := &PlannerBackup{
+		Version:    backupVersion,
+		ExportedAt: now.UTC(),
+		Planner: plannerForm{
+			Name:          planner.Name,
+			StartBalance:  planner.StartBalance,
+			HorizonMonths: planner.HorizonMonths,
+			Currency:      planner.Currency,
+		},
+		Accounts:          []BackupAccount{},
+		RangeTransactions: newAPIRangeTransactions(rangeTxns),
+		Transactions:      []BackupTransaction{},
+	}
+	for _, a := range accounts {
+		backup.Accounts = append(backup.Accounts, BackupAccount{
+			ID: a.ID,
+			accountForm: accountForm{
+				Name:             a.Name,
+				Kind:             a.Kind,
+				OpeningBalance:   a.OpeningBalance,
+				StatementDay:     a.StatementDay,
+				PaymentDueDays:   a.PaymentDueDays,
+				PaymentAccountID: a.PaymentAccountID,
+				InterestPercent:  a.InterestPercent,
+				Compounding:      a.Compounding,
+			},
+		})
+	}
+	for i := range txns {
+		if txns[i].IsOccurrence() && !txns[i].IsOverride {
+			continue
+		}
+		backup.Transactions = append(backup.Transactions, BackupTransaction{
+			APIExpandedTransaction: newAPIExpandedTransaction(&txns[i]),
+			ImportID:               txns[i].ImportID,
+		})
+	}
+	return backup
+}
+
+// decodeBackup reads a backup from the body of a request or an uploaded file.
+func decodeBackup(r io.Reader) (*PlannerBackup, error) {
+	decoder := json.NewDecoder(io.LimitReader(r, maxBackupSize))
+	decoder.DisallowUnknownFields()
+	var backup PlannerBackup
+	if err := decoder.Decode(&backup); err != nil {
+		return nil, &formError{"backup", "the file is not a planner backup: " + err.Error()}
+	}
+	if backup.Version != backupVersion {
+		return nil, &formError{"version", fmt.Sprintf("backup version %d is not supported", backup.Version)}
+	}
+	return &backup, nil
+}
+
+// backupError names the item of the backup that failed a check.
+func backupError(list string, i int, err error) error {
+	var validationErrs validator.ValidationErrors
+	var formErr *formError
+	switch {
+	case errors.As(err, &validationErrs):
+		formErr = &formError{validationErrs[0].Field(), fieldErrorMessage(validationErrs[0])}
+	case !errors.As(err, &formErr):
+		return err
+	}
+	return &formError{
+		Field:   fmt.Sprintf("%s[%d].%s", list, i, formErr.Field),
+		Message: fmt.Sprintf("%s %d: %s", strings.ReplaceAll(list, "_", " "), i+1, formErr.Message),
+	}
+}
+
+// remap replaces the accounts of a backup with their restored IDs. An account
+// that is not in the backup is kept for check to reject.
+func (f *accountsForm) remap(ids map[uuid.UUID]uuid.UUID) {
+	if id, ok := ids[f.AccountID]; ok {
+		f.AccountID = id
+	}
+	if id, ok := ids[f.ToAccountID]; ok {
+		f.ToAccountID = id
+	}
+}
+
+// restoredPlanner is a new planner of the user with the contents of a backup.
+type restoredPlanner struct {
+	planner   *Planner
+	accounts  []Account
+	rangeTxns []RangeTransaction
+	txns      []ExpandedTransaction
+}
+
+// restore checks the backup like the forms of its items and returns it as a new
+// planner of the user with new IDs.
+func (b *PlannerBackup) restore(userID uuid.UUID) (*restoredPlanner, error) {
+	validate := newValidator()
+	if err := validate.Struct(&b.Planner); err != nil {
+		return nil, err
+	}
+	restored := &restoredPlanner{planner: b.Planner.planner(userID)}
+
+	accountIDs := map[uuid.UUID]uuid.UUID{uuid.Nil: uuid.Nil}
+	for i := range b.Accounts {
+		if err := validate.Struct(&b.Accounts[i].accountForm); err != nil {
+			return nil, backupError("accounts", i, err)
+		}
+		account := b.Accounts[i].account(restored.planner)
+		account.ID, _ = uuid.NewV4()
+		accountIDs[b.Accounts[i].ID] = account.ID
+		restored.accounts = append(restored.accounts, *account)
+	}
+	for i := range restored.accounts {
+		account := &restored.accounts[i]
+		if paymentAccountID, ok := accountIDs[account.PaymentAccountID]; ok {
+			account.PaymentAccountID = paymentAccountID
+		}
+		if err := checkAccount(account, restored.accounts); err != nil {
+			return nil, backupError("accounts", i, err)
+		}
+	}
+
+	rangeIDs := map[uuid.UUID]uuid.UUID{}
+	for i := range b.RangeTransactions {
+		f := &b.RangeTransactions[i].rangeTransactionForm
+		err := validate.Struct(f)
+		if err == nil {
+			err = f.checkRecurrence()
+		}
+		if err == nil {
+			f.remap(accountIDs)
+			err = f.accountsForm.check(f.IncomeOrExpense, restored.accounts)
+		}
+		if err != nil {
+			return nil, backupError("range_transactions", i, err)
+		}
+		rtx := f.rangeTransaction()
+		rtx.ID, _ = uuid.NewV4()
+		rtx.UserID = userID
+		rtx.PlannerID = restored.planner.ID
+		rtx.Source = b.RangeTransactions[i].Source
+		rangeIDs[b.RangeTransactions[i].ID] = rtx.ID
+		restored.rangeTxns = append(restored.rangeTxns, *rtx)
+	}
+
+	for i := range b.Transactions {
+		backupTxn := &b.Transactions[i]
+		f := &backupTxn.oneTimeTransactionForm
+		err := validate.Struct(f)
+		if err == nil {
+			err = f.check()
+		}
+		if err == nil {
+			f.remap(accountIDs)
+			err = f.accountsForm.check(f.IncomeOrExpense, restored.accounts)
+		}
+		if err != nil {
+			return nil, backupError("transactions", i, err)
+		}
+		etx := f.expandedTransaction()
+		etx.ID, _ = uuid.NewV4()
+		etx.UserID = userID
+		etx.PlannerID = restored.planner.ID
+		etx.Source = backupTxn.Source
+		etx.ImportID = backupTxn.ImportID
+		if backupTxn.RangeTransactionID != nil {
+			rangeID, ok := rangeIDs[*backupTxn.RangeTransactionID]
+			if !ok {
+				return nil, backupError("transactions", i, &formError{"range_transaction_id", "the range transaction is not in the backup"})
+			}
+			// the other occurrences are expanded again
+			etx.RangeTransactionID = rangeID
+			etx.OccurrenceDate = backupTxn.OccurrenceDate.Time
+			etx.IsOverride = true
+		}
+		restored.txns = append(restored.txns, *etx)
+	}
+	return restored, nil
+}
+
+// restoreBackup adds the planner of the backup to the user.
+func (s *Server) restoreBackup(user *User, backup *PlannerBackup) (*Planner, error) {
+	restored, err := backup.restore(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repository.RestorePlanner(restored.planner, restored.accounts, restored.rangeTxns, restored.txns)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info().Msgf("restored planner %s from a backup", restored.planner.ID)
+	return restored.planner, nil
+}
+
+//
+// CSV exports
+//
+
+// formatAmount writes an amount to a CSV cell.
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// accountNames returns the name of each account of the planner by ID.
+func accountNames(planner *Planner, accounts []Account) map[uuid.UUID]string {
+	names := map[uuid.UUID]string{}
+	for _, a := range plannerAccounts(planner, accounts) {
+		names[a.ID] = a.Name
+	}
+	return names
+}
+
+func rangeTransactionRecords(planner *Planner, accounts []Account, rangeTxns []RangeTransaction) [][]string {
+	names := accountNames(planner, accounts)
+	records := [][]string{{
+		"id", "title", "type", "account", "to_account", "category", "amount",
+		"growth_annual_percent", "growth_mode", "recurrence", "start", "end", "notes",
+	}}
+	for i := range rangeTxns {
+		rt := &rangeTxns[i]
+		toAccount := ""
+		if rt.IncomeOrExpense == "transfer" {
+			toAccount = names[rt.ToAccountID]
+		}
+		records = append(records, []string{
+			rt.ID.String(), rt.Title, rt.IncomeOrExpense, names[rt.AccountID], toAccount, rt.Category,
+			formatAmount(rt.Amount), strconv.FormatFloat(rt.Growth.AnnualPercent, 'f', -1, 64), rt.Growth.Mode,
+			rt.RecurrenceString(), rt.RecurrenceStart.Format(time.DateOnly), rt.RecurrenceEnd.Format(time.DateOnly), rt.Notes,
+		})
+	}
+	return records
+}
+
+func seriesRecords(series APISeries) [][]string {
+	names := map[uuid.UUID]string{}
+	header := []string{"date", "title", "type", "account", "to_account", "category", "amount", "net_cash"}
+	for _, a := range series.Accounts {
+		names[a.ID] = a.Name
+		header = append(header, a.Name+" balance")
+	}
+	records := [][]string{header}
+	for _, p := range series.Points {
+		toAccount := ""
+		if p.IncomeOrExpense == "transfer" {
+			toAccount = names[p.ToAccountID]
+		}
+		record := []string{
+			p.Date.Format(time.DateOnly), p.Title, p.IncomeOrExpense, names[p.AccountID], toAccount, p.Category,
+			formatAmount(p.Amount), formatAmount(p.NetCash),
+		}
+		for _, balance := range p.Balances {
+			record = append(record, formatAmount(balance))
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func insightRecords(insights []APIInsight) [][]string {
+	records := [][]string{{"kind", "severity", "date", "amount", "category", "change_percent"}}
+	for _, in := range insights {
+		changePercent := ""
+		if in.Kind == InsightCategorySpike {
+			changePercent = strconv.Itoa(in.ChangePercent)
+		}
+		records = append(records, []string{
+			string(in.Kind), string(in.Severity), in.Date.Format(time.DateOnly), formatAmount(in.Amount),
+			in.Category, changePercent,
+		})
+	}
+	return records
+}
+
+// exportFilename is the name of the downloaded file of the planner.
+func exportFilename(planner *Planner, dataset, format string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(planner.Name))
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "planner"
+	}
+	return fmt.Sprintf("%s-%s.%s", name, dataset, format)
+}
+
+// exportPlanner downloads the range transactions, the cash flow, the insights or
+// the backup of the planner. The format query parameter is csv or json, a backup
+// is only JSON.
+func (s *Server) exportPlanner(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	rangeTxns, err := s.repository.ListRangeTransactions(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list range transactions", err)
+		return
+	}
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list expanded transactions", err)
+		return
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list accounts", err)
+		return
+	}
+	now := time.Now()
+
+	// document is the JSON export, records the CSV export with the header first
+	var document any
+	var records [][]string
+	dataset := r.PathValue("dataset")
+	switch dataset {
+	case "range-transactions":
+		document = newAPIRangeTransactions(rangeTxns)
+		records = rangeTransactionRecords(planner, accounts, rangeTxns)
+	case "series":
+		series := newAPISeries(planner, accounts, txns, now)
+		document = series
+		records = seriesRecords(series)
+	case "insights":
+		insights := newAPIInsights(ComputeInsights(cashFlow(planner, accounts, txns, now, planner.End(now)), defaultInsightOptions))
+		document = insights
+		records = insightRecords(insights)
+	case "backup":
+		document = newPlannerBackup(planner, accounts, rangeTxns, txns, now)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+		if records == nil {
+			format = "json"
+		}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(planner, dataset, format)))
+	switch {
+	case format == "json":
+		err = writeJSON(w, http.StatusOK, document)
+	case format == "csv" && records != nil:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = csv.NewWriter(w).WriteAll(records)
+	default:
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "unsupported export format "+format, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msgf("unable to write the %s export of planner %s", dataset, planner.ID)
+	}
+}
+
+// restorePlanner adds a planner from an uploaded backup.
+func (s *Server) restorePlanner(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	var planner *Planner
+	file, _, err := r.FormFile("backup")
+	if err == nil {
+		defer file.Close()
+		var backup *PlannerBackup
+		if backup, err = decodeBackup(file); err == nil {
+			planner, err = s.restoreBackup(user, backup)
+		}
+	} else {
+		err = &formError{"backup", "choose a backup file to restore"}
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to restore planner", err)
+			return
+		}
+		s.renderPlanners(w, r, user, http.StatusUnprocessableEntity, message)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+}
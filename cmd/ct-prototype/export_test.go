@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestExportAndRestorePlanner(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "Savings")
+	form.Set("kind", AccountSavings)
+	form.Set("opening_balance", "1000")
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/accounts", form), http.StatusFound)
+	accounts, _ := repository.ListAccounts(user.ID, plannerID)
+	ensureInt(t, len(accounts), 1)
+
+	start := time.Now().AddDate(0, 0, 1)
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Rent, flat")
+	form.Set("income_or_expense", "expense")
+	form.Set("amount", "900")
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_start", start.Format(time.DateOnly))
+	form.Set("recurrence_end", start.AddDate(0, 2, 0).Format(time.DateOnly))
+	form.Set("growth_annual_percent", "3")
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-range-transaction", form), http.StatusFound, plannerURL)
+
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Save")
+	form.Set("income_or_expense", "transfer")
+	form.Set("to_account_id", accounts[0].ID.String())
+	form.Set("amount", "200")
+	form.Set("transaction_date", start.Format(time.DateOnly))
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form), http.StatusFound, plannerURL)
+
+	// edit the second rent on its own
+	txns, _ := repository.ListExpandedTransactions(user.ID, plannerID)
+	ensureInt(t, len(txns), 4)
+	var rent ExpandedTransaction
+	for _, etx := range txns {
+		if etx.IsOccurrence() && etx.TransactionDate.After(start) && rent.ID == uuid.Nil {
+			rent = etx
+		}
+	}
+	rent.Amount = 950
+	if err := repository.UpdateExpandedTransaction(rent.ID, &rent); err != nil {
+		t.Fatal(err)
+	}
+
+	readCSV := func(dataset string) [][]string {
+		t.Helper()
+		recorder := serve(t, server, jar, "GET", plannerURL+"/export/"+dataset+"?format=csv", nil)
+		ensureCode(t, recorder, http.StatusOK)
+		ensureString(t, recorder.Header().Get("Content-Disposition"), `attachment; filename="household-`+dataset+`.csv"`)
+		records, err := csv.NewReader(recorder.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("reading the %s export: %v", dataset, err)
+		}
+		return records
+	}
+	records := readCSV("range-transactions")
+	ensureInt(t, len(records), 2)
+	ensureString(t, records[1][1], "Rent, flat")
+	ensureString(t, records[1][3], mainAccountName)
+	ensureString(t, records[1][6], "900.00")
+
+	records = readCSV("series")
+	ensureInt(t, len(records), 5)
+	ensureString(t, strings.Join(records[0][8:], ","), "Main balance,Savings balance")
+	// the rent and the transfer are on the same day
+	save := records[2]
+	if save[1] != "Save" {
+		save = records[1]
+	}
+	ensureString(t, save[1], "Save")
+	ensureString(t, save[4], "Savings")
+	ensureString(t, strings.Join(records[2][7:], ","), "100.00,-1100.00,1200.00")
+	ensureString(t, save[6], "200.00")
+
+	records = readCSV("insights")
+	ensureString(t, strings.Join(records[0], ","), "kind,severity,date,amount,category,change_percent")
+
+	recorder := serve(t, server, jar, "GET", plannerURL+"/export/insights?format=json", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	var insights []APIInsight
+	if err := json.Unmarshal(recorder.Body.Bytes(), &insights); err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(insights), len(records)-1)
+	ensureCode(t, serve(t, server, jar, "GET", plannerURL+"/export/backup?format=csv", nil), http.StatusBadRequest)
+	ensureCode(t, serve(t, server, jar, "GET", plannerURL+"/export/budgets", nil), http.StatusNotFound)
+
+	recorder = serve(t, server, jar, "GET", plannerURL+"/export/backup", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	backup := recorder.Body.Bytes()
+
+	restore := func(backup []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("csrf-token", csrfToken)
+		fw, _ := mw.CreateFormFile("backup", "household-backup.json")
+		_, _ = fw.Write(backup)
+		mw.Close()
+
+		r := httptest.NewRequest("POST", "http://localhost/planners/restore", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		for _, c := range jar.Cookies(r.URL) {
+			r.AddCookie(c)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
+	}
+	ensureCode(t, restore([]byte(`{"version": 1, "planner": {}}`)), http.StatusUnprocessableEntity)
+	ensureCode(t, restore([]byte(`not json`)), http.StatusUnprocessableEntity)
+
+	recorder = restore(backup)
+	ensureCode(t, recorder, http.StatusFound)
+	restoredID := uuid.FromStringOrNil(strings.TrimPrefix(recorder.Header().Get("Location"), "/planners/"))
+	if restoredID == uuid.Nil || restoredID == plannerID {
+		t.Fatalf("unexpected planner %s", recorder.Header().Get("Location"))
+	}
+	restoredAccounts, _ := repository.ListAccounts(user.ID, restoredID)
+	ensureInt(t, len(restoredAccounts), 1)
+	if restoredAccounts[0].ID == accounts[0].ID || restoredAccounts[0].OpeningBalance != 1000 {
+		t.Errorf("unexpected restored account %+v", restoredAccounts[0])
+	}
+	rangeTxns, _ := repository.ListRangeTransactions(user.ID, restoredID)
+	ensureInt(t, len(rangeTxns), 1)
+	ensureFloat(t, rangeTxns[0].Growth.AnnualPercent, 3)
+
+	restoredTxns, _ := repository.ListExpandedTransactions(user.ID, restoredID)
+	ensureInt(t, len(restoredTxns), len(txns))
+	var overrides int
+	for _, etx := range restoredTxns {
+		switch {
+		case etx.IsOverride:
+			overrides++
+			ensureFloat(t, etx.Amount, 950)
+			if etx.RangeTransactionID != rangeTxns[0].ID || !etx.OccurrenceDate.Equal(rent.OccurrenceDate) {
+				t.Errorf("unexpected restored override %+v", etx)
+			}
+		case etx.IncomeOrExpense == "transfer" && etx.ToAccountID != restoredAccounts[0].ID:
+			t.Errorf("the transfer goes to %s", etx.ToAccountID)
+		}
+	}
+	ensureInt(t, overrides, 1)
+}
+
+func TestRestoreBackup(t *testing.T) {
+	savings, _ := uuid.NewV4()
+	backup := func() *PlannerBackup {
+		start := time.Now().AddDate(-2, 0, 0)
+		rtx := RangeTransaction{
+			Title:           "Salary",
+			IncomeOrExpense: "income",
+			Amount:          3000,
+			AccountID:       savings,
+			RecurrenceStart: start,
+			RecurrenceEnd:   start.AddDate(3, 0, 0),
+			Recurrence:      Recurrence{Freq: FreqMonthly},
+		}
+		return newPlannerBackup(
+			&Planner{Name: "Old", HorizonMonths: 12, Currency: "EUR"},
+			[]Account{{ID: savings, Name: "Savings", Kind: AccountSavings}},
+			[]RangeTransaction{rtx}, nil, time.Now(),
+		)
+	}
+
+	userID, _ := uuid.NewV4()
+	restored, err := backup().restore(userID)
+	if err != nil {
+		t.Fatalf("a series that started in the past is not restored: %v", err)
+	}
+	ensureInt(t, len(restored.rangeTxns), 1)
+	if restored.rangeTxns[0].AccountID != restored.accounts[0].ID || restored.accounts[0].ID == savings {
+		t.Errorf("the account was not given a new ID %+v", restored.rangeTxns[0])
+	}
+
+	unknownAccount := backup()
+	unknownAccount.RangeTransactions[0].AccountID, _ = uuid.NewV4()
+	_, err = unknownAccount.restore(userID)
+	var formErr *formError
+	if !errors.As(err, &formErr) {
+		t.Fatalf("got %v, want a form error", err)
+	}
+	ensureString(t, formErr.Field, "range_transactions[0].account_id")
+	ensureString(t, formErr.Message, "range transactions 1: the account is not in the planner")
+
+	invalid := backup()
+	invalid.Accounts[0].Kind = "crypto"
+	_, err = invalid.restore(userID)
+	if !errors.As(err, &formErr) {
+		t.Fatalf("got %v, want a form error", err)
+	}
+	ensureString(t, formErr.Field, "accounts[0].kind")
+}
+
+func TestCalendarFeed(t *testing.T) {
+	server, _ := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+
+	add := func(title, incomeOrExpense string, days int) {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("title", title)
+		form.Set("income_or_expense", incomeOrExpense)
+		form.Set("amount", "1250.5")
+		form.Set("transaction_date", time.Now().AddDate(0, 0, days).Format(time.DateOnly))
+		ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form), http.StatusFound, plannerURL)
+	}
+	add("Payday", "income", 3)
+	add("Rent; flat 2", "expense", 5)
+	// after the end of the planner
+	add("Insurance", "expense", 400)
+
+	createFeed := func() string {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		recorder := serve(t, server, jar, "POST", "/settings/calendar-token", form)
+		ensureCode(t, recorder, http.StatusOK)
+		match := regexp.MustCompile(`<code id="new-calendar-feed">http://localhost(/calendar/ctc_[0-9a-f]+\.ics)</code>`).FindStringSubmatch(recorder.Body.String())
+		if match == nil {
+			t.Fatalf("the feed URL is not shown:\n%s", recorder.Body.String())
+		}
+		return match[1]
+	}
+	feed := createFeed()
+
+	// the feed is read without a session
+	anonymous, _ := cookiejar.New(nil)
+	recorder := serve(t, server, anonymous, "GET", feed, nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, recorder.Header().Get("Content-Type"), "text/calendar; charset=utf-8")
+	body := recorder.Body.String()
+	ensureInt(t, strings.Count(body, "BEGIN:VEVENT"), 2)
+	for _, want := range []string{"SUMMARY:Payday +$1250.50\r\n", `SUMMARY:Rent\; flat 2 -$1250.50` + "\r\n", "CATEGORIES:PAYDAY\r\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the feed has no %q:\n%s", want, body)
+		}
+	}
+
+	// a new link replaces the old one
+	newFeed := createFeed()
+	ensureCode(t, serve(t, server, anonymous, "GET", feed, nil), http.StatusNotFound)
+	ensureCode(t, serve(t, server, anonymous, "GET", newFeed, nil), http.StatusOK)
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	ensureRedirect(t, serve(t, server, jar, "POST", "/settings/calendar-token/delete", form), http.StatusFound, "/settings/api-tokens")
+	ensureCode(t, serve(t, server, anonymous, "GET", newFeed, nil), http.StatusNotFound)
+}
+
+func TestWriteICSLine(t *testing.T) {
+	var b strings.Builder
+	writeICSLine(&b, "SUMMARY:"+strings.Repeat("€", 60))
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	if len(lines) < 3 {
+		t.Fatalf("the line was not folded: %q", b.String())
+	}
+	var unfolded string
+	for i, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("line %d has %d octets", i, len(line))
+		}
+		if i > 0 {
+			line = strings.TrimPrefix(line, " ")
+		}
+		unfolded += line
+	}
+	ensureString(t, unfolded, "SUMMARY:"+strings.Repeat("€", 60))
+}
+
+func TestWriteCalendarEscapes(t *testing.T) {
+	var b strings.Builder
+	events := []calendarEvent{{
+		UID:      "1@ct-prototype",
+		Date:     time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+		Summary:  "Rent; flat",
+		Category: "BILL,Rent\r\nX-INJECTED:yes",
+	}}
+	if err := writeCalendar(&b, events, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	ics := b.String()
+	if strings.Contains(ics, "\r\nX-INJECTED") {
+		t.Fatalf("the category adds a line:\n%s", ics)
+	}
+	for _, line := range []string{`SUMMARY:Rent\; flat`, `CATEGORIES:BILL\,Rent\nX-INJECTED:yes`} {
+		if !strings.Contains(ics, "\r\n"+line+"\r\n") {
+			t.Errorf("the calendar has no line %q:\n%s", line, ics)
+		}
+	}
+}
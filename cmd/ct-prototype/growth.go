@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// The ways the amount of a range transaction grows
+const (
+	// GrowthRaise steps the amount up on each anniversary of the start
+	GrowthRaise = "raise"
+	// GrowthInflation changes the amount a little with every occurrence
+	GrowthInflation = "inflation"
+)
+
+// The ways the interest of an account compounds
+const (
+	// CompoundingAPY takes the rate as the annual percentage yield and credits the
+	// interest monthly, a year of a steady balance earns exactly the rate
+	CompoundingAPY = "apy"
+	// CompoundingMonthly credits a twelfth of the rate each month
+	CompoundingMonthly = "monthly"
+	// CompoundingDaily adds a 365th of the rate each day and credits the interest
+	// monthly
+	CompoundingDaily = "daily"
+)
+
+// interestCategory is the category of the generated interest
+const interestCategory = "Interest"
+
+// Growth is a yearly change of the amount of a range transaction, like a raise of
+// a salary or the inflation of a bill.
+type Growth struct {
+	// AnnualPercent is the change in percent per year, negative for a decline
+	AnnualPercent float64
+	// Mode is GrowthRaise or GrowthInflation
+	Mode string
+}
+
+func (g Growth) grows() bool {
+	return g.AnnualPercent != 0
+}
+
+// yearsBetween returns the years from start to day. The whole years count the
+// anniversaries, the rest is the part of the year to the next one.
+func yearsBetween(start, day time.Time) (whole int, fraction float64) {
+	start, day = truncateDay(start), truncateDay(day)
+	if !day.After(start) {
+		return 0, 0
+	}
+	for !start.AddDate(whole+1, 0, 0).After(day) {
+		whole++
+	}
+	anniversary := start.AddDate(whole, 0, 0)
+	next := start.AddDate(whole+1, 0, 0)
+	return whole, day.Sub(anniversary).Hours() / next.Sub(anniversary).Hours()
+}
+
+// factor is what the amount of the occurrence on day is multiplied by when the
+// series starts on start.
+func (g Growth) factor(start, day time.Time) float64 {
+	if !g.grows() {
+		return 1
+	}
+	whole, fraction := yearsBetween(start, day)
+	years := float64(whole)
+	if g.Mode == GrowthInflation {
+		years += fraction
+	}
+	return math.Pow(1+g.AnnualPercent/100, years)
+}
+
+// roundCents rounds the amount to a cent.
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// accruesInterest reports if the balance of the account earns or is charged
+// interest.
+func (a *Account) accruesInterest() bool {
+	return a.InterestPercent != 0
+}
+
+// monthlyRate is the interest of a month on the average balance of the month for
+// the APY and monthly compounding.
+func (a *Account) monthlyRate() float64 {
+	rate := a.InterestPercent / 100
+	if a.Compounding == CompoundingAPY {
+		return math.Pow(1+rate, 1.0/12) - 1
+	}
+	return rate / 12
+}
+
+// interestAccrual adds up the interest of an account over a month.
+type interestAccrual struct {
+	// balanceDays is the sum of the balance at the end of each day
+	balanceDays float64
+	// accrued is the interest compounded daily and not credited yet
+	accrued float64
+}
+
+// addDay accrues the interest of a day that ends with the balance.
+func (ia *interestAccrual) addDay(account *Account, balance float64) {
+	if account.Compounding == CompoundingDaily {
+		ia.accrued += (balance + ia.accrued) * account.InterestPercent / 100 / 365
+		return
+	}
+	ia.balanceDays += balance
+}
+
+// credit returns the interest of the month of day and starts the next month.
+func (ia *interestAccrual) credit(account *Account, day time.Time) float64 {
+	interest := ia.accrued
+	if account.Compounding != CompoundingDaily {
+		interest = ia.balanceDays / float64(daysInMonth(day.Year(), day.Month())) * account.monthlyRate()
+	}
+	*ia = interestAccrual{}
+	return roundCents(interest)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ensureNear asserts that got is within tolerance of want, the amounts are
+// rounded to cents along the way.
+func ensureNear(t *testing.T, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > tolerance {
+		t.Fatalf("got %v, want %v within %v", got, want, tolerance)
+	}
+}
+
+func TestGrowthRaise(t *testing.T) {
+	rt := RangeTransaction{
+		Title:           "Salary",
+		IncomeOrExpense: "income",
+		Amount:          5000,
+		Growth:          Growth{AnnualPercent: 3, Mode: GrowthRaise},
+		RecurrenceStart: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		RecurrenceEnd:   time.Date(2028, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	rt.Recurrence.Freq = FreqMonthly
+	expanded := rt.Expand()
+	ensureInt(t, len(expanded), 60)
+
+	total := 0.0
+	for i, etx := range expanded {
+		// the amount steps up on each anniversary and stays for the year
+		ensureNear(t, etx.Amount, 5000*math.Pow(1.03, float64(i/12)), 0.01)
+		total += etx.Amount
+	}
+	// a growing annuity of the yearly salary
+	ensureNear(t, total, 60000*(math.Pow(1.03, 5)-1)/0.03, 0.05)
+}
+
+func TestGrowthInflation(t *testing.T) {
+	rt := RangeTransaction{
+		Title:           "Rent",
+		IncomeOrExpense: "expense",
+		Amount:          1200,
+		Growth:          Growth{AnnualPercent: 2, Mode: GrowthInflation},
+		RecurrenceStart: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		RecurrenceEnd:   time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	rt.Recurrence.Freq = FreqMonthly
+	expanded := rt.Expand()
+	ensureInt(t, len(expanded), 60)
+	for k := 0; k < 5; k++ {
+		// each January is a whole number of years from the start
+		ensureNear(t, expanded[12*k].Amount, 1200*math.Pow(1.02, float64(k)), 0.01)
+	}
+	// in between the amount rises with the part of the year gone by
+	if !(expanded[6].Amount > expanded[0].Amount && expanded[6].Amount < expanded[12].Amount) {
+		t.Errorf("July amount %v is not between %v and %v", expanded[6].Amount, expanded[0].Amount, expanded[12].Amount)
+	}
+}
+
+func TestAccountInterest(t *testing.T) {
+	tests := []struct {
+		name    string
+		account Account
+		start   time.Time
+		end     time.Time
+		want    float64
+	}{
+		{
+			name:    "savings yield",
+			account: Account{Name: "Savings", Kind: AccountSavings, OpeningBalance: 10000, InterestPercent: 5, Compounding: CompoundingAPY},
+			start:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:     time.Date(2028, 12, 31, 0, 0, 0, 0, time.UTC),
+			want:    10000 * math.Pow(1.05, 5),
+		},
+		{
+			name:    "loan interest",
+			account: Account{Name: "Loan", Kind: AccountCreditCard, OpeningBalance: -20000, InterestPercent: 6, Compounding: CompoundingMonthly},
+			start:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:     time.Date(2028, 12, 31, 0, 0, 0, 0, time.UTC),
+			want:    -20000 * math.Pow(1.005, 60),
+		},
+		{
+			name:    "daily compounding",
+			account: Account{Name: "Savings", Kind: AccountSavings, OpeningBalance: 10000, InterestPercent: 3.65, Compounding: CompoundingDaily},
+			start:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:     time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+			want:    10000 * math.Pow(1+0.0365/365, 365),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.account.ID, _ = uuid.NewV4()
+			segTxns := cashFlow(&Planner{}, []Account{tt.account}, nil, tt.start, tt.end)
+			months := (tt.end.Year()-tt.start.Year())*12 + int(tt.end.Month()-tt.start.Month()) + 1
+			ensureInt(t, len(segTxns), months)
+			last := segTxns[len(segTxns)-1]
+			if !last.IsGenerated() || last.Category != interestCategory {
+				t.Errorf("unexpected interest transaction %+v", last)
+			}
+			// each month is rounded to a cent
+			ensureNear(t, last.Balances[1], tt.want, 0.01*float64(months))
+			ensureNear(t, last.NetCash, tt.want, 0.01*float64(months))
+		})
+	}
+}
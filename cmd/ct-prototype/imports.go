@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+)
+
+// maxStatementSize caps the size of an uploaded statement
+const maxStatementSize = 10 << 20
+
+func (s *Server) renderImportPage(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, result *ImportResult) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	now := time.Now()
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		Username:   user.Username,
+		UserID:     user.ID,
+		PlannerID:  planner.ID,
+		PlannerEnd: planner.End(now),
+		Planner:    planner,
+		Planners:   planners,
+		Import:     result,
+	}
+	if err := StaticResources.ExecuteTemplate(w, "import.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// importPage renders the statement upload form.
+func (s *Server) importPage(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	s.renderImportPage(w, r, user, planner, nil)
+}
+
+// importStatement adds the transactions of an uploaded CSV, OFX or QFX statement
+// to the planner and suggests range transactions for the recurring payees.
+func (s *Server) importStatement(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	validate := validator.New()
+	type Form struct {
+		Source            string `form:"source" validate:"required,oneof=bank card brokerage"`
+		DateColumn        string `form:"csv_date_column" validate:"max=255"`
+		DescriptionColumn string `form:"csv_description_column" validate:"max=255"`
+		AmountColumn      string `form:"csv_amount_column" validate:"max=255"`
+		DebitColumn       string `form:"csv_debit_column" validate:"max=255"`
+		CreditColumn      string `form:"csv_credit_column" validate:"max=255"`
+		DateFormat        string `form:"csv_date_format" validate:"max=64"`
+		Negate            bool   `form:"csv_negate"`
+	}
+
+	file, header, err := r.FormFile("statement")
+	if err != nil {
+		s.internalError(w, "unable to read the uploaded statement", err)
+		return
+	}
+	defer file.Close()
+	// one byte more than allowed tells a statement at the limit from a larger one
+	data, err := io.ReadAll(io.LimitReader(file, maxStatementSize+1))
+	if err != nil {
+		s.internalError(w, "unable to read the uploaded statement", err)
+		return
+	}
+	if len(data) > maxStatementSize {
+		http.Error(w, fmt.Sprintf("the statement is larger than %d MB", maxStatementSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	decoder := form.NewDecoder()
+	var form Form
+	err = decoder.Decode(&form, r.MultipartForm.Value)
+	if err != nil {
+		s.internalError(w, "unable to parse POST form", err)
+		return
+	}
+	err = validate.Struct(form)
+	if err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+
+	var lines []StatementLine
+	if isOFX(header.Filename, data) {
+		lines, err = ParseOFXStatement(bytes.NewReader(data))
+	} else {
+		lines, err = ParseCSVStatement(bytes.NewReader(data), CSVMapping{
+			Date:        form.DateColumn,
+			Description: form.DescriptionColumn,
+			Amount:      form.AmountColumn,
+			Debit:       form.DebitColumn,
+			Credit:      form.CreditColumn,
+			DateFormat:  form.DateFormat,
+			Negate:      form.Negate,
+		})
+	}
+	if err != nil {
+		s.internalError(w, "unable to parse the statement", err)
+		return
+	}
+
+	txns := statementTransactions(lines, user.ID, planner.ID, form.Source)
+	added, err := s.repository.AddImportedTransactions(user.ID, planner.ID, txns)
+	if err != nil {
+		s.internalError(w, "unable to add the imported transactions", err)
+		return
+	}
+	now := time.Now()
+	result := &ImportResult{
+		Filename:    header.Filename,
+		Added:       added,
+		Duplicates:  len(txns) - added,
+		Suggestions: DetectRecurring(lines, now, planner.End(now)),
+	}
+	s.logger.Info().Msgf("imported %d of %d transactions from %s into planner %s",
+		added, len(txns), header.Filename, planner.ID)
+	s.renderImportPage(w, r, user, planner, result)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type InsightKind string
+
+const (
+	InsightLowestBalance   InsightKind = "lowest_balance"
+	InsightNegativeBalance InsightKind = "negative_balance"
+	InsightSurplus         InsightKind = "surplus"
+	InsightCategorySpike   InsightKind = "category_spike"
+)
+
+type InsightSeverity string
+
+const (
+	SeverityRisk        InsightSeverity = "risk"
+	SeverityOpportunity InsightSeverity = "opportunity"
+	SeverityInfo        InsightSeverity = "info"
+)
+
+// Insight is a risk or opportunity found in the projected cash flow of a planner.
+type Insight struct {
+	Kind     InsightKind
+	Severity InsightSeverity
+	// Date is the day of a balance insight or the first day of the month of a
+	// monthly insight
+	Date   time.Time
+	Amount float64
+	// Category and ChangePercent describe a category spike
+	Category      string
+	ChangePercent int
+}
+
+// Insights are shown on the planner card with a badge per severity.
+type Insights []Insight
+
+func (in Insights) count(severity InsightSeverity) int {
+	n := 0
+	for _, i := range in {
+		if i.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Risks is the number of risk insights.
+func (in Insights) Risks() int {
+	return in.count(SeverityRisk)
+}
+
+// Opportunities is the number of opportunity insights.
+func (in Insights) Opportunities() int {
+	return in.count(SeverityOpportunity)
+}
+
+// InsightOptions are the thresholds of the insights.
+type InsightOptions struct {
+	// LowBalance makes the lowest balance a risk when it is below it
+	LowBalance float64
+	// Surplus is the monthly income minus expenses worth investing
+	Surplus float64
+	// SpikeRatio and SpikeMinAmount are how much a category has to grow over the
+	// previous month to be a spike
+	SpikeRatio     float64
+	SpikeMinAmount float64
+}
+
+var defaultInsightOptions = InsightOptions{
+	LowBalance:     500,
+	Surplus:        1000,
+	SpikeRatio:     1.3,
+	SpikeMinAmount: 50,
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// ComputeInsights finds the insights of the cash flow. The transactions are sorted by
+// date and have their NetCash set.
+func ComputeInsights(txns []*SegmentedTransaction, opts InsightOptions) Insights {
+	var insights Insights
+	if len(txns) == 0 {
+		return insights
+	}
+
+	lowest := txns[0]
+	var firstNegative *SegmentedTransaction
+	for _, stx := range txns {
+		if stx.NetCash < lowest.NetCash {
+			lowest = stx
+		}
+		if firstNegative == nil && stx.NetCash < 0 {
+			firstNegative = stx
+		}
+	}
+	severity := SeverityInfo
+	if lowest.NetCash < opts.LowBalance {
+		severity = SeverityRisk
+	}
+	insights = append(insights, Insight{
+		Kind:     InsightLowestBalance,
+		Severity: severity,
+		Date:     lowest.TransactionDate,
+		Amount:   lowest.NetCash,
+	})
+	if firstNegative != nil {
+		insights = append(insights, Insight{
+			Kind:     InsightNegativeBalance,
+			Severity: SeverityRisk,
+			Date:     firstNegative.TransactionDate,
+			Amount:   firstNegative.NetCash,
+		})
+	}
+
+	surplus := map[time.Time]float64{}
+	// category expenses by month
+	spending := map[string]map[time.Time]float64{}
+	var months []time.Time
+	for _, stx := range txns {
+		month := monthStart(stx.TransactionDate)
+		if _, ok := surplus[month]; !ok {
+			months = append(months, month)
+		}
+		if stx.IsTransfer() {
+			continue
+		}
+		if stx.IncomeOrExpense == "income" {
+			surplus[month] += stx.Amount
+			continue
+		}
+		surplus[month] -= stx.Amount
+		if stx.Category == "" {
+			continue
+		}
+		if spending[stx.Category] == nil {
+			spending[stx.Category] = map[time.Time]float64{}
+		}
+		spending[stx.Category][month] += stx.Amount
+	}
+
+	for _, month := range months {
+		if surplus[month] >= opts.Surplus {
+			insights = append(insights, Insight{
+				Kind:     InsightSurplus,
+				Severity: SeverityOpportunity,
+				Date:     month,
+				Amount:   surplus[month],
+			})
+		}
+	}
+
+	var spikes Insights
+	for category, byMonth := range spending {
+		for _, month := range months {
+			previous, current := byMonth[month.AddDate(0, -1, 0)], byMonth[month]
+			if previous <= 0 || current < previous*opts.SpikeRatio || current-previous < opts.SpikeMinAmount {
+				continue
+			}
+			spikes = append(spikes, Insight{
+				Kind:          InsightCategorySpike,
+				Severity:      SeverityRisk,
+				Date:          month,
+				Amount:        current,
+				Category:      category,
+				ChangePercent: int(math.Round((current/previous - 1) * 100)),
+			})
+		}
+	}
+	sort.Slice(spikes, func(i, j int) bool {
+		if !spikes[i].Date.Equal(spikes[j].Date) {
+			return spikes[i].Date.Before(spikes[j].Date)
+		}
+		return spikes[i].Category < spikes[j].Category
+	})
+	return append(insights, spikes...)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeInsights(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	txns := []*SegmentedTransaction{
+		{TransactionDate: day(1, 1), IncomeOrExpense: "income", Amount: 3000},
+		{TransactionDate: day(1, 5), IncomeOrExpense: "expense", Category: "Dining", Amount: 100},
+		{TransactionDate: day(1, 10), IncomeOrExpense: "expense", Category: "Rent", Amount: 1200},
+		{TransactionDate: day(2, 1), IncomeOrExpense: "income", Amount: 500},
+		{TransactionDate: day(2, 5), IncomeOrExpense: "expense", Category: "Dining", Amount: 200},
+		{TransactionDate: day(2, 10), IncomeOrExpense: "expense", Category: "Rent", Amount: 1200},
+		{TransactionDate: day(2, 20), IncomeOrExpense: "expense", Category: "Travel", Amount: 1000},
+	}
+	netCash := 0.0
+	for _, stx := range txns {
+		if stx.IncomeOrExpense == "income" {
+			netCash += stx.Amount
+		} else {
+			netCash -= stx.Amount
+		}
+		stx.NetCash = netCash
+	}
+
+	got := ComputeInsights(txns, defaultInsightOptions)
+	wants := Insights{
+		{Kind: InsightLowestBalance, Severity: SeverityRisk, Date: day(2, 20), Amount: -200},
+		{Kind: InsightNegativeBalance, Severity: SeverityRisk, Date: day(2, 20), Amount: -200},
+		{Kind: InsightSurplus, Severity: SeverityOpportunity, Date: day(1, 1), Amount: 1700},
+		{Kind: InsightCategorySpike, Severity: SeverityRisk, Date: day(2, 1), Amount: 200, Category: "Dining", ChangePercent: 100},
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("got %d insights, want %d: %+v", len(got), len(wants), got)
+	}
+	for i := range wants {
+		if got[i] != wants[i] {
+			t.Errorf("insight %d: got %+v, want %+v", i, got[i], wants[i])
+		}
+	}
+	if got.Risks() != 3 || got.Opportunities() != 1 {
+		t.Errorf("got %d risks and %d opportunities", got.Risks(), got.Opportunities())
+	}
+
+	if insights := ComputeInsights(nil, defaultInsightOptions); len(insights) != 0 {
+		t.Errorf("got insights without transactions: %+v", insights)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// signInLimiter locks out a username or an IP address after too many failed sign
+// in attempts within a window. The attempts are kept in memory, a restart forgets
+// them.
+type signInLimiter struct {
+	mu  sync.Mutex
+	now func() time.Time
+
+	window  time.Duration
+	lockout time.Duration
+	// maxUsernameFailures protects one account, maxIPFailures stops a client
+	// guessing the passwords of many accounts
+	maxUsernameFailures int
+	maxIPFailures       int
+
+	attempts map[string]*signInAttempts
+}
+
+type signInAttempts struct {
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// maxSignInAttemptKeys is the number of usernames and IP addresses after which
+// the finished windows are removed
+const maxSignInAttemptKeys = 4096
+
+func newSignInLimiter() *signInLimiter {
+	return &signInLimiter{
+		now:                 time.Now,
+		window:              15 * time.Minute,
+		lockout:             15 * time.Minute,
+		maxUsernameFailures: 5,
+		maxIPFailures:       20,
+		attempts:            map[string]*signInAttempts{},
+	}
+}
+
+func usernameKey(username string) string {
+	return "username:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockedUntil returns when the username and IP address may sign in again, the
+// zero time when they may now.
+func (l *signInLimiter) lockedUntil(username, ip string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var until time.Time
+	for _, key := range []string{usernameKey(username), ipKey(ip)} {
+		if a, ok := l.attempts[key]; ok && a.lockedUntil.After(now) && a.lockedUntil.After(until) {
+			until = a.lockedUntil
+		}
+	}
+	return until
+}
+
+// failed records a failed attempt and starts the lockout of the username or IP
+// address that reached its limit.
+func (l *signInLimiter) failed(username, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.attempts) >= maxSignInAttemptKeys {
+		for key, a := range l.attempts {
+			if now.Sub(a.windowStart) > l.window && !a.lockedUntil.After(now) {
+				delete(l.attempts, key)
+			}
+		}
+	}
+	l.fail(usernameKey(username), l.maxUsernameFailures, now)
+	l.fail(ipKey(ip), l.maxIPFailures, now)
+}
+
+func (l *signInLimiter) fail(key string, max int, now time.Time) {
+	a, ok := l.attempts[key]
+	if !ok {
+		a = &signInAttempts{windowStart: now}
+		l.attempts[key] = a
+	}
+	if now.Sub(a.windowStart) > l.window {
+		a.failures, a.windowStart = 0, now
+	}
+	a.failures++
+	if a.failures >= max {
+		a.lockedUntil = now.Add(l.lockout)
+		a.failures = 0
+		a.windowStart = now
+	}
+}
+
+// succeeded forgets the failed attempts of the username.
+func (l *signInLimiter) succeeded(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, usernameKey(username))
+}
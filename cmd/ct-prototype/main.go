@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -8,7 +9,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,8 +21,6 @@ import (
 	"golang.org/x/term"
 )
 
-const demoPlannerID = "22850c5a-eeee-4995-9eb0-1f6897acdc7e"
-
 func main() {
 	err := godotenv.Load("dev.env")
 	exitOnError(err)
@@ -32,31 +30,40 @@ func main() {
 
 	log := NewConsole(false)
 
-	repository, err := NewPostgresDB(log)
+	var repository Repository
+	if path, ok := os.LookupEnv("SQLITE_PATH"); ok {
+		repository, err = OpenSQLiteDB(path, log)
+	} else {
+		repository, err = NewPostgresDB(log)
+	}
 	exitOnError(err)
 
 	// use the application as a cli tool to add an admin user to the db
 	if *addUser {
+		username := os.Getenv("ADMIN_USERNAME")
 		var password string
-		for len(password) < 6 {
-			fmt.Printf("Enter password (at least 6-16 chars): ")
+		for {
+			fmt.Printf("Enter password (%d-%d chars): ", minPasswordLength, maxPasswordLength)
 			b, err := term.ReadPassword(int(os.Stdin.Fd()))
 			fmt.Println()
 			exitOnError(err)
 			password = string(b)
+			if err = checkPasswordPolicy(username, password); err == nil {
+				break
+			}
+			fmt.Println(err)
 		}
 		newuserID, _ := uuid.NewV4()
-		salt := generateSecureToken(8)
-		hash, err := GeneratePasswordHash(password, salt)
+		hash, salt, err := newPasswordHash(password)
 		exitOnError(err)
 		err = repository.AddUser(
 			newuserID,
-			os.Getenv("ADMIN_USERNAME"),
+			username,
 			hash,
 			salt,
 		)
 		exitOnError(err)
-		log.Info().Msgf("added user %s with id %s", os.Getenv("ADMIN_USERNAME"), newuserID)
+		log.Info().Msgf("added user %s with id %s", username, newuserID)
 		return
 	}
 
@@ -117,6 +124,8 @@ type Server struct {
 	AdminUsername     string
 	AdminPasswordHash string
 
+	signInLimiter *signInLimiter
+
 	mux      *http.ServeMux
 	homeTmpl *template.Template
 	listTmpl *template.Template
@@ -125,19 +134,117 @@ type Server struct {
 type Repository interface {
 	AddUser(ID uuid.UUID, username, passwordHash, passwordSalt string) error
 	GetUser(username string) (*User, error)
-	CreateSignInSession(username string) (string, error)
-	IsSignInTokenValid(username string, token string) (bool, error)
-	DeleteSignInToken(username string) error
+	UpdatePassword(userID uuid.UUID, passwordHash, passwordSalt string) error
+	// SetCalendarToken replaces the hash of the calendar feed token of the user, an
+	// empty hash turns the feed off.
+	SetCalendarToken(userID uuid.UUID, tokenHash string) error
+	GetCalendarTokenUser(tokenHash string) (*User, error)
+	// DeleteUser deletes the user with the planners the user owns, the sessions and
+	// API tokens of the user, and removes the user from the planners of others.
+	DeleteUser(userID uuid.UUID) error
+
+	// AddSession adds a signed in device and removes the expired sessions of the user.
+	AddSession(session *Session) error
+	// GetSession returns the session with the token hash that has not expired at now
+	// with its user and records that it was seen.
+	GetSession(tokenHash string, now time.Time) (*Session, *User, error)
+	ListSessions(userID uuid.UUID) ([]Session, error)
+	DeleteSession(userID, sessionID uuid.UUID) error
+	// DeleteSessions signs the user out on every device.
+	DeleteSessions(userID uuid.UUID) error
+
+	AddAPIToken(token *APIToken) error
+	// GetAPITokenUser returns the user of the token with the hash and records that
+	// the token was used.
+	GetAPITokenUser(tokenHash string) (*User, error)
+	ListAPITokens(userID uuid.UUID) ([]APIToken, error)
+	DeleteAPIToken(userID, tokenID uuid.UUID) error
+
+	AddCategory(category *Category) error
+	ListCategories(userID uuid.UUID) ([]Category, error)
+	UpdateCategory(category *Category) error
+	// DeleteCategory deletes the category and moves its children to the top level.
+	DeleteCategory(userID, categoryID uuid.UUID) error
+
+	// The planner methods return ErrNotFound when the user is not a member of the
+	// planner and ErrForbidden when the role of the user does not allow the call.
+	// The UserID of the records that are added or updated is the user making the
+	// change.
+
+	// AddPlanner adds the planner with its user as the owner.
+	AddPlanner(p *Planner) error
+	// GetPlanner returns the planner with the Role of the user.
+	GetPlanner(userID, plannerID uuid.UUID) (*Planner, error)
+	// ListPlanners lists the planners the user is a member of.
+	ListPlanners(userID uuid.UUID) ([]Planner, error)
+	RenamePlanner(userID, plannerID uuid.UUID, name string) error
+	DuplicatePlanner(userID, plannerID, newPlannerID uuid.UUID, name string) error
+	// ForkPlanner copies the planner like DuplicatePlanner into a scenario of it,
+	// each range transaction of the scenario gets a new ID and records the one it
+	// copies in ForkedFromID.
+	ForkPlanner(userID, plannerID, scenarioID uuid.UUID, name string) error
+	// RestorePlanner adds the planner with its accounts, one-time transactions and
+	// overrides, and expands the range transactions around the overrides.
+	RestorePlanner(p *Planner, accounts []Account, rangeTxns []RangeTransaction, txns []ExpandedTransaction) error
+	DeletePlanner(userID, plannerID uuid.UUID) error
+
+	AddAccount(account *Account) error
+	ListAccounts(userID, plannerID uuid.UUID) ([]Account, error)
+	UpdateAccount(account *Account) error
+	// DeleteAccount deletes the account, its transactions move to the main account.
+	DeleteAccount(userID, plannerID, accountID uuid.UUID) error
 
 	AddRangeTransaction(rtx *RangeTransaction) error
+	GetRangeTransaction(userID, plannerID, rangeTransactionID uuid.UUID) (*RangeTransaction, error)
 	UpdateRangeTransaction(rangeTransactionID uuid.UUID, newValue *RangeTransaction) error
 	DeleteRangeTransaction(userID, plannerID, rangeTransactionID uuid.UUID) error
 	ListRangeTransactions(userID, plannerID uuid.UUID) ([]RangeTransaction, error)
 
 	AddExpandedTransaction(etx *ExpandedTransaction) error
+	GetExpandedTransaction(userID, plannerID, expandedTransactionID uuid.UUID) (*ExpandedTransaction, error)
 	UpdateExpandedTransaction(expandedTransactionID uuid.UUID, newValue *ExpandedTransaction) error
 	DeleteExpandedTransaction(userID, plannerID, expandedTransactionID uuid.UUID) error
 	ListExpandedTransactions(userID, plannerID uuid.UUID) ([]ExpandedTransaction, error)
+	// ListExpandedTransactionsBetween lists the transactions from the day of start
+	// to the day of end, both included, by date.
+	ListExpandedTransactionsBetween(userID, plannerID uuid.UUID, start, end time.Time) ([]ExpandedTransaction, error)
+	// ExpandedTransactionTotalsBefore returns how much the transactions before the
+	// day of before changed the balance of each account.
+	ExpandedTransactionTotalsBefore(userID, plannerID uuid.UUID, before time.Time) (map[uuid.UUID]float64, error)
+	// AddImportedTransactions adds the transactions whose ImportID is not in the
+	// planner yet and returns how many were added.
+	AddImportedTransactions(userID, plannerID uuid.UUID, txns []ExpandedTransaction) (int, error)
+
+	// ListPlannerMembers lists the members of the planner with their usernames,
+	// the owner first.
+	ListPlannerMembers(userID, plannerID uuid.UUID) ([]PlannerMember, error)
+	// SetPlannerMember adds the member or changes its role. Only the owner manages
+	// the members and the owner keeps its role.
+	SetPlannerMember(userID uuid.UUID, member *PlannerMember) error
+	// DeletePlannerMember removes the member from the planner. The owner removes the
+	// others and the others only remove themselves.
+	DeletePlannerMember(userID, plannerID, memberID uuid.UUID) error
+	AddPlannerInvite(userID uuid.UUID, invite *PlannerInvite) error
+	ListPlannerInvites(userID, plannerID uuid.UUID) ([]PlannerInvite, error)
+	DeletePlannerInvite(userID, plannerID, inviteID uuid.UUID) error
+	// GetPlannerInvite returns the invite with the token hash that has not expired at
+	// now with the planner it is for.
+	GetPlannerInvite(tokenHash string, now time.Time) (*PlannerInvite, *Planner, error)
+	// AcceptPlannerInvite makes the user a member of the planner of the invite and
+	// deletes the invite. A user who is already a member keeps their role.
+	AcceptPlannerInvite(userID uuid.UUID, tokenHash string, now time.Time) (*Planner, error)
+
+	// ListAuditEntries lists the changes of the planner data, the newest first. The
+	// methods that change the accounts and transactions add the entries.
+	ListAuditEntries(userID, plannerID uuid.UUID) ([]AuditEntry, error)
+	GetAuditEntry(userID, plannerID, entryID uuid.UUID) (*AuditEntry, error)
+	// LatestAuditEntry returns the latest change of the user to the record, which is
+	// the change a request of the user just made.
+	LatestAuditEntry(userID, plannerID, entityID uuid.UUID) (*AuditEntry, error)
+	// UndoAuditEntry restores the rows from before the change and adds an undo
+	// entry. It returns ErrConflict when the change was undone, is an undo or a
+	// later change of the same rows was not undone.
+	UndoAuditEntry(userID, plannerID, entryID uuid.UUID) error
 }
 
 func NewServer(
@@ -147,9 +254,10 @@ func NewServer(
 	passwordHash string,
 ) (*Server, error) {
 	s := &Server{
-		repository: repository,
-		logger:     logger,
-		mux:        http.NewServeMux(),
+		repository:    repository,
+		logger:        logger,
+		signInLimiter: newSignInLimiter(),
+		mux:           http.NewServeMux(),
 	}
 	s.addRoutes()
 	return s, nil
@@ -174,18 +282,75 @@ func (s *Server) addRoutes() {
 	// s.mux.HandleFunc("/healthz", healthz())
 	s.mux.HandleFunc("/sign-in", csrf(s.signIn))
 	s.mux.HandleFunc("/sign-out", s.signedIn(csrf(s.signOut)))
+	s.mux.HandleFunc("/sign-out-everywhere", s.signedIn(csrf(s.signOutEverywhere)))
+	s.mux.HandleFunc("GET /sign-up", s.signUpPage)
+	s.mux.HandleFunc("POST /sign-up", csrf(s.signUp))
 
-	s.mux.HandleFunc("/demo", s.seedDemoData)
+	s.mux.HandleFunc("GET /settings", s.signedIn(s.settingsPage))
+	s.mux.HandleFunc("POST /settings/sessions/{sessionID}/delete", s.signedIn(csrf(s.revokeSession)))
+	s.mux.HandleFunc("POST /settings/password", s.signedIn(csrf(s.changePassword)))
+	s.mux.HandleFunc("POST /settings/delete-account", s.signedIn(csrf(s.deleteAccount)))
 
-	s.mux.HandleFunc("/add-range-transaction", s.signedIn(csrf(s.addRangeEntry)))
-	s.mux.HandleFunc("/update-range-transaction", s.signedIn(csrf(s.notImplemented)))
-	s.mux.HandleFunc("/delete-range-transaction", s.signedIn(csrf(s.deleteRangeEntry)))
-
-	s.mux.HandleFunc("/add-one-time-transaction", s.signedIn(csrf(s.addOneTimeEntry)))
-	s.mux.HandleFunc("/update-one-time-transaction", s.signedIn(csrf(s.notImplemented)))
-	s.mux.HandleFunc("/delete-one-time-transaction", s.signedIn(csrf(s.deleteOneTimeEntry)))
+	s.mux.HandleFunc("/demo", s.seedDemoData)
 
-	s.mux.HandleFunc("/add-free-flow", s.signedIn(csrf(s.notImplemented)))
+	s.mux.HandleFunc("/planners", s.signedIn(s.listPlanners))
+	s.mux.HandleFunc("/planners/create", s.signedIn(csrf(s.createPlanner)))
+	s.mux.HandleFunc("POST /planners/restore", s.signedIn(csrf(s.restorePlanner)))
+	s.mux.HandleFunc("/planners/{id}", s.signedIn(s.plannerHome))
+	s.mux.HandleFunc("/planners/{id}/rename", s.signedIn(csrf(s.renamePlanner)))
+	s.mux.HandleFunc("/planners/{id}/duplicate", s.signedIn(csrf(s.duplicatePlanner)))
+	s.mux.HandleFunc("POST /planners/{id}/fork", s.signedIn(csrf(s.forkPlanner)))
+	s.mux.HandleFunc("GET /planners/{id}/compare", s.signedIn(s.comparePlanners))
+	s.mux.HandleFunc("/planners/{id}/delete", s.signedIn(csrf(s.deletePlanner)))
+
+	s.mux.HandleFunc("/planners/{id}/add-range-transaction", s.signedIn(csrf(s.addRangeEntry)))
+	s.mux.HandleFunc("/planners/{id}/range-transactions/{txID}/edit", s.signedIn(s.editRangeEntry))
+	s.mux.HandleFunc("/planners/{id}/update-range-transaction", s.signedIn(csrf(s.updateRangeEntry)))
+	s.mux.HandleFunc("/planners/{id}/delete-range-transaction", s.signedIn(csrf(s.deleteRangeEntry)))
+
+	s.mux.HandleFunc("/planners/{id}/add-one-time-transaction", s.signedIn(csrf(s.addOneTimeEntry)))
+	s.mux.HandleFunc("/planners/{id}/one-time-transactions/{txID}/edit", s.signedIn(s.editOneTimeEntry))
+	s.mux.HandleFunc("/planners/{id}/update-one-time-transaction", s.signedIn(csrf(s.updateOneTimeEntry)))
+	s.mux.HandleFunc("/planners/{id}/delete-one-time-transaction", s.signedIn(csrf(s.deleteOneTimeEntry)))
+
+	s.mux.HandleFunc("GET /planners/{id}/import", s.signedIn(s.importPage))
+	s.mux.HandleFunc("POST /planners/{id}/import", s.signedIn(csrf(s.importStatement)))
+	s.mux.HandleFunc("GET /planners/{id}/simulation", s.signedIn(s.simulation))
+	s.mux.HandleFunc("GET /planners/{id}/summary", s.signedIn(s.plannerSummary))
+	s.mux.HandleFunc("GET /planners/{id}/budget", s.signedIn(s.budgetPage))
+	s.mux.HandleFunc("GET /planners/{id}/export/{dataset}", s.signedIn(s.exportPlanner))
+	s.mux.HandleFunc("GET /planners/{id}/accounts", s.signedIn(s.plannerAccountsPage))
+	s.mux.HandleFunc("POST /planners/{id}/accounts", s.signedIn(csrf(s.createPlannerAccount)))
+	s.mux.HandleFunc("POST /planners/{id}/accounts/{accountID}/update", s.signedIn(csrf(s.updatePlannerAccount)))
+	s.mux.HandleFunc("POST /planners/{id}/accounts/{accountID}/delete", s.signedIn(csrf(s.deletePlannerAccount)))
+
+	s.mux.HandleFunc("GET /planners/{id}/history", s.signedIn(s.plannerHistoryPage))
+	s.mux.HandleFunc("POST /planners/{id}/history/{entryID}/undo", s.signedIn(csrf(s.undoPlannerChange)))
+
+	s.mux.HandleFunc("GET /planners/{id}/members", s.signedIn(s.plannerMembersPage))
+	s.mux.HandleFunc("POST /planners/{id}/members", s.signedIn(csrf(s.addPlannerMember)))
+	s.mux.HandleFunc("POST /planners/{id}/members/{userID}/update", s.signedIn(csrf(s.updatePlannerMember)))
+	s.mux.HandleFunc("POST /planners/{id}/members/{userID}/delete", s.signedIn(csrf(s.deletePlannerMember)))
+	s.mux.HandleFunc("POST /planners/{id}/invites", s.signedIn(csrf(s.createPlannerInvite)))
+	s.mux.HandleFunc("POST /planners/{id}/invites/{inviteID}/delete", s.signedIn(csrf(s.deletePlannerInvite)))
+	s.mux.HandleFunc("GET /invites/{token}", s.signedIn(s.invitePage))
+	s.mux.HandleFunc("POST /invites/{token}", s.signedIn(csrf(s.acceptPlannerInvite)))
+
+	s.mux.HandleFunc("/planners/{id}/add-free-flow", s.signedIn(csrf(s.notImplemented)))
+
+	s.mux.HandleFunc("GET /categories", s.signedIn(s.categoriesPage))
+	s.mux.HandleFunc("POST /categories", s.signedIn(csrf(s.createCategory)))
+	s.mux.HandleFunc("POST /categories/{categoryID}/update", s.signedIn(csrf(s.updateCategory)))
+	s.mux.HandleFunc("POST /categories/{categoryID}/delete", s.signedIn(csrf(s.deleteCategory)))
+
+	s.mux.HandleFunc("GET /settings/api-tokens", s.signedIn(s.apiTokensPage))
+	s.mux.HandleFunc("POST /settings/api-tokens", s.signedIn(csrf(s.createAPIToken)))
+	s.mux.HandleFunc("POST /settings/api-tokens/{tokenID}/delete", s.signedIn(csrf(s.deleteAPIToken)))
+	s.mux.HandleFunc("POST /settings/calendar-token", s.signedIn(csrf(s.createCalendarToken)))
+	s.mux.HandleFunc("POST /settings/calendar-token/delete", s.signedIn(csrf(s.deleteCalendarToken)))
+	s.mux.HandleFunc("GET /calendar/{token}", s.calendarFeed)
+
+	s.addAPIRoutes()
 }
 
 func (s *Server) signIn(w http.ResponseWriter, r *http.Request) {
@@ -199,55 +364,50 @@ func (s *Server) signIn(w http.ResponseWriter, r *http.Request) {
 		"sign in attempt with user %s and return url %s",
 		username, returnURL,
 	)
+	ip := clientIP(r)
+	if until := s.signInLimiter.lockedUntil(username, ip); !until.IsZero() {
+		s.logger.Warn().Str("ip", ip).Time("locked_until", until).Msgf("sign in of user %s is locked", username)
+		location := "/?error=locked&return-url=" + url.QueryEscape(returnURL)
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
 	user, err := s.repository.GetUser(username)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		s.internalError(w, "unable to get user", err)
 		return
 	}
-	s.logger.Info().Msgf("got user with id %s", user.ID)
 
-	// TODO rate-limit
-	if os.Getenv("BYPASS_LOGIN") != "true" || CheckPasswordHash(
+	if user == nil || CheckPasswordHash(
 		user.PasswordSalt,
 		password,
 		user.PasswordHash,
 	) != nil {
-		s.logger.Info().Str("pw_bypass", os.Getenv("BYPASS_LOGIN")).Msgf("password verification failed")
+		s.logger.Info().Msgf("password verification failed for user %s", username)
+		s.signInLimiter.failed(username, ip)
 		location := "/?error=sign-in&return-url=" + url.QueryEscape(returnURL)
 		http.Redirect(w, r, location, http.StatusFound)
 		return
 	}
-	token, err := s.repository.CreateSignInSession(username)
-	if err != nil {
+	s.signInLimiter.succeeded(username)
+	if err = s.startSession(w, r, user); err != nil {
 		s.internalError(w, "creating sign in", err)
 		return
 	}
-	initBrowserSession(w, r, username, token)
 
 	s.logger.Info().Msgf("login success for user %s", username)
 	http.Redirect(w, r, returnURL, http.StatusFound)
 }
 
+// signOut ends the session of the device.
 func (s *Server) signOut(w http.ResponseWriter, r *http.Request) {
-	cookie := &http.Cookie{
-		Name:     "session_token",
-		MaxAge:   -1,
-		Path:     "/",
-		Secure:   r.URL.Scheme == "https",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	}
-	http.SetCookie(w, cookie)
+	clearBrowserSession(w, r)
 
-	userLogin, err := extractUserLogin(r)
+	signedIn, err := s.sessionForRequest(r)
 	if err != nil {
 		s.internalError(w, "deleting sign in", err)
 		return
 	}
-
-	err = s.repository.DeleteSignInToken(
-		userLogin.Username,
-	)
+	err = s.repository.DeleteSession(signedIn.user.ID, signedIn.session.ID)
 	if err != nil {
 		s.internalError(w, "deleting sign in", err)
 		return
@@ -256,34 +416,8 @@ func (s *Server) signOut(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func (s *Server) signedIn(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.isSignedIn(r) {
-			location := "/?return-url=" + url.QueryEscape(r.URL.Path)
-			http.Redirect(w, r, location, http.StatusFound)
-			return
-		}
-		h(w, r)
-	}
-}
-
-func (s *Server) isSignedIn(r *http.Request) bool {
-	userLogin, err := extractUserLogin(r)
-	if err != nil {
-		return false
-	}
-
-	// TODO rate-limit
-	valid, err := s.repository.IsSignInTokenValid(
-		userLogin.Username,
-		userLogin.SessionToken,
-	)
-	return err == nil && valid
-}
-
 func (s *Server) seedDemoData(w http.ResponseWriter, r *http.Request) {
 	var err error
-	plannerID, _ := uuid.FromString(demoPlannerID)
 
 	isSignedIn := s.isSignedIn(r)
 	if !isSignedIn {
@@ -305,7 +439,21 @@ func (s *Server) seedDemoData(w http.ResponseWriter, r *http.Request) {
 
 	user, _ := s.repository.GetUser(os.Getenv("ADMIN_USERNAME"))
 
+	plannerID, _ := uuid.NewV4()
+	err = s.repository.AddPlanner(&Planner{
+		ID:            plannerID,
+		UserID:        user.ID,
+		Name:          "Demo",
+		HorizonMonths: 12,
+		Currency:      "USD",
+	})
+	if err != nil {
+		s.internalError(w, "unable to add demo planner", err)
+		return
+	}
+
 	for _, rtx := range bankRangeTxns {
+		rtx.ID, _ = uuid.NewV4()
 		rtx.UserID = user.ID
 		rtx.PlannerID = plannerID
 		err = s.repository.AddRangeTransaction(&rtx)
@@ -316,6 +464,7 @@ func (s *Server) seedDemoData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, etx := range bankOneTimeTxns {
+		etx.ID, _ = uuid.NewV4()
 		etx.UserID = user.ID
 		etx.PlannerID = plannerID
 		err = s.repository.AddExpandedTransaction(&etx)
@@ -325,79 +474,91 @@ func (s *Server) seedDemoData(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	s.logger.Info().Msgf("added %d range transactins and %d one time entries", len(bankRangeTxns), len(bankOneTimeTxns))
-	http.Redirect(w, r, "/", http.StatusFound)
-}
-
-func (s *Server) home(w http.ResponseWriter, r *http.Request) {
-	isSignedIn := s.isSignedIn(r)
-	plannerID, err := uuid.FromString(demoPlannerID)
+	categories, err := s.repository.ListCategories(user.ID)
 	if err != nil {
-		s.internalError(w, "unable to render template", err)
+		s.internalError(w, "unable to list categories", err)
 		return
 	}
-
-	var allRangeTxns []RangeTransaction
-	var segTxns []*SegmentedTransaction
-	if isSignedIn {
-		userLogin, err := extractUserLogin(r)
-		if err != nil {
-			s.internalError(w, "unable to get user login", err)
-			return
+	// the categories are shared by the planners of the user, add them once
+	if len(categories) == 0 {
+		// the fixed IDs only link the children to their parents, the rows get new ones
+		ids := map[uuid.UUID]uuid.UUID{}
+		for _, c := range demoCategories {
+			id, _ := uuid.NewV4()
+			if c.ID != uuid.Nil {
+				ids[c.ID] = id
+			}
+			c.ID = id
+			c.ParentID = ids[c.ParentID]
+			c.UserID = user.ID
+			if err = s.repository.AddCategory(&c); err != nil {
+				s.internalError(w, "unable to add category seed data", err)
+				return
+			}
 		}
-		user, _ := s.repository.GetUser(userLogin.Username)
+	}
 
-		rangeTxns, err := s.repository.ListRangeTransactions(user.ID, plannerID)
-		if err != nil {
-			s.internalError(w, "unable to fetch range txns", err)
-			return
-		}
+	s.logger.Info().Msgf("added %d range transactins and %d one time entries", len(bankRangeTxns), len(bankOneTimeTxns))
+	http.Redirect(w, r, plannerURL(plannerID), http.StatusFound)
+}
 
-		expandedTransactions, _ := s.repository.ListExpandedTransactions(
-			user.ID, plannerID,
-		)
-		for _, etx := range expandedTransactions {
-			segTxns = append(segTxns, &SegmentedTransaction{
-				ExpandedTransactionID: etx.ID,
-				Title:                 etx.Title,
-				TransactionDate:       etx.TransactionDate,
-				IncomeOrExpense:       etx.IncomeOrExpense,
-				Amount:                etx.Amount,
-			})
+// home renders the sign in form or sends the user to their most recently updated planner.
+func (s *Server) home(w http.ResponseWriter, r *http.Request) {
+	if !s.isSignedIn(r) {
+		data := HomePageState{
+			CSRFToken:    getCSRFToken(w, r),
+			ReturnURL:    r.URL.Query().Get("return-url"),
+			SignInError:  r.URL.Query().Get("error") == "sign-in",
+			SignInLocked: r.URL.Query().Get("error") == "locked",
 		}
-
-		sort.SliceStable(segTxns, func(i, j int) bool {
-			return segTxns[i].TransactionDate.Before(segTxns[j].TransactionDate)
-		})
-		netCash := float64(0)
-		for _, stx := range segTxns {
-			if stx.IncomeOrExpense == "income" {
-				netCash += stx.Amount
-			} else {
-				netCash -= stx.Amount
-			}
-			stx.NetCash = netCash
+		// render the login screen
+		if err := StaticResources.ExecuteTemplate(w, "index.html", data); err != nil {
+			s.internalError(w, "unable to render template", err)
 		}
+		return
+	}
 
-		allRangeTxns = rangeTxns
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
 	}
+	if len(planners) == 0 {
+		http.Redirect(w, r, "/planners", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planners[0].ID), http.StatusFound)
+}
 
-	data := HomePageState{
-		CSRFToken:             getCSRFToken(w, r),
-		IsLoggedIn:            isSignedIn,
-		ReturnURL:             r.URL.Query().Get("return-url"),
-		SignInError:           r.URL.Query().Get("error") == "sign-in",
-		PlannerID:             plannerID,
-		PlannerEnd:            time.Now().AddDate(1, 0, 0),
-		RangeStart:            time.Now(),
-		RangeEnd:              time.Now().AddDate(0, 1, 0),
-		Username:              os.Getenv("ADMIN_USERNAME"),
-		UserID:                uuid.Nil,
-		RangeTransactions:     allRangeTxns,
-		SegmentedTransactions: segTxns,
+func (s *Server) plannerHome(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	now := time.Now()
+	window, err := parsePlannerWindow(r.URL.Query(), planner, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := s.plannerState(user, planner, window, now, true)
+	if err != nil {
+		s.internalError(w, "unable to compute the planner", err)
+		return
 	}
+	data.CSRFToken = getCSRFToken(w, r)
+	data.Planners = planners
 
-	// render the login screen
 	s.logger.Info().Msg("rendering base template")
 	if err := StaticResources.ExecuteTemplate(w, "index.html", data); err != nil {
 		s.internalError(w, "unable to render template", err)
@@ -405,111 +566,109 @@ func (s *Server) home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) addRangeEntry(w http.ResponseWriter, r *http.Request) {
-	userLogin, err := extractUserLogin(r)
-	if err != nil {
-		s.internalError(w, "unable to get user login", err)
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	form, err := s.decodeRangeTransactionForm(r, nil)
+	if err == nil {
+		err = s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm)
+	}
+	if errors.Is(err, errStartInPast) {
+		fmt.Fprintf(w, "<h2>%s</h2>", err)
 		return
 	}
-	user, _ := s.repository.GetUser(userLogin.Username)
-
-	plannerID, err := uuid.FromString(demoPlannerID)
 	if err != nil {
-		s.internalError(w, "unable to render template", err)
+		s.internalError(w, "unable to validate POST form", err)
 		return
 	}
-	newUUID, _ := uuid.NewV4()
 
-	if err := r.ParseForm(); err != nil {
-		s.internalError(w, "unable to parse form", err)
+	transaction := form.rangeTransaction()
+	transaction.ID, _ = uuid.NewV4()
+	transaction.PlannerID = planner.ID
+	transaction.UserID = user.ID
+	transaction.Source = "planner"
+
+	if err = s.repository.AddRangeTransaction(transaction); err != nil {
+		s.internalError(w, "unable to save range tnx", err)
 		return
 	}
+	s.plannerChanged(w, r, user, planner, transaction.ID)
+}
 
-	_ = func(timeInput string) time.Time {
-		t, err := time.Parse("Jan 02, 2006", timeInput)
-		if err != nil {
-			s.internalError(w, "unable parse time input", err)
-		}
-		return t
+// editRangeEntry renders the form to edit a range transaction pre-filled with its values.
+func (s *Server) editRangeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
 	}
-
-	validate := validator.New()
-	type Form struct {
-		Title           string  `form:"title" validate:"required,min=0,max=255"`
-		IncomeOrExpense string  `form:"income_or_expense" validate:"required,lowercase,min=0,max=255"`
-		Category        string  `form:"category" validate:"min=0,max=255"`
-		Notes           string  `form:"notes" validate:"min=0,max=255"`
-		Amount          float64 `form:"amount" validate:"required,gt=0"`
-
-		RecurrenceEveryDays int       `form:"recurrence_every" validate:"required"`
-		RecurrenceStart     time.Time `form:"recurrence_start"`
-		RecurrenceEnd       time.Time `form:"recurrence_end"`
+	id, err := uuid.FromString(r.PathValue("txID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rtx, err := s.repository.GetRangeTransaction(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
 	}
-	// validate:"datetime='Jan 02, 2006'"
-
-	decoder := form.NewDecoder()
-	decoder.RegisterCustomTypeFunc(func(vals []string) (interface{}, error) {
-		return time.Parse("2006-01-02", vals[0])
-	}, time.Time{})
-
-	var form Form
-	err = decoder.Decode(&form, r.PostForm)
 	if err != nil {
-		s.internalError(w, "unable to parse POST form", err)
-		l := s.logger.Error()
-		for k, v := range r.PostForm {
-			l = l.Strs(k, v)
-		}
-		l.Send()
+		s.internalError(w, "unable to get range transaction", err)
 		return
 	}
-	err = validate.Struct(form)
+	s.renderEditPage(w, r, user, planner, HomePageState{EditRangeTransaction: rtx})
+}
+
+func (s *Server) updateRangeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.FormValue("range_transaction_id"))
 	if err != nil {
 		s.internalError(w, "unable to validate POST form", err)
 		return
 	}
-	if time.Now().AddDate(0, 0, -1).After(form.RecurrenceStart) {
-		fmt.Fprintf(w, "<h2>cannot have a future item starting in the past. focus on the future</h2>")
+	stored, err := s.repository.GetRangeTransaction(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
 		return
 	}
-	if form.RecurrenceEnd.Before(form.RecurrenceStart) {
-		s.internalError(w, "recurrence cannot end before it starts", err)
+	if err != nil {
+		s.internalError(w, "unable to get range transaction", err)
 		return
 	}
-
-	transaction := &RangeTransaction{
-		ID:                  newUUID,
-		PlannerID:           plannerID,
-		UserID:              user.ID,
-		Title:               form.Title,
-		IncomeOrExpense:     form.IncomeOrExpense,
-		Category:            form.Category,
-		Notes:               form.Notes,
-		RecurrenceEveryDays: form.RecurrenceEveryDays,
-		RecurrenceStart:     form.RecurrenceStart,
-		RecurrenceEnd:       form.RecurrenceEnd,
-		Amount:              form.Amount,
-		Source:              "planner",
+	form, err := s.decodeRangeTransactionForm(r, stored)
+	if err == nil {
+		err = s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm)
 	}
-
-	if err = s.repository.AddRangeTransaction(transaction); err != nil {
-		s.internalError(w, "unable to save range tnx", err)
+	if errors.Is(err, errStartInPast) {
+		fmt.Fprintf(w, "<h2>%s</h2>", err)
 		return
 	}
-	http.Redirect(w, r, "/", http.StatusFound)
-}
-
-func (s *Server) deleteRangeEntry(w http.ResponseWriter, r *http.Request) {
-	userLogin, err := extractUserLogin(r)
 	if err != nil {
-		s.internalError(w, "unable to get user login", err)
+		s.internalError(w, "unable to validate POST form", err)
 		return
 	}
-	user, _ := s.repository.GetUser(userLogin.Username)
-	plannerID, err := uuid.FromString(demoPlannerID)
+
+	transaction := form.rangeTransaction()
+	transaction.PlannerID = planner.ID
+	transaction.UserID = user.ID
+	err = s.repository.UpdateRangeTransaction(id, transaction)
 	if err != nil {
-		s.internalError(w, "unable to render template", err)
+		s.internalError(w, "unable to update range transaction", err)
 		return
 	}
+	s.logger.Info().Msgf("updated range transaction with id %s", id)
+	s.plannerChanged(w, r, user, planner, id)
+}
+
+func (s *Server) deleteRangeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	var err error
 
 	if err := r.ParseForm(); err != nil {
 		s.internalError(w, "unable to parse form", err)
@@ -539,95 +698,100 @@ func (s *Server) deleteRangeEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id, _ := uuid.FromString(form.RangeTransactionID)
-	err = s.repository.DeleteRangeTransaction(user.ID, plannerID, id)
+	err = s.repository.DeleteRangeTransaction(user.ID, planner.ID, id)
 	if err != nil {
 		s.internalError(w, "unable to delete range transaction", err)
 		return
 	}
 	s.logger.Info().Msgf("deleted range transaction with id %s", form.RangeTransactionID)
-	http.Redirect(w, r, "/", http.StatusFound)
+	s.plannerChanged(w, r, user, planner, id)
 }
 
 func (s *Server) addOneTimeEntry(w http.ResponseWriter, r *http.Request) {
-	userLogin, err := extractUserLogin(r)
-	if err != nil {
-		s.internalError(w, "unable to get user login", err)
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
 		return
 	}
-	user, _ := s.repository.GetUser(userLogin.Username)
-	plannerID, err := uuid.FromString(demoPlannerID)
+	form, err := s.decodeOneTimeTransactionForm(r)
+	if err == nil {
+		err = s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm)
+	}
 	if err != nil {
-		s.internalError(w, "unable to render template", err)
+		s.internalError(w, "unable to validate POST form", err)
 		return
 	}
-
-	if err := r.ParseForm(); err != nil {
-		s.internalError(w, "unable to parse form", err)
+	transaction := form.expandedTransaction()
+	transaction.ID, _ = uuid.NewV4()
+	transaction.UserID = user.ID
+	transaction.PlannerID = planner.ID
+	if err = s.repository.AddExpandedTransaction(transaction); err != nil {
+		s.internalError(w, "unable to add item", err)
 		return
 	}
+	s.logger.Info().Msgf("added one-time transaction with id %s", transaction.ID)
+	s.plannerChanged(w, r, user, planner, transaction.ID)
+}
 
-	validate := validator.New()
-	type Form struct {
-		Title           string    `form:"title" validate:"required,min=1,max=255"`
-		IncomeOrExpense string    `form:"income_or_expense" validate:"required,oneof=income expense"`
-		Category        string    `form:"category" validate:"min=0,max=255"`
-		Amount          float64   `form:"amount" validate:"required,gt=0"`
-		TransactionDate time.Time `form:"transaction_date"`
+// editOneTimeEntry renders the form to edit a one-time transaction or a single
+// occurrence of a range transaction.
+func (s *Server) editOneTimeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
 	}
-
-	decoder := form.NewDecoder()
-	decoder.RegisterCustomTypeFunc(func(vals []string) (interface{}, error) {
-		return time.Parse("2006-01-02", vals[0])
-	}, time.Time{})
-
-	var form Form
-	err = decoder.Decode(&form, r.PostForm)
+	id, err := uuid.FromString(r.PathValue("txID"))
 	if err != nil {
-		s.internalError(w, "unable to parse POST form", err)
-		l := s.logger.Error()
-		for k, v := range r.PostForm {
-			l = l.Strs(k, v)
-		}
-		l.Send()
+		http.NotFound(w, r)
 		return
 	}
-	err = validate.Struct(form)
-	if err != nil {
-		s.internalError(w, "unable to validate POST form", err)
+	etx, err := s.repository.GetExpandedTransaction(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
 		return
 	}
-	newUUID, _ := uuid.NewV4()
-	err = s.repository.AddExpandedTransaction(&ExpandedTransaction{
-		ID:                 newUUID,
-		UserID:             user.ID,
-		RangeTransactionID: uuid.Nil,
-		PlannerID:          plannerID,
-		Title:              form.Title,
-		IncomeOrExpense:    form.IncomeOrExpense,
-		Category:           form.Category,
-		TransactionDate:    form.TransactionDate,
-		Amount:             form.Amount,
-	})
 	if err != nil {
-		s.internalError(w, "unable to add item", err)
+		s.internalError(w, "unable to get transaction", err)
 		return
 	}
-	s.logger.Info().Msgf("added one-time transaction with id %s", newUUID)
-	http.Redirect(w, r, "/", http.StatusFound)
+	s.renderEditPage(w, r, user, planner, HomePageState{EditExpandedTransaction: etx})
 }
 
-func (s *Server) deleteOneTimeEntry(w http.ResponseWriter, r *http.Request) {
-	userLogin, err := extractUserLogin(r)
+func (s *Server) updateOneTimeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.FormValue("expanded_transaction_id"))
 	if err != nil {
-		s.internalError(w, "unable to get user login", err)
+		s.internalError(w, "unable to validate POST form", err)
 		return
 	}
-	user, _ := s.repository.GetUser(userLogin.Username)
-	plannerID, err := uuid.FromString(demoPlannerID)
+	form, err := s.decodeOneTimeTransactionForm(r)
+	if err == nil {
+		err = s.checkAccounts(planner, form.IncomeOrExpense, &form.accountsForm)
+	}
 	if err != nil {
-		s.internalError(w, "unable to render template", err)
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+	transaction := form.expandedTransaction()
+	transaction.UserID = user.ID
+	transaction.PlannerID = planner.ID
+	err = s.repository.UpdateExpandedTransaction(id, transaction)
+	if err != nil {
+		s.internalError(w, "unable to update transaction", err)
 		return
 	}
+	s.logger.Info().Msgf("updated one-time transaction with id %s", id)
+	s.plannerChanged(w, r, user, planner, id)
+}
+
+func (s *Server) deleteOneTimeEntry(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	var err error
 
 	if err := r.ParseForm(); err != nil {
 		s.internalError(w, "unable to parse form", err)
@@ -661,13 +825,44 @@ func (s *Server) deleteOneTimeEntry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	id, _ := uuid.FromString(form.ExpandedTransactionID)
-	err = s.repository.DeleteExpandedTransaction(user.ID, plannerID, id)
+	err = s.repository.DeleteExpandedTransaction(user.ID, planner.ID, id)
 	if err != nil {
 		s.internalError(w, "unable to add item", err)
 		return
 	}
 	s.logger.Info().Msgf("added one-time transaction with id %s", id)
-	http.Redirect(w, r, "/", http.StatusFound)
+	s.plannerChanged(w, r, user, planner, id)
+}
+
+// renderEditPage renders the edit form of the transaction set in data.
+func (s *Server) renderEditPage(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, data HomePageState) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	categories, err := s.repository.ListCategories(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list categories", err)
+		return
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list accounts", err)
+		return
+	}
+	data.CSRFToken = getCSRFToken(w, r)
+	data.IsLoggedIn = true
+	data.Username = user.Username
+	data.UserID = user.ID
+	data.PlannerID = planner.ID
+	data.Planner = planner
+	data.Planners = planners
+	data.Categories = categories
+	data.Accounts = plannerAccounts(planner, accounts)
+	if err := StaticResources.ExecuteTemplate(w, "edit_transaction.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
 }
 
 func (s *Server) notImplemented(w http.ResponseWriter, r *http.Request) {
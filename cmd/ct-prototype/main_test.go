@@ -1,35 +1,133 @@
 package main
 
 import (
-	"database/sql"
+	"bytes"
 	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
 	"golang.org/x/net/html"
-	_ "modernc.org/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
-func TestServer(t *testing.T) {
-	db, err := sql.Open("sqlite", ":memory:")
+const (
+	testUsername = "user@prototype.proto"
+	testPassword = "mango-river-42"
+)
+
+// testPostgresDSN runs the tests on Postgres instead of SQLite when
+// CT_TEST_POSTGRES_DSN names a test database in the key=value form of NewPostgresDB.
+var testPostgresDSN = os.Getenv("CT_TEST_POSTGRES_DSN")
+
+// testPasswordHash hashes testPassword for the user, it passes the password
+// policy like a password set on the sign up form.
+func testPasswordHash(t *testing.T, username string) (hash, salt string) {
+	t.Helper()
+	if err := checkPasswordPolicy(username, testPassword); err != nil {
+		t.Fatalf("test password of %s: %v", username, err)
+	}
+	salt = generateSecureToken(8)
+	hash, err := GeneratePasswordHash(testPassword, salt)
+	if err != nil {
+		t.Fatalf("hashing password: %v", err)
+	}
+	return hash, salt
+}
+
+// newTestRepository returns an empty repository on an in-memory sqlite database,
+// or on a schema of its own in the Postgres test database.
+func newTestRepository(t *testing.T) Repository {
+	t.Helper()
+	logger := zerolog.Nop()
+	if testPostgresDSN == "" {
+		repository, err := OpenSQLiteDB(":memory:", &logger)
+		if err != nil {
+			t.Fatalf("creating repository: %v", err)
+		}
+		t.Cleanup(func() {
+			if db, err := repository.db.DB(); err == nil {
+				db.Close()
+			}
+		})
+		return repository
+	}
+
+	admin, err := gorm.Open(postgres.Open(testPostgresDSN), &gorm.Config{Logger: gormlogger.Discard})
 	if err != nil {
 		t.Fatalf("opening database: %v", err)
 	}
-	defer db.Close()
-	model, err := NewSQLModel(db)
+	id, _ := uuid.NewV4()
+	schema := "ct_test_" + strings.ReplaceAll(id.String(), "-", "")
+	if err = admin.Exec(`CREATE SCHEMA ` + schema).Error; err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	repository, err := OpenPostgresDB(testPostgresDSN+" search_path="+schema, &logger)
+	t.Cleanup(func() {
+		if repository != nil {
+			if db, err := repository.db.DB(); err == nil {
+				db.Close()
+			}
+		}
+		if err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`).Error; err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+		if db, err := admin.DB(); err == nil {
+			db.Close()
+		}
+	})
+	if err != nil {
+		t.Fatalf("creating repository: %v", err)
+	}
+	return repository
+}
+
+// countRows returns the number of rows of the table of the repository.
+func countRows(t *testing.T, repository Repository, table string) int {
+	t.Helper()
+	var n int64
+	r, ok := repository.(*GormDB)
+	if !ok {
+		t.Fatalf("no rows to count in %T", repository)
+	}
+	err := r.db.Table(table).Count(&n).Error
 	if err != nil {
-		t.Fatalf("creating model: %v", err)
+		t.Fatal(err)
 	}
-	server, err := NewServer(model, nullLogger{}, "Pacific/Auckland", "", "", true)
+	return int(n)
+}
+
+// newTestServer returns a server on an empty repository with one user.
+func newTestServer(t *testing.T) (*Server, Repository) {
+	t.Helper()
+	logger := zerolog.Nop()
+	repository := newTestRepository(t)
+	userID, _ := uuid.NewV4()
+	hash, salt := testPasswordHash(t, testUsername)
+	if err := repository.AddUser(userID, testUsername, hash, salt); err != nil {
+		t.Fatalf("adding user: %v", err)
+	}
+	server, err := NewServer(repository, &logger, "", "")
 	if err != nil {
 		t.Fatalf("creating server: %v", err)
 	}
+	return server, repository
+}
+
+func TestServer(t *testing.T) {
+	server, _ := newTestServer(t)
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		t.Fatalf("creating cookie jar: %v", err)
@@ -43,202 +141,307 @@ func TestServer(t *testing.T) {
 		ensureCode(t, recorder, http.StatusOK)
 		forms := parseForms(t, recorder.Body.String())
 		ensureInt(t, len(forms), 1)
-		ensureString(t, forms[0].Action, "/create-list")
+		ensureString(t, forms[0].Action, "/sign-in")
 		csrfToken = forms[0].Inputs["csrf-token"]
 		if csrfToken == "" {
 			t.Fatal("csrf-token input not found")
 		}
 	}
 
-	// Create list
-	var listID string
-	var listIDs []string
+	// Sign in with the wrong password
 	{
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("name", "Shopping List")
-		recorder := serve(t, server, jar, "POST", "/create-list", form)
+		form.Set("username", testUsername)
+		form.Set("password", "wrong")
+		recorder := serve(t, server, jar, "POST", "/sign-in", form)
 
-		ensureCode(t, recorder, http.StatusFound)
-		location := recorder.Result().Header.Get("Location")
-		ensureRegex(t, location, "/lists/[a-z]{10}")
-		listID = location[7:]
-		listIDs = append(listIDs, listID)
+		ensureRedirect(t, recorder, http.StatusFound, "/?error=sign-in&return-url=%2F")
 	}
 
-	// Create another list
+	// Sign in
 	{
-		time.Sleep(time.Millisecond) // wait at least 1ms to ensure time_created is newer
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("name", "Another List")
-		recorder := serve(t, server, jar, "POST", "/create-list", form)
+		form.Set("username", testUsername)
+		form.Set("password", testPassword)
+		recorder := serve(t, server, jar, "POST", "/sign-in", form)
 
-		ensureCode(t, recorder, http.StatusFound)
-		location := recorder.Result().Header.Get("Location")
-		ensureRegex(t, location, "/lists/[a-z]{10}")
-		listIDs = append(listIDs, location[7:])
+		ensureRedirect(t, recorder, http.StatusFound, "/")
 	}
 
-	// Fetch homepage again (should link to lists)
+	// Homepage sends a user without planners to the planners list
 	{
 		recorder := serve(t, server, jar, "GET", "/", nil)
 
-		links := parseLinks(t, recorder.Body.String())
-		ensureInt(t, len(links), 5) // 2 links per list (view + delete), 1 link for "About"
-		ensureString(t, links[0].Href, "/lists/"+listIDs[1])
-		ensureString(t, links[0].Text, "Another List")
-		ensureString(t, links[1].Href, "/lists/"+listIDs[1]+"?delete=1")
-		ensureString(t, links[1].Text, "✕")
-		ensureString(t, links[2].Href, "/lists/"+listIDs[0])
-		ensureString(t, links[2].Text, "Shopping List")
-		ensureString(t, links[3].Href, "/lists/"+listIDs[0]+"?delete=1")
-		ensureString(t, links[3].Text, "✕")
-		ensureString(t, links[4].Text, "About")
+		ensureRedirect(t, recorder, http.StatusFound, "/planners")
 	}
 
-	// Fetch list page in "delete" mode
+	// Create planner
+	var plannerURL string
 	{
-		recorder := serve(t, server, jar, "GET", "/lists/"+listIDs[1]+"?delete=1", nil)
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("name", "Household")
+		form.Set("start_balance", "1000")
+		form.Set("horizon_months", "12")
+		form.Set("currency", "USD")
+		recorder := serve(t, server, jar, "POST", "/planners/create", form)
 
-		forms := parseForms(t, recorder.Body.String())
-		ensureInt(t, len(forms), 2)
-		ensureString(t, forms[0].Action, "/delete-list")
-		ensureString(t, forms[0].Inputs["csrf-token"], csrfToken)
-		ensureString(t, forms[0].Inputs["list-id"], listIDs[1])
-		ensureString(t, forms[1].Action, "/add-item")
-		ensureString(t, forms[1].Inputs["csrf-token"], csrfToken)
-		ensureString(t, forms[1].Inputs["list-id"], listIDs[1])
+		ensureCode(t, recorder, http.StatusFound)
+		plannerURL = recorder.Result().Header.Get("Location")
+		ensureRegex(t, plannerURL, "/planners/[0-9a-f-]{36}")
+	}
+
+	// Reject a form with the wrong CSRF token
+	{
+		form := url.Values{}
+		form.Set("csrf-token", "not-the-token")
+		form.Set("title", "Forged")
+		form.Set("income_or_expense", "income")
+		form.Set("transaction_date", time.Now().Format(time.DateOnly))
+		form.Set("amount", "1")
+		recorder := serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form)
+
+		ensureCode(t, recorder, http.StatusBadRequest)
 	}
 
-	// Delete list
+	// Add a weekly expense for three weeks
+	start := time.Now().AddDate(0, 0, 1)
 	{
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("list-id", listIDs[1])
-		recorder := serve(t, server, jar, "POST", "/delete-list", form)
+		form.Set("title", "Groceries")
+		form.Set("income_or_expense", "expense")
+		form.Set("amount", "300")
+		form.Set("recurrence_freq", "weekly")
+		form.Set("recurrence_start", start.Format(time.DateOnly))
+		form.Set("recurrence_end", start.AddDate(0, 0, 20).Format(time.DateOnly))
+		recorder := serve(t, server, jar, "POST", plannerURL+"/add-range-transaction", form)
 
-		ensureRedirect(t, recorder, http.StatusFound, "/")
+		ensureRedirect(t, recorder, http.StatusFound, plannerURL)
 	}
 
-	// Ensure list was deleted foo
+	// Add a one-time income between the second and third expense
+	{
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("title", "Bonus")
+		form.Set("income_or_expense", "income")
+		form.Set("transaction_date", start.AddDate(0, 0, 10).Format(time.DateOnly))
+		form.Set("amount", "500")
+		recorder := serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form)
+
+		ensureRedirect(t, recorder, http.StatusFound, plannerURL)
+	}
+
+	// Homepage sends the user to the planner
 	{
 		recorder := serve(t, server, jar, "GET", "/", nil)
 
-		links := parseLinks(t, recorder.Body.String())
-		ensureInt(t, len(links), 3) // 2 links per list (view + delete), 1 link for "About"
-		ensureString(t, links[0].Href, "/lists/"+listIDs[0])
-		ensureString(t, links[0].Text, "Shopping List")
-		ensureString(t, links[1].Href, "/lists/"+listIDs[0]+"?delete=1")
-		ensureString(t, links[1].Text, "✕")
-		ensureString(t, links[2].Text, "About")
+		ensureRedirect(t, recorder, http.StatusFound, plannerURL)
 	}
 
-	// Fetch empty list
+	// Net cash starts at the planner balance, the whole planner is shown as the
+	// groceries can run into the next month
+	var bonusID, rangeID string
 	{
-		recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
+		recorder := serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
 
-		forms := parseForms(t, recorder.Body.String())
+		ensureCode(t, recorder, http.StatusOK)
+		rows := parseCashFlow(t, recorder.Body.String())
+		ensureCashFlow(t, rows, []string{"Groceries", "Groceries", "Bonus", "Groceries"}, []string{"700", "400", "900", "600"})
+		if !strings.Contains(recorder.Body.String(), "Lowest balance of $400 on "+start.AddDate(0, 0, 7).Format("02 Jan 2006")) {
+			t.Error("planner card is missing the lowest balance insight")
+		}
+
+		forms := formsWithAction(parseForms(t, recorder.Body.String()), plannerURL+"/delete-one-time-transaction")
+		ensureInt(t, len(forms), 4)
+		ensureString(t, forms[2].Inputs["csrf-token"], csrfToken)
+		bonusID = forms[2].Inputs["expanded_transaction_id"]
+
+		forms = formsWithAction(parseForms(t, recorder.Body.String()), plannerURL+"/delete-range-transaction")
 		ensureInt(t, len(forms), 1)
-		ensureString(t, forms[0].Action, "/add-item")
-		ensureString(t, forms[0].Inputs["csrf-token"], csrfToken)
-		ensureString(t, forms[0].Inputs["list-id"], listID)
+		rangeID = forms[0].Inputs["range_transaction_id"]
 	}
 
-	// Add item
+	// Delete the one-time income
 	{
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("list-id", listID)
-		form.Set("description", "Milk (2L)")
-		recorder := serve(t, server, jar, "POST", "/add-item", form)
+		form.Set("expanded_transaction_id", bonusID)
+		recorder := serve(t, server, jar, "POST", plannerURL+"/delete-one-time-transaction", form)
 
-		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+		ensureRedirect(t, recorder, http.StatusFound, plannerURL)
+
+		recorder = serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
+		rows := parseCashFlow(t, recorder.Body.String())
+		ensureCashFlow(t, rows, []string{"Groceries", "Groceries", "Groceries"}, []string{"700", "400", "100"})
 	}
 
-	// Add another item
+	// Delete the range transaction with its occurrences
 	{
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("list-id", listID)
-		form.Set("description", "A dozen eggs")
-		recorder := serve(t, server, jar, "POST", "/add-item", form)
+		form.Set("range_transaction_id", rangeID)
+		recorder := serve(t, server, jar, "POST", plannerURL+"/delete-range-transaction", form)
+
+		ensureRedirect(t, recorder, http.StatusFound, plannerURL)
 
-		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+		recorder = serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
+		ensureInt(t, len(parseCashFlow(t, recorder.Body.String())), 0)
 	}
 
-	// Fetch populated list
-	var itemIDs []string
+	// Sign out
 	{
-		recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		recorder := serve(t, server, jar, "POST", "/sign-out", form)
 
-		forms := parseForms(t, recorder.Body.String())
-		ensureInt(t, len(forms), 5) // 2 forms for each list item, 1 for /add-item
-
-		var labels []string
-		for i := 0; i < 2; i++ {
-			ensureString(t, forms[i*2].Action, "/update-done")
-			ensureString(t, forms[i*2].Inputs["csrf-token"], csrfToken)
-			ensureString(t, forms[i*2].Inputs["list-id"], listID)
-			ensureString(t, forms[i*2].Inputs["done"], "on")
-			itemIDs = append(itemIDs, forms[i*2].Inputs["item-id"])
-			labels = append(labels, forms[i*2].Label)
-			ensureString(t, forms[i*2+1].Action, "/delete-item")
-			ensureString(t, forms[i*2+1].Inputs["csrf-token"], csrfToken)
-			ensureString(t, forms[i*2+1].Inputs["list-id"], listID)
-			ensureString(t, forms[i*2+1].Inputs["item-id"], forms[i*2].Inputs["item-id"])
-		}
-		ensureInt(t, len(labels), 2)
-		ensureString(t, labels[0], "Milk (2L)")
-		ensureString(t, labels[1], "A dozen eggs")
+		ensureRedirect(t, recorder, http.StatusFound, "/")
 
-		ensureString(t, forms[len(forms)-1].Action, "/add-item")
-		ensureString(t, forms[len(forms)-1].Inputs["csrf-token"], csrfToken)
-		ensureString(t, forms[len(forms)-1].Inputs["list-id"], listID)
+		recorder = serve(t, server, jar, "GET", plannerURL, nil)
+		ensureRedirect(t, recorder, http.StatusFound, "/?return-url="+url.QueryEscape(plannerURL))
 	}
+}
 
-	// Mark item done
-	{
-		form := url.Values{}
-		form.Set("csrf-token", csrfToken)
-		form.Set("list-id", listID)
-		form.Set("item-id", itemIDs[1])
-		form.Set("done", "on")
-		recorder := serve(t, server, jar, "POST", "/update-done", form)
+func TestSignedOutRedirect(t *testing.T) {
+	server, _ := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/planners", nil)
+	ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fplanners")
+}
+
+// signInWithPlanner signs in the test user and creates a planner.
+func signInWithPlanner(t *testing.T, server *Server, jar http.CookieJar) (csrfToken, plannerURL string) {
+	t.Helper()
+	recorder := serve(t, server, jar, "GET", "/", nil)
+	csrfToken = parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("username", testUsername)
+	form.Set("password", testPassword)
+	recorder = serve(t, server, jar, "POST", "/sign-in", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("name", "Household")
+	form.Set("currency", "USD")
+	recorder = serve(t, server, jar, "POST", "/planners/create", form)
+	ensureCode(t, recorder, http.StatusFound)
+	return csrfToken, recorder.Result().Header.Get("Location")
+}
 
-		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+func TestImportStatement(t *testing.T) {
+	server, _ := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+
+	upload := func(statement string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("csrf-token", csrfToken)
+		_ = mw.WriteField("source", "bank")
+		fw, _ := mw.CreateFormFile("statement", "march.ofx")
+		_, _ = io.WriteString(fw, statement)
+		mw.Close()
+
+		r := httptest.NewRequest("POST", "http://localhost"+plannerURL+"/import", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		for _, c := range jar.Cookies(r.URL) {
+			r.AddCookie(c)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
 	}
 
-	// Ensure item was marked done
-	{
-		recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	recorder := upload(testSGMLStatement)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), "Added 2 transactions, skipped 0 already imported.") {
+		t.Fatalf("unexpected import page:\n%s", recorder.Body.String())
+	}
 
-		forms := parseForms(t, recorder.Body.String())
-		ensureInt(t, len(forms), 5)
-		ensureString(t, forms[0].Inputs["item-id"], itemIDs[0])
-		ensureString(t, forms[0].Inputs["done"], "on")
-		ensureString(t, forms[2].Inputs["item-id"], itemIDs[1])
-		ensureString(t, forms[2].Inputs["done"], "")
+	recorder = upload(testSGMLStatement)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), "Added 0 transactions, skipped 2 already imported.") {
+		t.Fatalf("unexpected import page:\n%s", recorder.Body.String())
 	}
 
-	// Delete item
-	{
+	// A statement over the limit is refused instead of cut off
+	padding := strings.Repeat(" ", maxStatementSize+1-len(testSGMLStatement))
+	ensureCode(t, upload(testSGMLStatement+padding), http.StatusRequestEntityTooLarge)
+}
+
+func TestUpdateOngoingRangeTransaction(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The series started two months ago
+	start := truncateDay(time.Now().AddDate(0, -2, 0))
+	id, _ := uuid.NewV4()
+	err = repository.AddRangeTransaction(&RangeTransaction{
+		ID: id, UserID: user.ID, PlannerID: plannerID, Title: "Rent", IncomeOrExpense: "expense", Amount: 900,
+		RecurrenceStart: start, RecurrenceEnd: start.AddDate(1, 0, 0), Recurrence: Recurrence{Freq: FreqMonthly},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(start time.Time, amount string) *httptest.ResponseRecorder {
 		form := url.Values{}
 		form.Set("csrf-token", csrfToken)
-		form.Set("list-id", listID)
-		form.Set("item-id", itemIDs[0])
-		recorder := serve(t, server, jar, "POST", "/delete-item", form)
+		form.Set("range_transaction_id", id.String())
+		form.Set("title", "Rent")
+		form.Set("income_or_expense", "expense")
+		form.Set("amount", amount)
+		form.Set("recurrence_freq", "monthly")
+		form.Set("recurrence_start", start.Format(time.DateOnly))
+		form.Set("recurrence_end", start.AddDate(1, 0, 0).Format(time.DateOnly))
+		return serve(t, server, jar, "POST", plannerURL+"/update-range-transaction", form)
+	}
+
+	// The amount changes while the start stays where it was
+	ensureRedirect(t, update(start, "1000"), http.StatusFound, plannerURL)
+	rtx, err := repository.GetRangeTransaction(user.ID, plannerID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureFloat(t, rtx.Amount, 1000)
+	ensureString(t, rtx.RecurrenceStart.Format(time.DateOnly), start.Format(time.DateOnly))
 
-		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+	// Moving the start to another day in the past is refused
+	recorder := update(start.AddDate(0, 0, 7), "1000")
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), errStartInPast.Error()) {
+		t.Fatalf("the start was moved into the past:\n%s", recorder.Body.String())
 	}
+}
 
-	// Ensure item was deleted
-	{
-		recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
-		forms := parseForms(t, recorder.Body.String())
-		ensureInt(t, len(forms), 3)
-		ensureString(t, forms[0].Inputs["item-id"], itemIDs[1])
-		ensureString(t, forms[0].Label, "A dozen eggs")
+// ensureCashFlow asserts the titles and net cash of the cash flow rows.
+func ensureCashFlow(t *testing.T, rows [][]string, titles, netCash []string) {
+	t.Helper()
+	ensureInt(t, len(rows), len(titles))
+	for i, row := range rows {
+		ensureString(t, row[1], titles[i])
+		ensureString(t, row[4], netCash[i])
 	}
 }
 
@@ -275,6 +478,14 @@ func ensureInt(t *testing.T, got, want int) {
 	}
 }
 
+// ensureFloat asserts that got==want for amounts, up to rounding errors.
+func ensureFloat(t *testing.T, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
 // ensureRegex asserts that got (in its entirety) matches the given regex pattern.
 func ensureRegex(t *testing.T, got, pattern string) {
 	t.Helper()
@@ -323,6 +534,19 @@ func parseForms(t *testing.T, htmlStr string) []Form {
 
 	var forms []Form
 	var traverse func(*html.Node)
+	var collect func(*html.Node, *Form)
+
+	collect = func(n *html.Node, f *Form) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "input" {
+				f.Inputs[getAttr(c, "name")] = getAttr(c, "value")
+			}
+			if c.Type == html.ElementNode && c.Data == "label" && f.Label == "" {
+				f.Label = strings.TrimSpace(getText(c))
+			}
+			collect(c, f)
+		}
+	}
 
 	traverse = func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "form" {
@@ -331,28 +555,10 @@ func parseForms(t *testing.T, htmlStr string) []Form {
 			if method != "POST" {
 				t.Fatalf("form %s method: got %s, want POST", action, method)
 			}
-			enctype := getAttr(n, "enctype")
-			if enctype != "application/x-www-form-urlencoded" {
-				t.Fatalf("form %s enctype: got %s, want application/x-www-form-urlencoded",
-					action, method)
-			}
-
-			inputs := make(map[string]string)
-			var label string
-			for c := n.FirstChild; c != nil; c = c.NextSibling {
-				if c.Type == html.ElementNode && c.Data == "input" {
-					inputs[getAttr(c, "name")] = getAttr(c, "value")
-				}
-				if c.Type == html.ElementNode && c.Data == "label" {
-					label = getText(c.FirstChild)
-				}
-			}
-
-			forms = append(forms, Form{
-				Action: action,
-				Inputs: inputs,
-				Label:  label,
-			})
+			f := Form{Action: action, Inputs: make(map[string]string)}
+			collect(n, &f)
+			forms = append(forms, f)
+			return
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			traverse(c)
@@ -363,28 +569,51 @@ func parseForms(t *testing.T, htmlStr string) []Form {
 	return forms
 }
 
-type Link struct {
-	Href string
-	Text string
+// formsWithAction returns the forms that post to the action.
+func formsWithAction(forms []Form, action string) []Form {
+	var matching []Form
+	for _, f := range forms {
+		if f.Action == action {
+			matching = append(matching, f)
+		}
+	}
+	return matching
 }
 
-// parseLinks parses the links in an HTML document and returns the list of links.
-func parseLinks(t *testing.T, htmlStr string) []Link {
+// parseCashFlow returns the trimmed cell texts of the rows of the table with a
+// "Net Cash" column.
+func parseCashFlow(t *testing.T, htmlStr string) [][]string {
 	t.Helper()
 	doc, err := html.Parse(strings.NewReader(htmlStr))
 	if err != nil {
 		t.Fatalf("parsing HTML: %v", err)
 	}
 
-	var links []Link
+	var rows [][]string
 	var traverse func(*html.Node)
 
 	traverse = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
-			links = append(links, Link{
-				Href: getAttr(n, "href"),
-				Text: getText(n),
-			})
+		if n.Type == html.ElementNode && n.Data == "table" && strings.Contains(getText(n), "Net Cash") {
+			var rowsIn func(*html.Node)
+			rowsIn = func(n *html.Node) {
+				if n.Type == html.ElementNode && n.Data == "tr" {
+					var cells []string
+					for c := n.FirstChild; c != nil; c = c.NextSibling {
+						if c.Type == html.ElementNode && c.Data == "td" {
+							cells = append(cells, strings.TrimSpace(getText(c)))
+						}
+					}
+					if len(cells) > 0 {
+						rows = append(rows, cells)
+					}
+					return
+				}
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					rowsIn(c)
+				}
+			}
+			rowsIn(n)
+			return
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			traverse(c)
@@ -392,7 +621,7 @@ func parseLinks(t *testing.T, htmlStr string) []Link {
 	}
 
 	traverse(doc)
-	return links
+	return rows
 }
 
 // getAttr returns the value of the named attribute, or "" if not found.
@@ -419,7 +648,3 @@ func getText(n *html.Node) string {
 	}
 	return text
 }
-
-type nullLogger struct{}
-
-func (nullLogger) Printf(format string, v ...interface{}) {}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Roles of the members of a planner. The owner created the planner and is the
+// only one who deletes it and manages its members, an editor changes its
+// accounts and transactions and a viewer only reads them.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// The roles the repository methods allow.
+var (
+	readRoles  = []string{RoleOwner, RoleEditor, RoleViewer}
+	editRoles  = []string{RoleOwner, RoleEditor}
+	ownerRoles = []string{RoleOwner}
+
+	// memberRoles are the roles the owner gives to the other members
+	memberRoles = []string{RoleEditor, RoleViewer}
+)
+
+// inviteLifetime is how long an invite link can be accepted.
+const inviteLifetime = 7 * 24 * time.Hour
+
+// memberForm adds a user to a planner by username or changes the role of a member.
+type memberForm struct {
+	Username string `form:"username" validate:"omitempty,max=255"`
+	Role     string `form:"role" validate:"required,oneof=editor viewer"`
+}
+
+// inviteURL is the link that is sent to the user who is invited.
+func inviteURL(r *http.Request, token string) string {
+	return requestOrigin(r) + "/invites/" + token
+}
+
+// renderPlannerMembers renders the members and invites of the planner with the
+// message of a rejected form and the link of an invite that was just created.
+func (s *Server) renderPlannerMembers(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, status int, formError, newInviteURL string) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	members, err := s.repository.ListPlannerMembers(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list members", err)
+		return
+	}
+	var invites []PlannerInvite
+	if planner.IsOwner() {
+		if invites, err = s.repository.ListPlannerInvites(user.ID, planner.ID); err != nil {
+			s.internalError(w, "unable to list invites", err)
+			return
+		}
+	}
+	data := HomePageState{
+		CSRFToken:    getCSRFToken(w, r),
+		IsLoggedIn:   true,
+		PlannerID:    planner.ID,
+		Planner:      planner,
+		Planners:     planners,
+		Username:     user.Username,
+		UserID:       user.ID,
+		Members:      members,
+		Invites:      invites,
+		NewInviteURL: newInviteURL,
+		FormError:    formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "members.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// plannerMembersPage lists the members of the planner, the owner also sees the
+// open invites.
+func (s *Server) plannerMembersPage(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return
+	}
+	s.renderPlannerMembers(w, r, user, planner, http.StatusOK, "", "")
+}
+
+// addPlannerMember gives the user with the username of the form a role on the
+// planner.
+func (s *Server) addPlannerMember(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.ownedPlanner(w, r)
+	if !ok {
+		return
+	}
+	var f memberForm
+	err := s.decodeForm(r, &f)
+	var member *User
+	if err == nil {
+		member, err = s.repository.GetUser(strings.TrimSpace(f.Username))
+	}
+	if errors.Is(err, ErrNotFound) {
+		s.renderPlannerMembers(w, r, user, planner, http.StatusUnprocessableEntity, "There is no user with that username.", "")
+		return
+	}
+	if err == nil && member.ID == user.ID {
+		err = &formError{"username", "you own the planner"}
+	}
+	if err == nil {
+		err = s.repository.SetPlannerMember(user.ID, &PlannerMember{PlannerID: planner.ID, UserID: member.ID, Role: f.Role})
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to add member", err)
+			return
+		}
+		s.renderPlannerMembers(w, r, user, planner, http.StatusUnprocessableEntity, message, "")
+		return
+	}
+	s.logger.Info().Msgf("added user %s to planner %s as %s", member.ID, planner.ID, f.Role)
+	http.Redirect(w, r, plannerURL(planner.ID)+"/members", http.StatusFound)
+}
+
+// updatePlannerMember changes the role of a member of the planner.
+func (s *Server) updatePlannerMember(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.ownedPlanner(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.FromString(r.PathValue("userID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var f memberForm
+	if err := s.decodeForm(r, &f); err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+	members, err := s.repository.ListPlannerMembers(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list members", err)
+		return
+	}
+	if !slices.ContainsFunc(members, func(m PlannerMember) bool { return m.UserID == memberID }) {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.SetPlannerMember(user.ID, &PlannerMember{PlannerID: planner.ID, UserID: memberID, Role: f.Role})
+	if errors.Is(err, ErrForbidden) {
+		http.Error(w, "The owner keeps their role.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to update member", err)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/members", http.StatusFound)
+}
+
+// deletePlannerMember removes a member from the planner. The owner removes the
+// others and the others leave the planner.
+func (s *Server) deletePlannerMember(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return
+	}
+	memberID, err := uuid.FromString(r.PathValue("userID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeletePlannerMember(user.ID, planner.ID, memberID)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if errors.Is(err, ErrForbidden) {
+		http.Error(w, "Only the owner removes other members and the owner cannot leave.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to remove member", err)
+		return
+	}
+	s.logger.Info().Msgf("removed user %s from planner %s", memberID, planner.ID)
+	if memberID == user.ID {
+		http.Redirect(w, r, "/planners", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/members", http.StatusFound)
+}
+
+// createPlannerInvite adds a single-use invite link and shows it once.
+func (s *Server) createPlannerInvite(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.ownedPlanner(w, r)
+	if !ok {
+		return
+	}
+	var f memberForm
+	if err := s.decodeForm(r, &f); err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+	token := generateSecureToken(32)
+	id, _ := uuid.NewV4()
+	err := s.repository.AddPlannerInvite(user.ID, &PlannerInvite{
+		ID:        id,
+		PlannerID: planner.ID,
+		Role:      f.Role,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(inviteLifetime),
+	})
+	if err != nil {
+		s.internalError(w, "unable to add invite", err)
+		return
+	}
+	s.logger.Info().Msgf("added invite %s to planner %s", id, planner.ID)
+	s.renderPlannerMembers(w, r, user, planner, http.StatusOK, "", inviteURL(r, token))
+}
+
+func (s *Server) deletePlannerInvite(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.ownedPlanner(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("inviteID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeletePlannerInvite(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to delete invite", err)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/members", http.StatusFound)
+}
+
+// invitePage asks the signed in user to join the planner of the invite link.
+func (s *Server) invitePage(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	invite, planner, err := s.repository.GetPlannerInvite(hashToken(r.PathValue("token")), time.Now())
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "The invite link was used, revoked or has expired.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to get invite", err)
+		return
+	}
+	if _, err := s.repository.GetPlanner(user.ID, planner.ID); err == nil {
+		http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Invite:     invite,
+		// the planner is not set so the page does not link to it before joining
+		InvitePlannerName: planner.Name,
+	}
+	if err := StaticResources.ExecuteTemplate(w, "invite.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// acceptPlannerInvite makes the signed in user a member of the planner of the
+// invite link, the link cannot be used again.
+func (s *Server) acceptPlannerInvite(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	planner, err := s.repository.AcceptPlannerInvite(user.ID, hashToken(r.PathValue("token")), time.Now())
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "The invite link was used, revoked or has expired.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to accept invite", err)
+		return
+	}
+	s.logger.Info().Msgf("user %s joined planner %s with an invite", user.ID, planner.ID)
+	http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// signInNewUser adds a user with the test password and signs them in on a new
+// device.
+func signInNewUser(t *testing.T, server *Server, repository Repository, username string) (*User, *cookiejar.Jar, string) {
+	t.Helper()
+	id, _ := uuid.NewV4()
+	hash, salt := testPasswordHash(t, username)
+	if err := repository.AddUser(id, username, hash, salt); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/", nil)
+	csrfToken := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("username", username)
+	form.Set("password", testPassword)
+	ensureRedirect(t, serve(t, server, jar, "POST", "/sign-in", form), http.StatusFound, "/")
+	user, err := repository.GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, jar, csrfToken
+}
+
+func TestPlannerMembers(t *testing.T) {
+	server, repository := newTestServer(t)
+	ownerJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	ownerCSRF, plannerURL := signInWithPlanner(t, server, ownerJar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	owner, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addRent := func(jar *cookiejar.Jar, csrfToken string) int {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("title", "Rent")
+		form.Set("income_or_expense", "expense")
+		form.Set("amount", "900")
+		form.Set("transaction_date", time.Now().AddDate(0, 0, 3).Format(time.DateOnly))
+		return serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form).Code
+	}
+	ensureInt(t, addRent(ownerJar, ownerCSRF), http.StatusFound)
+	txns, err := repository.ListExpandedTransactions(owner.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(txns), 1)
+
+	viewer, viewerJar, viewerCSRF := signInNewUser(t, server, repository, "viewer@prototype.proto")
+	editor, editorJar, editorCSRF := signInNewUser(t, server, repository, "editor@prototype.proto")
+
+	// A user who is not a member does not find the planner
+	ensureCode(t, serve(t, server, viewerJar, "GET", plannerURL+"?view=all", nil), http.StatusNotFound)
+	if _, err := repository.ListExpandedTransactions(viewer.ID, plannerID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v listing the transactions of another user", err)
+	}
+
+	// Invite the viewer by username
+	{
+		form := url.Values{}
+		form.Set("csrf-token", ownerCSRF)
+		form.Set("username", "nobody@prototype.proto")
+		form.Set("role", RoleViewer)
+		recorder := serve(t, server, ownerJar, "POST", plannerURL+"/members", form)
+		ensureCode(t, recorder, http.StatusUnprocessableEntity)
+		if !strings.Contains(recorder.Body.String(), "There is no user with that username.") {
+			t.Fatal("no error for an unknown username")
+		}
+
+		form.Set("username", viewer.Username)
+		ensureRedirect(t, serve(t, server, ownerJar, "POST", plannerURL+"/members", form), http.StatusFound, plannerURL+"/members")
+
+		// only the owner manages the members
+		form.Set("csrf-token", viewerCSRF)
+		form.Set("username", editor.Username)
+		ensureCode(t, serve(t, server, viewerJar, "POST", plannerURL+"/members", form), http.StatusForbidden)
+	}
+
+	// The viewer reads the planner but does not change it
+	{
+		recorder := serve(t, server, viewerJar, "GET", plannerURL+"?view=all", nil)
+		ensureCode(t, recorder, http.StatusOK)
+		body := recorder.Body.String()
+		ensureCashFlow(t, parseCashFlow(t, body), []string{"Rent"}, []string{"-900"})
+		if forms := formsWithAction(parseForms(t, body), plannerURL+"/delete-one-time-transaction"); len(forms) != 0 {
+			t.Fatal("the viewer sees the delete form")
+		}
+		ensureInt(t, addRent(viewerJar, viewerCSRF), http.StatusForbidden)
+
+		form := url.Values{}
+		form.Set("csrf-token", viewerCSRF)
+		form.Set("expanded_transaction_id", txns[0].ID.String())
+		ensureCode(t, serve(t, server, viewerJar, "POST", plannerURL+"/delete-one-time-transaction", form), http.StatusForbidden)
+		ensureCode(t, serve(t, server, viewerJar, "POST", plannerURL+"/delete", form), http.StatusForbidden)
+
+		// the repository checks the role too
+		err := repository.DeleteExpandedTransaction(viewer.ID, plannerID, txns[0].ID)
+		if !errors.Is(err, ErrForbidden) {
+			t.Fatalf("got %v deleting as a viewer", err)
+		}
+		rent := txns[0]
+		rent.ID, _ = uuid.NewV4()
+		rent.UserID = viewer.ID
+		if err := repository.AddExpandedTransaction(&rent); !errors.Is(err, ErrForbidden) {
+			t.Fatalf("got %v adding as a viewer", err)
+		}
+
+		// a viewer copies the planner into one they own
+		ensureCode(t, serve(t, server, viewerJar, "POST", plannerURL+"/duplicate", form), http.StatusFound)
+		planners, err := repository.ListPlanners(viewer.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ensureInt(t, len(planners), 2)
+		for _, p := range planners {
+			if p.ID == plannerID {
+				ensureString(t, p.Role, RoleViewer)
+			} else {
+				ensureString(t, p.Role, RoleOwner)
+				ensureString(t, p.UserID.String(), viewer.ID.String())
+
+				// the ID of a transaction of the shared planner does not replace it
+				taken := txns[0]
+				taken.UserID, taken.PlannerID, taken.Amount = viewer.ID, p.ID, 1
+				if err := repository.AddExpandedTransaction(&taken); err == nil {
+					t.Fatal("added a transaction with the ID of a transaction of another planner")
+				}
+				stored, err := repository.GetExpandedTransaction(owner.ID, plannerID, txns[0].ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ensureFloat(t, stored.Amount, txns[0].Amount)
+			}
+		}
+	}
+
+	// An invite link makes an editor once
+	{
+		form := url.Values{}
+		form.Set("csrf-token", ownerCSRF)
+		form.Set("role", RoleEditor)
+		recorder := serve(t, server, ownerJar, "POST", plannerURL+"/invites", form)
+		ensureCode(t, recorder, http.StatusOK)
+		match := regexp.MustCompile(`http://[^/]+(/invites/[0-9a-f]+)`).FindStringSubmatch(recorder.Body.String())
+		if match == nil {
+			t.Fatal("no invite link")
+		}
+		inviteURL := match[1]
+
+		recorder = serve(t, server, editorJar, "GET", inviteURL, nil)
+		ensureCode(t, recorder, http.StatusOK)
+		if !strings.Contains(recorder.Body.String(), "Join Household") {
+			t.Fatal("the invite page does not name the planner")
+		}
+		form.Set("csrf-token", editorCSRF)
+		ensureRedirect(t, serve(t, server, editorJar, "POST", inviteURL, form), http.StatusFound, plannerURL)
+		ensureInt(t, addRent(editorJar, editorCSRF), http.StatusFound)
+
+		// the link is used up
+		_, otherJar, otherCSRF := signInNewUser(t, server, repository, "other@prototype.proto")
+		form.Set("csrf-token", otherCSRF)
+		ensureCode(t, serve(t, server, otherJar, "POST", inviteURL, form), http.StatusNotFound)
+		ensureCode(t, serve(t, server, otherJar, "GET", plannerURL+"?view=all", nil), http.StatusNotFound)
+
+		members, err := repository.ListPlannerMembers(owner.ID, plannerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var roles []string
+		for _, m := range members {
+			roles = append(roles, m.Username+":"+m.Role)
+		}
+		ensureString(t, strings.Join(roles, ","),
+			testUsername+":owner,viewer@prototype.proto:viewer,editor@prototype.proto:editor")
+	}
+
+	// Deleting a transaction of the planner needs the planner it belongs to
+	{
+		otherPlanner := &Planner{ID: uuid.Must(uuid.NewV4()), UserID: editor.ID, Name: "Other", HorizonMonths: 12, Currency: "USD"}
+		if err := repository.AddPlanner(otherPlanner); err != nil {
+			t.Fatal(err)
+		}
+		if err := repository.DeleteExpandedTransaction(editor.ID, otherPlanner.ID, txns[0].ID); err == nil {
+			t.Fatal("deleted a transaction of another planner")
+		}
+		if _, err := repository.GetExpandedTransaction(owner.ID, plannerID, txns[0].ID); err != nil {
+			t.Fatalf("the transaction is gone: %v", err)
+		}
+	}
+
+	// The owner removes the editor and the viewer leaves
+	{
+		form := url.Values{}
+		form.Set("csrf-token", ownerCSRF)
+		ensureCode(t, serve(t, server, ownerJar, "POST", plannerURL+"/members/"+owner.ID.String()+"/delete", form), http.StatusForbidden)
+		ensureRedirect(t, serve(t, server, ownerJar, "POST", plannerURL+"/members/"+editor.ID.String()+"/delete", form),
+			http.StatusFound, plannerURL+"/members")
+		ensureCode(t, serve(t, server, editorJar, "GET", plannerURL+"?view=all", nil), http.StatusNotFound)
+		ensureInt(t, addRent(editorJar, editorCSRF), http.StatusNotFound)
+
+		form.Set("csrf-token", viewerCSRF)
+		ensureRedirect(t, serve(t, server, viewerJar, "POST", plannerURL+"/members/"+viewer.ID.String()+"/delete", form),
+			http.StatusFound, "/planners")
+		ensureCode(t, serve(t, server, viewerJar, "GET", plannerURL+"?view=all", nil), http.StatusNotFound)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"slices"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -11,33 +12,226 @@ import (
 //
 
 type User struct {
-	ID                   uuid.UUID `gorm:"primarykey"`
-	Username             string    `gorm:"index;unique"`
-	PasswordHash         string
-	PasswordSalt         string
-	LoginSessionToken    string                `gorm:"index"`
+	ID           uuid.UUID `gorm:"primarykey"`
+	Username     string    `gorm:"index;unique"`
+	PasswordHash string
+	PasswordSalt string
+	// CalendarTokenHash is the hash of the secret in the URL of the calendar feed,
+	// empty without a feed
+	CalendarTokenHash    string                `gorm:"index"`
+	Sessions             []Session             `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Planners             []Planner             `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	RangeTransactions    []RangeTransaction    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	ExpandedTransactions []ExpandedTransaction `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	APITokens            []APIToken            `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Categories           []Category            `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	CreatedAt            time.Time
 	UpdatedAt            time.Time
 }
 
+// Session is a signed in browser, a user has one per device. Only the hash of the
+// session cookie is stored.
+type Session struct {
+	ID         uuid.UUID `gorm:"primarykey"`
+	UserID     uuid.UUID `gorm:"index"` // FK
+	TokenHash  string    `gorm:"uniqueIndex"`
+	UserAgent  string
+	IPAddress  string
+	ExpiresAt  time.Time `gorm:"index"`
+	LastSeenAt time.Time
+	CreatedAt  time.Time
+}
+
+// APIToken authenticates the requests of a script or app to the JSON API. Only the
+// hash of the token is stored, the token is shown once when it is created.
+type APIToken struct {
+	ID         uuid.UUID `gorm:"primarykey"`
+	UserID     uuid.UUID `gorm:"index"` // FK
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"`
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+}
+
+// Category groups the transactions with its name in their Category, the names
+// match without case. A category can sit under a parent, whose spending includes
+// the spending of its children.
+type Category struct {
+	ID     uuid.UUID `gorm:"primarykey"`
+	UserID uuid.UUID `gorm:"uniqueIndex:idx_categories_user_name"` // FK
+	// ParentID is uuid.Nil for a top level category
+	ParentID uuid.UUID
+	Name     string `gorm:"uniqueIndex:idx_categories_user_name"`
+	Color    string // #rrggbb
+	// MonthlyBudget caps the planned expenses of a month, 0 for no budget
+	MonthlyBudget float64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// The kinds of accounts
+const (
+	AccountChecking   = "checking"
+	AccountSavings    = "savings"
+	AccountCreditCard = "credit_card"
+	AccountBrokerage  = "brokerage"
+)
+
+// mainAccountName is the account of the transactions without one, it opens with
+// the StartBalance of the planner and is not stored
+const mainAccountName = "Main"
+
+// Account holds part of the money of a planner, the balance of the planner is the
+// sum of its accounts.
+type Account struct {
+	ID        uuid.UUID `gorm:"primarykey"`
+	PlannerID uuid.UUID `gorm:"index"`
+	UserID    uuid.UUID // FK
+	Name      string
+	Kind      string
+	// OpeningBalance is the balance when the planner starts, the amount owed on a
+	// credit card is negative
+	OpeningBalance float64
+	// StatementDay closes the statement of a credit card on that day of each
+	// month, 0 for an account without statements. The amount owed on the
+	// statement is paid from PaymentAccountID PaymentDueDays later.
+	StatementDay     int
+	PaymentDueDays   int
+	PaymentAccountID uuid.UUID
+	// InterestPercent is the yearly interest on the balance, earned on a positive
+	// balance and charged on a negative one. Compounding is how it adds up.
+	InterestPercent float64
+	Compounding     string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// IsMain reports if the account is the main account of the planner.
+func (a *Account) IsMain() bool {
+	return a.ID == uuid.Nil
+}
+
+// HasStatements reports if the account is a credit card with statement cycles.
+func (a *Account) HasStatements() bool {
+	return a.Kind == AccountCreditCard && a.StatementDay > 0
+}
+
+// Planner is a cashflow projection that starts from StartBalance and covers
+// HorizonMonths from today.
+type Planner struct {
+	ID            uuid.UUID `gorm:"primarykey"`
+	UserID        uuid.UUID `gorm:"index"` // FK, the owner
+	Name          string
+	StartBalance  float64
+	HorizonMonths int
+	Currency      string // ISO 4217 code
+	// ParentID is the planner a scenario was forked from, uuid.Nil for a planner
+	// that is not a scenario
+	ParentID  uuid.UUID `gorm:"index"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Role is the role of the user the planner was fetched for
+	Role string `gorm:"-"`
+}
+
+// IsScenario reports if the planner was forked from another one.
+func (p *Planner) IsScenario() bool {
+	return p.ParentID != uuid.Nil
+}
+
+// CanEdit reports if the role allows changing the accounts and transactions.
+func (p *Planner) CanEdit() bool {
+	return slices.Contains(editRoles, p.Role)
+}
+
+// IsOwner reports if the planner was fetched for its owner.
+func (p *Planner) IsOwner() bool {
+	return p.Role == RoleOwner
+}
+
+// PlannerMember gives a user a role on a planner, the owner is a member too.
+type PlannerMember struct {
+	PlannerID uuid.UUID `gorm:"primarykey"`
+	UserID    uuid.UUID `gorm:"primarykey;index"`
+	Role      string
+	CreatedAt time.Time
+
+	// Username is read from the users table when the members are listed
+	Username string `gorm:"->;-:migration"`
+}
+
+// PlannerInvite is a single-use link that makes the user who accepts it a member
+// of the planner. Only the hash of its token is stored.
+type PlannerInvite struct {
+	ID        uuid.UUID `gorm:"primarykey"`
+	PlannerID uuid.UUID `gorm:"index"`
+	CreatedBy uuid.UUID
+	Role      string
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// AuditEntry records a change of the planner data with the rows before and after
+// it. The entries are only added, an undo is an entry of its own.
+type AuditEntry struct {
+	ID        uuid.UUID `gorm:"primarykey"`
+	PlannerID uuid.UUID `gorm:"index"`
+	UserID    uuid.UUID
+	// Action is create, update, delete, import or undo
+	Action string
+	// Entity is the kind of record that was changed and EntityID its ID
+	Entity   string
+	EntityID uuid.UUID
+	// Title is the name or title of the record when it was changed
+	Title string
+	// Before and After are the JSON of the AuditState of the rows the change touched
+	Before string
+	After  string
+	// UndoOf is the entry an undo reverted
+	UndoOf    uuid.UUID
+	CreatedAt time.Time
+
+	// Username and Undone are set when the entries are listed
+	Username string `gorm:"-"`
+	Undone   bool   `gorm:"-"`
+}
+
+// End is the last day covered by the planner.
+func (p *Planner) End(now time.Time) time.Time {
+	return now.AddDate(0, p.HorizonMonths, 0)
+}
+
 type RangeTransaction struct {
 	ID        uuid.UUID `gorm:"primarykey"`
 	PlannerID uuid.UUID `gorm:"index"`
 	UserID    uuid.UUID // FK
 
-	Title               string
-	IncomeOrExpense     string
+	Title string
+	// IncomeOrExpense is income, expense or transfer
+	IncomeOrExpense string
+	// AccountID is the account of the transaction, uuid.Nil for the main account.
+	// A transfer moves the amount from AccountID to ToAccountID.
+	AccountID           uuid.UUID
+	ToAccountID         uuid.UUID
 	Category            string
 	Notes               string
 	RecurrenceEveryDays int
 	RecurrenceStart     time.Time
 	RecurrenceEnd       time.Time
+	Recurrence          Recurrence `gorm:"embedded;embeddedPrefix:recurrence_"`
 	Amount              float64
-	Source              string // bank/planner/bank-modified/card/brokerage
-	CreatedAt           time.Time
-	UpdatedAt           time.Time
+	// Growth changes the amount of the later occurrences, Amount is the amount of
+	// the first one
+	Growth      Growth      `gorm:"embedded;embeddedPrefix:growth_"`
+	Uncertainty Uncertainty `gorm:"embedded;embeddedPrefix:uncertainty_"`
+	Source      string      // bank/planner/bank-modified/card/brokerage
+	// ForkedFromID is the range transaction of the parent planner a scenario
+	// copied, uuid.Nil when it was added to the scenario
+	ForkedFromID uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
 }
 
 type ExpandedTransaction struct {
@@ -48,24 +242,73 @@ type ExpandedTransaction struct {
 	Title              string
 	TransactionDate    time.Time
 	IncomeOrExpense    string
+	AccountID          uuid.UUID
+	ToAccountID        uuid.UUID
 	Category           string
 	Amount             float64
+	Uncertainty        Uncertainty `gorm:"embedded;embeddedPrefix:uncertainty_"`
+	// OccurrenceDate is the date in the series the row was generated for.
+	OccurrenceDate time.Time
+	// IsOverride marks an occurrence edited on its own. Regenerating the
+	// series keeps it instead of the generated row.
+	IsOverride bool
+	// Source is where the transaction came from, e.g. bank, card or brokerage
+	Source string
+	// ImportID identifies an imported statement line so a re-import skips it
+	ImportID string `gorm:"index"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// IsOccurrence reports if the transaction was generated from a range transaction.
+func (etx *ExpandedTransaction) IsOccurrence() bool {
+	return etx.RangeTransactionID != uuid.Nil
+}
+
+// recurrenceEqual reports if both transactions expand to the same dates with the
+// same amount, so the occurrences can be updated in place. The occurrences of a
+// growing amount are always expanded again.
+func (rt *RangeTransaction) recurrenceEqual(other *RangeTransaction) bool {
+	return rt.RecurrenceEveryDays == other.RecurrenceEveryDays &&
+		rt.RecurrenceStart.Equal(other.RecurrenceStart) &&
+		rt.RecurrenceEnd.Equal(other.RecurrenceEnd) &&
+		rt.Recurrence == other.Recurrence &&
+		!rt.Growth.grows() && !other.Growth.grows()
+}
+
 //
 // Frontend models
 //
 
 type SegmentedTransaction struct {
 	ExpandedTransactionID uuid.UUID
+	RangeTransactionID    uuid.UUID
+	IsOverride            bool
 	Title                 string
 	TransactionDate       time.Time
 	IncomeOrExpense       string
+	AccountID             uuid.UUID
+	ToAccountID           uuid.UUID
+	AccountName           string
+	ToAccountName         string
+	Category              string
 	Amount                float64
 	NetCash               float64
+	// Balances are the balances of the accounts after the transaction, in the
+	// order of the accounts of the cash flow
+	Balances []float64
+}
+
+// IsTransfer reports if the transaction moves money between accounts.
+func (stx *SegmentedTransaction) IsTransfer() bool {
+	return stx.IncomeOrExpense == "transfer"
+}
+
+// IsGenerated reports if the transaction was added by the cash flow, like the
+// payment of a credit card statement, and cannot be edited.
+func (stx *SegmentedTransaction) IsGenerated() bool {
+	return stx.ExpandedTransactionID == uuid.Nil
 }
 
 // all the data required to render the web page
@@ -73,19 +316,87 @@ type HomePageState struct {
 	CSRFToken   string
 	IsLoggedIn  bool
 	SignInError bool
+	// SignInLocked is set after too many failed sign in attempts
+	SignInLocked bool
+	// FormError is why the submitted form was rejected
+	FormError string
+	// Notice confirms the outcome of the last request
+	Notice string
 
 	// redirect url
 	ReturnURL string
 
 	PlannerID  uuid.UUID
 	PlannerEnd time.Time
+	Planner    *Planner
+	Planners   []Planner
 
 	RangeStart time.Time
 	RangeEnd   time.Time
+	// Window is the part of the cash flow on the planner page
+	Window *PlannerWindow
 
 	Username string
 	UserID   uuid.UUID
 
 	RangeTransactions     []RangeTransaction
 	SegmentedTransactions []*SegmentedTransaction
+	// CashFlowMonths are the rows of the cash flow table by month
+	CashFlowMonths []CashFlowMonth
+	// ChartPoints are the balances of the chart aggregated by the window
+	ChartPoints []ChartPoint
+	Insights    Insights
+
+	// the transaction shown in the edit form
+	EditRangeTransaction    *RangeTransaction
+	EditExpandedTransaction *ExpandedTransaction
+
+	// the outcome of a statement upload
+	Import *ImportResult
+
+	Simulation *SimulationResult
+
+	Categories []Category
+	Budget     *BudgetReport
+
+	// Accounts are the accounts of the planner, the main account first
+	Accounts []Account
+
+	Sessions         []Session
+	CurrentSessionID uuid.UUID
+
+	APITokens []APIToken
+	// NewAPIToken is the token created by the last request
+	NewAPIToken string
+	// HasCalendarFeed is set when the user has a calendar feed, NewCalendarFeedURL
+	// is its URL right after it was created
+	HasCalendarFeed    bool
+	NewCalendarFeedURL string
+
+	// Members are the members of the planner with the open Invites of its owner,
+	// NewInviteURL is the link of an invite right after it was created
+	Members      []PlannerMember
+	Invites      []PlannerInvite
+	NewInviteURL string
+	// Invite is the invite link that was opened with the name of its planner
+	Invite            *PlannerInvite
+	InvitePlannerName string
+
+	// AuditEntries are the changes of the planner, the newest first
+	AuditEntries []AuditEntry
+	// LastChange is the change the user just made on the planner page
+	LastChange *AuditEntry
+	// Update is set when the partials swap only the rows LastChange touched
+	Update *PlannerUpdate
+
+	// Comparison compares a scenario with the planner it was forked from
+	Comparison *ScenarioComparison
+}
+
+// ImportResult is the outcome of a statement upload.
+type ImportResult struct {
+	Filename    string
+	Added       int
+	Duplicates  int
+	Suggestions []RangeTransaction
 }
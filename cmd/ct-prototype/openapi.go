@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// The OpenAPI document is generated from the route table and the request and
+// response structs, their json tags give the names and their validate tags the
+// constraints of the fields.
+
+var pathParameterPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPISchemas collects the schemas of the structs under their Go names.
+type openAPISchemas map[string]any
+
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// schema returns the schema of a value of type t, a reference for a struct.
+func (schemas openAPISchemas) schema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(Date{}):
+		return map[string]any{"type": "string", "format": "date"}
+	case reflect.TypeOf(uuid.UUID{}):
+		return map[string]any{"type": "string", "format": "uuid"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemas.schema(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemas.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			// set before the fields for a struct that refers to itself
+			schemas[name] = nil
+			schemas[name] = schemas.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// object returns the schema of the fields of a struct, the fields of an embedded
+// struct are its own like in encoding/json.
+func (schemas openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property := schemas.schema(field.Type)
+			if field.Type.Kind() == reflect.Pointer || strings.Contains(options, "omitempty") {
+				property["nullable"] = true
+			}
+			if applyValidateTag(property, field.Tag.Get("validate")) {
+				required = append(required, name)
+			}
+			properties[name] = property
+		}
+	}
+	addFields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyValidateTag adds the constraints of the validator rules to the schema of a
+// field and reports if the field is required.
+func applyValidateTag(property map[string]any, tag string) (required bool) {
+	if tag == "" {
+		return false
+	}
+	isString := property["type"] == "string"
+	number := func(s string) any {
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n
+		}
+		return s
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			enum := []any{}
+			for _, v := range strings.Fields(param) {
+				enum = append(enum, v)
+			}
+			property["enum"] = enum
+		case "min", "max":
+			if isString {
+				property[name+"Length"] = number(param)
+			} else if name == "min" {
+				property["minimum"] = number(param)
+			} else {
+				property["maximum"] = number(param)
+			}
+		case "gte":
+			property["minimum"] = number(param)
+		case "lte":
+			property["maximum"] = number(param)
+		case "gt":
+			property["minimum"] = number(param)
+			property["exclusiveMinimum"] = true
+		case "lt":
+			property["maximum"] = number(param)
+			property["exclusiveMaximum"] = true
+		case "iso4217":
+			property["pattern"] = "^[A-Z]{3}$"
+		case "uuid4":
+			property["format"] = "uuid"
+		case "lowercase":
+			property["pattern"] = "^[^A-Z]*$"
+		}
+	}
+	return required
+}
+
+// openAPIDocument describes the routes as an OpenAPI 3 document.
+func openAPIDocument(routes []apiRoute) map[string]any {
+	schemas := openAPISchemas{}
+	errorResponse := map[string]any{
+		"description": "the error and the fields that failed the validation",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(APIError{}))},
+		},
+	}
+
+	paths := map[string]any{}
+	for _, route := range routes {
+		operation := map[string]any{
+			"summary":     route.summary,
+			"operationId": strings.ToLower(route.method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_").Replace(route.path),
+		}
+
+		parameters := []any{}
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(route.path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string", "format": "uuid"},
+			})
+		}
+		for _, p := range route.query {
+			parameters = append(parameters, map[string]any{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"schema":      map[string]any{"type": p.Type},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if route.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(route.request))},
+				},
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(route.status)}
+		if route.response != nil {
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(route.response))},
+			}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(route.status): success,
+			"default":                  errorResponse,
+		}
+
+		path := apiPrefix + route.path
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path].(map[string]any)[strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Cashflow planner API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []any{}}},
+	}
+}
+
+// openAPI serves the OpenAPI document of the API, it needs no token.
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	if err := writeJSON(w, http.StatusOK, openAPIDocument(s.apiRoutes())); err != nil {
+		s.logger.Error().Err(err).Msg("unable to write the openapi document")
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// isHTMX reports if htmx sent the request, htmx swaps the response into the
+// page instead of following a redirect.
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// plannerState computes the cash flow of the window of the planner and everything
+// shown with it on the planner page. The simulation is left out unless simulate is
+// set, it takes longer than the rest together.
+func (s *Server) plannerState(user *User, planner *Planner, window *PlannerWindow, now time.Time, simulate bool) (HomePageState, error) {
+	rangeTxns, err := s.repository.ListRangeTransactions(user.ID, planner.ID)
+	if err != nil {
+		return HomePageState{}, fmt.Errorf("listing range transactions: %w", err)
+	}
+	data, err := s.windowState(user, planner, window, now, simulate)
+	if err != nil {
+		return HomePageState{}, err
+	}
+	data.RangeTransactions = rangeTxns
+	return data, nil
+}
+
+// windowState computes the cash flow of the window with the planner card, the chart
+// and the budgets that sum it up.
+func (s *Server) windowState(user *User, planner *Planner, window *PlannerWindow, now time.Time, simulate bool) (HomePageState, error) {
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return HomePageState{}, fmt.Errorf("listing accounts: %w", err)
+	}
+	segTxns, expandedTransactions, err := s.windowCashFlow(user, planner, accounts, window, now)
+	if err != nil {
+		return HomePageState{}, fmt.Errorf("computing the cash flow: %w", err)
+	}
+	budgetStart := window.Start
+	if window.View == ViewAll {
+		budgetStart = now
+	}
+	budget, categories, err := s.plannerBudget(user.ID, segTxns, budgetStart, window.End)
+	if err != nil {
+		return HomePageState{}, fmt.Errorf("listing categories: %w", err)
+	}
+	// the simulation of a window starts with the balance carried into it
+	var simulation *SimulationResult
+	switch {
+	case !simulate:
+	case window.View == ViewAll:
+		simulation = simulatePlanner(planner, accounts, expandedTransactions, now, defaultSimulationTrials, 1)
+	default:
+		simulation = Simulate(window.OpeningNetCash, expandedTransactions, window.Start, window.End,
+			defaultSimulationTrials, rand.New(rand.NewSource(1)))
+	}
+	data := plannerPageState(user, planner, accounts, window, now)
+	data.SegmentedTransactions = segTxns
+	data.CashFlowMonths = cashFlowMonths(segTxns, window)
+	data.ChartPoints = chartPoints(segTxns, window.Chart)
+	data.Insights = ComputeInsights(segTxns, defaultInsightOptions)
+	data.Simulation = simulation
+	data.Categories = categories
+	data.Budget = budget
+	return data, nil
+}
+
+// plannerPageState is the state every part of the planner page is rendered with.
+func plannerPageState(user *User, planner *Planner, accounts []Account, window *PlannerWindow, now time.Time) HomePageState {
+	return HomePageState{
+		IsLoggedIn: true,
+		PlannerID:  planner.ID,
+		PlannerEnd: planner.End(now),
+		Planner:    planner,
+		RangeStart: window.Start,
+		RangeEnd:   window.End,
+		Window:     window,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Accounts:   plannerAccounts(planner, accounts),
+	}
+}
+
+// PlannerUpdate is what the partials swap after a change of transactions: the rows
+// of the range transactions of the change and the months of the cash flow from its
+// earliest transaction on.
+type PlannerUpdate struct {
+	// CashFlow is set when the change moved the opening balance or the first
+	// month of the cash flow and the whole table is swapped with the planner
+	// card, the chart and the budgets.
+	CashFlow bool
+	// DeletedRanges are the range transactions whose rows are removed
+	DeletedRanges []uuid.UUID
+	// SummaryURL loads the planner card, the chart and the budgets of the window
+	// after the months are swapped, they sum up every month of it. It is empty when
+	// the change left the window as it was.
+	SummaryURL string
+
+	// ranges are the range transactions of the change that are still there and
+	// from is the day of its earliest transaction
+	ranges      []uuid.UUID
+	addedRanges map[uuid.UUID]bool
+	from        time.Time
+}
+
+// Added reports if the row of the range transaction is not on the page yet and
+// is added after the others.
+func (u *PlannerUpdate) Added(id uuid.UUID) bool {
+	return u.addedRanges[id]
+}
+
+// plannerUpdate finds the rows the change of the entry touched. It returns nil
+// when the change of a planner or an account moved every row.
+func plannerUpdate(entry *AuditEntry) (*PlannerUpdate, error) {
+	before, after, err := entry.states()
+	if err != nil {
+		return nil, err
+	}
+	if before.Planner != nil || after.Planner != nil || len(before.Accounts) > 0 || len(after.Accounts) > 0 {
+		return nil, nil
+	}
+
+	update := &PlannerUpdate{addedRanges: map[uuid.UUID]bool{}}
+	shown := map[uuid.UUID]bool{}
+	for _, rt := range before.RangeTransactions {
+		shown[rt.ID] = true
+	}
+	for _, rt := range after.RangeTransactions {
+		update.ranges = append(update.ranges, rt.ID)
+		update.addedRanges[rt.ID] = !shown[rt.ID]
+	}
+	for _, rt := range before.RangeTransactions {
+		if !slices.Contains(update.ranges, rt.ID) {
+			update.DeletedRanges = append(update.DeletedRanges, rt.ID)
+		}
+	}
+	// the rows before the earliest transaction of the change keep their balances
+	for _, etx := range slices.Concat(before.ExpandedTransactions, after.ExpandedTransactions) {
+		if update.from.IsZero() || etx.TransactionDate.Before(update.from) {
+			update.from = etx.TransactionDate
+		}
+	}
+	return update, nil
+}
+
+// updateState computes what the partials swap for the update: the rows of its range
+// transactions and the months of the cash flow from the month of its earliest
+// transaction on, with the balance carried into that month summed up by the
+// repository. Only a change that reaches back to the first month shown computes the
+// whole window.
+func (s *Server) updateState(user *User, planner *Planner, window *PlannerWindow, update *PlannerUpdate, now time.Time) (HomePageState, error) {
+	from := update.from
+	switch {
+	case from.IsZero() || from.After(window.End):
+	case window.View != ViewAll:
+		update.CashFlow = from.Before(window.Start)
+	default:
+		// the whole planner starts with the month of its first transaction
+		totals, err := s.repository.ExpandedTransactionTotalsBefore(user.ID, planner.ID, monthStart(from))
+		if err != nil {
+			return HomePageState{}, fmt.Errorf("summing the transactions before the change: %w", err)
+		}
+		update.CashFlow = len(totals) == 0
+	}
+
+	var data HomePageState
+	if update.CashFlow {
+		var err error
+		if data, err = s.windowState(user, planner, window, now, false); err != nil {
+			return HomePageState{}, err
+		}
+	} else {
+		accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+		if err != nil {
+			return HomePageState{}, fmt.Errorf("listing accounts: %w", err)
+		}
+		data = plannerPageState(user, planner, accounts, window, now)
+		if !from.IsZero() && !from.After(window.End) {
+			// a window of the months of the change, it is never the all view so the
+			// balances before it are summed up instead of listed
+			changed := &PlannerWindow{View: ViewMonth, Chart: window.Chart, Start: monthStart(from), End: window.End}
+			segTxns, _, err := s.windowCashFlow(user, planner, accounts, changed, now)
+			if err != nil {
+				return HomePageState{}, fmt.Errorf("computing the cash flow: %w", err)
+			}
+			data.CashFlowMonths = cashFlowMonths(segTxns, changed)
+			update.SummaryURL = window.SummaryURL()
+		}
+	}
+
+	for _, id := range update.ranges {
+		rt, err := s.repository.GetRangeTransaction(user.ID, planner.ID, id)
+		if errors.Is(err, ErrNotFound) {
+			// deleted by another member since
+			update.DeletedRanges = append(update.DeletedRanges, id)
+			continue
+		}
+		if err != nil {
+			return HomePageState{}, fmt.Errorf("getting range transaction %s: %w", id, err)
+		}
+		data.RangeTransactions = append(data.RangeTransactions, *rt)
+	}
+	return data, nil
+}
+
+// plannerChanged answers a request that changed the record with entityID on the
+// planner. An htmx request gets the change for undo and the rows it touched as
+// out-of-band swaps so the rest of the page stays as it is, any other request is
+// sent back to the planner page. The chart goes without the simulation until the
+// page is loaded again.
+func (s *Server) plannerChanged(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, entityID uuid.UUID) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+		return
+	}
+	now := time.Now()
+	window, err := parsePlannerWindow(windowQuery(r), planner, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the change the user just made, offered for undo
+	entry, err := s.repository.LatestAuditEntry(user.ID, planner.ID, entityID)
+	if err != nil {
+		s.internalError(w, "unable to find the change", err)
+		return
+	}
+	update, err := plannerUpdate(entry)
+	if err != nil {
+		s.internalError(w, "unable to find the changed rows", err)
+		return
+	}
+	var data HomePageState
+	if update == nil {
+		data, err = s.plannerState(user, planner, window, now, false)
+	} else {
+		data, err = s.updateState(user, planner, window, update, now)
+	}
+	if err != nil {
+		s.internalError(w, "unable to compute the planner", err)
+		return
+	}
+	data.CSRFToken = getCSRFToken(w, r)
+	data.LastChange = entry
+	data.Update = update
+	if err := StaticResources.ExecuteTemplate(w, "plannerPartials", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// plannerSummary renders the planner card, the chart and the budgets of the window
+// as out-of-band swaps, the partials load them after a change.
+func (s *Server) plannerSummary(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	window, err := parsePlannerWindow(r.URL.Query(), planner, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := s.windowState(user, planner, window, now, false)
+	if err != nil {
+		s.internalError(w, "unable to compute the planner", err)
+		return
+	}
+	if err := StaticResources.ExecuteTemplate(w, "plannerSummary", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"golang.org/x/net/html"
+)
+
+// serveHTMX serves the form as htmx posts it from the page at pageURL.
+func serveHTMX(t *testing.T, server *Server, jar http.CookieJar, pageURL, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r, err := http.NewRequest("POST", "http://localhost"+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("creating request: %v", err)
+	}
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("HX-Request", "true")
+	r.Header.Add("HX-Current-URL", "http://localhost"+pageURL)
+	for _, c := range jar.Cookies(r.URL) {
+		r.Header.Add("Cookie", c.Name+"="+c.Value)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, r)
+	return recorder
+}
+
+// swappedBodies returns the cells of the rows of the table bodies the partials
+// swap out of band by their id, a deleted body has no rows.
+func swappedBodies(t *testing.T, htmlStr string) map[string][][]string {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		t.Fatalf("parsing HTML: %v", err)
+	}
+	bodies := map[string][][]string{}
+	var traverse func(n *html.Node)
+	traverse = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "tbody" && getAttr(n, "hx-swap-oob") != "" {
+			rows := [][]string{}
+			for tr := n.FirstChild; tr != nil; tr = tr.NextSibling {
+				if tr.Type != html.ElementNode || tr.Data != "tr" {
+					continue
+				}
+				var cells []string
+				for td := tr.FirstChild; td != nil; td = td.NextSibling {
+					if td.Type == html.ElementNode && td.Data == "td" {
+						cells = append(cells, strings.TrimSpace(getText(td)))
+					}
+				}
+				rows = append(rows, cells)
+			}
+			bodies[getAttr(n, "id")] = rows
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverse(c)
+		}
+	}
+	traverse(doc)
+	return bodies
+}
+
+func TestPlannerPartials(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the page shows the whole planner as the second rent can fall in the next month
+	pageURL := plannerURL + "?view=all"
+	swaps := func(body string) []string {
+		var ids []string
+		for _, match := range regexp.MustCompile(`id="([^"]+)"[^>]*hx-swap-oob="true"`).FindAllStringSubmatch(body, -1) {
+			ids = append(ids, match[1])
+		}
+		return ids
+	}
+
+	// the page has the sections the partials replace
+	page := serve(t, server, jar, "GET", plannerURL, nil).Body.String()
+	for _, id := range []string{"planner-card", "planner-message", "line-chart", "summary-table", "budget-summary", "transactions-table", "range-transactions"} {
+		if !strings.Contains(page, `id="`+id+`"`) {
+			t.Fatalf("planner page has no section %s", id)
+		}
+	}
+
+	start := time.Now().AddDate(0, 0, 1)
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Rent")
+	form.Set("income_or_expense", "expense")
+	form.Set("amount", "900")
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_start", start.Format(time.DateOnly))
+	form.Set("recurrence_end", start.AddDate(0, 1, 0).Format(time.DateOnly))
+	recorder := serveHTMX(t, server, jar, pageURL, plannerURL+"/add-range-transaction", form)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, recorder.Header().Get("Location"), "")
+	body := recorder.Body.String()
+	// the first rows of the cash flow come with the whole table
+	ensureString(t, strings.Join(swaps(body), ","), "planner-card,line-chart,budget-summary,transactions-table")
+	if strings.Contains(body, "<html") || strings.Contains(body, plannerURL+"/add-range-transaction") {
+		t.Errorf("partials render more than the changed sections:\n%s", body)
+	}
+	// the series is added to the repeating transactions and skips the simulation
+	if !strings.Contains(body, `hx-swap-oob="beforeend:#range-transactions"`) {
+		t.Fatalf("partials do not add the range transaction:\n%s", body)
+	}
+	if strings.Contains(body, "simulated trials") {
+		t.Error("partials simulate the changed plan")
+	}
+	ensureCashFlow(t, parseCashFlow(t, body), []string{"Rent", "Rent"}, []string{"-900", "-1800"})
+
+	// the delete forms in the swapped table post through htmx as well
+	forms := formsWithAction(parseForms(t, body), plannerURL+"/delete-one-time-transaction")
+	ensureInt(t, len(forms), 2)
+	if !strings.Contains(body, `hx-post="`+plannerURL+`/delete-one-time-transaction"`) {
+		t.Fatal("delete forms of the cash flow do not post through htmx")
+	}
+	secondRent := url.Values{}
+	for k, v := range forms[1].Inputs {
+		secondRent.Set(k, v)
+	}
+
+	// a later transaction only swaps the months from its own on
+	bonusDate := start.AddDate(0, 2, 0)
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Bonus")
+	form.Set("income_or_expense", "income")
+	form.Set("amount", "1000")
+	form.Set("transaction_date", bonusDate.Format(time.DateOnly))
+	recorder = serveHTMX(t, server, jar, pageURL, plannerURL+"/add-one-time-transaction", form)
+	ensureCode(t, recorder, http.StatusOK)
+	body = recorder.Body.String()
+	if strings.Contains(body, `id="transactions-table"`) || strings.Contains(body, `id="range-transaction-`) {
+		t.Fatalf("partials swap rows the change did not touch:\n%s", body)
+	}
+	bodies := swappedBodies(t, body)
+	bonusMonth := "cash-flow-" + bonusDate.Format("2006-01")
+	if _, ok := bodies["cash-flow-"+start.Format("2006-01")]; ok {
+		t.Errorf("partials swap the month of the first rent")
+	}
+	rows := bodies[bonusMonth]
+	ensureInt(t, len(rows), 1)
+	ensureString(t, rows[0][1], "Bonus")
+	ensureString(t, rows[0][4], "-800")
+	// the card, the chart and the budgets of the whole window load after the swap
+	if strings.Contains(body, `id="planner-card"`) {
+		t.Error("partials swap the planner card before the summary is loaded")
+	}
+	summary := regexp.MustCompile(`hx-get="([^"]+)" hx-trigger="load"`).FindStringSubmatch(body)
+	if summary == nil {
+		t.Fatalf("partials do not load the summary:\n%s", body)
+	}
+	ensureString(t, html.UnescapeString(summary[1]), plannerURL+"/summary?view=all")
+	recorder = serve(t, server, jar, "GET", html.UnescapeString(summary[1]), nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, strings.Join(swaps(recorder.Body.String()), ","), "planner-card,line-chart,budget-summary")
+	if strings.Contains(recorder.Body.String(), "simulated trials") {
+		t.Error("the summary simulates the changed plan")
+	}
+
+	// an edited series replaces its row of the repeating transactions
+	rangeTxns, err := repository.ListRangeTransactions(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(rangeTxns), 1)
+	rangeRow := "range-transaction-" + rangeTxns[0].ID.String()
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("range_transaction_id", rangeTxns[0].ID.String())
+	form.Set("title", "Rent")
+	form.Set("income_or_expense", "expense")
+	form.Set("amount", "1000")
+	form.Set("recurrence_freq", "monthly")
+	form.Set("recurrence_start", start.Format(time.DateOnly))
+	form.Set("recurrence_end", start.AddDate(0, 1, 0).Format(time.DateOnly))
+	recorder = serveHTMX(t, server, jar, pageURL, plannerURL+"/update-range-transaction", form)
+	ensureCode(t, recorder, http.StatusOK)
+	body = recorder.Body.String()
+	rows = swappedBodies(t, body)[rangeRow]
+	ensureInt(t, len(rows), 1)
+	if !strings.Contains(rows[0][4], "1000") {
+		t.Fatalf("the row of the series was not updated: %v", rows[0])
+	}
+	ensureCashFlow(t, parseCashFlow(t, body), []string{"Rent", "Rent", "Bonus"}, []string{"-1000", "-2000", "-1000"})
+
+	// deleting the second rent leaves the months before it
+	recorder = serveHTMX(t, server, jar, pageURL, plannerURL+"/delete-one-time-transaction", secondRent)
+	ensureCode(t, recorder, http.StatusOK)
+	bodies = swappedBodies(t, recorder.Body.String())
+	if _, ok := bodies["cash-flow-"+start.Format("2006-01")]; ok {
+		t.Errorf("partials swap the month of the first rent")
+	}
+	ensureInt(t, len(bodies["cash-flow-"+start.AddDate(0, 1, 0).Format("2006-01")]), 0)
+	ensureString(t, bodies[bonusMonth][0][4], "0")
+
+	// a deleted series removes its row
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("range_transaction_id", rangeTxns[0].ID.String())
+	recorder = serveHTMX(t, server, jar, pageURL, plannerURL+"/delete-range-transaction", form)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), `<tbody id="`+rangeRow+`" hx-swap-oob="delete">`) {
+		t.Fatalf("partials do not remove the series:\n%s", recorder.Body.String())
+	}
+
+	// without htmx the browser is sent back to the planner
+	form = url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Gym")
+	form.Set("income_or_expense", "expense")
+	form.Set("amount", "50")
+	form.Set("transaction_date", start.Format(time.DateOnly))
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form), http.StatusFound, plannerURL)
+}
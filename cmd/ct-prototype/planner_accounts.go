@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// plannerAccounts returns the main account of the planner followed by its
+// accounts. The transactions without an account belong to the main account.
+func plannerAccounts(planner *Planner, accounts []Account) []Account {
+	main := Account{
+		PlannerID:      planner.ID,
+		UserID:         planner.UserID,
+		Name:           mainAccountName,
+		Kind:           AccountChecking,
+		OpeningBalance: planner.StartBalance,
+	}
+	return append([]Account{main}, accounts...)
+}
+
+// openingBalance is the total balance of the accounts when the planner starts.
+func openingBalance(planner *Planner, accounts []Account) float64 {
+	balance := planner.StartBalance
+	for i := range accounts {
+		balance += accounts[i].OpeningBalance
+	}
+	return balance
+}
+
+// duplicateAccounts gives the accounts new IDs in the planner and returns the new
+// ID of each old one. The main account keeps uuid.Nil.
+func duplicateAccounts(accounts []Account, plannerID uuid.UUID) map[uuid.UUID]uuid.UUID {
+	newIDs := map[uuid.UUID]uuid.UUID{uuid.Nil: uuid.Nil}
+	for i := range accounts {
+		newID, _ := uuid.NewV4()
+		newIDs[accounts[i].ID] = newID
+		accounts[i].ID = newID
+		accounts[i].PlannerID = plannerID
+	}
+	for i := range accounts {
+		accounts[i].PaymentAccountID = newIDs[accounts[i].PaymentAccountID]
+	}
+	return newIDs
+}
+
+// accountForm creates or updates an account of a planner.
+type accountForm struct {
+	Name           string  `form:"name" json:"name" validate:"required,max=255"`
+	Kind           string  `form:"kind" json:"kind" validate:"required,oneof=checking savings credit_card brokerage"`
+	OpeningBalance float64 `form:"opening_balance" json:"opening_balance"`
+	// StatementDay and PaymentDueDays only apply to a credit card
+	StatementDay     int       `form:"statement_day" json:"statement_day" validate:"gte=0,lte=31"`
+	PaymentDueDays   int       `form:"payment_due_days" json:"payment_due_days" validate:"gte=0,lte=60"`
+	PaymentAccountID uuid.UUID `form:"payment_account_id" json:"payment_account_id"`
+	// InterestPercent is earned on a positive balance and charged on a negative one
+	InterestPercent float64 `form:"interest_percent" json:"interest_percent" validate:"gte=0,lte=100"`
+	Compounding     string  `form:"compounding" json:"compounding" validate:"omitempty,oneof=apy monthly daily"`
+}
+
+var errAccountExists = &formError{"name", "an account with the name exists"}
+
+// account returns the account of the form in the planner.
+func (f *accountForm) account(planner *Planner) *Account {
+	account := &Account{
+		PlannerID:      planner.ID,
+		UserID:         planner.UserID,
+		Name:           strings.TrimSpace(f.Name),
+		Kind:           f.Kind,
+		OpeningBalance: f.OpeningBalance,
+	}
+	if f.InterestPercent > 0 {
+		account.InterestPercent = f.InterestPercent
+		account.Compounding = f.Compounding
+		if account.Compounding == "" {
+			account.Compounding = CompoundingAPY
+		}
+	}
+	if f.Kind == AccountCreditCard {
+		account.StatementDay = f.StatementDay
+		account.PaymentDueDays = f.PaymentDueDays
+		account.PaymentAccountID = f.PaymentAccountID
+	}
+	return account
+}
+
+// checkAccount returns why the account cannot be saved next to the other accounts
+// of the planner.
+func checkAccount(account *Account, accounts []Account) error {
+	if strings.EqualFold(account.Name, mainAccountName) {
+		return errAccountExists
+	}
+	paymentAccount := account.PaymentAccountID == uuid.Nil
+	for i := range accounts {
+		if accounts[i].ID != account.ID && strings.EqualFold(accounts[i].Name, account.Name) {
+			return errAccountExists
+		}
+		if accounts[i].ID == account.PaymentAccountID && accounts[i].ID != account.ID {
+			paymentAccount = true
+		}
+	}
+	if !paymentAccount {
+		return &formError{"payment_account_id", "the statements must be paid from another account of the planner"}
+	}
+	return nil
+}
+
+// checkAccounts checks the accounts of a transaction form against the accounts of
+// the planner.
+func (s *Server) checkAccounts(planner *Planner, incomeOrExpense string, f *accountsForm) error {
+	// the owner is always a member of the planner
+	accounts, err := s.repository.ListAccounts(planner.UserID, planner.ID)
+	if err != nil {
+		return err
+	}
+	return f.check(incomeOrExpense, accounts)
+}
+
+// renderPlannerAccounts renders the accounts page of the planner with the message
+// of a rejected form.
+func (s *Server) renderPlannerAccounts(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, status int, formError string) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list accounts", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		PlannerID:  planner.ID,
+		Planner:    planner,
+		Planners:   planners,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Accounts:   plannerAccounts(planner, accounts),
+		FormError:  formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "accounts.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// plannerAccountsPage lists the accounts of the planner.
+func (s *Server) plannerAccountsPage(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	s.renderPlannerAccounts(w, r, user, planner, http.StatusOK, "")
+}
+
+// savePlannerAccount checks the account of the form and adds it, or updates it
+// when it has an ID.
+func (s *Server) savePlannerAccount(w http.ResponseWriter, r *http.Request, user *User, planner *Planner, id uuid.UUID) {
+	var f accountForm
+	var account *Account
+	err := s.decodeForm(r, &f)
+	if err == nil {
+		account = f.account(planner)
+		account.ID = id
+		account.UserID = user.ID
+		var accounts []Account
+		if accounts, err = s.repository.ListAccounts(user.ID, planner.ID); err == nil {
+			err = checkAccount(account, accounts)
+		}
+	}
+	if err == nil {
+		if id == uuid.Nil {
+			account.ID, _ = uuid.NewV4()
+			err = s.repository.AddAccount(account)
+		} else {
+			err = s.repository.UpdateAccount(account)
+		}
+	}
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		message, ok := formMessage(err)
+		if !ok {
+			s.internalError(w, "unable to save account", err)
+			return
+		}
+		s.renderPlannerAccounts(w, r, user, planner, http.StatusUnprocessableEntity, message)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/accounts", http.StatusFound)
+}
+
+func (s *Server) createPlannerAccount(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	s.savePlannerAccount(w, r, user, planner, uuid.Nil)
+}
+
+func (s *Server) updatePlannerAccount(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("accountID"))
+	if err != nil || id == uuid.Nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.savePlannerAccount(w, r, user, planner, id)
+}
+
+// deletePlannerAccount deletes the account, its transactions move to the main account.
+func (s *Server) deletePlannerAccount(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("accountID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeleteAccount(user.ID, planner.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to delete account", err)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID)+"/accounts", http.StatusFound)
+}
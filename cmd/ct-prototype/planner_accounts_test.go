@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestCashFlowAccounts(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	savings, _ := uuid.NewV4()
+	card, _ := uuid.NewV4()
+	planner := &Planner{StartBalance: 1000}
+	accounts := []Account{
+		{ID: savings, Name: "Savings", Kind: AccountSavings, OpeningBalance: 500},
+		// closes on the last day of each month and is paid 5 days later
+		{ID: card, Name: "Card", Kind: AccountCreditCard, OpeningBalance: -100, StatementDay: 31, PaymentDueDays: 5},
+	}
+	txns := []ExpandedTransaction{
+		{Title: "Salary", TransactionDate: day(2, 1), IncomeOrExpense: "income", Amount: 2000},
+		{Title: "Save", TransactionDate: day(2, 12), IncomeOrExpense: "transfer", ToAccountID: savings, Amount: 300},
+		{Title: "Flight", TransactionDate: day(2, 20), IncomeOrExpense: "expense", AccountID: card, Amount: 200},
+		{Title: "Dinner", TransactionDate: day(3, 10), IncomeOrExpense: "expense", AccountID: card, Amount: 50},
+	}
+
+	segTxns := cashFlow(planner, accounts, txns, day(2, 10), day(4, 30))
+	type want struct {
+		title    string
+		date     time.Time
+		amount   float64
+		netCash  float64
+		balances []float64
+	}
+	wants := []want{
+		{"Salary", day(2, 1), 2000, 3400, []float64{3000, 500, -100}},
+		{"Save", day(2, 12), 300, 3400, []float64{2700, 800, -100}},
+		{"Flight", day(2, 20), 200, 3200, []float64{2700, 800, -300}},
+		// the February statement closes on the 29th
+		{"Card statement payment", day(3, 5), 300, 3200, []float64{2400, 800, 0}},
+		{"Dinner", day(3, 10), 50, 3150, []float64{2400, 800, -50}},
+		{"Card statement payment", day(4, 5), 50, 3150, []float64{2350, 800, 0}},
+	}
+	if len(segTxns) != len(wants) {
+		t.Fatalf("got %d transactions, want %d", len(segTxns), len(wants))
+	}
+	for i, want := range wants {
+		stx := segTxns[i]
+		if stx.Title != want.title || !stx.TransactionDate.Equal(want.date) {
+			t.Errorf("transaction %d: got %s on %s, want %s on %s", i, stx.Title, stx.TransactionDate, want.title, want.date)
+		}
+		ensureFloat(t, stx.Amount, want.amount)
+		ensureFloat(t, stx.NetCash, want.netCash)
+		for a := range want.balances {
+			ensureFloat(t, stx.Balances[a], want.balances[a])
+		}
+	}
+	payment := segTxns[3]
+	if !payment.IsTransfer() || !payment.IsGenerated() || payment.AccountName != mainAccountName || payment.ToAccountName != "Card" {
+		t.Errorf("unexpected statement payment %+v", payment)
+	}
+}
+
+func TestCheckTransactionAccounts(t *testing.T) {
+	savings, _ := uuid.NewV4()
+	other, _ := uuid.NewV4()
+	accounts := []Account{{ID: savings, Name: "Savings"}}
+	tests := []struct {
+		name            string
+		incomeOrExpense string
+		form            accountsForm
+		valid           bool
+	}{
+		{"main", "expense", accountsForm{}, true},
+		{"account", "income", accountsForm{AccountID: savings}, true},
+		{"transfer", "transfer", accountsForm{ToAccountID: savings}, true},
+		{"other planner", "expense", accountsForm{AccountID: other}, false},
+		{"same account", "transfer", accountsForm{AccountID: savings, ToAccountID: savings}, false},
+		{"no destination", "transfer", accountsForm{}, false},
+	}
+	for _, tt := range tests {
+		err := tt.form.check(tt.incomeOrExpense, accounts)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestPlannerAccounts(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	ensureCode(t, serve(t, server, jar, "GET", plannerURL+"/accounts", nil), http.StatusOK)
+
+	addAccount := func(name, kind, paymentAccountID string) *http.Response {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("name", name)
+		form.Set("kind", kind)
+		form.Set("opening_balance", "100")
+		form.Set("statement_day", "15")
+		form.Set("payment_due_days", "10")
+		form.Set("payment_account_id", paymentAccountID)
+		return serve(t, server, jar, "POST", plannerURL+"/accounts", form).Result()
+	}
+	ensureInt(t, addAccount("Savings", AccountSavings, "").StatusCode, http.StatusFound)
+	ensureInt(t, addAccount("savings", AccountChecking, "").StatusCode, http.StatusUnprocessableEntity)
+	ensureInt(t, addAccount(mainAccountName, AccountChecking, "").StatusCode, http.StatusUnprocessableEntity)
+	ensureInt(t, addAccount("Stocks", "crypto", "").StatusCode, http.StatusUnprocessableEntity)
+
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	accounts, err := repository.ListAccounts(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(accounts), 1)
+	savings := accounts[0]
+	if savings.StatementDay != 0 {
+		t.Errorf("a savings account got statement day %d", savings.StatementDay)
+	}
+	ensureInt(t, addAccount("Visa", AccountCreditCard, savings.ID.String()).StatusCode, http.StatusFound)
+
+	// an account of another planner is rejected
+	transfer := url.Values{}
+	transfer.Set("csrf-token", csrfToken)
+	transfer.Set("title", "Save")
+	transfer.Set("income_or_expense", "transfer")
+	transfer.Set("to_account_id", uuid.Must(uuid.NewV4()).String())
+	transfer.Set("amount", "40")
+	transfer.Set("transaction_date", time.Now().AddDate(0, 0, 1).Format(time.DateOnly))
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", transfer), http.StatusInternalServerError)
+	transfer.Set("to_account_id", savings.ID.String())
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", transfer), http.StatusFound, plannerURL)
+
+	recorder := serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	rows := parseCashFlow(t, recorder.Body.String())
+	ensureInt(t, len(rows), 1)
+	// date, title, type, account, amount, net cash and the balance of each account
+	ensureString(t, rows[0][3], "Main → Savings")
+	ensureString(t, rows[0][5], "200")
+	ensureString(t, rows[0][6], "-40")
+	ensureString(t, rows[0][7], "140")
+	ensureString(t, rows[0][8], "100")
+
+	// deleting the account moves its transactions to the main account
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	ensureRedirect(t, serve(t, server, jar, "POST", plannerURL+"/accounts/"+savings.ID.String()+"/delete", form), http.StatusFound, plannerURL+"/accounts")
+	ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/accounts/"+savings.ID.String()+"/delete", form), http.StatusNotFound)
+	txns, err := repository.ListExpandedTransactions(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(txns), 1)
+	if txns[0].ToAccountID != uuid.Nil {
+		t.Errorf("the transfer kept the deleted account %s", txns[0].ToAccountID)
+	}
+	accounts, _ = repository.ListAccounts(user.ID, plannerID)
+	ensureInt(t, len(accounts), 1)
+	if accounts[0].PaymentAccountID != uuid.Nil {
+		t.Errorf("the card is paid from the deleted account %s", accounts[0].PaymentAccountID)
+	}
+}
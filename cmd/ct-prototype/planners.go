@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/form/v4"
+	"github.com/gofrs/uuid"
+)
+
+const defaultPlannerHorizonMonths = 12
+
+// plannerForm creates a planner from the HTML form or the JSON API.
+type plannerForm struct {
+	Name          string  `form:"name" json:"name" validate:"required,min=1,max=255"`
+	StartBalance  float64 `form:"start_balance" json:"start_balance"`
+	HorizonMonths int     `form:"horizon_months" json:"horizon_months" validate:"gte=0,lte=600"`
+	Currency      string  `form:"currency" json:"currency" validate:"required,iso4217"`
+}
+
+// planner returns a new planner of the user with the values of the form.
+func (f *plannerForm) planner(userID uuid.UUID) *Planner {
+	horizonMonths := f.HorizonMonths
+	if horizonMonths == 0 {
+		horizonMonths = defaultPlannerHorizonMonths
+	}
+	id, _ := uuid.NewV4()
+	return &Planner{
+		ID:            id,
+		UserID:        userID,
+		Name:          f.Name,
+		StartBalance:  f.StartBalance,
+		HorizonMonths: horizonMonths,
+		Currency:      f.Currency,
+	}
+}
+
+type plannerNameForm struct {
+	Name string `form:"name" json:"name" validate:"required,min=1,max=255"`
+}
+
+func plannerURL(plannerID uuid.UUID) string {
+	return "/planners/" + plannerID.String()
+}
+
+// plannerForRequest returns the signed in user and the planner with the ID in the
+// path. Only the members who can edit the planner change it, the others get a
+// 403 for anything but a GET. The error response is written when false is
+// returned.
+func (s *Server) plannerForRequest(w http.ResponseWriter, r *http.Request) (*User, *Planner, bool) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !planner.CanEdit() {
+		http.Error(w, "Viewers cannot change the planner.", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return user, planner, true
+}
+
+// ownedPlanner returns the signed in user and the planner with the ID in the path
+// when the user owns it. The error response is written when false is returned.
+func (s *Server) ownedPlanner(w http.ResponseWriter, r *http.Request) (*User, *Planner, bool) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if !planner.IsOwner() {
+		http.Error(w, "Only the owner can do this.", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return user, planner, true
+}
+
+// memberPlanner returns the signed in user and the planner with the ID in the path
+// for any member of the planner. The error response is written when false is
+// returned.
+func (s *Server) memberPlanner(w http.ResponseWriter, r *http.Request) (*User, *Planner, bool) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return nil, nil, false
+	}
+	plannerID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+	planner, err := s.repository.GetPlanner(user.ID, plannerID)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+	if err != nil {
+		s.internalError(w, "unable to get planner", err)
+		return nil, nil, false
+	}
+	return user, planner, true
+}
+
+func (s *Server) listPlanners(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	s.renderPlanners(w, r, user, http.StatusOK, "")
+}
+
+// renderPlanners renders the planners of the user with the message of a rejected
+// form.
+func (s *Server) renderPlanners(w http.ResponseWriter, r *http.Request, user *User, status int, formError string) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Planners:   planners,
+		FormError:  formError,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "planners.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+func (s *Server) createPlanner(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.internalError(w, "unable to parse form", err)
+		return
+	}
+
+	validate := newValidator()
+	decoder := form.NewDecoder()
+	var form plannerForm
+	err = decoder.Decode(&form, r.PostForm)
+	if err != nil {
+		s.internalError(w, "unable to parse POST form", err)
+		return
+	}
+	err = validate.Struct(form)
+	if err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+
+	planner := form.planner(user.ID)
+	if err = s.repository.AddPlanner(planner); err != nil {
+		s.internalError(w, "unable to add planner", err)
+		return
+	}
+	s.logger.Info().Msgf("added planner with id %s", planner.ID)
+	http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+}
+
+func (s *Server) renamePlanner(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.internalError(w, "unable to parse form", err)
+		return
+	}
+
+	validate := newValidator()
+	decoder := form.NewDecoder()
+	var form plannerNameForm
+	err := decoder.Decode(&form, r.PostForm)
+	if err != nil {
+		s.internalError(w, "unable to parse POST form", err)
+		return
+	}
+	err = validate.Struct(form)
+	if err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+	if err = s.repository.RenamePlanner(user.ID, planner.ID, form.Name); err != nil {
+		s.internalError(w, "unable to rename planner", err)
+		return
+	}
+	http.Redirect(w, r, plannerURL(planner.ID), http.StatusFound)
+}
+
+func (s *Server) duplicatePlanner(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		name = planner.Name + " (copy)"
+	}
+	newUUID, _ := uuid.NewV4()
+	if err := s.repository.DuplicatePlanner(user.ID, planner.ID, newUUID, name); err != nil {
+		s.internalError(w, "unable to duplicate planner", err)
+		return
+	}
+	s.logger.Info().Msgf("duplicated planner %s to %s", planner.ID, newUUID)
+	http.Redirect(w, r, plannerURL(newUUID), http.StatusFound)
+}
+
+func (s *Server) deletePlanner(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.ownedPlanner(w, r)
+	if !ok {
+		return
+	}
+	if err := s.repository.DeletePlanner(user.ID, planner.ID); err != nil {
+		s.internalError(w, "unable to delete planner", err)
+		return
+	}
+	s.logger.Info().Msgf("deleted planner with id %s", planner.ID)
+	http.Redirect(w, r, "/planners", http.StatusFound)
+}
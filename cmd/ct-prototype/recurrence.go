@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Recurrence follows the RFC 5545 RRULE parts the planner needs. Day-of-month rules
+// are clamped to the last day of shorter months instead of skipping them, so rent
+// on the 31st is paid on the 30th in April.
+type Recurrence struct {
+	// Freq is daily, weekly, monthly or yearly. Daily repeats every
+	// RangeTransaction.RecurrenceEveryDays days.
+	Freq string
+	// Interval repeats every N weeks, months or years
+	Interval int
+	// ByWeekday is the weekly day (MO..SU), defaults to the weekday of the start
+	ByWeekday string
+	// ByMonthDay is the monthly day (1..31, -1 for the last day), defaults to the day of the start
+	ByMonthDay int
+	// LastBusinessDay repeats monthly on the last Monday to Friday of the month
+	LastBusinessDay bool
+	// ExceptionDates are comma separated YYYY-MM-DD days without an occurrence
+	ExceptionDates string
+}
+
+const (
+	FreqDaily   = "daily"
+	FreqWeekly  = "weekly"
+	FreqMonthly = "monthly"
+	FreqYearly  = "yearly"
+)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// parseExceptionDates returns the valid YYYY-MM-DD days of the comma separated list.
+func parseExceptionDates(s string) ([]time.Time, error) {
+	var dates []time.Time
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.Parse(time.DateOnly, part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exception date %s", part)
+		}
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
+
+// normalizeExceptionDates sorts and dedupes the comma separated days.
+func normalizeExceptionDates(s string) (string, error) {
+	dates, err := parseExceptionDates(s)
+	if err != nil {
+		return "", err
+	}
+	days := make([]string, 0, len(dates))
+	for _, d := range dates {
+		days = append(days, d.Format(time.DateOnly))
+	}
+	slices.Sort(days)
+	return strings.Join(slices.Compact(days), ","), nil
+}
+
+func (r Recurrence) interval() int {
+	return max(r.Interval, 1)
+}
+
+// Occurrences returns the days of the range transaction from its start to its
+// end, both inclusive, without the exception dates.
+func (rt *RangeTransaction) Occurrences() []time.Time {
+	start := truncateDay(rt.RecurrenceStart)
+	end := truncateDay(rt.RecurrenceEnd)
+	r := rt.Recurrence
+	step := r.interval()
+
+	var days []time.Time
+	switch r.Freq {
+	case FreqWeekly:
+		weekday, ok := rruleWeekdays[r.ByWeekday]
+		if !ok {
+			weekday = start.Weekday()
+		}
+		first := start.AddDate(0, 0, (int(weekday)-int(start.Weekday())+7)%7)
+		for d := first; !d.After(end); d = d.AddDate(0, 0, 7*step) {
+			days = append(days, d)
+		}
+	case FreqMonthly:
+		for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(end); m = m.AddDate(0, step, 0) {
+			last := daysInMonth(m.Year(), m.Month())
+			var day int
+			switch {
+			case r.LastBusinessDay:
+				lastDay := time.Date(m.Year(), m.Month(), last, 0, 0, 0, 0, time.UTC)
+				day = last
+				switch lastDay.Weekday() {
+				case time.Saturday:
+					day -= 1
+				case time.Sunday:
+					day -= 2
+				}
+			case r.ByMonthDay == -1:
+				day = last
+			case r.ByMonthDay > 0:
+				day = min(r.ByMonthDay, last)
+			default:
+				day = min(start.Day(), last)
+			}
+			days = append(days, time.Date(m.Year(), m.Month(), day, 0, 0, 0, 0, time.UTC))
+		}
+	case FreqYearly:
+		for year := start.Year(); year <= end.Year(); year += step {
+			day := min(start.Day(), daysInMonth(year, start.Month()))
+			days = append(days, time.Date(year, start.Month(), day, 0, 0, 0, 0, time.UTC))
+		}
+	default:
+		every := max(rt.RecurrenceEveryDays, 1)
+		for d := start; !d.After(end); d = d.AddDate(0, 0, every) {
+			days = append(days, d)
+		}
+	}
+
+	exceptions, _ := parseExceptionDates(r.ExceptionDates)
+	return slices.DeleteFunc(days, func(d time.Time) bool {
+		return d.Before(start) || d.After(end) || slices.ContainsFunc(exceptions, d.Equal)
+	})
+}
+
+// RecurrenceString describes the recurrence, e.g. "Every 2 weeks on Friday".
+func (rt *RangeTransaction) RecurrenceString() string {
+	r := rt.Recurrence
+	every := func(unit string) string {
+		if r.interval() == 1 {
+			return "Every " + unit
+		}
+		return fmt.Sprintf("Every %d %ss", r.interval(), unit)
+	}
+	switch r.Freq {
+	case FreqWeekly:
+		weekday, ok := rruleWeekdays[r.ByWeekday]
+		if !ok {
+			weekday = rt.RecurrenceStart.Weekday()
+		}
+		return every("week") + " on " + weekday.String()
+	case FreqMonthly:
+		switch {
+		case r.LastBusinessDay:
+			return every("month") + " on the last business day"
+		case r.ByMonthDay == -1:
+			return every("month") + " on the last day"
+		case r.ByMonthDay > 0:
+			return fmt.Sprintf("%s on day %d", every("month"), r.ByMonthDay)
+		default:
+			return fmt.Sprintf("%s on day %d", every("month"), rt.RecurrenceStart.Day())
+		}
+	case FreqYearly:
+		return every("year") + " on " + rt.RecurrenceStart.Format("02 Jan")
+	default:
+		return fmt.Sprintf("Every %d days", rt.RecurrenceEveryDays)
+	}
+}
+
+// RRule returns the recurrence as an RFC 5545 RRULE value.
+func (rt *RangeTransaction) RRule() string {
+	r := rt.Recurrence
+	parts := []string{}
+	switch r.Freq {
+	case FreqWeekly:
+		weekday := r.ByWeekday
+		if weekday == "" {
+			weekday = strings.ToUpper(rt.RecurrenceStart.Weekday().String()[:2])
+		}
+		parts = append(parts, "FREQ=WEEKLY", fmt.Sprintf("INTERVAL=%d", r.interval()), "BYDAY="+weekday)
+	case FreqMonthly:
+		parts = append(parts, "FREQ=MONTHLY", fmt.Sprintf("INTERVAL=%d", r.interval()))
+		switch {
+		case r.LastBusinessDay:
+			parts = append(parts, "BYDAY=MO,TU,WE,TH,FR", "BYSETPOS=-1")
+		case r.ByMonthDay != 0:
+			parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", r.ByMonthDay))
+		default:
+			parts = append(parts, fmt.Sprintf("BYMONTHDAY=%d", rt.RecurrenceStart.Day()))
+		}
+	case FreqYearly:
+		parts = append(parts, "FREQ=YEARLY", fmt.Sprintf("INTERVAL=%d", r.interval()))
+	default:
+		parts = append(parts, "FREQ=DAILY", fmt.Sprintf("INTERVAL=%d", max(rt.RecurrenceEveryDays, 1)))
+	}
+	parts = append(parts, "UNTIL="+truncateDay(rt.RecurrenceEnd).Format("20060102"))
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// quickRange is a random range transaction for the property tests.
+type quickRange struct {
+	RangeTransaction
+}
+
+func (quickRange) Generate(rand *rand.Rand, size int) reflect.Value {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, rand.Intn(5*365))
+	end := start.AddDate(0, 0, rand.Intn(3*365))
+	rt := RangeTransaction{
+		Title:               "quick",
+		IncomeOrExpense:     "expense",
+		Amount:              float64(rand.Intn(1000) + 1),
+		RecurrenceEveryDays: rand.Intn(60) + 1,
+		RecurrenceStart:     start,
+		RecurrenceEnd:       end,
+	}
+	freqs := []string{FreqDaily, FreqWeekly, FreqMonthly, FreqYearly}
+	rt.Recurrence.Freq = freqs[rand.Intn(len(freqs))]
+	rt.Recurrence.Interval = rand.Intn(4)
+	switch rt.Recurrence.Freq {
+	case FreqWeekly:
+		weekdays := []string{"", "MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+		rt.Recurrence.ByWeekday = weekdays[rand.Intn(len(weekdays))]
+	case FreqMonthly:
+		switch rand.Intn(4) {
+		case 0:
+			rt.Recurrence.LastBusinessDay = true
+		case 1:
+			rt.Recurrence.ByMonthDay = -1
+		case 2:
+			rt.Recurrence.ByMonthDay = rand.Intn(31) + 1
+		}
+	}
+	var exceptions []string
+	for i := rand.Intn(4); i > 0 && !end.Before(start); i-- {
+		day := start.AddDate(0, 0, rand.Intn(int(end.Sub(start).Hours()/24)+1))
+		exceptions = append(exceptions, day.Format(time.DateOnly))
+	}
+	rt.Recurrence.ExceptionDates = strings.Join(exceptions, ",")
+	return reflect.ValueOf(quickRange{rt})
+}
+
+func TestOccurrencesProperties(t *testing.T) {
+	property := func(q quickRange) bool {
+		rt := &q.RangeTransaction
+		r := rt.Recurrence
+		days := rt.Occurrences()
+		exceptions, _ := parseExceptionDates(r.ExceptionDates)
+		for i, d := range days {
+			if d.Before(rt.RecurrenceStart) || d.After(rt.RecurrenceEnd) {
+				t.Logf("%s: %s is outside of the range", rt.RRule(), d)
+				return false
+			}
+			if slices.ContainsFunc(exceptions, d.Equal) {
+				t.Logf("%s: %s is an exception date", rt.RRule(), d)
+				return false
+			}
+			if i > 0 && !d.After(days[i-1]) {
+				t.Logf("%s: %s is not after %s", rt.RRule(), d, days[i-1])
+				return false
+			}
+			last := daysInMonth(d.Year(), d.Month())
+			switch {
+			case r.Freq == FreqWeekly && r.ByWeekday != "" && d.Weekday() != rruleWeekdays[r.ByWeekday]:
+				t.Logf("%s: %s is a %s", rt.RRule(), d, d.Weekday())
+				return false
+			case r.Freq == FreqMonthly && r.LastBusinessDay &&
+				(d.Weekday() == time.Saturday || d.Weekday() == time.Sunday || last-d.Day() > 2):
+				t.Logf("%s: %s is not the last business day", rt.RRule(), d)
+				return false
+			case r.Freq == FreqMonthly && r.ByMonthDay == -1 && d.Day() != last:
+				t.Logf("%s: %s is not the last day", rt.RRule(), d)
+				return false
+			case r.Freq == FreqMonthly && r.ByMonthDay > 0 && d.Day() != min(r.ByMonthDay, last):
+				t.Logf("%s: %s is not day %d", rt.RRule(), d, r.ByMonthDay)
+				return false
+			case r.Freq == FreqYearly && d.Month() != rt.RecurrenceStart.Month():
+				t.Logf("%s: %s is not in %s", rt.RRule(), d, rt.RecurrenceStart.Month())
+				return false
+			}
+		}
+		if len(exceptions) > 0 {
+			return true
+		}
+		// without exceptions the spacing between occurrences is fixed
+		for i := 1; i < len(days); i++ {
+			var want time.Time
+			switch r.Freq {
+			case FreqDaily:
+				want = days[i-1].AddDate(0, 0, rt.RecurrenceEveryDays)
+			case FreqWeekly:
+				want = days[i-1].AddDate(0, 0, 7*r.interval())
+			default:
+				continue
+			}
+			if !days[i].Equal(want) {
+				t.Logf("%s: %s follows %s, want %s", rt.RRule(), days[i], days[i-1], want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOccurrencesExamples(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	tests := []struct {
+		name  string
+		rt    RangeTransaction
+		wants []string
+	}{
+		{
+			name: "rent on the 31st",
+			rt: RangeTransaction{
+				RecurrenceStart: day("2024-01-31"), RecurrenceEnd: day("2024-04-30"),
+				Recurrence: Recurrence{Freq: FreqMonthly, ByMonthDay: 31},
+			},
+			wants: []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30"},
+		},
+		{
+			name: "paycheck on the last business day",
+			rt: RangeTransaction{
+				RecurrenceStart: day("2024-03-01"), RecurrenceEnd: day("2024-06-30"),
+				Recurrence: Recurrence{Freq: FreqMonthly, LastBusinessDay: true},
+			},
+			wants: []string{"2024-03-29", "2024-04-30", "2024-05-31", "2024-06-28"},
+		},
+		{
+			name: "every other friday without a holiday",
+			rt: RangeTransaction{
+				RecurrenceStart: day("2024-12-01"), RecurrenceEnd: day("2025-01-20"),
+				Recurrence: Recurrence{Freq: FreqWeekly, Interval: 2, ByWeekday: "FR", ExceptionDates: "2024-12-27"},
+			},
+			wants: []string{"2024-12-06", "2024-12-20", "2025-01-03", "2025-01-17"},
+		},
+		{
+			name: "yearly on a leap day",
+			rt: RangeTransaction{
+				RecurrenceStart: day("2024-02-29"), RecurrenceEnd: day("2028-03-01"),
+				Recurrence: Recurrence{Freq: FreqYearly},
+			},
+			wants: []string{"2024-02-29", "2025-02-28", "2026-02-28", "2027-02-28", "2028-02-29"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range tt.rt.Occurrences() {
+				got = append(got, d.Format(time.DateOnly))
+			}
+			if !slices.Equal(got, tt.wants) {
+				t.Errorf("got %v, want %v", got, tt.wants)
+			}
+		})
+	}
+}
+
+func TestExpandWithOverrides(t *testing.T) {
+	property := func(q quickRange) bool {
+		rt := &q.RangeTransaction
+		series := rt.Expand()
+		if len(series) == 0 {
+			return true
+		}
+		kept := series[len(series)/2]
+		kept.IsOverride = true
+		kept.TransactionDate = kept.TransactionDate.AddDate(0, 0, 1)
+		gone := ExpandedTransaction{IsOverride: true, OccurrenceDate: rt.RecurrenceEnd.AddDate(0, 0, 1)}
+
+		generated, stale := rt.ExpandWithOverrides([]ExpandedTransaction{kept, gone})
+		if len(generated) != len(series)-1 {
+			t.Logf("%s: generated %d of %d occurrences", rt.RRule(), len(generated), len(series))
+			return false
+		}
+		for _, etx := range generated {
+			if etx.OccurrenceDate.Equal(kept.OccurrenceDate) || etx.ID == uuid.Nil {
+				t.Logf("%s: unexpected occurrence %+v", rt.RRule(), etx)
+				return false
+			}
+		}
+		return len(stale) == 1 && stale[0].OccurrenceDate.Equal(gone.OccurrenceDate)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
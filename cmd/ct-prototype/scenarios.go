@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Kinds of the changes of a scenario.
+const (
+	ScenarioAdded    = "added"
+	ScenarioRemoved  = "removed"
+	ScenarioModified = "modified"
+)
+
+// ScenarioChange is a range transaction the scenario added, removed or modified
+// compared to the planner it was forked from.
+type ScenarioChange struct {
+	Kind  string
+	Title string
+	// Fields says what changed in a modified range transaction
+	Fields []string
+}
+
+// ScenarioMonth is the net cash of the planner and of the scenario at the end of
+// a month.
+type ScenarioMonth struct {
+	Month    time.Time
+	Planner  float64
+	Scenario float64
+}
+
+// Difference is how much more the scenario has than the planner.
+func (m ScenarioMonth) Difference() float64 {
+	return roundCents(m.Scenario - m.Planner)
+}
+
+// ScenarioComparison is what the comparison page shows.
+type ScenarioComparison struct {
+	Planner  *Planner
+	Scenario *Planner
+	Changes  []ScenarioChange
+	Months   []ScenarioMonth
+	// PlannerPoints and ScenarioPoints are the weekly net cash of both
+	PlannerPoints  []ChartPoint
+	ScenarioPoints []ChartPoint
+}
+
+// scenarioChanges compares the range transactions of the scenario with the ones of
+// the planner. A range transaction of the scenario is matched with the one it
+// was forked from, the accounts are compared by name as the scenario has its
+// own copies of them.
+func scenarioChanges(base, scenario []RangeTransaction, baseAccounts, scenarioAccounts map[uuid.UUID]string) []ScenarioChange {
+	forked := map[uuid.UUID]*RangeTransaction{}
+	for i := range scenario {
+		if scenario[i].ForkedFromID != uuid.Nil {
+			forked[scenario[i].ForkedFromID] = &scenario[i]
+		}
+	}
+	var changes []ScenarioChange
+	known := map[uuid.UUID]bool{}
+	for i := range base {
+		b := &base[i]
+		known[b.ID] = true
+		s, ok := forked[b.ID]
+		if !ok {
+			changes = append(changes, ScenarioChange{Kind: ScenarioRemoved, Title: b.Title})
+			continue
+		}
+		var fields []string
+		changed := func(field, from, to string) {
+			if from != to {
+				fields = append(fields, fmt.Sprintf("%s from %s to %s", field, from, to))
+			}
+		}
+		changed("title", b.Title, s.Title)
+		changed("type", b.IncomeOrExpense, s.IncomeOrExpense)
+		changed("amount", fmt.Sprint(b.Amount), fmt.Sprint(s.Amount))
+		changed("account", baseAccounts[b.AccountID], scenarioAccounts[s.AccountID])
+		changed("to account", baseAccounts[b.ToAccountID], scenarioAccounts[s.ToAccountID])
+		changed("category", b.Category, s.Category)
+		if b.RecurrenceEveryDays != s.RecurrenceEveryDays || b.Recurrence != s.Recurrence ||
+			!b.RecurrenceStart.Equal(s.RecurrenceStart) || !b.RecurrenceEnd.Equal(s.RecurrenceEnd) {
+			fields = append(fields, "recurrence")
+		}
+		if b.Growth != s.Growth {
+			fields = append(fields, "growth")
+		}
+		if b.Uncertainty != s.Uncertainty {
+			fields = append(fields, "uncertainty")
+		}
+		if b.Notes != s.Notes {
+			fields = append(fields, "notes")
+		}
+		if len(fields) > 0 {
+			changes = append(changes, ScenarioChange{Kind: ScenarioModified, Title: s.Title, Fields: fields})
+		}
+	}
+	// a range transaction the planner deleted after the fork is new to the scenario too
+	for i := range scenario {
+		if !known[scenario[i].ForkedFromID] {
+			changes = append(changes, ScenarioChange{Kind: ScenarioAdded, Title: scenario[i].Title})
+		}
+	}
+	return changes
+}
+
+// monthEndBalances returns the net cash at the end of each month, a month without
+// transactions keeps the net cash it started with.
+func monthEndBalances(opening float64, txns []*SegmentedTransaction, months []time.Time) []float64 {
+	balances := make([]float64, len(months))
+	netCash := opening
+	next := 0
+	for i, month := range months {
+		end := month.AddDate(0, 1, 0)
+		for next < len(txns) && txns[next].TransactionDate.Before(end) {
+			netCash = txns[next].NetCash
+			next++
+		}
+		balances[i] = netCash
+	}
+	return balances
+}
+
+// plannerCashFlow returns the whole cash flow of the planner until end with its
+// range transactions and the names of its accounts.
+func (s *Server) plannerCashFlow(user *User, planner *Planner, now, end time.Time) ([]*SegmentedTransaction, []RangeTransaction, map[uuid.UUID]string, float64, error) {
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("listing accounts: %w", err)
+	}
+	rangeTxns, err := s.repository.ListRangeTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("listing range transactions: %w", err)
+	}
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		return nil, nil, nil, 0, fmt.Errorf("listing expanded transactions: %w", err)
+	}
+	return cashFlow(planner, accounts, txns, now, end), rangeTxns, accountNames(planner, accounts),
+		openingBalance(planner, accounts), nil
+}
+
+// compareScenario compares the scenario with the planner from the month of now to
+// the end of the longer of the two.
+func (s *Server) compareScenario(user *User, planner, scenario *Planner, now time.Time) (*ScenarioComparison, error) {
+	end := planner.End(now)
+	if scenario.End(now).After(end) {
+		end = scenario.End(now)
+	}
+	baseTxns, baseRangeTxns, baseAccounts, baseOpening, err := s.plannerCashFlow(user, planner, now, end)
+	if err != nil {
+		return nil, err
+	}
+	txns, rangeTxns, accounts, opening, err := s.plannerCashFlow(user, scenario, now, end)
+	if err != nil {
+		return nil, err
+	}
+	var months []time.Time
+	for month := monthStart(now); !month.After(end); month = month.AddDate(0, 1, 0) {
+		months = append(months, month)
+	}
+	baseBalances := monthEndBalances(baseOpening, baseTxns, months)
+	balances := monthEndBalances(opening, txns, months)
+	comparison := &ScenarioComparison{
+		Planner:        planner,
+		Scenario:       scenario,
+		Changes:        scenarioChanges(baseRangeTxns, rangeTxns, baseAccounts, accounts),
+		PlannerPoints:  chartPoints(baseTxns, ChartWeekly),
+		ScenarioPoints: chartPoints(txns, ChartWeekly),
+	}
+	for i, month := range months {
+		comparison.Months = append(comparison.Months, ScenarioMonth{
+			Month:    month,
+			Planner:  baseBalances[i],
+			Scenario: balances[i],
+		})
+	}
+	return comparison, nil
+}
+
+// forkPlanner copies the planner into a scenario to try changes on. Any member
+// can fork a planner and owns the scenario.
+func (s *Server) forkPlanner(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.memberPlanner(w, r)
+	if !ok {
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		name = planner.Name + " (scenario)"
+	}
+	scenarioID, _ := uuid.NewV4()
+	if err := s.repository.ForkPlanner(user.ID, planner.ID, scenarioID, name); err != nil {
+		s.internalError(w, "unable to fork planner", err)
+		return
+	}
+	s.logger.Info().Msgf("forked planner %s to scenario %s", planner.ID, scenarioID)
+	http.Redirect(w, r, plannerURL(scenarioID), http.StatusFound)
+}
+
+// comparePlanners shows the changes of the scenario and its net cash next to the
+// net cash of the planner it was forked from.
+func (s *Server) comparePlanners(w http.ResponseWriter, r *http.Request) {
+	user, scenario, ok := s.memberPlanner(w, r)
+	if !ok {
+		return
+	}
+	if !scenario.IsScenario() {
+		http.Error(w, "Only a scenario is compared with the planner it was forked from.", http.StatusNotFound)
+		return
+	}
+	planner, err := s.repository.GetPlanner(user.ID, scenario.ParentID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "The planner of the scenario was deleted or is not shared with you.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to get planner", err)
+		return
+	}
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	comparison, err := s.compareScenario(user, planner, scenario, time.Now())
+	if err != nil {
+		s.internalError(w, "unable to compare the scenario", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:  getCSRFToken(w, r),
+		IsLoggedIn: true,
+		PlannerID:  scenario.ID,
+		Planner:    scenario,
+		Planners:   planners,
+		Username:   user.Username,
+		UserID:     user.ID,
+		Comparison: comparison,
+	}
+	if err := StaticResources.ExecuteTemplate(w, "compare.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
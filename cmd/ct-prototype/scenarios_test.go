@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestScenarioChanges(t *testing.T) {
+	newID := func() uuid.UUID {
+		id, _ := uuid.NewV4()
+		return id
+	}
+	savings, scenarioSavings := newID(), newID()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	rent := RangeTransaction{ID: newID(), Title: "Rent", IncomeOrExpense: "expense", Amount: 900,
+		RecurrenceStart: start, RecurrenceEnd: start.AddDate(1, 0, 0), Recurrence: Recurrence{Freq: FreqMonthly}}
+	gym := RangeTransaction{ID: newID(), Title: "Gym", IncomeOrExpense: "expense", Amount: 50, AccountID: savings,
+		RecurrenceStart: start, RecurrenceEnd: start.AddDate(1, 0, 0), Recurrence: Recurrence{Freq: FreqMonthly}}
+	salary := RangeTransaction{ID: newID(), Title: "Salary", IncomeOrExpense: "income", Amount: 3000, AccountID: savings,
+		RecurrenceStart: start, RecurrenceEnd: start.AddDate(1, 0, 0), Recurrence: Recurrence{Freq: FreqMonthly}}
+	base := []RangeTransaction{rent, gym, salary}
+
+	forked := func(rt RangeTransaction) RangeTransaction {
+		rt.ForkedFromID, rt.ID = rt.ID, newID()
+		if rt.AccountID == savings {
+			rt.AccountID = scenarioSavings
+		}
+		return rt
+	}
+	scenarioRent := forked(rent)
+	scenarioRent.Amount = 1200
+	scenarioRent.RecurrenceEnd = start.AddDate(0, 6, 0)
+	scenarioSalary := forked(salary)
+	bonus := RangeTransaction{ID: newID(), Title: "Bonus", IncomeOrExpense: "income", Amount: 1000}
+	scenario := []RangeTransaction{scenarioRent, scenarioSalary, bonus}
+
+	changes := scenarioChanges(base, scenario,
+		map[uuid.UUID]string{uuid.Nil: mainAccountName, savings: "Savings"},
+		map[uuid.UUID]string{uuid.Nil: mainAccountName, scenarioSavings: "Savings"})
+	expected := []ScenarioChange{
+		{Kind: ScenarioModified, Title: "Rent", Fields: []string{"amount from 900 to 1200", "recurrence"}},
+		{Kind: ScenarioRemoved, Title: "Gym"},
+		{Kind: ScenarioAdded, Title: "Bonus"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("got changes %+v, want %+v", changes, expected)
+	}
+}
+
+func TestMonthEndBalances(t *testing.T) {
+	months := []time.Time{
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	txns := []*SegmentedTransaction{
+		{TransactionDate: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), NetCash: 900},
+		{TransactionDate: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), NetCash: 800},
+		{TransactionDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), NetCash: 500},
+	}
+	balances := monthEndBalances(1000, txns, months)
+	if !reflect.DeepEqual(balances, []float64{800, 800, 500}) {
+		t.Fatalf("got month-end balances %v", balances)
+	}
+}
+
+func TestForkAndComparePlanner(t *testing.T) {
+	server, repository := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+	plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().AddDate(0, 0, 1)
+	addRange := func(path, title, incomeOrExpense, amount string) {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("title", title)
+		form.Set("income_or_expense", incomeOrExpense)
+		form.Set("amount", amount)
+		form.Set("recurrence_freq", "monthly")
+		form.Set("recurrence_start", start.Format(time.DateOnly))
+		form.Set("recurrence_end", start.AddDate(0, 1, 0).Format(time.DateOnly))
+		ensureCode(t, serve(t, server, jar, "POST", path+"/add-range-transaction", form), http.StatusFound)
+	}
+	addRange(plannerURL, "Rent", "expense", "900")
+	addRange(plannerURL, "Gym", "expense", "50")
+
+	// only a scenario has a planner to compare with
+	ensureCode(t, serve(t, server, jar, "GET", plannerURL+"/compare", nil), http.StatusNotFound)
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	recorder := serve(t, server, jar, "POST", plannerURL+"/fork", form)
+	ensureCode(t, recorder, http.StatusFound)
+	scenarioURL := recorder.Header().Get("Location")
+	scenarioID := uuid.FromStringOrNil(strings.TrimPrefix(scenarioURL, "/planners/"))
+	scenario, err := repository.GetPlanner(user.ID, scenarioID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.ParentID != plannerID {
+		t.Fatalf("the scenario has parent %s, want %s", scenario.ParentID, plannerID)
+	}
+	ensureString(t, scenario.Name, "Household (scenario)")
+
+	// Try a higher rent without the gym and with a bonus in the scenario
+	rangeTxns, err := repository.ListRangeTransactions(user.ID, scenarioID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(rangeTxns), 2)
+	for _, rt := range rangeTxns {
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("range_transaction_id", rt.ID.String())
+		if rt.Title == "Gym" {
+			ensureCode(t, serve(t, server, jar, "POST", scenarioURL+"/delete-range-transaction", form), http.StatusFound)
+			continue
+		}
+		form.Set("title", "Rent")
+		form.Set("income_or_expense", "expense")
+		form.Set("amount", "1200")
+		form.Set("recurrence_freq", "monthly")
+		form.Set("recurrence_start", start.Format(time.DateOnly))
+		form.Set("recurrence_end", start.AddDate(0, 1, 0).Format(time.DateOnly))
+		ensureCode(t, serve(t, server, jar, "POST", scenarioURL+"/update-range-transaction", form), http.StatusFound)
+	}
+	addRange(scenarioURL, "Bonus", "income", "1000")
+
+	// The planner keeps its transactions
+	rows := parseCashFlow(t, serve(t, server, jar, "GET", plannerURL+"?view=all", nil).Body.String())
+	ensureInt(t, len(rows), 4)
+	ensureString(t, rows[3][4], "-1900")
+
+	recorder = serve(t, server, jar, "GET", scenarioURL+"/compare", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	body := recorder.Body.String()
+	for _, change := range []string{"amount from 900 to 1200", "removed", "added", "month-end-balances"} {
+		if !strings.Contains(body, change) {
+			t.Errorf("the comparison has no %q", change)
+		}
+	}
+
+	planner, err := repository.GetPlanner(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparison, err := server.compareScenario(user, planner, scenario, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(comparison.Changes), 3)
+	months := comparison.Months
+	ensureInt(t, len(months), planner.HorizonMonths+1)
+	last := months[len(months)-1]
+	ensureFloat(t, last.Planner, -1900)
+	ensureFloat(t, last.Scenario, -400)
+	// the bonus comes twice, the rent costs 300 more twice and the gym is gone
+	ensureFloat(t, last.Difference(), 1500)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+type contextKey int
+
+// sessionContextKey holds the signedInSession of a request behind signedIn
+const sessionContextKey contextKey = iota
+
+// signedInSession is the session of a request with its user.
+type signedInSession struct {
+	session *Session
+	user    *User
+}
+
+// maxUserAgentLength caps the user agent stored with a session
+const maxUserAgentLength = 255
+
+// startSession signs the user in on the device of the request.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, user *User) error {
+	now := time.Now()
+	token := generateSecureToken(32)
+	id, _ := uuid.NewV4()
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := &Session{
+		ID:         id,
+		UserID:     user.ID,
+		TokenHash:  hashToken(token),
+		UserAgent:  userAgent,
+		IPAddress:  clientIP(r),
+		ExpiresAt:  now.Add(sessionDuration),
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+	if err := s.repository.AddSession(session); err != nil {
+		return err
+	}
+	initBrowserSession(w, r, token, session.ExpiresAt)
+	return nil
+}
+
+// sessionForRequest returns the session of the cookie of the request with its user.
+func (s *Server) sessionForRequest(r *http.Request) (*signedInSession, error) {
+	if signedIn, ok := r.Context().Value(sessionContextKey).(*signedInSession); ok {
+		return signedIn, nil
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, err
+	}
+	session, user, err := s.repository.GetSession(hashToken(cookie.Value), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &signedInSession{session, user}, nil
+}
+
+func (s *Server) signedIn(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		signedIn, err := s.sessionForRequest(r)
+		if err != nil {
+			if !errors.Is(err, http.ErrNoCookie) && !errors.Is(err, ErrNotFound) {
+				s.logger.Err(err).Msg("unable to get the session")
+			}
+			location := "/?return-url=" + url.QueryEscape(r.URL.Path)
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, signedIn)))
+	}
+}
+
+func (s *Server) isSignedIn(r *http.Request) bool {
+	_, err := s.sessionForRequest(r)
+	return err == nil
+}
+
+// currentUser returns the signed in user of the request.
+func (s *Server) currentUser(r *http.Request) (*User, error) {
+	signedIn, err := s.sessionForRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return signedIn.user, nil
+}
+
+// passwordChangedNotice is shown on the settings page after a password change
+const passwordChangedNotice = "Your password was changed and your other devices were signed out."
+
+// settingsPage lists the signed in devices of the user.
+func (s *Server) settingsPage(w http.ResponseWriter, r *http.Request) {
+	var notice string
+	if r.URL.Query().Get("notice") == "password-changed" {
+		notice = passwordChangedNotice
+	}
+	s.renderSettings(w, r, http.StatusOK, "", notice)
+}
+
+// renderSettings renders the settings page with the message of a rejected form
+// or a notice.
+func (s *Server) renderSettings(w http.ResponseWriter, r *http.Request, status int, formError, notice string) {
+	signedIn, err := s.sessionForRequest(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	user := signedIn.user
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	sessions, err := s.repository.ListSessions(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list sessions", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:        getCSRFToken(w, r),
+		IsLoggedIn:       true,
+		Username:         user.Username,
+		UserID:           user.ID,
+		Planners:         planners,
+		Sessions:         sessions,
+		CurrentSessionID: signedIn.session.ID,
+		FormError:        formError,
+		Notice:           notice,
+	}
+	w.WriteHeader(status)
+	if err := StaticResources.ExecuteTemplate(w, "settings.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// revokeSession signs out a device of the user.
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+	signedIn, err := s.sessionForRequest(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("sessionID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeleteSession(signedIn.user.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to delete session", err)
+		return
+	}
+	s.logger.Info().Msgf("revoked session %s of user %s", id, signedIn.user.ID)
+	if id == signedIn.session.ID {
+		clearBrowserSession(w, r)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+// signOutEverywhere signs the user out on every device.
+func (s *Server) signOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	if err = s.repository.DeleteSessions(user.ID); err != nil {
+		s.internalError(w, "unable to delete sessions", err)
+		return
+	}
+	s.logger.Info().Msgf("signed out user %s everywhere", user.ID)
+	clearBrowserSession(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestSignInLimiter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newSignInLimiter()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < limiter.maxUsernameFailures-1; i++ {
+		limiter.failed("alice", "10.0.0.1")
+	}
+	if !limiter.lockedUntil("alice", "10.0.0.1").IsZero() {
+		t.Fatal("locked before the limit")
+	}
+	limiter.failed("Alice", "10.0.0.2")
+	if until := limiter.lockedUntil("alice", "10.0.0.3"); !until.Equal(now.Add(limiter.lockout)) {
+		t.Fatalf("username locked until %s, want %s", until, now.Add(limiter.lockout))
+	}
+	if !limiter.lockedUntil("bob", "10.0.0.1").IsZero() {
+		t.Fatal("another user on the same IP address is locked")
+	}
+
+	now = now.Add(limiter.lockout + time.Second)
+	if !limiter.lockedUntil("alice", "10.0.0.1").IsZero() {
+		t.Fatal("still locked after the lockout")
+	}
+
+	// failures spread over more than the window do not add up
+	for i := 0; i < limiter.maxUsernameFailures-1; i++ {
+		limiter.failed("carol", "10.0.1.1")
+	}
+	now = now.Add(limiter.window + time.Second)
+	limiter.failed("carol", "10.0.1.1")
+	if !limiter.lockedUntil("carol", "10.0.1.1").IsZero() {
+		t.Fatal("failures of an earlier window locked the username")
+	}
+	limiter.succeeded("carol")
+	if _, ok := limiter.attempts[usernameKey("carol")]; ok {
+		t.Fatal("a successful sign in kept the failures")
+	}
+
+	// one IP address guessing the passwords of many users
+	for i := 0; i < limiter.maxIPFailures; i++ {
+		limiter.failed("user"+string(rune('a'+i)), "10.0.2.1")
+	}
+	if limiter.lockedUntil("dave", "10.0.2.1").IsZero() {
+		t.Fatal("the IP address is not locked")
+	}
+	if !limiter.lockedUntil("dave", "10.0.2.2").IsZero() {
+		t.Fatal("another IP address is locked")
+	}
+}
+
+// signIn signs the test user in on a new device.
+func signIn(t *testing.T, server *Server, password string) (*cookiejar.Jar, string) {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/", nil)
+	csrfToken := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("username", testUsername)
+	form.Set("password", password)
+	serve(t, server, jar, "POST", "/sign-in", form)
+	return jar, csrfToken
+}
+
+// sessionID returns the ID of the session of the device.
+func sessionID(t *testing.T, repository Repository, jar http.CookieJar) uuid.UUID {
+	t.Helper()
+	u, _ := url.Parse("http://localhost/")
+	for _, c := range jar.Cookies(u) {
+		if c.Name == sessionCookie {
+			session, _, err := repository.GetSession(hashToken(c.Value), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return session.ID
+		}
+	}
+	t.Fatal("no session cookie")
+	return uuid.Nil
+}
+
+func TestSessions(t *testing.T) {
+	server, repository := newTestServer(t)
+
+	// Two devices stay signed in at the same time
+	laptop, laptopCSRF := signIn(t, server, testPassword)
+	phone, _ := signIn(t, server, testPassword)
+	ensureCode(t, serve(t, server, laptop, "GET", "/planners", nil), http.StatusOK)
+	ensureCode(t, serve(t, server, phone, "GET", "/planners", nil), http.StatusOK)
+
+	recorder := serve(t, server, laptop, "GET", "/settings", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureInt(t, len(formsWithAction(parseForms(t, recorder.Body.String()), "/sign-out-everywhere")), 1)
+	ensureInt(t, strings.Count(recorder.Body.String(), `action="/settings/sessions/`), 2)
+	ensureInt(t, strings.Count(recorder.Body.String(), `data-badge-caption="this device"`), 1)
+
+	// Revoke the phone from the laptop
+	form := url.Values{}
+	form.Set("csrf-token", laptopCSRF)
+	recorder = serve(t, server, laptop, "POST", "/settings/sessions/"+sessionID(t, repository, phone).String()+"/delete", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/settings")
+	ensureRedirect(t, serve(t, server, phone, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+	ensureCode(t, serve(t, server, laptop, "GET", "/planners", nil), http.StatusOK)
+
+	// Sign out everywhere
+	phone, _ = signIn(t, server, testPassword)
+	recorder = serve(t, server, laptop, "POST", "/sign-out-everywhere", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+	ensureRedirect(t, serve(t, server, laptop, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+	ensureRedirect(t, serve(t, server, phone, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+
+	// An expired session is signed out
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := uuid.NewV4()
+	err = repository.AddSession(&Session{
+		ID:        id,
+		UserID:    user.ID,
+		TokenHash: hashToken("expired"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, _ := cookiejar.New(nil)
+	jar.SetCookies(&url.URL{Scheme: "http", Host: "localhost"}, []*http.Cookie{{Name: sessionCookie, Value: "expired"}})
+	ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+}
+
+func TestSignInLockout(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	for i := 0; i < server.signInLimiter.maxUsernameFailures; i++ {
+		jar, _ := signIn(t, server, "wrong")
+		ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+	}
+
+	// the right password is rejected while the username is locked
+	jar, csrfToken := signIn(t, server, testPassword)
+	ensureRedirect(t, serve(t, server, jar, "GET", "/planners", nil), http.StatusFound, "/?return-url=%2Fplanners")
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("username", testUsername)
+	form.Set("password", testPassword)
+	recorder := serve(t, server, jar, "POST", "/sign-in", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/?error=locked&return-url=%2F")
+	recorder = serve(t, server, jar, "GET", "/?error=locked", nil)
+	if !strings.Contains(recorder.Body.String(), "too many failed sign in attempts") {
+		t.Error("the sign in form does not show the lockout")
+	}
+
+	// an unknown username counts as a failure instead of an error
+	form.Set("username", "nobody@prototype.proto")
+	recorder = serve(t, server, jar, "POST", "/sign-in", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/?error=sign-in&return-url=%2F")
+}
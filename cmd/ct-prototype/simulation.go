@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Uncertainty describes how a transaction may differ from the plan. The zero value
+// is a transaction that happens exactly as planned.
+type Uncertainty struct {
+	// AmountStdDevPercent is the standard deviation of the amount in percent of it
+	AmountStdDevPercent float64
+	// SkipPercent is the chance in percent that the transaction does not happen
+	SkipPercent float64
+	// DateJitterDays moves the transaction up to this many days earlier or later
+	DateJitterDays int
+}
+
+// IsZero reports if the transaction is certain.
+func (u Uncertainty) IsZero() bool {
+	return u == Uncertainty{}
+}
+
+const (
+	defaultSimulationTrials = 200
+	maxSimulationTrials     = 5000
+	// maxSimulationBands caps the points of the bands, long horizons are sampled
+	maxSimulationBands = 400
+)
+
+// SimulationBand is the 10th, 50th and 90th percentile balance of the trials on a day.
+type SimulationBand struct {
+	Date time.Time `json:"date"`
+	P10  float64   `json:"p10"`
+	P50  float64   `json:"p50"`
+	P90  float64   `json:"p90"`
+}
+
+// SimulationResult is the outcome of a Monte Carlo simulation of a planner.
+type SimulationResult struct {
+	Trials int              `json:"trials"`
+	Bands  []SimulationBand `json:"bands"`
+	// ProbabilityBelowZero is the share of trials with a negative balance on any day
+	ProbabilityBelowZero float64 `json:"probability_below_zero"`
+}
+
+// BelowZeroPercent is ProbabilityBelowZero in percent.
+func (r *SimulationResult) BelowZeroPercent() float64 {
+	return math.Round(r.ProbabilityBelowZero*1000) / 10
+}
+
+// sample returns the day and signed amount of the transaction in one trial, ok is
+// false when the transaction is skipped.
+func (u Uncertainty) sample(rng *rand.Rand, etx *ExpandedTransaction) (day time.Time, amount float64, ok bool) {
+	if u.SkipPercent > 0 && rng.Float64()*100 < u.SkipPercent {
+		return time.Time{}, 0, false
+	}
+	day = truncateDay(etx.TransactionDate)
+	if u.DateJitterDays > 0 {
+		day = day.AddDate(0, 0, rng.Intn(2*u.DateJitterDays+1)-u.DateJitterDays)
+	}
+	amount = etx.Amount
+	if u.AmountStdDevPercent > 0 {
+		amount = math.Max(0, amount+rng.NormFloat64()*amount*u.AmountStdDevPercent/100)
+	}
+	if etx.IncomeOrExpense != "income" {
+		amount = -amount
+	}
+	return day, amount, true
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// Simulate runs the trials of the planner from its start balance over the days from
+// from to until. Transactions before from change the balance on the first day.
+func Simulate(startBalance float64, txns []ExpandedTransaction, from, until time.Time, trials int, rng *rand.Rand) *SimulationResult {
+	from, until = truncateDay(from), truncateDay(until)
+	result := &SimulationResult{Trials: trials}
+	if trials <= 0 || until.Before(from) {
+		return result
+	}
+	days := int(until.Sub(from).Hours()/24) + 1
+	step := (days + maxSimulationBands - 1) / maxSimulationBands
+
+	var sampledDays []int
+	for d := 0; d < days; d += step {
+		sampledDays = append(sampledDays, d)
+	}
+	if sampledDays[len(sampledDays)-1] != days-1 {
+		sampledDays = append(sampledDays, days-1)
+	}
+	balances := make([][]float64, len(sampledDays))
+	for i := range balances {
+		balances[i] = make([]float64, trials)
+	}
+
+	deltas := make([]float64, days)
+	belowZero := 0
+	for trial := 0; trial < trials; trial++ {
+		clear(deltas)
+		for i := range txns {
+			// a transfer between accounts leaves the total balance as it is
+			if txns[i].IncomeOrExpense == "transfer" {
+				continue
+			}
+			day, amount, ok := txns[i].Uncertainty.sample(rng, &txns[i])
+			if !ok || day.After(until) {
+				continue
+			}
+			deltas[max(int(day.Sub(from).Hours()/24), 0)] += amount
+		}
+		balance, negative, next := startBalance, false, 0
+		for d := 0; d < days; d++ {
+			balance += deltas[d]
+			negative = negative || balance < 0
+			if next < len(sampledDays) && sampledDays[next] == d {
+				balances[next][trial] = balance
+				next++
+			}
+		}
+		if negative {
+			belowZero++
+		}
+	}
+
+	for i, d := range sampledDays {
+		slices.Sort(balances[i])
+		result.Bands = append(result.Bands, SimulationBand{
+			Date: from.AddDate(0, 0, d),
+			P10:  percentile(balances[i], 10),
+			P50:  percentile(balances[i], 50),
+			P90:  percentile(balances[i], 90),
+		})
+	}
+	result.ProbabilityBelowZero = float64(belowZero) / float64(trials)
+	return result
+}
+
+// simulatePlanner simulates the transactions of the planner from the first one, or
+// today if it has none, to the end of the planner. The balance is the total of the
+// accounts.
+func simulatePlanner(planner *Planner, accounts []Account, txns []ExpandedTransaction, now time.Time, trials int, seed int64) *SimulationResult {
+	from := now
+	for i := range txns {
+		if txns[i].TransactionDate.Before(from) {
+			from = txns[i].TransactionDate
+		}
+	}
+	return Simulate(openingBalance(planner, accounts), txns, from, planner.End(now), trials, rand.New(rand.NewSource(seed)))
+}
+
+// simulationParams reads the trials and seed query parameters, a seed makes a run
+// repeatable.
+func simulationParams(query url.Values) (trials int, seed int64, err error) {
+	trials, seed = defaultSimulationTrials, 1
+	if v := query.Get("trials"); v != "" {
+		if trials, err = strconv.Atoi(v); err != nil || trials < 1 || trials > maxSimulationTrials {
+			return 0, 0, fmt.Errorf("trials must be between 1 and %d", maxSimulationTrials)
+		}
+	}
+	if v := query.Get("seed"); v != "" {
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, 0, errors.New("seed must be an integer")
+		}
+	}
+	return trials, seed, nil
+}
+
+// simulation returns the balance bands of the planner as JSON.
+func (s *Server) simulation(w http.ResponseWriter, r *http.Request) {
+	user, planner, ok := s.plannerForRequest(w, r)
+	if !ok {
+		return
+	}
+	trials, seed, err := simulationParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to fetch expanded txns", err)
+		return
+	}
+	accounts, err := s.repository.ListAccounts(user.ID, planner.ID)
+	if err != nil {
+		s.internalError(w, "unable to list accounts", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(simulatePlanner(planner, accounts, txns, time.Now(), trials, seed)); err != nil {
+		s.logger.Error().Err(err).Msg("unable to write the simulation")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestSimulateWithoutUncertainty(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	txns := []ExpandedTransaction{
+		{TransactionDate: from.AddDate(0, 0, 1), IncomeOrExpense: "expense", Amount: 300},
+		{TransactionDate: from.AddDate(0, 0, 3), IncomeOrExpense: "income", Amount: 100},
+	}
+	result := Simulate(250, txns, from, from.AddDate(0, 0, 4), 50, rand.New(rand.NewSource(1)))
+
+	wants := []float64{250, -50, -50, 50, 50}
+	if len(result.Bands) != len(wants) {
+		t.Fatalf("got %d bands, want %d", len(result.Bands), len(wants))
+	}
+	for i, band := range result.Bands {
+		if band.P10 != wants[i] || band.P50 != wants[i] || band.P90 != wants[i] {
+			t.Errorf("day %d: got %+v, want %v", i, band, wants[i])
+		}
+		if !band.Date.Equal(from.AddDate(0, 0, i)) {
+			t.Errorf("day %d: got date %s", i, band.Date)
+		}
+	}
+	if result.ProbabilityBelowZero != 1 {
+		t.Errorf("got probability %v, want 1", result.ProbabilityBelowZero)
+	}
+}
+
+func TestSimulateWithUncertainty(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	txns := []ExpandedTransaction{
+		{
+			TransactionDate: from.AddDate(0, 0, 5), IncomeOrExpense: "income", Amount: 1000,
+			Uncertainty: Uncertainty{SkipPercent: 50, DateJitterDays: 3},
+		},
+		{
+			TransactionDate: from.AddDate(0, 0, 10), IncomeOrExpense: "expense", Amount: 800,
+			Uncertainty: Uncertainty{AmountStdDevPercent: 20},
+		},
+	}
+	until := from.AddDate(2, 0, 0)
+	result := Simulate(500, txns, from, until, 1000, rand.New(rand.NewSource(7)))
+
+	if len(result.Bands) > maxSimulationBands+1 {
+		t.Errorf("got %d bands, want at most %d", len(result.Bands), maxSimulationBands+1)
+	}
+	if last := result.Bands[len(result.Bands)-1]; !last.Date.Equal(until) {
+		t.Errorf("last band is on %s, want %s", last.Date, until)
+	}
+	for _, band := range result.Bands {
+		if band.P10 > band.P50 || band.P50 > band.P90 {
+			t.Fatalf("unordered band %+v", band)
+		}
+	}
+	last := result.Bands[len(result.Bands)-1]
+	if last.P10 >= 0 || last.P90 <= 500 {
+		t.Errorf("band %+v does not cover a skipped and a received income", last)
+	}
+	// the income is skipped in about half of the trials and the balance then goes
+	// below zero in all but the rare trials with a much smaller expense
+	if p := result.ProbabilityBelowZero; p < 0.4 || p > 0.6 {
+		t.Errorf("got probability %v, want about 0.5", p)
+	}
+
+	again := Simulate(500, txns, from, until, 1000, rand.New(rand.NewSource(7)))
+	if again.ProbabilityBelowZero != result.ProbabilityBelowZero || again.Bands[100] != result.Bands[100] {
+		t.Error("the same seed gave a different result")
+	}
+}
+
+func TestSimulationEndpoint(t *testing.T) {
+	server, _ := newTestServer(t)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+
+	form := url.Values{}
+	form.Set("csrf-token", csrfToken)
+	form.Set("title", "Freelance")
+	form.Set("income_or_expense", "expense")
+	form.Set("transaction_date", time.Now().AddDate(0, 0, 3).Format(time.DateOnly))
+	form.Set("amount", "100")
+	form.Set("uncertainty_skip_percent", "50")
+	recorder := serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form)
+	ensureRedirect(t, recorder, http.StatusFound, plannerURL)
+
+	recorder = serve(t, server, jar, "GET", plannerURL+"/simulation?trials=400&seed=3", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, recorder.Result().Header.Get("Content-Type"), "application/json")
+	var result SimulationResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, result.Trials, 400)
+	if p := result.ProbabilityBelowZero; p < 0.4 || p > 0.6 {
+		t.Errorf("got probability %v, want about 0.5", p)
+	}
+	last := result.Bands[len(result.Bands)-1]
+	if last.P10 != -100 || last.P90 != 0 {
+		t.Errorf("unexpected last band %+v", last)
+	}
+
+	recorder = serve(t, server, jar, "GET", plannerURL+"/simulation?trials=0", nil)
+	ensureCode(t, recorder, http.StatusBadRequest)
+
+	recorder = serve(t, server, jar, "GET", plannerURL, nil)
+	ensureCode(t, recorder, http.StatusOK)
+	if !regexp.MustCompile(`Chance of going below zero: \d+(\.\d)?%`).MatchString(recorder.Body.String()) {
+		t.Error("chart is missing the chance of going below zero")
+	}
+}
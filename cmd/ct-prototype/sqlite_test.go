@@ -0,0 +1,186 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+)
+
+// firstPlanner and firstRangeTransaction are the tables the SQLite repository was
+// released with, before accounts, growth and scenarios.
+type firstPlanner struct {
+	ID            uuid.UUID `gorm:"primarykey"`
+	UserID        uuid.UUID `gorm:"index"`
+	Name          string
+	StartBalance  float64
+	HorizonMonths int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (firstPlanner) TableName() string { return "planners" }
+
+type firstRangeTransaction struct {
+	ID              uuid.UUID `gorm:"primarykey"`
+	PlannerID       uuid.UUID `gorm:"index"`
+	UserID          uuid.UUID
+	Title           string
+	IncomeOrExpense string
+	RecurrenceStart time.Time
+	RecurrenceEnd   time.Time
+	Recurrence      struct{ Freq string } `gorm:"embedded;embeddedPrefix:recurrence_"`
+	Amount          float64
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (firstRangeTransaction) TableName() string { return "range_transactions" }
+
+func openTestSQLiteFile(t *testing.T, path string) *GormDB {
+	t.Helper()
+	logger := zerolog.Nop()
+	repository, err := OpenSQLiteDB(path, &logger)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		if db, err := repository.db.DB(); err == nil {
+			db.Close()
+		}
+	})
+	return repository
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	if testPostgresDSN != "" {
+		t.Skip("migrates a sqlite database, the tests run on postgres")
+	}
+	path := filepath.Join(t.TempDir(), "ct-prototype.db")
+	first := openTestSQLiteFile(t, path)
+	if err := first.db.Migrator().DropTable(&Planner{}, &RangeTransaction{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.db.AutoMigrate(&firstPlanner{}, &firstRangeTransaction{}); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, _ := uuid.NewV4()
+	plannerID, _ := uuid.NewV4()
+	rangeID, _ := uuid.NewV4()
+	start := truncateDay(time.Now().AddDate(0, 0, 1))
+	rtx := firstRangeTransaction{
+		ID: rangeID, PlannerID: plannerID, UserID: userID, Title: "Rent", IncomeOrExpense: "expense",
+		RecurrenceStart: start, RecurrenceEnd: start.AddDate(0, 2, 0), Amount: 900,
+	}
+	rtx.Recurrence.Freq = FreqMonthly
+	err := first.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&User{ID: userID, Username: testUsername}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&firstPlanner{ID: plannerID, UserID: userID, Name: "Household"}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rtx).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := openTestSQLiteFile(t, path)
+	// opening the migrated database again changes nothing
+	openTestSQLiteFile(t, path)
+
+	planner, err := repository.GetPlanner(userID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureString(t, planner.Name, "Household")
+	if planner.IsScenario() {
+		t.Fatal("a planner of the first schema is a scenario")
+	}
+	rangeTxns, err := repository.ListRangeTransactions(userID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(rangeTxns), 1)
+	ensureString(t, rangeTxns[0].Title, "Rent")
+	ensureString(t, rangeTxns[0].AccountID.String(), uuid.Nil.String())
+
+	updated := rangeTxns[0]
+	updated.Amount = 1000
+	updated.RecurrenceEnd = start.AddDate(0, 3, 0)
+	if err = repository.UpdateRangeTransaction(updated.ID, &updated); err != nil {
+		t.Fatal(err)
+	}
+	txns, err := repository.ListExpandedTransactions(userID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureInt(t, len(txns), 4)
+	ensureFloat(t, txns[0].Amount, 1000)
+}
+
+func TestSQLiteConnections(t *testing.T) {
+	if testPostgresDSN != "" {
+		t.Skip("opens sqlite databases, the tests run on postgres")
+	}
+	for path, want := range map[string]int{
+		":memory:": 1,
+		filepath.Join(t.TempDir(), "ct-prototype.db"): 0,
+	} {
+		db, err := openTestSQLiteFile(t, path).db.DB()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := db.Stats().MaxOpenConnections; got != want {
+			t.Errorf("%s allows %d open connections, want %d", path, got, want)
+		}
+	}
+}
+
+func TestSQLiteTimesInUTC(t *testing.T) {
+	if testPostgresDSN != "" {
+		t.Skip("reads the times sqlite stores, the tests run on postgres")
+	}
+	repository := openTestSQLiteFile(t, ":memory:")
+	userID, _ := uuid.NewV4()
+	if err := repository.AddUser(userID, testUsername, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// the session expires at 05:00 UTC, written in a zone ahead of UTC
+	expiresAt := time.Date(2024, 11, 3, 10, 0, 0, 0, time.FixedZone("east", 5*60*60))
+	sessionID, _ := uuid.NewV4()
+	err := repository.AddSession(&Session{
+		ID: sessionID, UserID: userID, TokenHash: "token", ExpiresAt: expiresAt, LastSeenAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err = repository.db.Raw(`SELECT expires_at || '' FROM sessions`).Scan(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, "2024-11-03 05:00:00.000000000") {
+		t.Errorf("stored expiry %q is not in UTC", stored)
+	}
+
+	west := time.FixedZone("west", -8*60*60)
+	// 01:00 in the west is 09:00 UTC, after the expiry even though its local text sorts first
+	if _, _, err = repository.GetSession("token", time.Date(2024, 11, 3, 1, 0, 0, 0, west)); err == nil {
+		t.Error("an expired session was found")
+	}
+	// 20:00 the day before in the west is 04:00 UTC, before the expiry
+	session, _, err := repository.GetSession("token", time.Date(2024, 11, 2, 20, 0, 0, 0, west))
+	if err != nil {
+		t.Fatalf("the session was not found before it expired: %v", err)
+	}
+	if !session.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("got expiry %v, want %v", session.ExpiresAt, expiresAt)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+)
+
+// StatementLine is a transaction read from a bank, card or brokerage statement.
+// Amount is negative for money leaving the account.
+type StatementLine struct {
+	Date   time.Time
+	Payee  string
+	Amount float64
+	// ExternalID is the id the bank gave the transaction, e.g. the OFX FITID
+	ExternalID string
+}
+
+// CSVMapping names the statement columns by header or 1-based column number.
+type CSVMapping struct {
+	Date        string
+	Description string
+	// Amount is a signed amount column. Debit and Credit are used when it is empty.
+	Amount     string
+	Debit      string
+	Credit     string
+	DateFormat string
+	// Negate flips the sign of the amounts of statements that list charges as positive
+	Negate bool
+}
+
+const (
+	defaultCSVDateColumn        = "Date"
+	defaultCSVDescriptionColumn = "Description"
+	defaultCSVAmountColumn      = "Amount"
+)
+
+var errNotOFX = errors.New("not an OFX or QFX file")
+
+func (m CSVMapping) withDefaults() CSVMapping {
+	if m.Date == "" {
+		m.Date = defaultCSVDateColumn
+	}
+	if m.Description == "" {
+		m.Description = defaultCSVDescriptionColumn
+	}
+	if m.Amount == "" && m.Debit == "" && m.Credit == "" {
+		m.Amount = defaultCSVAmountColumn
+	}
+	if m.DateFormat == "" {
+		m.DateFormat = time.DateOnly
+	}
+	return m
+}
+
+// column returns the index of the named or numbered column in the header, -1
+// when name is empty.
+func column(header []string, name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(header) {
+			return 0, fmt.Errorf("column %d is not in the statement", n)
+		}
+		return n - 1, nil
+	}
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q is not in the statement header", name)
+}
+
+// parseStatementAmount reads amounts like "$1,234.50", "-12" and "(12.00)".
+func parseStatementAmount(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -math.Abs(amount)
+	}
+	return amount, nil
+}
+
+// ParseCSVStatement reads the statement lines of a CSV file with a header row.
+func ParseCSVStatement(r io.Reader, m CSVMapping) ([]StatementLine, error) {
+	m = m.withDefaults()
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the statement header: %w", err)
+	}
+	dateCol, err := column(header, m.Date)
+	if err != nil {
+		return nil, err
+	}
+	descriptionCol, err := column(header, m.Description)
+	if err != nil {
+		return nil, err
+	}
+	amountCol, err := column(header, m.Amount)
+	if err != nil {
+		return nil, err
+	}
+	debitCol, err := column(header, m.Debit)
+	if err != nil {
+		return nil, err
+	}
+	creditCol, err := column(header, m.Credit)
+	if err != nil {
+		return nil, err
+	}
+	field := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var lines []StatementLine
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		date, err := time.Parse(m.DateFormat, field(record, dateCol))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q for format %s", line, field(record, dateCol), m.DateFormat)
+		}
+		var amount float64
+		if amountCol >= 0 {
+			if amount, err = parseStatementAmount(field(record, amountCol)); err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount %q", line, field(record, amountCol))
+			}
+		} else {
+			debit, err := parseStatementAmount(field(record, debitCol))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid debit %q", line, field(record, debitCol))
+			}
+			credit, err := parseStatementAmount(field(record, creditCol))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid credit %q", line, field(record, creditCol))
+			}
+			amount = math.Abs(credit) - math.Abs(debit)
+		}
+		if m.Negate {
+			amount = -amount
+		}
+		lines = append(lines, StatementLine{
+			Date:   truncateDay(date),
+			Payee:  field(record, descriptionCol),
+			Amount: amount,
+		})
+	}
+	return lines, nil
+}
+
+// ofxTag matches the tags of both SGML (OFX 1.x, QFX) and XML (OFX 2.x) files.
+var ofxTag = regexp.MustCompile(`<(/?)([A-Za-z0-9.]+)>([^<]*)`)
+
+// ParseOFXStatement reads the STMTTRN transactions of an OFX or QFX file.
+func ParseOFXStatement(r io.Reader) ([]StatementLine, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+	if start < 0 {
+		return nil, errNotOFX
+	}
+
+	var lines []StatementLine
+	var fields map[string]string
+	for _, m := range ofxTag.FindAllSubmatch(data[start:], -1) {
+		closing, tag, value := len(m[1]) > 0, strings.ToUpper(string(m[2])), strings.TrimSpace(string(m[3]))
+		switch {
+		case tag == "STMTTRN" && !closing:
+			fields = map[string]string{}
+		case tag == "STMTTRN" && closing:
+			line, err := ofxStatementLine(fields)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, line)
+			fields = nil
+		case fields != nil && !closing && value != "":
+			fields[tag] = value
+		}
+	}
+	return lines, nil
+}
+
+func ofxStatementLine(fields map[string]string) (StatementLine, error) {
+	posted := fields["DTPOSTED"]
+	if len(posted) < 8 {
+		return StatementLine{}, fmt.Errorf("transaction %s has an invalid DTPOSTED %q", fields["FITID"], posted)
+	}
+	date, err := time.Parse("20060102", posted[:8])
+	if err != nil {
+		return StatementLine{}, fmt.Errorf("transaction %s has an invalid DTPOSTED %q", fields["FITID"], posted)
+	}
+	amount, err := parseStatementAmount(fields["TRNAMT"])
+	if err != nil {
+		return StatementLine{}, fmt.Errorf("transaction %s has an invalid TRNAMT %q", fields["FITID"], fields["TRNAMT"])
+	}
+	payee := fields["NAME"]
+	if payee == "" {
+		payee = fields["MEMO"]
+	}
+	return StatementLine{
+		Date:       date,
+		Payee:      payee,
+		Amount:     amount,
+		ExternalID: fields["FITID"],
+	}, nil
+}
+
+// isOFX reports if the file is an OFX or QFX statement by its name or content.
+func isOFX(filename string, data []byte) bool {
+	name := strings.ToLower(filename)
+	if strings.HasSuffix(name, ".ofx") || strings.HasSuffix(name, ".qfx") {
+		return true
+	}
+	head := bytes.ToUpper(data[:min(len(data), 1024)])
+	return bytes.Contains(head, []byte("OFXHEADER")) || bytes.Contains(head, []byte("<OFX>"))
+}
+
+// importIDs returns the ids that identify the lines when the statement is imported
+// again. Lines without a bank id are identified by their content and the number
+// of identical lines before them.
+func importIDs(lines []StatementLine) []string {
+	ids := make([]string, len(lines))
+	seen := map[string]int{}
+	for i, l := range lines {
+		if l.ExternalID != "" {
+			ids[i] = "fitid:" + l.ExternalID
+			continue
+		}
+		key := fmt.Sprintf("%s|%.2f|%s", l.Date.Format(time.DateOnly), l.Amount, strings.ToLower(l.Payee))
+		sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d", key, seen[key])))
+		seen[key]++
+		ids[i] = "sha1:" + hex.EncodeToString(sum[:])
+	}
+	return ids
+}
+
+// statementTransactions returns the one-time transactions of the statement lines.
+func statementTransactions(lines []StatementLine, userID, plannerID uuid.UUID, source string) []ExpandedTransaction {
+	ids := importIDs(lines)
+	txns := make([]ExpandedTransaction, 0, len(lines))
+	for i, l := range lines {
+		id, _ := uuid.NewV4()
+		incomeOrExpense := "expense"
+		if l.Amount > 0 {
+			incomeOrExpense = "income"
+		}
+		txns = append(txns, ExpandedTransaction{
+			ID:              id,
+			UserID:          userID,
+			PlannerID:       plannerID,
+			Title:           l.Payee,
+			TransactionDate: l.Date,
+			OccurrenceDate:  l.Date,
+			IncomeOrExpense: incomeOrExpense,
+			Amount:          math.Abs(l.Amount),
+			Source:          source,
+			ImportID:        ids[i],
+		})
+	}
+	return txns
+}
+
+const (
+	// minRecurringLines is the number of payments needed to suggest a recurrence
+	minRecurringLines = 3
+	// recurringAmountTolerance is how far an amount may be from the median amount
+	recurringAmountTolerance = 0.15
+)
+
+// normalizePayee drops the reference numbers and punctuation banks add to payee
+// names, so "NETFLIX.COM 8374" and "Netflix.com 1121" are the same payee.
+func normalizePayee(payee string) string {
+	words := strings.FieldsFunc(strings.ToLower(payee), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return strings.Join(words, " ")
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// recurrenceForGaps returns the recurrence of payments with the given days between
+// them. ok is false when the gaps are not regular.
+func recurrenceForGaps(gaps []float64, last time.Time) (r Recurrence, everyDays int, ok bool) {
+	within := func(lo, hi float64) bool {
+		return !slices.ContainsFunc(gaps, func(g float64) bool { return g < lo || g > hi })
+	}
+	m := median(gaps)
+	switch {
+	case within(6, 8):
+		return Recurrence{Freq: FreqWeekly, Interval: 1, ByWeekday: strings.ToUpper(last.Weekday().String()[:2])}, 7, true
+	case within(13, 15):
+		return Recurrence{Freq: FreqWeekly, Interval: 2, ByWeekday: strings.ToUpper(last.Weekday().String()[:2])}, 14, true
+	case within(27, 32):
+		return Recurrence{Freq: FreqMonthly, Interval: 1, ByMonthDay: last.Day()}, 30, true
+	case within(88, 94):
+		return Recurrence{Freq: FreqMonthly, Interval: 3, ByMonthDay: last.Day()}, 91, true
+	case within(360, 370):
+		return Recurrence{Freq: FreqYearly, Interval: 1}, 365, true
+	}
+	tolerance := math.Max(2, 0.15*m)
+	if m >= 1 && within(m-tolerance, m+tolerance) {
+		return Recurrence{Freq: FreqDaily}, int(math.Round(m)), true
+	}
+	return Recurrence{}, 0, false
+}
+
+// DetectRecurring suggests range transactions for payees paid at regular intervals
+// with similar amounts. The suggestions start at the next payment from now and end
+// at until.
+func DetectRecurring(lines []StatementLine, now, until time.Time) []RangeTransaction {
+	type group struct {
+		lines []StatementLine
+	}
+	groups := map[string]*group{}
+	var keys []string
+	for _, l := range lines {
+		payee := normalizePayee(l.Payee)
+		if payee == "" || l.Amount == 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s|%t", payee, l.Amount > 0)
+		if groups[key] == nil {
+			groups[key] = &group{}
+			keys = append(keys, key)
+		}
+		groups[key].lines = append(groups[key].lines, l)
+	}
+
+	today := truncateDay(now)
+	var suggestions []RangeTransaction
+	for _, key := range keys {
+		g := groups[key].lines
+		if len(g) < minRecurringLines {
+			continue
+		}
+		sort.SliceStable(g, func(i, j int) bool { return g[i].Date.Before(g[j].Date) })
+
+		amounts := make([]float64, len(g))
+		for i, l := range g {
+			amounts[i] = math.Abs(l.Amount)
+		}
+		amount := median(amounts)
+		if slices.ContainsFunc(amounts, func(a float64) bool {
+			return math.Abs(a-amount) > recurringAmountTolerance*amount
+		}) {
+			continue
+		}
+
+		var gaps []float64
+		for i := 1; i < len(g); i++ {
+			gaps = append(gaps, g[i].Date.Sub(g[i-1].Date).Hours()/24)
+		}
+		last := g[len(g)-1]
+		recurrence, everyDays, ok := recurrenceForGaps(gaps, last.Date)
+		if !ok {
+			continue
+		}
+
+		incomeOrExpense := "expense"
+		if last.Amount > 0 {
+			incomeOrExpense = "income"
+		}
+		rt := RangeTransaction{
+			Title:               last.Payee,
+			IncomeOrExpense:     incomeOrExpense,
+			RecurrenceEveryDays: everyDays,
+			RecurrenceStart:     last.Date,
+			RecurrenceEnd:       until,
+			Recurrence:          recurrence,
+			Amount:              math.Round(amount*100) / 100,
+		}
+		next := slices.IndexFunc(rt.Occurrences(), func(d time.Time) bool {
+			return d.After(last.Date) && !d.Before(today)
+		})
+		if next < 0 {
+			continue
+		}
+		rt.RecurrenceStart = rt.Occurrences()[next]
+		suggestions = append(suggestions, rt)
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Amount > suggestions[j].Amount
+	})
+	return suggestions
+}
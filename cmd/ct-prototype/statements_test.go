@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestParseCSVStatement(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		mapping CSVMapping
+		wants   []StatementLine
+	}{
+		{
+			name: "signed amount with default columns",
+			csv: "Date,Description,Amount\n" +
+				"2024-03-01,ACME PAYROLL,\"$2,500.00\"\n" +
+				"\n" +
+				"2024-03-02,Coffee,(4.50)\n",
+			wants: []StatementLine{
+				{Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Payee: "ACME PAYROLL", Amount: 2500},
+				{Date: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Payee: "Coffee", Amount: -4.5},
+			},
+		},
+		{
+			name: "debit and credit columns by number",
+			csv: "Posted,Memo,Out,In\n" +
+				"03/05/2024,Rent,1200,\n" +
+				"03/06/2024,Refund,,25.10\n",
+			mapping: CSVMapping{Date: "1", Description: "2", Debit: "3", Credit: "4", DateFormat: "01/02/2006"},
+			wants: []StatementLine{
+				{Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), Payee: "Rent", Amount: -1200},
+				{Date: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), Payee: "Refund", Amount: 25.1},
+			},
+		},
+		{
+			name:    "card statement with positive charges",
+			csv:     "date,payee,charge\n2024-03-07,Grocer,80.25\n",
+			mapping: CSVMapping{Description: "payee", Amount: "charge", Negate: true},
+			wants: []StatementLine{
+				{Date: time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC), Payee: "Grocer", Amount: -80.25},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCSVStatement(strings.NewReader(tt.csv), tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.wants) {
+				t.Fatalf("got %d lines, want %d: %+v", len(got), len(tt.wants), got)
+			}
+			for i := range got {
+				if !got[i].Date.Equal(tt.wants[i].Date) || got[i].Payee != tt.wants[i].Payee || got[i].Amount != tt.wants[i].Amount {
+					t.Errorf("line %d: got %+v, want %+v", i, got[i], tt.wants[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSVStatementErrors(t *testing.T) {
+	for name, csv := range map[string]string{
+		"missing column": "When,Description,Amount\n2024-03-01,x,1\n",
+		"bad date":       "Date,Description,Amount\n01/03/2024,x,1\n",
+		"bad amount":     "Date,Description,Amount\n2024-03-01,x,abc\n",
+	} {
+		if _, err := ParseCSVStatement(strings.NewReader(csv), CSVMapping{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+const testSGMLStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS><BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20240301120000[-5:EST]
+<TRNAMT>-15.99
+<FITID>2024030101
+<NAME>NETFLIX.COM
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20240302
+<TRNAMT>2500.00
+<FITID>2024030201
+<MEMO>ACME PAYROLL
+</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const testXMLStatement = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS><BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20240305</DTPOSTED><TRNAMT>-42.10</TRNAMT><FITID>A1</FITID><NAME>Grocer</NAME></STMTTRN>
+</BANKTRANLIST></CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>
+`
+
+func TestParseOFXStatement(t *testing.T) {
+	lines, err := ParseOFXStatement(strings.NewReader(testSGMLStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if lines[0].Payee != "NETFLIX.COM" || lines[0].Amount != -15.99 || lines[0].ExternalID != "2024030101" ||
+		!lines[0].Date.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first line %+v", lines[0])
+	}
+	if lines[1].Payee != "ACME PAYROLL" || lines[1].Amount != 2500 {
+		t.Errorf("unexpected second line %+v", lines[1])
+	}
+
+	lines, err = ParseOFXStatement(strings.NewReader(testXMLStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0].Payee != "Grocer" || lines[0].Amount != -42.10 || lines[0].ExternalID != "A1" {
+		t.Errorf("unexpected lines %+v", lines)
+	}
+
+	if _, err = ParseOFXStatement(strings.NewReader("Date,Amount\n")); err == nil {
+		t.Error("expected an error for a CSV file")
+	}
+	if !isOFX("statement.qfx", nil) || !isOFX("download", []byte(testSGMLStatement)) || isOFX("x.csv", []byte("Date,Amount")) {
+		t.Error("isOFX detected the wrong format")
+	}
+}
+
+func TestImportIDs(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	coffee := StatementLine{Date: day, Payee: "Coffee", Amount: -4.5}
+	ids := importIDs([]StatementLine{coffee, coffee, {Date: day, Payee: "x", Amount: 1, ExternalID: "F1"}})
+	if ids[0] == ids[1] {
+		t.Error("two identical purchases on the same day got the same id")
+	}
+	if ids[2] != "fitid:F1" {
+		t.Errorf("got id %s for a line with a FITID", ids[2])
+	}
+	again := importIDs([]StatementLine{coffee, coffee})
+	if again[0] != ids[0] || again[1] != ids[1] {
+		t.Error("the ids changed when the statement was imported again")
+	}
+}
+
+func TestAddImportedTransactionsSkipsDuplicates(t *testing.T) {
+	_, repository := newTestServer(t)
+	user, err := repository.GetUser(testUsername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plannerID, _ := uuid.NewV4()
+	if err = repository.AddPlanner(&Planner{ID: plannerID, UserID: user.ID, Name: "import", Currency: "USD"}); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := ParseOFXStatement(strings.NewReader(testSGMLStatement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{2, 0} {
+		added, err := repository.AddImportedTransactions(user.ID, plannerID, statementTransactions(lines, user.ID, plannerID, "bank"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want {
+			t.Errorf("import %d: added %d, want %d", i+1, added, want)
+		}
+	}
+	txns, err := repository.ListExpandedTransactions(user.ID, plannerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 2 || txns[0].Source != "bank" || txns[0].ImportID == "" {
+		t.Errorf("unexpected transactions %+v", txns)
+	}
+}
+
+func TestDetectRecurring(t *testing.T) {
+	now := time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)
+	var lines []StatementLine
+	for m := 0; m < 5; m++ {
+		lines = append(lines,
+			StatementLine{Date: time.Date(2024, time.Month(1+m), 15, 0, 0, 0, 0, time.UTC), Payee: "NETFLIX.COM " + string(rune('0'+m)), Amount: -15.99},
+			StatementLine{Date: time.Date(2024, time.Month(1+m), 3+m, 0, 0, 0, 0, time.UTC), Payee: "Grocer", Amount: -float64(20 + 40*m)},
+		)
+	}
+	for d := 0; d < 4; d++ {
+		lines = append(lines, StatementLine{Date: time.Date(2024, 5, 3+14*d, 0, 0, 0, 0, time.UTC), Payee: "ACME PAYROLL", Amount: 2000 + float64(d)})
+	}
+
+	suggestions := DetectRecurring(lines, now, now.AddDate(1, 0, 0))
+	if len(suggestions) != 2 {
+		t.Fatalf("got %d suggestions, want 2: %+v", len(suggestions), suggestions)
+	}
+
+	payroll := suggestions[0]
+	if payroll.IncomeOrExpense != "income" || payroll.Recurrence.Freq != FreqWeekly || payroll.Recurrence.Interval != 2 ||
+		payroll.Recurrence.ByWeekday != "FR" || payroll.Amount != 2001.5 {
+		t.Errorf("unexpected payroll suggestion %+v", payroll)
+	}
+	if want := time.Date(2024, 6, 28, 0, 0, 0, 0, time.UTC); !payroll.RecurrenceStart.Equal(want) {
+		t.Errorf("payroll starts %s, want %s", payroll.RecurrenceStart, want)
+	}
+
+	netflix := suggestions[1]
+	if netflix.IncomeOrExpense != "expense" || netflix.Recurrence.Freq != FreqMonthly || netflix.Recurrence.ByMonthDay != 15 || netflix.Amount != 15.99 {
+		t.Errorf("unexpected netflix suggestion %+v", netflix)
+	}
+	if want := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC); !netflix.RecurrenceStart.Equal(want) {
+		t.Errorf("netflix starts %s, want %s", netflix.RecurrenceStart, want)
+	}
+}
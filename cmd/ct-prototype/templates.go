@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"encoding/json"
 	"text/template"
 	"time"
 
@@ -16,12 +17,38 @@ var FuncMap = template.FuncMap{
 	"dayDate": func(t time.Time) string {
 		return t.Format("02 Jan 2006")
 	},
+	"inputDate": func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	},
 	"unixTs": func(t time.Time) int64 {
 		return t.Unix()
 	},
 	"uuidStr": func(u uuid.UUID) string {
 		return u.String()
 	},
+	// jsString quotes the string for a script, < and > are escaped so it cannot
+	// close the script element
+	"jsString": func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	},
+	"currencySymbol": currencySymbol,
+}
+
+// currencySymbol returns the symbol written before an amount in the currency.
+func currencySymbol(code string) string {
+	if symbol, ok := currencySymbols[code]; ok {
+		return symbol
+	}
+	return code + " "
+}
+
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"INR": "₹",
+	"JPY": "¥",
 }
 
 var (
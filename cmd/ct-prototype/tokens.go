@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gofrs/uuid"
+)
+
+// apiTokenPrefix makes the API tokens easy to find by secret scanners
+const apiTokenPrefix = "ctp_"
+
+// newAPIToken returns a new token and the hash that is stored for it.
+func newAPIToken() (token, hash string) {
+	token = apiTokenPrefix + generateSecureToken(32)
+	return token, hashToken(token)
+}
+
+// hashToken returns the hash stored for an API token or a session cookie.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// renderAPITokensPage renders the API tokens and the calendar feed of the user
+// with a token or feed URL that was just created.
+func (s *Server) renderAPITokensPage(w http.ResponseWriter, r *http.Request, user *User, newToken, newCalendarFeedURL string) {
+	planners, err := s.repository.ListPlanners(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list planners", err)
+		return
+	}
+	tokens, err := s.repository.ListAPITokens(user.ID)
+	if err != nil {
+		s.internalError(w, "unable to list api tokens", err)
+		return
+	}
+	data := HomePageState{
+		CSRFToken:   getCSRFToken(w, r),
+		IsLoggedIn:  true,
+		Username:    user.Username,
+		UserID:      user.ID,
+		Planners:    planners,
+		APITokens:   tokens,
+		NewAPIToken: newToken,
+
+		HasCalendarFeed:    user.CalendarTokenHash != "",
+		NewCalendarFeedURL: newCalendarFeedURL,
+	}
+	if err := StaticResources.ExecuteTemplate(w, "api_tokens.html", data); err != nil {
+		s.internalError(w, "unable to render template", err)
+	}
+}
+
+// apiTokensPage lists the API tokens of the user.
+func (s *Server) apiTokensPage(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	s.renderAPITokensPage(w, r, user, "", "")
+}
+
+// createAPIToken adds a token and shows it once.
+func (s *Server) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	type Form struct {
+		Name string `form:"name" json:"name" validate:"required,min=1,max=255"`
+	}
+	var form Form
+	if err := s.decodeForm(r, &form); err != nil {
+		s.internalError(w, "unable to validate POST form", err)
+		return
+	}
+
+	token, hash := newAPIToken()
+	id, _ := uuid.NewV4()
+	err = s.repository.AddAPIToken(&APIToken{
+		ID:        id,
+		UserID:    user.ID,
+		Name:      form.Name,
+		TokenHash: hash,
+	})
+	if err != nil {
+		s.internalError(w, "unable to add api token", err)
+		return
+	}
+	s.logger.Info().Msgf("added api token %s for user %s", id, user.ID)
+	s.renderAPITokensPage(w, r, user, token, "")
+}
+
+func (s *Server) deleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		s.internalError(w, "unable to get user login", err)
+		return
+	}
+	id, err := uuid.FromString(r.PathValue("tokenID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.repository.DeleteAPIToken(user.ID, id)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.internalError(w, "unable to delete api token", err)
+		return
+	}
+	s.logger.Info().Msgf("deleted api token %s", id)
+	http.Redirect(w, r, "/settings/api-tokens", http.StatusFound)
+}
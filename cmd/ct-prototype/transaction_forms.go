@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid"
+)
+
+// The add and update handlers of the HTML forms and the JSON API decode the same
+// forms so all of them apply the same rules. The json names match the form names.
+
+// formError is a value that passed the validator but breaks a rule of the form.
+type formError struct {
+	Field   string
+	Message string
+}
+
+func (e *formError) Error() string {
+	return e.Message
+}
+
+var errStartInPast = &formError{"recurrence_start", "cannot have a future item starting in the past. focus on the future"}
+
+// Date is a day written as 2006-01-02 in forms and JSON.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Format(time.DateOnly))
+}
+
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	d.Time = t
+	return err
+}
+
+type rangeTransactionForm struct {
+	Title           string  `form:"title" json:"title" validate:"required,min=0,max=255"`
+	IncomeOrExpense string  `form:"income_or_expense" json:"income_or_expense" validate:"required,lowercase,min=0,max=255"`
+	Category        string  `form:"category" json:"category" validate:"min=0,max=255"`
+	Notes           string  `form:"notes" json:"notes" validate:"min=0,max=255"`
+	Amount          float64 `form:"amount" json:"amount" validate:"required,gt=0"`
+
+	RecurrenceEveryDays int  `form:"recurrence_every" json:"recurrence_every" validate:"gte=0"`
+	RecurrenceStart     Date `form:"recurrence_start" json:"recurrence_start"`
+	RecurrenceEnd       Date `form:"recurrence_end" json:"recurrence_end"`
+
+	RecurrenceFreq            string `form:"recurrence_freq" json:"recurrence_freq" validate:"omitempty,oneof=daily weekly monthly yearly"`
+	RecurrenceInterval        int    `form:"recurrence_interval" json:"recurrence_interval" validate:"gte=0,lte=120"`
+	RecurrenceByWeekday       string `form:"recurrence_by_weekday" json:"recurrence_by_weekday" validate:"omitempty,oneof=MO TU WE TH FR SA SU"`
+	RecurrenceByMonthDay      int    `form:"recurrence_by_month_day" json:"recurrence_by_month_day" validate:"gte=-1,lte=31"`
+	RecurrenceLastBusinessDay bool   `form:"recurrence_last_business_day" json:"recurrence_last_business_day"`
+	RecurrenceExceptionDates  string `form:"recurrence_exception_dates" json:"recurrence_exception_dates" validate:"max=4096"`
+
+	// GrowthAnnualPercent is the raise or inflation of the amount per year
+	GrowthAnnualPercent float64 `form:"growth_annual_percent" json:"growth_annual_percent" validate:"gte=-50,lte=100"`
+	GrowthMode          string  `form:"growth_mode" json:"growth_mode" validate:"omitempty,oneof=raise inflation"`
+
+	accountsForm
+	uncertaintyForm
+
+	// storedStart is the start of the series being updated, an ongoing series
+	// keeps its start in the past.
+	storedStart time.Time
+}
+
+// accountsForm holds the accounts of both transaction forms, a transfer moves the
+// amount from AccountID to ToAccountID. uuid.Nil is the main account.
+type accountsForm struct {
+	AccountID   uuid.UUID `form:"account_id" json:"account_id"`
+	ToAccountID uuid.UUID `form:"to_account_id" json:"to_account_id"`
+}
+
+// check returns why the accounts do not fit the accounts of the planner. The
+// account to transfer to is cleared when the transaction is not a transfer.
+func (f *accountsForm) check(incomeOrExpense string, accounts []Account) error {
+	known := map[uuid.UUID]bool{uuid.Nil: true}
+	for i := range accounts {
+		known[accounts[i].ID] = true
+	}
+	if incomeOrExpense != "transfer" {
+		f.ToAccountID = uuid.Nil
+	}
+	if !known[f.AccountID] {
+		return &formError{"account_id", "the account is not in the planner"}
+	}
+	if !known[f.ToAccountID] {
+		return &formError{"to_account_id", "the account is not in the planner"}
+	}
+	if incomeOrExpense == "transfer" && f.AccountID == f.ToAccountID {
+		return &formError{"to_account_id", "a transfer moves money to another account"}
+	}
+	return nil
+}
+
+// uncertaintyForm holds the fields of Uncertainty shared by both transaction forms.
+type uncertaintyForm struct {
+	AmountStdDevPercent float64 `form:"uncertainty_amount_std_dev_percent" json:"uncertainty_amount_std_dev_percent" validate:"gte=0,lte=100"`
+	SkipPercent         float64 `form:"uncertainty_skip_percent" json:"uncertainty_skip_percent" validate:"gte=0,lte=100"`
+	DateJitterDays      int     `form:"uncertainty_date_jitter_days" json:"uncertainty_date_jitter_days" validate:"gte=0,lte=90"`
+}
+
+func (f *uncertaintyForm) uncertainty() Uncertainty {
+	return Uncertainty{
+		AmountStdDevPercent: f.AmountStdDevPercent,
+		SkipPercent:         f.SkipPercent,
+		DateJitterDays:      f.DateJitterDays,
+	}
+}
+
+func (f *rangeTransactionForm) recurrence() Recurrence {
+	return Recurrence{
+		Freq:            f.RecurrenceFreq,
+		Interval:        f.RecurrenceInterval,
+		ByWeekday:       f.RecurrenceByWeekday,
+		ByMonthDay:      f.RecurrenceByMonthDay,
+		LastBusinessDay: f.RecurrenceLastBusinessDay,
+		ExceptionDates:  f.RecurrenceExceptionDates,
+	}
+}
+
+// rangeTransaction returns the range transaction with the values of the form.
+func (f *rangeTransactionForm) rangeTransaction() *RangeTransaction {
+	return &RangeTransaction{
+		Title:               f.Title,
+		IncomeOrExpense:     f.IncomeOrExpense,
+		AccountID:           f.AccountID,
+		ToAccountID:         f.ToAccountID,
+		Category:            f.Category,
+		Notes:               f.Notes,
+		RecurrenceEveryDays: f.RecurrenceEveryDays,
+		RecurrenceStart:     f.RecurrenceStart.Time,
+		RecurrenceEnd:       f.RecurrenceEnd.Time,
+		Recurrence:          f.recurrence(),
+		Amount:              f.Amount,
+		Growth:              Growth{AnnualPercent: f.GrowthAnnualPercent, Mode: f.GrowthMode},
+		Uncertainty:         f.uncertainty(),
+	}
+}
+
+// check applies the rules across the fields of a validated form.
+func (f *rangeTransactionForm) check() error {
+	keepsStart := !f.storedStart.IsZero() && truncateDay(f.storedStart).Equal(truncateDay(f.RecurrenceStart.Time))
+	if !keepsStart && time.Now().AddDate(0, 0, -1).After(f.RecurrenceStart.Time) {
+		return errStartInPast
+	}
+	return f.checkRecurrence()
+}
+
+// checkRecurrence applies the rules of check that hold for a series that started
+// in the past, like one restored from a backup.
+func (f *rangeTransactionForm) checkRecurrence() error {
+	if f.RecurrenceEnd.Before(f.RecurrenceStart.Time) {
+		return &formError{"recurrence_end", "recurrence cannot end before it starts"}
+	}
+	if f.RecurrenceFreq == "" {
+		f.RecurrenceFreq = FreqDaily
+	}
+	if f.RecurrenceFreq == FreqDaily && f.RecurrenceEveryDays < 1 {
+		return &formError{"recurrence_every", "a daily recurrence needs the number of days between occurrences"}
+	}
+	if f.RecurrenceLastBusinessDay && f.RecurrenceFreq != FreqMonthly {
+		return &formError{"recurrence_last_business_day", "last business day is only supported for monthly recurrences"}
+	}
+	var err error
+	if f.RecurrenceExceptionDates, err = normalizeExceptionDates(f.RecurrenceExceptionDates); err != nil {
+		return &formError{"recurrence_exception_dates", err.Error()}
+	}
+	switch {
+	case f.GrowthAnnualPercent == 0:
+		f.GrowthMode = ""
+	case f.GrowthMode == "":
+		f.GrowthMode = GrowthRaise
+	}
+	return nil
+}
+
+type oneTimeTransactionForm struct {
+	Title           string  `form:"title" json:"title" validate:"required,min=1,max=255"`
+	IncomeOrExpense string  `form:"income_or_expense" json:"income_or_expense" validate:"required,oneof=income expense transfer"`
+	Category        string  `form:"category" json:"category" validate:"min=0,max=255"`
+	Amount          float64 `form:"amount" json:"amount" validate:"required,gt=0"`
+	TransactionDate Date    `form:"transaction_date" json:"transaction_date"`
+
+	accountsForm
+	uncertaintyForm
+}
+
+// expandedTransaction returns the one-time transaction with the values of the form.
+func (f *oneTimeTransactionForm) expandedTransaction() *ExpandedTransaction {
+	return &ExpandedTransaction{
+		Title:           f.Title,
+		IncomeOrExpense: f.IncomeOrExpense,
+		AccountID:       f.AccountID,
+		ToAccountID:     f.ToAccountID,
+		Category:        f.Category,
+		TransactionDate: f.TransactionDate.Time,
+		OccurrenceDate:  f.TransactionDate.Time,
+		Amount:          f.Amount,
+		Uncertainty:     f.uncertainty(),
+	}
+}
+
+func (f *oneTimeTransactionForm) check() error {
+	if f.TransactionDate.IsZero() {
+		return &formError{"transaction_date", "the transaction date is required"}
+	}
+	return nil
+}
+
+func newFormDecoder() *form.Decoder {
+	decoder := form.NewDecoder()
+	decoder.RegisterCustomTypeFunc(func(vals []string) (interface{}, error) {
+		return time.Parse(time.DateOnly, vals[0])
+	}, time.Time{})
+	decoder.RegisterCustomTypeFunc(func(vals []string) (interface{}, error) {
+		t, err := time.Parse(time.DateOnly, vals[0])
+		return Date{t}, err
+	}, Date{})
+	decoder.RegisterCustomTypeFunc(func(vals []string) (interface{}, error) {
+		if vals[0] == "" {
+			return uuid.Nil, nil
+		}
+		return uuid.FromString(vals[0])
+	}, uuid.UUID{})
+	return decoder
+}
+
+// newValidator returns a validator that reports the fields by their json names.
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return validate
+}
+
+// decodeForm parses the POST form of the request into v and validates it.
+func (s *Server) decodeForm(r *http.Request, v interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	if err := newFormDecoder().Decode(v, r.PostForm); err != nil {
+		l := s.logger.Error()
+		for k, v := range r.PostForm {
+			l = l.Strs(k, v)
+		}
+		l.Msg("unable to decode POST form")
+		return err
+	}
+	return newValidator().Struct(v)
+}
+
+// decodeRangeTransactionForm decodes the form of a new series, or of the stored
+// one when it is not nil.
+func (s *Server) decodeRangeTransactionForm(r *http.Request, stored *RangeTransaction) (*rangeTransactionForm, error) {
+	var f rangeTransactionForm
+	if stored != nil {
+		f.storedStart = stored.RecurrenceStart
+	}
+	if err := s.decodeForm(r, &f); err != nil {
+		return nil, err
+	}
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (s *Server) decodeOneTimeTransactionForm(r *http.Request) (*oneTimeTransactionForm, error) {
+	var f oneTimeTransactionForm
+	if err := s.decodeForm(r, &f); err != nil {
+		return nil, err
+	}
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
@@ -1,31 +1,235 @@
 package main
 
-func (rt *RangeTransaction) Expand() []ExpandedTransaction {
+import (
+	"slices"
+	"sort"
+	"time"
 
-	// in-memory computation of
-	numDays := int(rt.RecurrenceEnd.Sub(rt.RecurrenceStart).Hours() / 24)
-	if numDays == 0 {
-		numDays = 1
-	}
-	amountPerDay := rt.Amount / float64(numDays)
+	"github.com/gofrs/uuid"
+)
 
+// Expand returns a transaction with the full amount for each occurrence of the
+// range transaction, grown since the start. It is the only place occurrences are
+// generated, every repository inserts its rows.
+func (rt *RangeTransaction) Expand() []ExpandedTransaction {
 	var expanded []ExpandedTransaction
-	for i := 0; i < numDays; i++ {
-		day := rt.RecurrenceStart.AddDate(0, 0, i)
-		var incomeOrExpense string
-		if rt.IncomeOrExpense == "income" {
-			incomeOrExpense = "income"
-		} else {
-			incomeOrExpense = "expense"
+	for _, day := range rt.Occurrences() {
+		incomeOrExpense := "expense"
+		if rt.IncomeOrExpense == "income" || rt.IncomeOrExpense == "transfer" {
+			incomeOrExpense = rt.IncomeOrExpense
 		}
+		id, _ := uuid.NewV4()
 		expanded = append(expanded, ExpandedTransaction{
+			ID:                 id,
 			RangeTransactionID: rt.ID,
+			UserID:             rt.UserID,
+			PlannerID:          rt.PlannerID,
 			Title:              rt.Title,
 			TransactionDate:    day,
+			OccurrenceDate:     day,
 			IncomeOrExpense:    incomeOrExpense,
+			AccountID:          rt.AccountID,
+			ToAccountID:        rt.ToAccountID,
 			Category:           rt.Category,
-			Amount:             amountPerDay,
+			Amount:             roundCents(rt.Amount * rt.Growth.factor(rt.RecurrenceStart, day)),
+			Uncertainty:        rt.Uncertainty,
 		})
 	}
 	return expanded
 }
+
+// ExpandWithOverrides returns the occurrences to insert next to the existing overrides
+// of the series and the overrides whose dates are no longer in the series.
+func (rt *RangeTransaction) ExpandWithOverrides(overrides []ExpandedTransaction) (generated, stale []ExpandedTransaction) {
+	overridden := map[string]bool{}
+	for _, o := range overrides {
+		overridden[o.OccurrenceDate.Format(time.DateOnly)] = true
+	}
+	inSeries := map[string]bool{}
+	for _, etx := range rt.Expand() {
+		day := etx.OccurrenceDate.Format(time.DateOnly)
+		inSeries[day] = true
+		if !overridden[day] {
+			generated = append(generated, etx)
+		}
+	}
+	for _, o := range overrides {
+		if !inSeries[o.OccurrenceDate.Format(time.DateOnly)] {
+			stale = append(stale, o)
+		}
+	}
+	return generated, stale
+}
+
+// cashFlow returns the transactions of the planner until end sorted by date with
+// the balance of each account and the total balance after each of them. The
+// balances are in the order of plannerAccounts. Transfers move money between the
+// accounts and leave the total as it is. From the day of now the accounts earn or
+// are charged interest at the end of each month, and the statements of the credit
+// cards close and are paid on their due dates with generated transfers.
+func cashFlow(planner *Planner, accounts []Account, txns []ExpandedTransaction, now, end time.Time) []*SegmentedTransaction {
+	all := plannerAccounts(planner, accounts)
+	flow := &cashFlowRun{
+		accounts: all,
+		index:    map[uuid.UUID]int{},
+		balances: make([]float64, len(all)),
+		netCash:  openingBalance(planner, accounts),
+	}
+	for i := range all {
+		flow.index[all[i].ID] = i
+		flow.balances[i] = all[i].OpeningBalance
+	}
+
+	var planned []*SegmentedTransaction
+	for _, etx := range txns {
+		if etx.TransactionDate.After(end) {
+			continue
+		}
+		planned = append(planned, &SegmentedTransaction{
+			ExpandedTransactionID: etx.ID,
+			RangeTransactionID:    etx.RangeTransactionID,
+			IsOverride:            etx.IsOverride,
+			Title:                 etx.Title,
+			TransactionDate:       etx.TransactionDate,
+			IncomeOrExpense:       etx.IncomeOrExpense,
+			AccountID:             etx.AccountID,
+			ToAccountID:           etx.ToAccountID,
+			Category:              etx.Category,
+			Amount:                etx.Amount,
+		})
+	}
+	sort.SliceStable(planned, func(i, j int) bool {
+		return planned[i].TransactionDate.Before(planned[j].TransactionDate)
+	})
+
+	start := truncateDay(now)
+	first := start
+	if len(planned) > 0 && planned[0].TransactionDate.Before(first) {
+		first = truncateDay(planned[0].TransactionDate)
+	}
+	accruals := make([]interestAccrual, len(all))
+	// payments are the statement payments that have not landed, by date
+	var payments []*SegmentedTransaction
+	landPayments := func(day time.Time) {
+		for len(payments) > 0 && !payments[0].TransactionDate.After(day) {
+			flow.apply(payments[0])
+			payments = payments[1:]
+		}
+	}
+	for day, next := first, 0; !day.After(end); day = day.AddDate(0, 0, 1) {
+		landPayments(day)
+		for ; next < len(planned) && !truncateDay(planned[next].TransactionDate).After(day); next++ {
+			flow.apply(planned[next])
+		}
+		if day.Before(start) {
+			continue
+		}
+		lastOfMonth := day.AddDate(0, 0, 1).Day() == 1
+		for i := range all {
+			account := &all[i]
+			if account.accruesInterest() {
+				accruals[i].addDay(account, flow.balances[i])
+				if lastOfMonth {
+					flow.apply(interestTransaction(account, day, accruals[i].credit(account, day)))
+				}
+			}
+			if !account.HasStatements() || !day.Equal(statementDate(account, day)) {
+				continue
+			}
+			owed := -flow.balances[i]
+			for _, p := range payments {
+				if p.ToAccountID == account.ID {
+					owed -= p.Amount
+				}
+			}
+			due := day.AddDate(0, 0, account.PaymentDueDays)
+			if owed < 0.005 || due.After(end) {
+				continue
+			}
+			payment := &SegmentedTransaction{
+				Title:           account.Name + " statement payment",
+				TransactionDate: due,
+				IncomeOrExpense: "transfer",
+				AccountID:       account.PaymentAccountID,
+				ToAccountID:     account.ID,
+				Amount:          roundCents(owed),
+			}
+			at, _ := slices.BinarySearchFunc(payments, due, func(p *SegmentedTransaction, due time.Time) int {
+				return p.TransactionDate.Compare(due)
+			})
+			payments = slices.Insert(payments, at, payment)
+		}
+		landPayments(day)
+	}
+	return flow.txns
+}
+
+// cashFlowRun keeps the balances of the accounts while the transactions of the
+// cash flow are applied in order.
+type cashFlowRun struct {
+	accounts []Account
+	index    map[uuid.UUID]int
+	balances []float64
+	netCash  float64
+	txns     []*SegmentedTransaction
+}
+
+// account is the account the ID refers to, the main account when it is unknown.
+func (f *cashFlowRun) account(id uuid.UUID) *Account {
+	return &f.accounts[f.index[id]]
+}
+
+// apply adds the transaction to the cash flow with the balances after it, a nil
+// transaction is skipped.
+func (f *cashFlowRun) apply(stx *SegmentedTransaction) {
+	if stx == nil {
+		return
+	}
+	from := f.account(stx.AccountID)
+	stx.AccountID, stx.AccountName = from.ID, from.Name
+	switch stx.IncomeOrExpense {
+	case "income":
+		f.netCash += stx.Amount
+		f.balances[f.index[from.ID]] += stx.Amount
+	case "transfer":
+		to := f.account(stx.ToAccountID)
+		stx.ToAccountID, stx.ToAccountName = to.ID, to.Name
+		f.balances[f.index[from.ID]] -= stx.Amount
+		f.balances[f.index[to.ID]] += stx.Amount
+	default:
+		stx.ToAccountID = uuid.Nil
+		f.netCash -= stx.Amount
+		f.balances[f.index[from.ID]] -= stx.Amount
+	}
+	stx.NetCash = f.netCash
+	stx.Balances = slices.Clone(f.balances)
+	f.txns = append(f.txns, stx)
+}
+
+// statementDate returns the day the statement of the credit card closes in the
+// month of day. A statement day past the end of a month closes on its last day.
+func statementDate(card *Account, day time.Time) time.Time {
+	m := monthStart(day)
+	return m.AddDate(0, 0, min(card.StatementDay, daysInMonth(m.Year(), m.Month()))-1)
+}
+
+// interestTransaction returns the interest earned or charged on the account, nil
+// when there is none.
+func interestTransaction(account *Account, day time.Time, interest float64) *SegmentedTransaction {
+	if interest == 0 {
+		return nil
+	}
+	stx := &SegmentedTransaction{
+		Title:           account.Name + " interest",
+		TransactionDate: day,
+		IncomeOrExpense: "income",
+		AccountID:       account.ID,
+		Category:        interestCategory,
+		Amount:          interest,
+	}
+	if interest < 0 {
+		stx.IncomeOrExpense = "expense"
+		stx.Amount = -interest
+	}
+	return stx
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Views of the planner page. A month or a quarter of the cash flow keeps large
+// planners quick to load, all shows the whole horizon at once.
+const (
+	ViewMonth   = "month"
+	ViewQuarter = "quarter"
+	ViewAll     = "all"
+)
+
+// Aggregations of the chart, a point per day, week or month with the balances at
+// its end.
+const (
+	ChartDaily   = "daily"
+	ChartWeekly  = "weekly"
+	ChartMonthly = "monthly"
+)
+
+// defaultCharts is the aggregation of the chart of each view.
+var defaultCharts = map[string]string{
+	ViewMonth:   ChartDaily,
+	ViewQuarter: ChartWeekly,
+	ViewAll:     ChartMonthly,
+}
+
+// PlannerWindow is the part of the cash flow shown on the planner page.
+type PlannerWindow struct {
+	View  string
+	Chart string
+	// Start is the first day of the window and End its last one, the all view
+	// starts at the zero time and ends with the planner.
+	Start time.Time
+	End   time.Time
+	// HasNext is set when the planner goes on after the window
+	HasNext bool
+
+	// OpeningNetCash and OpeningBalances are carried forward from the
+	// transactions before the window, the balances are in the order of the
+	// accounts of the cash flow.
+	OpeningNetCash  float64
+	OpeningBalances []float64
+
+	plannerURL string
+}
+
+// ChartPoint is a point of the chart with the balances at the end of its day,
+// week or month.
+type ChartPoint struct {
+	Date     time.Time
+	NetCash  float64
+	Balances []float64
+}
+
+// parsePlannerWindow reads the view, from and chart query parameters. From is the
+// month the window starts in as YYYY-MM, a quarter starts with the quarter of the
+// month. Without parameters the window is the month of now.
+func parsePlannerWindow(query url.Values, planner *Planner, now time.Time) (*PlannerWindow, error) {
+	plannerEnd := planner.End(now)
+	w := &PlannerWindow{View: query.Get("view"), Chart: query.Get("chart"), plannerURL: plannerURL(planner.ID)}
+	if w.View == "" {
+		w.View = ViewMonth
+	}
+	if _, ok := defaultCharts[w.View]; !ok {
+		return nil, fmt.Errorf("view must be %s, %s or %s", ViewMonth, ViewQuarter, ViewAll)
+	}
+	if w.Chart == "" {
+		w.Chart = defaultCharts[w.View]
+	}
+	if w.Chart != ChartDaily && w.Chart != ChartWeekly && w.Chart != ChartMonthly {
+		return nil, fmt.Errorf("chart must be %s, %s or %s", ChartDaily, ChartWeekly, ChartMonthly)
+	}
+	if w.View == ViewAll {
+		w.End = plannerEnd
+		return w, nil
+	}
+
+	w.Start = monthStart(now)
+	if from := query.Get("from"); from != "" {
+		start, err := time.Parse("2006-01", from)
+		if err != nil {
+			return nil, errors.New("from must be a month as YYYY-MM")
+		}
+		w.Start = start
+	}
+	if w.View == ViewQuarter {
+		w.Start = w.Start.AddDate(0, -int(w.Start.Month()-1)%3, 0)
+	}
+	w.End = w.Start.AddDate(0, w.months(), -1)
+	w.HasNext = w.End.Before(truncateDay(plannerEnd))
+	if w.End.After(plannerEnd) {
+		w.End = truncateDay(plannerEnd)
+	}
+	return w, nil
+}
+
+// windowQuery is the query of the page the request was sent from. htmx sends the
+// address of the page along, so a partial update keeps the window of the page.
+func windowQuery(r *http.Request) url.Values {
+	if !isHTMX(r) {
+		return r.URL.Query()
+	}
+	page, err := url.Parse(r.Header.Get("HX-Current-URL"))
+	if err != nil {
+		return url.Values{}
+	}
+	return page.Query()
+}
+
+// months is how many months the window spans.
+func (w *PlannerWindow) months() int {
+	if w.View == ViewQuarter {
+		return 3
+	}
+	return 1
+}
+
+// Label names the window, like October 2026 or Q4 2026.
+func (w *PlannerWindow) Label() string {
+	switch w.View {
+	case ViewAll:
+		return "Whole planner"
+	case ViewQuarter:
+		return fmt.Sprintf("Q%d %d", (w.Start.Month()-1)/3+1, w.Start.Year())
+	}
+	return w.Start.Format("January 2006")
+}
+
+// query returns the query parameters of the window.
+func (w *PlannerWindow) query(view string, start time.Time, chart string) string {
+	query := url.Values{"view": {view}}
+	if view != ViewAll && !start.IsZero() {
+		query.Set("from", start.Format("2006-01"))
+	}
+	if chart != "" && chart != defaultCharts[view] {
+		query.Set("chart", chart)
+	}
+	return query.Encode()
+}
+
+// link returns the address of the planner page with the window.
+func (w *PlannerWindow) link(view string, start time.Time, chart string) string {
+	return w.plannerURL + "?" + w.query(view, start, chart)
+}
+
+// SummaryURL is the address of the planner card, the chart and the budgets of this window.
+func (w *PlannerWindow) SummaryURL() string {
+	return w.plannerURL + "/summary?" + w.query(w.View, w.Start, w.Chart)
+}
+
+// PrevURL is the address of the window before this one.
+func (w *PlannerWindow) PrevURL() string {
+	return w.link(w.View, w.Start.AddDate(0, -w.months(), 0), w.Chart)
+}
+
+// NextURL is the address of the window after this one.
+func (w *PlannerWindow) NextURL() string {
+	return w.link(w.View, w.Start.AddDate(0, w.months(), 0), w.Chart)
+}
+
+// TodayURL is the address of the window of the view with today in it.
+func (w *PlannerWindow) TodayURL() string {
+	return w.link(w.View, time.Time{}, w.Chart)
+}
+
+// ViewURL is the address of the view starting with this window.
+func (w *PlannerWindow) ViewURL(view string) string {
+	return w.link(view, w.Start, "")
+}
+
+// ChartURL is the address of this window with the chart aggregated by chart.
+func (w *PlannerWindow) ChartURL(chart string) string {
+	return w.link(w.View, w.Start, chart)
+}
+
+// chartPeriod returns the start of the day, week or month of t. Weeks start on
+// Monday.
+func chartPeriod(t time.Time, chart string) time.Time {
+	day := truncateDay(t)
+	switch chart {
+	case ChartWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case ChartMonthly:
+		return monthStart(day)
+	}
+	return day
+}
+
+// chartPoints returns a point per day, week or month of the cash flow with the
+// balances after its last transaction, on the date of that transaction.
+func chartPoints(txns []*SegmentedTransaction, chart string) []ChartPoint {
+	var points []ChartPoint
+	var period time.Time
+	for _, stx := range txns {
+		point := ChartPoint{Date: stx.TransactionDate, NetCash: stx.NetCash, Balances: stx.Balances}
+		if p := chartPeriod(stx.TransactionDate, chart); len(points) == 0 || !p.Equal(period) {
+			points = append(points, point)
+			period = p
+			continue
+		}
+		points[len(points)-1] = point
+	}
+	return points
+}
+
+// CashFlowMonth is the rows of the cash flow in a month. The page swaps the rows
+// of a month at once after a change.
+type CashFlowMonth struct {
+	Month time.Time
+	Rows  []*SegmentedTransaction
+}
+
+// cashFlowMonths groups the cash flow by month, from the first month of the window
+// or the month of the first transaction in the all view to the last month of the
+// window. Months without transactions are kept so the page has a place for them.
+func cashFlowMonths(txns []*SegmentedTransaction, window *PlannerWindow) []CashFlowMonth {
+	first := window.Start
+	if window.View == ViewAll {
+		if len(txns) == 0 {
+			return nil
+		}
+		first = txns[0].TransactionDate
+	}
+	var months []CashFlowMonth
+	until := func(month time.Time) {
+		for len(months) == 0 || months[len(months)-1].Month.Before(month) {
+			next := monthStart(first)
+			if len(months) > 0 {
+				next = months[len(months)-1].Month.AddDate(0, 1, 0)
+			}
+			months = append(months, CashFlowMonth{Month: next})
+		}
+	}
+	for _, stx := range txns {
+		until(monthStart(stx.TransactionDate))
+		months[len(months)-1].Rows = append(months[len(months)-1].Rows, stx)
+	}
+	until(monthStart(window.End))
+	return months
+}
+
+// addAccountTotal adds the change a sum of transactions of a kind makes to the
+// balances of the accounts.
+func addAccountTotal(totals map[uuid.UUID]float64, accountID, toAccountID uuid.UUID, incomeOrExpense string, amount float64) {
+	switch incomeOrExpense {
+	case "income":
+		totals[accountID] += amount
+	case "transfer":
+		totals[accountID] -= amount
+		totals[toAccountID] += amount
+	default:
+		totals[accountID] -= amount
+	}
+}
+
+// carryForward returns copies of the planner and the accounts that open with the
+// totals of the transactions before the window, the total of an unknown account
+// goes to the main account like its transactions do.
+func carryForward(planner *Planner, accounts []Account, totals map[uuid.UUID]float64) (*Planner, []Account) {
+	carried := *planner
+	carriedAccounts := make([]Account, len(accounts))
+	known := map[uuid.UUID]bool{}
+	for i := range accounts {
+		carriedAccounts[i] = accounts[i]
+		carriedAccounts[i].OpeningBalance += totals[accounts[i].ID]
+		known[accounts[i].ID] = true
+	}
+	for id, total := range totals {
+		if !known[id] {
+			carried.StartBalance += total
+		}
+	}
+	return &carried, carriedAccounts
+}
+
+// windowCashFlow returns the cash flow of the window with the transactions of the
+// window and sets the balances the window opens with. The transactions before the
+// window are summed up by the repository. Interest and card statements depend on
+// the balance of every day from today, so when the window starts after today and
+// the planner has them the cash flow runs from today and is cut at the window.
+func (s *Server) windowCashFlow(user *User, planner *Planner, accounts []Account, window *PlannerWindow, now time.Time) ([]*SegmentedTransaction, []ExpandedTransaction, error) {
+	if window.View == ViewAll {
+		txns, err := s.repository.ListExpandedTransactions(user.ID, planner.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		window.OpeningNetCash = openingBalance(planner, accounts)
+		for _, account := range plannerAccounts(planner, accounts) {
+			window.OpeningBalances = append(window.OpeningBalances, account.OpeningBalance)
+		}
+		return cashFlow(planner, accounts, txns, now, window.End), txns, nil
+	}
+
+	runsFromToday := false
+	if window.Start.After(truncateDay(now)) {
+		for i := range accounts {
+			runsFromToday = runsFromToday || accounts[i].accruesInterest() || accounts[i].HasStatements()
+		}
+	}
+	if runsFromToday {
+		txns, err := s.repository.ListExpandedTransactionsBetween(user.ID, planner.ID, time.Time{}, window.End)
+		if err != nil {
+			return nil, nil, err
+		}
+		segTxns := cashFlow(planner, accounts, txns, now, window.End)
+		window.OpeningNetCash = openingBalance(planner, accounts)
+		for _, account := range plannerAccounts(planner, accounts) {
+			window.OpeningBalances = append(window.OpeningBalances, account.OpeningBalance)
+		}
+		for len(segTxns) > 0 && segTxns[0].TransactionDate.Before(window.Start) {
+			window.OpeningNetCash, window.OpeningBalances = segTxns[0].NetCash, segTxns[0].Balances
+			segTxns = segTxns[1:]
+		}
+		var inWindow []ExpandedTransaction
+		for i := range txns {
+			if !txns[i].TransactionDate.Before(window.Start) {
+				inWindow = append(inWindow, txns[i])
+			}
+		}
+		return segTxns, inWindow, nil
+	}
+
+	totals, err := s.repository.ExpandedTransactionTotalsBefore(user.ID, planner.ID, window.Start)
+	if err != nil {
+		return nil, nil, err
+	}
+	txns, err := s.repository.ListExpandedTransactionsBetween(user.ID, planner.ID, window.Start, window.End)
+	if err != nil {
+		return nil, nil, err
+	}
+	carried, carriedAccounts := carryForward(planner, accounts, totals)
+	window.OpeningNetCash = openingBalance(carried, carriedAccounts)
+	for _, account := range plannerAccounts(carried, carriedAccounts) {
+		window.OpeningBalances = append(window.OpeningBalances, account.OpeningBalance)
+	}
+	return cashFlow(carried, carriedAccounts, txns, now, window.End), txns, nil
+}
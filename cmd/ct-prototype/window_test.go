@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestParsePlannerWindow(t *testing.T) {
+	now := time.Date(2026, 5, 19, 10, 0, 0, 0, time.UTC)
+	planner := &Planner{ID: uuid.Must(uuid.NewV4()), HorizonMonths: 12}
+	tests := []struct {
+		query string
+		view  string
+		chart string
+		start string
+		end   string
+		label string
+		next  bool
+	}{
+		{"", ViewMonth, ChartDaily, "2026-05-01", "2026-05-31", "May 2026", true},
+		{"view=quarter&from=2026-05", ViewQuarter, ChartWeekly, "2026-04-01", "2026-06-30", "Q2 2026", true},
+		{"view=quarter&from=2026-12&chart=daily", ViewQuarter, ChartDaily, "2026-10-01", "2026-12-31", "Q4 2026", true},
+		{"from=2027-05&chart=monthly", ViewMonth, ChartMonthly, "2027-05-01", "2027-05-19", "May 2027", false},
+		{"view=all", ViewAll, ChartMonthly, "0001-01-01", "2027-05-19", "Whole planner", false},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		w, err := parsePlannerWindow(query, planner, now)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		ensureString(t, w.View, tt.view)
+		ensureString(t, w.Chart, tt.chart)
+		ensureString(t, w.Start.Format(time.DateOnly), tt.start)
+		ensureString(t, w.End.Format(time.DateOnly), tt.end)
+		ensureString(t, w.Label(), tt.label)
+		if w.HasNext != tt.next {
+			t.Errorf("%q: got has next %t", tt.query, w.HasNext)
+		}
+	}
+
+	query, _ := url.ParseQuery("view=quarter&from=2026-05")
+	w, _ := parsePlannerWindow(query, planner, now)
+	ensureString(t, w.PrevURL(), plannerURL(planner.ID)+"?from=2026-01&view=quarter")
+	ensureString(t, w.NextURL(), plannerURL(planner.ID)+"?from=2026-07&view=quarter")
+	ensureString(t, w.ChartURL(ChartMonthly), plannerURL(planner.ID)+"?chart=monthly&from=2026-04&view=quarter")
+	ensureString(t, w.ViewURL(ViewMonth), plannerURL(planner.ID)+"?from=2026-04&view=month")
+	ensureString(t, w.TodayURL(), plannerURL(planner.ID)+"?view=quarter")
+
+	for _, bad := range []string{"view=year", "from=2026-13", "from=May", "chart=hourly"} {
+		query, _ := url.ParseQuery(bad)
+		if _, err := parsePlannerWindow(query, planner, now); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+func TestChartPoints(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 6, d, 0, 0, 0, 0, time.UTC) }
+	var txns []*SegmentedTransaction
+	// June 1 2026 is a Monday
+	for i, d := range []int{1, 1, 3, 8, 14, 15, 30} {
+		txns = append(txns, &SegmentedTransaction{TransactionDate: day(d), NetCash: float64(i)})
+	}
+	txns = append(txns, &SegmentedTransaction{TransactionDate: time.Date(2026, 7, 2, 0, 0, 0, 0, time.UTC), NetCash: 7})
+
+	tests := []struct {
+		chart   string
+		netCash []float64
+	}{
+		{ChartDaily, []float64{1, 2, 3, 4, 5, 6, 7}},
+		{ChartWeekly, []float64{2, 4, 5, 7}},
+		{ChartMonthly, []float64{6, 7}},
+	}
+	for _, tt := range tests {
+		points := chartPoints(txns, tt.chart)
+		ensureInt(t, len(points), len(tt.netCash))
+		for i, p := range points {
+			ensureFloat(t, p.NetCash, tt.netCash[i])
+		}
+	}
+}
+
+func TestPlannerWindowCashFlow(t *testing.T) {
+	// without interest the repository carries the balances into the window, with
+	// interest the cash flow runs from today
+	for _, interest := range []string{"0", "6"} {
+		t.Run("interest "+interest, func(t *testing.T) {
+			server, repository := newTestServer(t)
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatalf("creating cookie jar: %v", err)
+			}
+			csrfToken, plannerURL := signInWithPlanner(t, server, jar)
+			user, err := repository.GetUser(testUsername)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plannerID := uuid.FromStringOrNil(strings.TrimPrefix(plannerURL, "/planners/"))
+
+			form := url.Values{}
+			form.Set("csrf-token", csrfToken)
+			form.Set("name", "Savings")
+			form.Set("kind", AccountSavings)
+			form.Set("opening_balance", "1000")
+			form.Set("interest_percent", interest)
+			form.Set("compounding", CompoundingMonthly)
+			ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/accounts", form), http.StatusFound)
+			accounts, _ := repository.ListAccounts(user.ID, plannerID)
+			ensureInt(t, len(accounts), 1)
+
+			thisMonth := monthStart(time.Now())
+			for i, tx := range []struct{ title, kind, amount string }{
+				{"Salary", "income", "1000"},
+				{"Save", "transfer", "100"},
+				{"Rent", "expense", "300"},
+			} {
+				form := url.Values{}
+				form.Set("csrf-token", csrfToken)
+				form.Set("title", tx.title)
+				form.Set("income_or_expense", tx.kind)
+				form.Set("amount", tx.amount)
+				form.Set("transaction_date", thisMonth.AddDate(0, i+1, 4).Format(time.DateOnly))
+				if tx.kind == "transfer" {
+					form.Set("to_account_id", accounts[0].ID.String())
+				}
+				ensureCode(t, serve(t, server, jar, "POST", plannerURL+"/add-one-time-transaction", form), http.StatusFound)
+			}
+
+			recorder := serve(t, server, jar, "GET", plannerURL+"?view=all", nil)
+			ensureCode(t, recorder, http.StatusOK)
+			all := parseCashFlow(t, recorder.Body.String())
+			opening := regexp.MustCompile(`Opening balance on [^:]+: \$(\S+)`)
+
+			// each month opens with the net cash of the whole planner before it
+			for i := -1; i <= 3; i++ {
+				start := thisMonth.AddDate(0, i, 0)
+				recorder := serve(t, server, jar, "GET", plannerURL+"?from="+start.Format("2006-01"), nil)
+				ensureCode(t, recorder, http.StatusOK)
+				body := recorder.Body.String()
+				match := opening.FindStringSubmatch(body)
+				if match == nil {
+					t.Fatalf("%s: no opening balance", start.Format("2006-01"))
+				}
+				wantOpening := "1000"
+				var wantRows [][]string
+				for _, row := range all {
+					date, err := time.Parse("02 Jan 2006", row[0])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if date.Before(start) {
+						wantOpening = row[5]
+					} else if date.Before(start.AddDate(0, 1, 0)) {
+						wantRows = append(wantRows, row)
+					}
+				}
+				ensureString(t, match[1], wantOpening)
+				rows := parseCashFlow(t, body)
+				ensureInt(t, len(rows), len(wantRows))
+				for j := range rows {
+					ensureString(t, strings.Join(rows[j][:8], "|"), strings.Join(wantRows[j][:8], "|"))
+				}
+			}
+
+			ensureCode(t, serve(t, server, jar, "GET", plannerURL+"?view=week", nil), http.StatusBadRequest)
+		})
+	}
+}
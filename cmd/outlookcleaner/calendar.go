@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Minimal iCalendar (RFC 5545) reader for the invites attached to messages.
+// Only the VEVENT properties stored in the events table are read.
+
+const (
+	icsDateTimeLayout    = "20060102T150405"
+	icsDateTimeUTCLayout = "20060102T150405Z"
+	icsDateLayout        = "20060102"
+)
+
+// icsProperty is a content line such as DTSTART;TZID=Europe/Paris:20240101T090000
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfoldICS joins the continuation lines that start with a space or a tab.
+func unfoldICS(content string) []string {
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func parseICSProperty(line string) (icsProperty, bool) {
+	// the value can contain colons (e.g. mailto:) so split at the first one outside quotes
+	inQuotes, sep := false, -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		}
+		if r == ':' && !inQuotes {
+			sep = i
+			break
+		}
+	}
+	if sep <= 0 {
+		return icsProperty{}, false
+	}
+	nameAndParams := strings.Split(line[:sep], ";")
+	prop := icsProperty{
+		name:   strings.ToUpper(nameAndParams[0]),
+		params: map[string]string{},
+		value:  line[sep+1:],
+	}
+	for _, p := range nameAndParams[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			prop.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return prop, true
+}
+
+func unescapeICSText(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// parseICSTime returns the time of a DTSTART or DTEND property and if it is a date without a time.
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	if prop.params["VALUE"] == "DATE" || len(prop.value) == len(icsDateLayout) {
+		t, err := time.Parse(icsDateLayout, prop.value)
+		return t, true, err
+	}
+	if strings.HasSuffix(prop.value, "Z") {
+		t, err := time.Parse(icsDateTimeUTCLayout, prop.value)
+		return t, false, err
+	}
+	loc := time.UTC
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation(icsDateTimeLayout, prop.value, loc)
+	return t, false, err
+}
+
+// parseICS returns the events in an iCalendar document.
+func parseICS(content string) ([]CalendarEvent, error) {
+	events := []CalendarEvent{}
+	method := ""
+	var current *CalendarEvent
+	for _, line := range unfoldICS(content) {
+		prop, ok := parseICSProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case prop.name == "METHOD" && current == nil:
+			method = strings.ToUpper(prop.value)
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VEVENT"):
+			current = &CalendarEvent{Method: method}
+		case prop.name == "END" && strings.EqualFold(prop.value, "VEVENT"):
+			if current == nil {
+				return nil, fmt.Errorf("END:VEVENT without BEGIN:VEVENT")
+			}
+			events = append(events, *current)
+			current = nil
+		case current == nil:
+			continue
+		case prop.name == "UID":
+			current.EventUID = prop.value
+		case prop.name == "SUMMARY":
+			current.Summary = unescapeICSText(prop.value)
+		case prop.name == "LOCATION":
+			current.Location = unescapeICSText(prop.value)
+		case prop.name == "STATUS":
+			current.Status = strings.ToUpper(prop.value)
+		case prop.name == "ORGANIZER":
+			current.Organizer = strings.TrimPrefix(strings.TrimPrefix(prop.value, "mailto:"), "MAILTO:")
+		case prop.name == "DTSTART":
+			t, allDay, err := parseICSTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTSTART %s with error %w", prop.value, err)
+			}
+			current.StartsAt, current.AllDay = t, allDay
+		case prop.name == "DTEND":
+			t, _, err := parseICSTime(prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTEND %s with error %w", prop.value, err)
+			}
+			current.EndsAt = t
+		}
+	}
+	return events, nil
+}
+
+// saveCalendarEvents replaces the events stored for the message.
+func saveCalendarEvents(db *gorm.DB, messageRowID uint, events []CalendarEvent) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("message_row_id = ?", messageRowID).Delete(&CalendarEvent{}).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		for i := range events {
+			events[i].MessageRowID = messageRowID
+		}
+		return tx.Create(&events).Error
+	})
+}
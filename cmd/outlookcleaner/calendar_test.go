@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseICS(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\n" +
+		"METHOD:REQUEST\r\n" +
+		"BEGIN:VTIMEZONE\r\nTZID:America/New_York\r\nEND:VTIMEZONE\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:abc-123\r\n" +
+		"SUMMARY:Quarterly planning\\, room 4\r\n" +
+		"LOCATION:Building 1\r\n" +
+		"ORGANIZER;CN=\"Doe, Jane\":mailto:jane@example.com\r\n" +
+		"DTSTART;TZID=America/New_York:20240305T093000\r\n" +
+		"DTEND:20240305T153000Z\r\n" +
+		"DESCRIPTION:a long description that is folded\r\n" +
+		"  onto the next line\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:holiday\r\n" +
+		"SUMMARY:Holiday\r\n" +
+		"DTSTART;VALUE=DATE:20240704\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := parseICS(ics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+
+	meeting := events[0]
+	ny, _ := time.LoadLocation("America/New_York")
+	if meeting.EventUID != "abc-123" || meeting.Method != "REQUEST" || meeting.Summary != "Quarterly planning, room 4" {
+		t.Errorf("unexpected event %+v", meeting)
+	}
+	if meeting.Organizer != "jane@example.com" {
+		t.Errorf("got organizer %q", meeting.Organizer)
+	}
+	if !meeting.StartsAt.Equal(time.Date(2024, 3, 5, 9, 30, 0, 0, ny)) {
+		t.Errorf("got start %s", meeting.StartsAt)
+	}
+	if !meeting.EndsAt.Equal(time.Date(2024, 3, 5, 15, 30, 0, 0, time.UTC)) {
+		t.Errorf("got end %s", meeting.EndsAt)
+	}
+
+	holiday := events[1]
+	if !holiday.AllDay || !holiday.StartsAt.Equal(time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected all day event %+v", holiday)
+	}
+}
@@ -14,6 +14,8 @@ type (
 		Database DatabaseConfig   `mapstructure:"db"`
 		Mail     MailConfig       `mapstructure:"mail"`
 		Encrypt  EncryptionConfig `mapstructure:"auth-cli"`
+		Receipts ReceiptsConfig   `mapstructure:"receipts"`
+		Digest   DigestConfig     `mapstructure:"digest"`
 	}
 
 	EncryptionConfig struct {
@@ -35,17 +37,87 @@ type (
 	}
 
 	MailAccountConfig struct {
-		Hostname    string              `mapstructure:"host"`
-		Port        int                 `mapstructure:"port"`
-		EncUser     string              `mapstructure:"user"`
-		EncPassword string              `mapstructure:"password"`
-		Prune       MailboxActionConfig `mapstructure:"prune"`
-		Ingest      MailboxActionConfig `mapstructure:"ingest"`
+		// Provider is one of outlook, gmail, fastmail or generic. Defaults to outlook.
+		Provider       string               `mapstructure:"provider"`
+		Hostname       string               `mapstructure:"host"`
+		Port           int                  `mapstructure:"port"`
+		EncUser        string               `mapstructure:"user"`
+		EncPassword    string               `mapstructure:"password"`
+		SpecialFolders SpecialFoldersConfig `mapstructure:"special_folders"`
+		Prune          MailboxActionConfig  `mapstructure:"prune"`
+		PruneRules     []PruneRuleConfig    `mapstructure:"prune_rules"`
+		Quarantine     QuarantineConfig     `mapstructure:"quarantine"`
+		Ingest         MailboxActionConfig  `mapstructure:"ingest"`
+	}
+	// SpecialFoldersConfig overrides the folders discovered through SPECIAL-USE
+	SpecialFoldersConfig struct {
+		Inbox   string `mapstructure:"inbox"`
+		Archive string `mapstructure:"archive"`
+		Trash   string `mapstructure:"trash"`
+		Junk    string `mapstructure:"junk"`
+		AllMail string `mapstructure:"all_mail"`
 	}
 	MailboxActionConfig struct {
 		ThresholdDays int      `mapstructure:"threshold_days,omitempty"`
 		Folders       []string `mapstructure:"folders"`
 	}
+	// PruneRuleConfig archives the messages in the folders that are older than the threshold
+	PruneRuleConfig struct {
+		Name          string   `mapstructure:"name"`
+		Folders       []string `mapstructure:"folders"`
+		OlderThanDays int      `mapstructure:"older_than_days"`
+		// Scope is message (default) or thread. A thread rule only matches when
+		// every message in the thread matches.
+		Scope       string `mapstructure:"scope"`
+		SkipFlagged bool   `mapstructure:"skip_flagged"`
+		// Action is archive (default) or delete. Deleted messages go to the quarantine folder.
+		Action string `mapstructure:"action"`
+	}
+	// QuarantineConfig controls where deleted messages wait before they are purged
+	QuarantineConfig struct {
+		Folder        string `mapstructure:"folder"`
+		RetentionDays int    `mapstructure:"retention_days"`
+		// ExportDir keeps a .eml copy of each message deleted. Purge only expunges
+		// messages with a copy and does not run without it.
+		ExportDir string `mapstructure:"export_dir"`
+	}
+
+	ReceiptsConfig struct {
+		Extractors []ReceiptExtractorConfig `mapstructure:"extractors"`
+	}
+	// ReceiptExtractorConfig reads receipts from a sender domain. Each pattern is a
+	// regular expression with the value in its first capture group.
+	ReceiptExtractorConfig struct {
+		Domain      string `mapstructure:"domain"`
+		Merchant    string `mapstructure:"merchant"`
+		OrderNumber string `mapstructure:"order_number"`
+		Amount      string `mapstructure:"amount"`
+		Date        string `mapstructure:"date"`
+		// DateLayout is the go time layout of the date pattern, e.g. January 2, 2006
+		DateLayout string `mapstructure:"date_layout"`
+	}
+
+	// DigestConfig sends a summary after a prune or purge. The digest is written to
+	// OutputFile when set, otherwise it is sent through the SMTP server.
+	DigestConfig struct {
+		From       string     `mapstructure:"from"`
+		To         []string   `mapstructure:"to"`
+		SMTP       SMTPConfig `mapstructure:"smtp"`
+		OutputFile string     `mapstructure:"output_file"`
+		// FlaggedOlderThanDays lists the flagged messages older than the threshold. Defaults to 30.
+		FlaggedOlderThanDays int `mapstructure:"flagged_older_than_days"`
+		// NewSenderDays is the window for senders seen for the first time. Defaults to 7.
+		NewSenderDays int `mapstructure:"new_sender_days"`
+		TopSenders    int `mapstructure:"top_senders"`
+	}
+	// SMTPConfig uses the same encrypted credentials as the mail accounts. STARTTLS
+	// is used when the server supports it.
+	SMTPConfig struct {
+		Hostname    string `mapstructure:"host"`
+		Port        int    `mapstructure:"port"`
+		EncUser     string `mapstructure:"user"`
+		EncPassword string `mapstructure:"password"`
+	}
 )
 
 var c *Config
@@ -18,7 +18,12 @@ var GormDB *gorm.DB
 type Message struct {
 	gorm.Model
 	MessageID       string `gorm:"unique"`
+	InReplyTo       string
+	References      string // space separated message IDs, oldest first
+	ThreadID        uint   `gorm:"index"`
+	Account         string `gorm:"index"`
 	UID             uint32
+	UIDValidity     uint32 // UIDVALIDITY of the folder the UID was read from
 	SeqNum          uint32
 	From            string `gorm:"size:255,index"`
 	FromName        string
@@ -28,7 +33,10 @@ type Message struct {
 	ReceivedAt      time.Time
 	RemoteDeletedAt time.Time
 	OpenedAt        sql.NullTime
+	QuarantinedAt   sql.NullTime `gorm:"index"`
+	QuarantinedFrom string
 	MailBoxFolder   string
+	Labels          string
 	SizeBytes       uint32
 	IsSeen          bool
 	IsFlagged       bool
@@ -42,6 +50,87 @@ func (Message) TableName() string {
 	return "outlookcleaner_messages"
 }
 
+// Thread is a conversation built from the In-Reply-To and References headers
+type Thread struct {
+	gorm.Model
+	RootMessageID   string `gorm:"unique"`
+	Subject         string
+	MessageCount    int
+	FlaggedCount    int
+	FirstReceivedAt time.Time
+	LastReceivedAt  time.Time `gorm:"index"`
+}
+
+func (Thread) TableName() string {
+	return "outlookcleaner_threads"
+}
+
+// CalendarEvent is a VEVENT read from a text/calendar part of a message
+type CalendarEvent struct {
+	gorm.Model
+	MessageRowID uint   `gorm:"index"`
+	EventUID     string `gorm:"index"`
+	Method       string
+	Summary      string
+	Location     string
+	Organizer    string
+	Status       string
+	StartsAt     time.Time `gorm:"index"`
+	EndsAt       time.Time
+	AllDay       bool
+}
+
+func (CalendarEvent) TableName() string {
+	return "outlookcleaner_events"
+}
+
+// Receipt holds the purchase details extracted from a receipt message
+type Receipt struct {
+	gorm.Model
+	MessageRowID uint `gorm:"unique"`
+	SenderDomain string
+	Merchant     string
+	OrderNumber  string
+	AmountCents  int64
+	Currency     string
+	PurchasedAt  time.Time `gorm:"index"`
+}
+
+func (Receipt) TableName() string {
+	return "outlookcleaner_receipts"
+}
+
+// Run is one prune or purge invocation. The messages it touched are in RunAction.
+type Run struct {
+	gorm.Model
+	Kind       string
+	DryRun     bool
+	FinishedAt sql.NullTime
+	Actions    []RunAction
+}
+
+func (Run) TableName() string {
+	return "outlookcleaner_runs"
+}
+
+// RunAction records a message moved by a run so the run can be undone
+type RunAction struct {
+	gorm.Model
+	RunID        uint `gorm:"index"`
+	MessageRowID uint `gorm:"index"`
+	MessageID    string
+	Account      string
+	Rule         string
+	Action       string
+	FromFolder   string
+	ToFolder     string
+	UndoneAt     sql.NullTime
+}
+
+func (RunAction) TableName() string {
+	return "outlookcleaner_run_actions"
+}
+
 // SetupDatabase - Connects the database
 func SetupDatabase(ctx context.Context) error {
 	dbConfig := getConfig(ctx).Database
@@ -79,7 +168,7 @@ func initDB(ctx context.Context) error {
 		return err
 	}
 	logger.GetLoggerFromContext(ctx).Info("running auto migrations")
-	err := GormDB.AutoMigrate(Message{})
+	err := GormDB.AutoMigrate(Message{}, Thread{}, CalendarEvent{}, Receipt{}, Run{}, RunAction{})
 	if err != nil {
 		return fmt.Errorf("failed to migrate database with error %w", err)
 	}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// useTestDB points GormDB at an empty in-memory database for the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an in-memory database only lives as long as its connection
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(Message{}, Thread{}, CalendarEvent{}, Receipt{}, Run{}, RunAction{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := GormDB
+	GormDB = db
+	t.Cleanup(func() {
+		GormDB = previous
+		sqlDB.Close()
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raokrutarth/golang-playspace/pkg/logger"
+)
+
+const (
+	defaultDigestFlaggedOlderThanDays = 30
+	defaultDigestNewSenderDays        = 7
+	defaultDigestTopSenders           = 10
+	defaultSMTPPort                   = 587
+	// digestFlaggedLimit caps the flagged messages listed in a digest
+	digestFlaggedLimit = 50
+)
+
+// DigestRuleCount is the number of messages a rule moved in the run
+type DigestRuleCount struct {
+	Account string
+	Rule    string
+	Action  string
+	Count   int
+}
+
+// DigestSender is a sender whose first message arrived within the new sender window
+type DigestSender struct {
+	Address string
+	Name    string
+	Count   int
+}
+
+// Digest summarizes a prune or purge run
+type Digest struct {
+	Run         Run
+	GeneratedAt time.Time
+	Rules       []DigestRuleCount
+	Total       int
+
+	NewSenderDays int
+	NewSenders    []DigestSender
+
+	FlaggedOlderThanDays int
+	Flagged              []Message
+
+	UndoCommand string
+}
+
+//nolint:gochecknoglobals // parsed once
+var digestTemplate = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>outlookcleaner {{.Run.Kind}} run #{{.Run.ID}}{{if .Run.DryRun}} (dry run){{end}}</h2>
+<p>{{.GeneratedAt.Format "Mon, 02 Jan 2006 15:04"}}</p>
+
+<h3>Messages {{if .Run.DryRun}}that would be {{end}}moved: {{.Total}}</h3>
+{{if .Rules}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Account</th><th>Rule</th><th>Action</th><th>Messages</th></tr>
+{{range .Rules}}<tr><td>{{.Account}}</td><td>{{.Rule}}</td><td>{{.Action}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>{{else}}<p>No rule matched any message.</p>{{end}}
+
+<h3>New senders in the last {{.NewSenderDays}} days</h3>
+{{if .NewSenders}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Sender</th><th>Messages</th></tr>
+{{range .NewSenders}}<tr><td>{{if .Name}}{{.Name}} &lt;{{.Address}}&gt;{{else}}{{.Address}}{{end}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>{{else}}<p>No new senders.</p>{{end}}
+
+<h3>Flagged messages older than {{.FlaggedOlderThanDays}} days</h3>
+{{if .Flagged}}<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Received</th><th>From</th><th>Subject</th><th>Folder</th></tr>
+{{range .Flagged}}<tr><td>{{.ReceivedAt.Format "2006-01-02"}}</td><td>{{.From}}</td><td>{{.Subject}}</td><td>{{.MailBoxFolder}}</td></tr>
+{{end}}</table>{{else}}<p>No old flagged messages.</p>{{end}}
+
+{{if .UndoCommand}}<h3>Undo</h3>
+<p>Move the messages of this run back with <code>{{.UndoCommand}}</code></p>{{end}}
+</body>
+</html>
+`))
+
+// BuildDigest reads the summary of the run from the database.
+func BuildDigest(run Run, cfg DigestConfig, now time.Time) (*Digest, error) {
+	d := &Digest{
+		Run:                  run,
+		GeneratedAt:          now,
+		NewSenderDays:        cfg.NewSenderDays,
+		FlaggedOlderThanDays: cfg.FlaggedOlderThanDays,
+	}
+	if d.NewSenderDays <= 0 {
+		d.NewSenderDays = defaultDigestNewSenderDays
+	}
+	if d.FlaggedOlderThanDays <= 0 {
+		d.FlaggedOlderThanDays = defaultDigestFlaggedOlderThanDays
+	}
+	topSenders := cfg.TopSenders
+	if topSenders <= 0 {
+		topSenders = defaultDigestTopSenders
+	}
+
+	err := GormDB.Model(&RunAction{}).
+		Select("account, rule, action, COUNT(*) AS count").
+		Where("run_id = ?", run.ID).
+		Group("account, rule, action").
+		Order("count DESC").
+		Scan(&d.Rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count the run actions with error %w", err)
+	}
+	for _, r := range d.Rules {
+		d.Total += r.Count
+		if !run.DryRun && r.Action != runActionPurge {
+			d.UndoCommand = fmt.Sprintf("outlook-cleaner undo %d", run.ID)
+		}
+	}
+
+	err = GormDB.Model(&Message{}).
+		Select(`"from" AS address, MAX(from_name) AS name, COUNT(*) AS count`).
+		Group(`"from"`).
+		Having("MIN(received_at) >= ?", now.AddDate(0, 0, -d.NewSenderDays)).
+		Order("count DESC").
+		Limit(topSenders).
+		Scan(&d.NewSenders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read new senders with error %w", err)
+	}
+
+	err = GormDB.
+		Where("is_flagged AND received_at < ?", now.AddDate(0, 0, -d.FlaggedOlderThanDays)).
+		Where("quarantined_at IS NULL AND remote_deleted_at = ?", time.Time{}).
+		Order("received_at").
+		Limit(digestFlaggedLimit).
+		Find(&d.Flagged).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flagged messages with error %w", err)
+	}
+	return d, nil
+}
+
+// Subject is the subject line of the digest email.
+func (d *Digest) Subject() string {
+	s := fmt.Sprintf("outlookcleaner %s: %d messages moved", d.Run.Kind, d.Total)
+	if d.Run.DryRun {
+		s += " (dry run)"
+	}
+	return s
+}
+
+// Render writes the digest as an HTML document.
+func (d *Digest) Render(w io.Writer) error {
+	return digestTemplate.Execute(w, d)
+}
+
+// digestMessage formats the HTML body as an RFC 5322 message.
+func digestMessage(from string, to []string, subject string, body []byte, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.Write(bytes.ReplaceAll(bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n")))
+	return b.Bytes()
+}
+
+// sendMail delivers the message through the SMTP server of the digest config.
+func (cfg DigestConfig) sendMail(enc EncryptionConfig, msg []byte) error {
+	if cfg.SMTP.Hostname == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return fmt.Errorf("digest needs smtp.host, from and to or an output_file")
+	}
+	port := cfg.SMTP.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	var auth smtp.Auth
+	if cfg.SMTP.EncUser != "" {
+		username, err := Decrypt(cfg.SMTP.EncUser, enc.Secret, enc.Iv)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt smtp username with error %w", err)
+		}
+		password, err := Decrypt(cfg.SMTP.EncPassword, enc.Secret, enc.Iv)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt smtp password with error %w", err)
+		}
+		auth = smtp.PlainAuth("", username, password, cfg.SMTP.Hostname)
+	}
+	addr := net.JoinHostPort(cfg.SMTP.Hostname, strconv.Itoa(port))
+	if err := smtp.SendMail(addr, auth, cfg.From, cfg.To, msg); err != nil {
+		return fmt.Errorf("failed to send digest through %s with error %w", addr, err)
+	}
+	return nil
+}
+
+// SendDigest summarizes the run and sends it by email or writes it to the output file.
+func SendDigest(ctx context.Context, run *Run) error {
+	l := logger.GetLoggerFromContext(ctx).With("run", run.ID)
+	cfg := getConfig(ctx)
+	now := time.Now()
+	d, err := BuildDigest(*run, cfg.Digest, now)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err = d.Render(&body); err != nil {
+		return fmt.Errorf("failed to render digest with error %w", err)
+	}
+	if cfg.Digest.OutputFile != "" {
+		if err = os.WriteFile(cfg.Digest.OutputFile, body.Bytes(), 0o600); err != nil {
+			return fmt.Errorf("failed to write digest with error %w", err)
+		}
+		l.Info("wrote digest", "file", cfg.Digest.OutputFile)
+		return nil
+	}
+	msg := digestMessage(cfg.Digest.From, cfg.Digest.To, d.Subject(), body.Bytes(), now)
+	if err = cfg.Digest.sendMail(cfg.Encrypt, msg); err != nil {
+		return err
+	}
+	l.Info("sent digest", "to", cfg.Digest.To)
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeSMTPServer accepts one message and returns the DATA it received.
+func fakeSMTPServer(t *testing.T) (string, int, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tc := textproto.NewConn(conn)
+		_ = tc.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				_ = tc.PrintfLine("250 localhost")
+			case "DATA":
+				_ = tc.PrintfLine("354 end with .")
+				data, err := tc.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- string(data)
+				_ = tc.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tc.PrintfLine("221 bye")
+				return
+			default:
+				_ = tc.PrintfLine("250 ok")
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return host, portNum, received
+}
+
+func testDigest() *Digest {
+	return &Digest{
+		Run:         Run{Model: gorm.Model{ID: 7}, Kind: runKindPrune},
+		GeneratedAt: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+		Rules: []DigestRuleCount{
+			{Account: "me@example.com", Rule: "stale", Action: pruneActionArchive, Count: 12},
+			{Account: "me@example.com", Rule: "newsletters", Action: pruneActionDelete, Count: 3},
+		},
+		Total:         15,
+		NewSenderDays: 7,
+		NewSenders:    []DigestSender{{Address: "shop@example.com", Name: "Shop <Deals>", Count: 4}},
+		Flagged: []Message{{
+			From: "boss@example.com", Subject: "Q4 plan", MailBoxFolder: "INBOX",
+			ReceivedAt: time.Date(2023, 11, 2, 0, 0, 0, 0, time.UTC),
+		}},
+		FlaggedOlderThanDays: 30,
+		UndoCommand:          "outlook-cleaner undo 7",
+	}
+}
+
+func TestRenderDigest(t *testing.T) {
+	var b bytes.Buffer
+	if err := testDigest().Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	html := b.String()
+	for _, want := range []string{
+		"prune run #7",
+		"<td>stale</td><td>archive</td><td>12</td>",
+		"<td>newsletters</td><td>delete</td><td>3</td>",
+		"Shop &lt;Deals&gt; &lt;shop@example.com&gt;",
+		"Q4 plan",
+		"<code>outlook-cleaner undo 7</code>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("digest is missing %q", want)
+		}
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	cfg := DigestConfig{
+		From: "cleaner@example.com",
+		To:   []string{"me@example.com"},
+		SMTP: SMTPConfig{Hostname: host, Port: port},
+	}
+	d := testDigest()
+	var body bytes.Buffer
+	if err := d.Render(&body); err != nil {
+		t.Fatal(err)
+	}
+	msg := digestMessage(cfg.From, cfg.To, d.Subject(), body.Bytes(), d.GeneratedAt)
+	if err := cfg.sendMail(EncryptionConfig{}, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-received:
+		for _, want := range []string{
+			"Subject: outlookcleaner prune: 15 messages moved",
+			"Content-Type: text/html; charset=utf-8",
+			"outlook-cleaner undo 7",
+		} {
+			if !strings.Contains(data, want) {
+				t.Errorf("sent message is missing %q", want)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp server did not receive the digest")
+	}
+}
+
+func TestSendDigestRequiresRecipients(t *testing.T) {
+	cfg := DigestConfig{SMTP: SMTPConfig{Hostname: "localhost"}}
+	if err := cfg.sendMail(EncryptionConfig{}, nil); err == nil {
+		t.Error("expected an error without from and to")
+	}
+}
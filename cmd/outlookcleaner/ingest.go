@@ -13,6 +13,7 @@ import (
 	"github.com/raokrutarth/golang-playspace/pkg/logger"
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
@@ -25,6 +26,33 @@ import (
 // move folders: https://github.com/thedustin/go-email-curator/blob/54c33f2d542d4c20e8a72fc03a0d88fc9d118253/action/move.go
 // https://github.com/donomii/shonkr/blob/6261545c6d47c623fe6043fec2130f4129018e1f/v3/getmail.go
 
+// ingestedColumns are overwritten when a message is ingested again. Columns
+// owned by other commands, such as the quarantine and thread, are kept.
+//
+//nolint:gochecknoglobals // read only
+var ingestedColumns = []string{
+	"uid", "uid_validity", "seq_num", "from", "from_name", "to", "subject", "received_at",
+	"in_reply_to", "references", "account", "labels",
+	"size_bytes", "is_seen", "is_flagged", "is_receipt", "attachment_names", "updated_at",
+}
+
+// saveIngestedMessage adds the message or overwrites the ingested columns of its row.
+// A quarantined message keeps its folder, on label based servers it is read again
+// from all mail while it waits in quarantine.
+func saveIngestedMessage(record *Message) *gorm.DB {
+	table := Message{}.TableName()
+	updates := append(clause.AssignmentColumns(ingestedColumns), clause.Assignment{
+		Column: clause.Column{Name: "mail_box_folder"},
+		Value: gorm.Expr(fmt.Sprintf(
+			"CASE WHEN %[1]s.quarantined_at IS NULL THEN excluded.mail_box_folder ELSE %[1]s.mail_box_folder END", table,
+		)),
+	})
+	return GormDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}},
+		DoUpdates: updates,
+	}).Create(record)
+}
+
 type accountIngestor struct {
 	imapClient *client.Client
 }
@@ -133,16 +161,19 @@ func (ingestor *accountIngestor) fetchNewMessages(folders []string) (result []*i
 }
 
 func Ingest(ctx context.Context, connections []MailAccountConnection) error {
-	var err error
+	extractors, err := newReceiptExtractors(getConfig(ctx).Receipts.Extractors)
+	if err != nil {
+		return fmt.Errorf("invalid receipt extractors: %w", err)
+	}
 	for _, conn := range connections { // TODO iterate over accounts instead of connections since connects are flaky and need to be created anew each time
-		for _, mInfo := range conn.mailboxes {
+		for _, mInfo := range conn.ingestFolders() {
 			// FIXME: reset connection due to unknown timeout
 			// t="2024-08-12 03:09:51" level=ERROR s=main.go:56 msg="failed to ingest" cmd=ingest error="unable to ingest mailbox with error unable to select folder Inbox/personal/cashtrac with error User is authenticated but not connected."
 			conn.client, err = newIMAPClient(ctx, conn.accountConfig)
 			if err != nil {
 				return fmt.Errorf("unable to create new imap client with error %w", err)
 			}
-			err = ingestMailbox(ctx, conn, mInfo)
+			err = ingestMailbox(ctx, conn, extractors, mInfo)
 			if err != nil {
 				return fmt.Errorf("unable to ingest mailbox with error %w", err)
 			}
@@ -150,10 +181,15 @@ func Ingest(ctx context.Context, connections []MailAccountConnection) error {
 			time.Sleep(time.Second * 30)
 		}
 	}
+	if err = RebuildThreads(ctx); err != nil {
+		return fmt.Errorf("unable to rebuild threads with error %w", err)
+	}
 	return nil
 }
 
-func ingestMailbox(ctx context.Context, conn MailAccountConnection, mailboxInfo imap.MailboxInfo) error {
+func ingestMailbox(
+	ctx context.Context, conn MailAccountConnection, extractors *receiptExtractors, mailboxInfo imap.MailboxInfo,
+) error {
 	folderUnderUse := mailboxInfo.Name
 	l := logger.GetLoggerFromContext(ctx).With("folderName", folderUnderUse)
 	status, err := conn.client.Select(mailboxInfo.Name, true)
@@ -194,9 +230,17 @@ func ingestMailbox(ctx context.Context, conn MailAccountConnection, mailboxInfo
 			// imap.FetchBodyStructure,
 			// section.FetchItem(),
 		}
+		if conn.profile.UsesLabels {
+			items = append(items, gmailLabelsItem)
+		}
 		done <- conn.client.Fetch(seqSet, items, messages)
 	}()
 
+	type pendingParts struct {
+		record *Message
+		parts  []bodyPart
+	}
+	var pending []pendingParts
 	l.Info("processing messages", "numMessageIds", len(messageIDs))
 	for msg := range messages {
 		sl := l.With("messageID", msg.Uid, "subject", msg.Envelope.Subject)
@@ -208,16 +252,19 @@ func ingestMailbox(ctx context.Context, conn MailAccountConnection, mailboxInfo
 		)
 
 		var dbRecord *Message
-		dbRecord, err = messageToDBRecord(logger.ContextWithLogger(ctx, sl), msg)
+		var parts []bodyPart
+		dbRecord, parts, err = messageToDBRecord(logger.ContextWithLogger(ctx, sl), msg)
 		if err != nil {
 			sl.Error("failed to parse message with error", "error", err)
 			continue
 		}
 		dbRecord.MailBoxFolder = folderUnderUse
-		dbWriteResult := GormDB.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "message_id"}},
-			UpdateAll: true,
-		}).Create(dbRecord) // pass pointer of data to Create
+		dbRecord.UIDValidity = status.UidValidity
+		dbRecord.Account = conn.username
+		if conn.profile.UsesLabels {
+			dbRecord.Labels = strings.Join(parseGmailLabels(msg), "#")
+		}
+		dbWriteResult := saveIngestedMessage(dbRecord)
 
 		sl.Debug(
 			"wrote record to database",
@@ -229,17 +276,30 @@ func ingestMailbox(ctx context.Context, conn MailAccountConnection, mailboxInfo
 		if dbWriteResult.RowsAffected == 0 {
 			sl.Error("no record added to DB", "subject", msg.Envelope.Subject)
 		}
+		if len(parts) > 0 {
+			pending = append(pending, pendingParts{record: dbRecord, parts: parts})
+		}
 	}
 	if err = <-done; err != nil {
 		return fmt.Errorf("failed to fetch all messages with error: %w", err)
 	}
+
+	// the client is busy until the envelope fetch is done so the parts are read after it
+	l.Info("reading calendar and receipt parts", "numMessages", len(pending))
+	for _, p := range pending {
+		if err = ingestBodyParts(ctx, conn, extractors, p.record, p.parts); err != nil {
+			l.Warn("failed to ingest message parts", "messageID", p.record.MessageID, "error", err)
+		}
+	}
 	l.Info("finished processing messages", "numMessageIds", len(messageIDs))
 	return nil
 }
 
-func messageToDBRecord(ctx context.Context, msg *imap.Message) (*Message, error) {
+// messageToDBRecord converts the fetched envelope into a database row. It also returns
+// the calendar and receipt text parts of the message that need to be fetched separately.
+func messageToDBRecord(ctx context.Context, msg *imap.Message) (*Message, []bodyPart, error) {
 	if msg == nil {
-		return nil, fmt.Errorf("message is nil")
+		return nil, nil, fmt.Errorf("message is nil")
 	}
 	l := logger.GetLoggerFromContext(ctx)
 
@@ -280,6 +340,13 @@ func messageToDBRecord(ctx context.Context, msg *imap.Message) (*Message, error)
 	}, msg.Envelope.Subject)
 	dbRecord.SizeBytes = msg.Size
 	dbRecord.UID = msg.Uid
+
+	inReplyTo, references, err := parseThreadHeaders(msg)
+	if err != nil {
+		l.Warn("failed to parse threading headers", "error", err)
+	}
+	dbRecord.InReplyTo = inReplyTo
+	dbRecord.References = strings.Join(references, " ")
 	dbRecord.SeqNum = msg.SeqNum
 
 	if _, isSeen := lo.Find(msg.Flags, func(f string) bool {
@@ -304,10 +371,18 @@ func messageToDBRecord(ctx context.Context, msg *imap.Message) (*Message, error)
 		dbRecord.IsReceipt = true
 	}
 
-	// get attachment names
+	// get attachment names and the parts to read after the envelope
 	var attachments []string
+	var parts []bodyPart
 	msg.BodyStructure.Walk(func(path []int, part *imap.BodyStructure) bool {
-		if !strings.EqualFold(part.Disposition, "attachment") {
+		isAttachment := strings.EqualFold(part.Disposition, "attachment")
+		switch p := newBodyPart(path, part); {
+		case p.mimeType == "text/calendar":
+			parts = append(parts, p)
+		case dbRecord.IsReceipt && !isAttachment && (p.mimeType == "text/plain" || p.mimeType == "text/html"):
+			parts = append(parts, p)
+		}
+		if !isAttachment {
 			return true
 		}
 		filename, _ := part.Filename()
@@ -364,5 +439,62 @@ func messageToDBRecord(ctx context.Context, msg *imap.Message) (*Message, error)
 	// 	}
 	// }
 
-	return dbRecord, nil
+	return dbRecord, parts, nil
+}
+
+// ingestBodyParts saves the calendar events and the receipt found in the parts of the message.
+func ingestBodyParts(
+	ctx context.Context, conn MailAccountConnection, extractors *receiptExtractors, record *Message, parts []bodyPart,
+) error {
+	l := logger.GetLoggerFromContext(ctx).With("messageID", record.MessageID)
+	if record.ID == 0 {
+		if err := GormDB.Where("message_id = ?", record.MessageID).Select("id").First(record).Error; err != nil {
+			return fmt.Errorf("failed to read message row with error %w", err)
+		}
+	}
+	contents, err := fetchBodyParts(conn.client, record.UID, parts)
+	if err != nil {
+		return err
+	}
+
+	events := []CalendarEvent{}
+	plainText, htmlText := "", ""
+	for i, p := range parts {
+		switch p.mimeType {
+		case "text/calendar":
+			partEvents, err := parseICS(contents[i])
+			if err != nil {
+				l.Warn("failed to parse calendar part", "path", p.path, "error", err)
+				continue
+			}
+			events = append(events, partEvents...)
+		case "text/plain":
+			plainText += contents[i] + "\n"
+		case "text/html":
+			htmlText += htmlToText(contents[i]) + "\n"
+		}
+	}
+	if err = saveCalendarEvents(GormDB, record.ID, events); err != nil {
+		return fmt.Errorf("failed to save calendar events with error %w", err)
+	}
+	if len(events) > 0 {
+		l.Debug("saved calendar events", "numEvents", len(events))
+	}
+
+	if !record.IsReceipt {
+		return nil
+	}
+	text := plainText
+	if strings.TrimSpace(text) == "" {
+		text = htmlText
+	}
+	receipt, ok := extractors.Extract(record, text)
+	if !ok {
+		l.Debug("no receipt details found", "from", record.From)
+		return nil
+	}
+	if err = saveReceipt(GormDB, record.ID, receipt); err != nil {
+		return fmt.Errorf("failed to save receipt with error %w", err)
+	}
+	return nil
 }
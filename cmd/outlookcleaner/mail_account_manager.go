@@ -16,6 +16,8 @@ type MailAccountConnection struct {
 	mailboxes     []imap.MailboxInfo
 	username      string
 	accountConfig MailAccountConfig
+	profile       ProviderProfile
+	folders       SpecialFolders
 	startedAt     time.Time
 }
 
@@ -32,6 +34,10 @@ func NewMailAccountConnections(ctx context.Context) ([]MailAccountConnection, er
 	connections := []MailAccountConnection{}
 	for _, account := range c.Mail.Accounts {
 		sl := l.With("encUsername", account.EncUser)
+		profile, err := profileForAccount(account)
+		if err != nil {
+			return nil, err
+		}
 		imapClient, err := newIMAPClient(ctx, account)
 		if err != nil {
 			return nil, fmt.Errorf("unable to initialize imap client with error: %w", err)
@@ -46,6 +52,15 @@ func NewMailAccountConnections(ctx context.Context) ([]MailAccountConnection, er
 		}
 		sl.Info("listed folders in the mailbox", "folders", folderNames)
 
+		if hasSpecialUse, _ := imapClient.Support("SPECIAL-USE"); !hasSpecialUse {
+			sl.Warn("server does not advertise SPECIAL-USE, using provider folder names", "provider", profile.Provider)
+		}
+		specialFolders := resolveSpecialFolders(profile, folders, account.SpecialFolders)
+		sl.Info("resolved special folders", "provider", profile.Provider, "folders", specialFolders)
+		if specialFolders.Archive == "" {
+			sl.Warn("no archive folder found for account, archiving is disabled")
+		}
+
 		// validate the configs
 		for _, fn := range account.Ingest.Folders {
 			if !slices.Contains(folderNames, fn) {
@@ -57,11 +72,18 @@ func NewMailAccountConnections(ctx context.Context) ([]MailAccountConnection, er
 				return []MailAccountConnection{}, fmt.Errorf("folder %s does not exist for account %s to prune", fn, account.EncUser)
 			}
 		}
+		for _, rule := range account.pruneRules() {
+			if err = validatePruneRule(rule, folderNames); err != nil {
+				return []MailAccountConnection{}, fmt.Errorf("invalid prune rule for account %s: %w", account.EncUser, err)
+			}
+		}
 		connections = append(connections, MailAccountConnection{
 			client:        imapClient,
 			username:      account.EncUser,
 			mailboxes:     folders,
 			accountConfig: account,
+			profile:       profile,
+			folders:       specialFolders,
 			startedAt:     time.Now(),
 		})
 	}
@@ -78,7 +100,10 @@ func newIMAPClient(ctx context.Context, account MailAccountConfig) (*client.Clie
 	decryptionIv := c.Encrypt.Iv
 	l.Info("identified credential decryption keys", "lenDecryptionKey", len(decryptionKey), "lenIv", len(decryptionIv))
 
-	var err error
+	profile, err := profileForAccount(account)
+	if err != nil {
+		return nil, err
+	}
 	var username, password string
 	username, err = Decrypt(account.EncUser, decryptionKey, decryptionIv)
 	if err != nil {
@@ -90,12 +115,13 @@ func newIMAPClient(ctx context.Context, account MailAccountConfig) (*client.Clie
 		return nil, fmt.Errorf("unable to decrypt password with error %w", err)
 	}
 	sl.Info("decrypted imap credentials", "lenPwd", len(password))
-	imapClient, tlsErr := client.DialTLS(fmt.Sprintf("%s:%d", account.Hostname, account.Port), nil)
+	address := profile.serverAddress(account)
+	imapClient, tlsErr := client.DialTLS(address, nil)
 	if tlsErr != nil {
-		return nil, fmt.Errorf("unable to connect to mail server %s with error %w", account.Hostname, tlsErr)
+		return nil, fmt.Errorf("unable to connect to mail server %s with error %w", address, tlsErr)
 	}
 	if err = imapClient.Login(username, password); err != nil {
-		return nil, fmt.Errorf("unable to login to host %s with error %w", account.Hostname, err)
+		return nil, fmt.Errorf("unable to login to host %s with error %w", address, err)
 	}
 	sl.Info("successfully logged into account")
 	return imapClient, nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -13,12 +14,13 @@ type Mailbox struct {
 	status *imap.MailboxStatus
 	info   *imap.MailboxInfo
 	client *client.Client
+	conn   *MailAccountConnection
 }
 
 // CAUTION: this selects the mailbox folder on the client globally.
-func NewMailbox(info *imap.MailboxInfo, imapClient *client.Client) (*Mailbox, error) {
+func NewMailbox(info *imap.MailboxInfo, conn *MailAccountConnection) (*Mailbox, error) {
 	// TODO get DB row
-	mailboxStatus, err := imapClient.Select(info.Name, false)
+	mailboxStatus, err := conn.client.Select(info.Name, false)
 	if err != nil {
 		log.Err(err).Str("folder", info.Name).Msgf("Unable to select folder")
 		return nil, fmt.Errorf("unable to select inbox %s", info.Name)
@@ -27,7 +29,8 @@ func NewMailbox(info *imap.MailboxInfo, imapClient *client.Client) (*Mailbox, er
 	return &Mailbox{
 		status: mailboxStatus,
 		info:   info,
-		client: imapClient,
+		client: conn.client,
+		conn:   conn,
 	}, nil
 }
 
@@ -35,51 +38,14 @@ func (mbox *Mailbox) getLastScrapeTime() time.Time {
 	return time.Now().Add(-5 * 365 * 24 * time.Hour)
 }
 
-// store store status without login
-func (mbox *Mailbox) storeStatus(mailBox string, mID uint32, isAdd bool, flags []interface{}) error {
-
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(mID)
-
-	var opt imap.FlagsOp
-	if isAdd {
-		opt = imap.AddFlags
-	} else {
-		opt = imap.RemoveFlags
-	}
-
-	item := imap.FormatFlagsOp(opt, true)
-
-	return mbox.client.Store(seqSet, item, flags, nil)
-}
-
 // SetRead set read status
 // func (mbox *Mailbox) SetRead(isRead bool) error {
 // 	return mbox.client.Store(m.Box, m.ID, isRead, []interface{}{imap.SeenFlag})
 // }
 
-// DeleteMail delete one mail
-func (mbox *Mailbox) DeleteMail(mailBox string, mID uint32) error {
-	// First mark the message as deleted
-	if err := mbox.storeStatus(mailBox, mID, true, []interface{}{imap.DeletedFlag}); err != nil {
-		return err
-	}
-
-	// Then delete it
-	deletedCh := make(chan uint32)
-	err := mbox.client.Expunge(deletedCh)
-	go func() {
-		defer close(deletedCh)
-		for mid := range deletedCh {
-			log.Info().Msgf("Deleted message with ID %d", mid)
-		}
-	}()
-	return err
-}
-
 func (mbox *Mailbox) GetUnReadMailIDs(mailBox string) ([]uint32, error) {
 	if len(mailBox) == 0 {
-		mailBox = "INBOX"
+		mailBox = mbox.conn.folders.Inbox
 	}
 
 	// Select mail box
@@ -166,26 +132,19 @@ func (mbox *Mailbox) GetUnReadMailIDs(mailBox string) ([]uint32, error) {
 // 	return nil
 // }
 
-func (mbox *Mailbox) DeleteMessages(
-	// msg *imap.Message,
-	uid uint32,
-	c *client.Client,
-) error {
-
-	var (
-		deleteFlagItem = imap.FormatFlagsOp(imap.AddFlags, true)
-		deleteFlag     = []interface{}{imap.DeletedFlag}
-	)
-	seqset := new(imap.SeqSet)
-	// seqset.AddNum(msg.Uid)
-	seqset.AddNum(uid)
-	err := c.UidStore(seqset, deleteFlagItem, deleteFlag, nil)
-
+// DeleteMessages moves the messages to the quarantine folder. They are expunged by purge
+// once the quarantine period is over.
+func (mbox *Mailbox) DeleteMessages(ctx context.Context, uids ...uint32) error {
+	var messages []Message
+	err := GormDB.Where("account = ? AND mail_box_folder = ? AND uid IN ?", mbox.conn.username, mbox.info.Name, uids).
+		Find(&messages).Error
 	if err != nil {
-		return fmt.Errorf("mark as deleted failed: %w", err)
+		return fmt.Errorf("failed to read messages to delete: %w", err)
 	}
-
-	return nil
+	if len(messages) != len(uids) {
+		return fmt.Errorf("found %d of %d messages in the database, ingest %s first", len(messages), len(uids), mbox.info.Name)
+	}
+	return mbox.conn.Delete(ctx, mbox.info.Name, messages)
 }
 
 // func (a ActionMove) Perform(msg *imap.Message, c *client.Client) error {
@@ -201,43 +160,12 @@ func (mbox *Mailbox) DeleteMessages(
 // 	return nil
 // }
 
-func (mbox *Mailbox) MoveMessages(
-	uid uint32,
-	c *client.Client,
-) error {
-
-	/*
-		seqset1 := new(imap.SeqSet)
-		seqset1.AddNum(uids...)
-
-		err := mbox.client.UidMove(seqset1, "z-archive")
-		if err != nil {
-			log.Err(err).Msg("archive of messages failed with error")
-		}
-
-		for i, mid := range toDel {
-			log.Info().Msgf("deleting message %d with id %s in mailbox %s",
-				i, mid, mbox.info.Name)
-			// if err := DeleteMessage(mid, mbox.client); err != nil {
-			// 	log.Err(err).Msgf("deletion of message with ID %d failed with error", mid)
-			// }
-
-			if err := MoveMessage(mid, mbox.client); err != nil {
-				log.Err(err).Msgf("archive of message with ID %d failed with error", mid)
-			}
-		}
-
-	*/
-
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(uid)
-
-	err := c.UidMove(seqset, "z-archive")
-
-	if err != nil {
-		return fmt.Errorf("move message failed: %w", err)
+// ArchiveMessages moves the messages to the account's archive folder
+// following the provider's archive semantics.
+func (mbox *Mailbox) ArchiveMessages(uids ...uint32) error {
+	if err := mbox.conn.archiveUIDs(mbox.info.Name, uids); err != nil {
+		return fmt.Errorf("archive of messages failed: %w", err)
 	}
-
 	return nil
 }
 
@@ -265,12 +193,6 @@ func (mbox *Mailbox) getMessageIdsInWindow(start, end time.Time) []uint32 {
 	return uids
 }
 
-func (mbox *Mailbox) Cleanup() {
-	if err := mbox.client.Expunge(nil); err != nil {
-		log.Err(err).Msgf("Mailbox cleanup for mailbox %s failed with error", mbox.info.Name)
-	}
-}
-
 /*
 
 
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/raokrutarth/golang-playspace/pkg/logger"
 	"github.com/spf13/cobra"
@@ -58,24 +60,266 @@ func main() {
 		},
 	}
 
-	// var cmdPrune = &cobra.Command{
-	// 	Use:   "prune",
-	// 	Short: "Move stale and read emails from the 'prune' folders to a review folder.",
-	// 	Run: func(cmd *cobra.Command, args []string) {
-	// 		log.Info("Running prune with command args")
-	// 		CustomPrune()
-	// 	},
-	// }
-	// add single folder cli flag to use during debugging
+	var dryRun, pruneDigest bool
+	var cmdPrune = &cobra.Command{
+		Use:   "prune",
+		Short: "Archive the ingested messages matched by the prune rules of each account.",
+		Run: func(cmd *cobra.Command, args []string) {
+			sl := l.With("cmd", cmd.Name())
+			sl.Info("running prune", "args", args, "dryRun", dryRun)
+			connections, err := NewMailAccountConnections(ctx)
+			if err != nil {
+				l.Error("failed to get account connection", "error", err)
+				os.Exit(1)
+			}
+			defer func() {
+				for _, c := range connections {
+					if err = c.client.Logout(); err != nil {
+						sl.Error("failed logout", "username", c.username, "error", err)
+					}
+				}
+			}()
+			run, err := startRun(runKindPrune, dryRun)
+			if err != nil {
+				sl.Error("failed to start run", "error", err)
+				return
+			}
+			if err = Prune(ctx, run, connections, dryRun); err != nil {
+				sl.Error("failed to prune", "error", err)
+			}
+			if err = run.finish(); err != nil {
+				sl.Error("failed to finish run", "run", run.ID, "error", err)
+			}
+			if !pruneDigest {
+				return
+			}
+			if err = SendDigest(ctx, run); err != nil {
+				sl.Error("failed to send digest", "run", run.ID, "error", err)
+			}
+		},
+	}
+	cmdPrune.Flags().BoolVar(&dryRun, "dry-run", false, "only log the number of messages each rule would archive")
+	cmdPrune.Flags().BoolVar(&pruneDigest, "digest", false, "send the digest of the run when done")
 
-	// TODO: add un-prune command
+	var purgeDryRun, purgeDigest bool
+	var cmdPurge = &cobra.Command{
+		Use:   "purge",
+		Short: "Expunge the messages that stayed in the quarantine folder longer than the retention period.",
+		Run: func(cmd *cobra.Command, args []string) {
+			sl := l.With("cmd", cmd.Name())
+			sl.Info("running purge", "args", args, "dryRun", purgeDryRun)
+			connections, err := NewMailAccountConnections(ctx)
+			if err != nil {
+				l.Error("failed to get account connection", "error", err)
+				os.Exit(1)
+			}
+			defer func() {
+				for _, c := range connections {
+					if err = c.client.Logout(); err != nil {
+						sl.Error("failed logout", "username", c.username, "error", err)
+					}
+				}
+			}()
+			run, err := startRun(runKindPurge, purgeDryRun)
+			if err != nil {
+				sl.Error("failed to start run", "error", err)
+				return
+			}
+			for i := range connections {
+				if err = connections[i].Purge(ctx, run, time.Now(), purgeDryRun); err != nil {
+					sl.Error("failed to purge", "username", connections[i].username, "error", err)
+				}
+			}
+			if err = run.finish(); err != nil {
+				sl.Error("failed to finish run", "run", run.ID, "error", err)
+			}
+			if !purgeDigest {
+				return
+			}
+			if err = SendDigest(ctx, run); err != nil {
+				sl.Error("failed to send digest", "run", run.ID, "error", err)
+			}
+		},
+	}
+	cmdPurge.Flags().BoolVar(&purgeDryRun, "dry-run", false, "only log the number of messages that would be expunged")
+	cmdPurge.Flags().BoolVar(&purgeDigest, "digest", false, "send the digest of the run when done")
+
+	var cmdRestore = &cobra.Command{
+		Use:   "restore [message-id...]",
+		Short: "Move quarantined messages back to the folder they were deleted from. Restores all when no IDs are given.",
+		Run: func(cmd *cobra.Command, args []string) {
+			sl := l.With("cmd", cmd.Name())
+			sl.Info("running restore", "args", args)
+			connections, err := NewMailAccountConnections(ctx)
+			if err != nil {
+				l.Error("failed to get account connection", "error", err)
+				os.Exit(1)
+			}
+			defer func() {
+				for _, c := range connections {
+					if err = c.client.Logout(); err != nil {
+						sl.Error("failed logout", "username", c.username, "error", err)
+					}
+				}
+			}()
+			for i := range connections {
+				if _, err = connections[i].Restore(ctx, args); err != nil {
+					sl.Error("failed to restore", "username", connections[i].username, "error", err)
+				}
+			}
+		},
+	}
+
+	var cmdUndo = &cobra.Command{
+		Use:   "undo <run-id>",
+		Short: "Move the messages archived or deleted by a prune run back to their folders.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sl := l.With("cmd", cmd.Name())
+			runID, err := strconv.ParseUint(args[0], 10, 0)
+			if err != nil {
+				sl.Error("invalid run id", "error", err)
+				os.Exit(1)
+			}
+			sl.Info("running undo", "run", runID)
+			connections, err := NewMailAccountConnections(ctx)
+			if err != nil {
+				l.Error("failed to get account connection", "error", err)
+				os.Exit(1)
+			}
+			defer func() {
+				for _, c := range connections {
+					if err = c.client.Logout(); err != nil {
+						sl.Error("failed logout", "username", c.username, "error", err)
+					}
+				}
+			}()
+			if err = Undo(ctx, connections, uint(runID)); err != nil {
+				sl.Error("failed to undo", "run", runID, "error", err)
+			}
+		},
+	}
+
+	var cmdDigest = &cobra.Command{
+		Use:   "digest [run-id]",
+		Short: "Send the digest of a prune or purge run. Defaults to the latest run.",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sl := l.With("cmd", cmd.Name())
+			var run *Run
+			if len(args) == 0 {
+				var err error
+				if run, err = LatestRun(""); err != nil {
+					sl.Error("failed to find a run", "error", err)
+					os.Exit(1)
+				}
+			} else {
+				runID, err := strconv.ParseUint(args[0], 10, 0)
+				if err != nil {
+					sl.Error("invalid run id", "error", err)
+					os.Exit(1)
+				}
+				run = &Run{}
+				if err = GormDB.First(run, runID).Error; err != nil {
+					sl.Error("failed to find the run", "run", runID, "error", err)
+					os.Exit(1)
+				}
+			}
+			if err := SendDigest(ctx, run); err != nil {
+				sl.Error("failed to send digest", "run", run.ID, "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	var receiptsCSV bool
+	var receiptsOutput, receiptsSince string
+	var cmdReceipts = &cobra.Command{
+		Use:   "receipts",
+		Short: "Work with the receipts extracted from the ingested messages.",
+	}
+	var cmdReceiptsExport = &cobra.Command{
+		Use:   "export",
+		Short: "Export the extracted receipts for expense reporting.",
+		Run: func(cmd *cobra.Command, _ []string) {
+			sl := l.With("cmd", cmd.Name())
+			if !receiptsCSV {
+				sl.Error("an export format is required, use --csv")
+				os.Exit(1)
+			}
+			since := time.Time{}
+			if receiptsSince != "" {
+				var err error
+				if since, err = time.Parse(time.DateOnly, receiptsSince); err != nil {
+					sl.Error("invalid --since date", "error", err)
+					os.Exit(1)
+				}
+			}
+			out := os.Stdout
+			if receiptsOutput != "" {
+				f, err := os.Create(receiptsOutput)
+				if err != nil {
+					sl.Error("failed to create output file", "error", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				out = f
+			}
+			if err := ExportReceiptsCSV(out, since); err != nil {
+				sl.Error("failed to export receipts", "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmdReceiptsExport.Flags().BoolVar(&receiptsCSV, "csv", false, "write the receipts as CSV")
+	cmdReceiptsExport.Flags().StringVarP(&receiptsOutput, "output", "o", "", "file to write to (default stdout)")
+	cmdReceiptsExport.Flags().StringVar(&receiptsSince, "since", "", "only export receipts on or after the date (YYYY-MM-DD)")
+	cmdReceipts.AddCommand(cmdReceiptsExport)
+
+	var threadsLimit int
+	var rebuildThreads bool
+	var cmdThreads = &cobra.Command{
+		Use:   "threads",
+		Short: "Show the largest and most recent conversations in the ingested messages.",
+		Run: func(cmd *cobra.Command, _ []string) {
+			sl := l.With("cmd", cmd.Name())
+			if rebuildThreads {
+				if err := RebuildThreads(ctx); err != nil {
+					sl.Error("failed to rebuild threads", "error", err)
+					os.Exit(1)
+				}
+			}
+			for _, view := range []struct {
+				title   string
+				orderBy string
+			}{
+				{title: "Largest conversations", orderBy: "message_count"},
+				{title: "Most recent conversations", orderBy: "last_received_at"},
+			} {
+				threads, err := ListThreads(view.orderBy, threadsLimit)
+				if err != nil {
+					sl.Error("failed to list threads", "error", err)
+					os.Exit(1)
+				}
+				printThreads(os.Stdout, view.title, threads)
+			}
+		},
+	}
+	cmdThreads.Flags().IntVar(&threadsLimit, "limit", 10, "number of threads to show per list")
+	cmdThreads.Flags().BoolVar(&rebuildThreads, "rebuild", false, "rebuild the threads from the ingested messages first")
 
 	var rootCmd = &cobra.Command{Use: "outlook-cleaner"}
 	rootCmd.AddCommand(
 		cmdAuthInit,
 		authValidate,
 		cmdIngest,
-		// cmdPrune
+		cmdPrune,
+		cmdPurge,
+		cmdRestore,
+		cmdUndo,
+		cmdDigest,
+		cmdReceipts,
+		cmdThreads,
 	)
 	if err := rootCmd.Execute(); err != nil {
 		l.Error("failed to execute root command", "error", err)
@@ -3,15 +3,106 @@ package main
 import (
 	"errors"
 	"fmt"
+	"html"
 	"io"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset" // decode non utf-8 parts
 	"github.com/emersion/go-message/mail"
 	"github.com/ozgio/strutil"
 	"github.com/rs/zerolog/log"
 )
 
+//nolint:gochecknoglobals // compiled once
+var (
+	htmlHiddenRe     = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	htmlTagRe        = regexp.MustCompile(`(?s)<[^>]*>`)
+	repeatedSpacesRe = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+// bodyPart is a part of a message picked during the BodyStructure walk to be
+// fetched after the envelope, e.g. a calendar invite or the text of a receipt.
+type bodyPart struct {
+	path     []int
+	mimeType string
+	params   map[string]string
+	encoding string
+}
+
+func newBodyPart(path []int, part *imap.BodyStructure) bodyPart {
+	return bodyPart{
+		path:     path,
+		mimeType: strings.ToLower(part.MIMEType + "/" + part.MIMESubType),
+		params:   part.Params,
+		encoding: part.Encoding,
+	}
+}
+
+// decode undoes the transfer encoding and charset of the fetched part.
+func (p bodyPart) decode(r io.Reader) (string, error) {
+	var h message.Header
+	h.SetContentType(p.mimeType, p.params)
+	if p.encoding != "" {
+		h.Set("Content-Transfer-Encoding", p.encoding)
+	}
+	entity, err := message.New(h, r)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return "", fmt.Errorf("failed to decode part %v with error %w", p.path, err)
+	}
+	b, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read part %v with error %w", p.path, err)
+	}
+	return string(b), nil
+}
+
+// fetchBodyParts downloads the parts of the message with BODY.PEEK so it is not marked as read.
+// The contents are returned in the order of the parts.
+func fetchBodyParts(imapClient *client.Client, uid uint32, parts []bodyPart) ([]string, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+	sections := make([]*imap.BodySectionName, len(parts))
+	items := []imap.FetchItem{imap.FetchUid}
+	for i, p := range parts {
+		sections[i] = &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: p.path}, Peek: true}
+		items = append(items, sections[i].FetchItem())
+	}
+
+	done := make(chan error, 1)
+	fetched := make(chan *imap.Message, 1)
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, fetched)
+	}()
+	contents := make([]string, len(parts))
+	var decodeErr error
+	for msg := range fetched {
+		for i, p := range parts {
+			literal := msg.GetBody(sections[i])
+			if literal == nil || decodeErr != nil {
+				continue
+			}
+			contents[i], decodeErr = p.decode(literal)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch message parts with error %w", err)
+	}
+	return contents, decodeErr
+}
+
+// htmlToText drops the markup of an html part so it can be searched like plain text.
+func htmlToText(s string) string {
+	s = htmlHiddenRe.ReplaceAllString(s, " ")
+	s = htmlTagRe.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	return strings.TrimSpace(repeatedSpacesRe.ReplaceAllString(s, " "))
+}
+
 type MessageBody struct {
 	MIMEType string
 	Message  string
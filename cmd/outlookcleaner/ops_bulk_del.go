@@ -29,7 +29,7 @@ func BulkDelete(from []string) {
 	defer accountMgr.client.Logout() // TODO find a better place for it
 
 	// reread inbox messages to get stats
-	folderUnderUse := "Inbox/INBOX"
+	folderUnderUse := accountMgr.folders.Inbox
 	mailboxInfo, ok = lo.Find(accountMgr.mailboxes, func(m imap.MailboxInfo) bool {
 		return m.Name == folderUnderUse
 	})
@@ -37,7 +37,7 @@ func BulkDelete(from []string) {
 		log.Error().Msg("Inbox folder not found")
 		return
 	}
-	mailbox, err = NewMailbox(&mailboxInfo, accountMgr.client)
+	mailbox, err = NewMailbox(&mailboxInfo, &accountMgr)
 	if err != nil {
 		log.Err(err).Msg("Failed to initalize mailbox for folder")
 		return
@@ -56,9 +56,9 @@ func BulkDelete(from []string) {
 
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(messageIDs...)
-	log.Info().Msgf("Moving %d messages to %s", len(messageIDs),
-		"Inbox/z-archive/to-delete")
-	err = mailbox.client.Move(seqSet, "Inbox/z-archive/to-delete")
+	destFolder := accountMgr.archiveSubfolder("to-delete")
+	log.Info().Msgf("Moving %d messages to %s", len(messageIDs), destFolder)
+	err = mailbox.client.Move(seqSet, destFolder)
 	if err != nil {
 		log.Err(err).Msg("Failed to move messages to archive folder.")
 		return
@@ -29,7 +29,7 @@ func CustomPrune() {
 	defer accountMgr.client.Logout() // TODO find a better place for it
 
 	// reread inbox messages to get stats
-	folderUnderUse = "Inbox/z-archive"
+	folderUnderUse = accountMgr.folders.Archive
 	mailboxInfo, ok = lo.Find(accountMgr.mailboxes, func(m imap.MailboxInfo) bool {
 		return m.Name == folderUnderUse
 	})
@@ -37,7 +37,7 @@ func CustomPrune() {
 		log.Error().Msg("Inbox folder not found")
 		return
 	}
-	mailbox, err = NewMailbox(&mailboxInfo, accountMgr.client)
+	mailbox, err = NewMailbox(&mailboxInfo, &accountMgr)
 	if err != nil {
 		log.Err(err).Msg("Failed to initalize mailbox for folder")
 		return
@@ -118,9 +118,9 @@ func CustomPrune() {
 		destFolder string
 	}{
 		// {ids: staleMessageIDs, destFolder: "Inbox/z-archive"},
-		{ids: flaggedMessageIDs, destFolder: "Inbox/z-archive/flagged"},
-		{ids: attachmentMessageIDs, destFolder: "Inbox/z-archive/has-attachment"},
-		{ids: receiptMessageIDs, destFolder: "Inbox/z-archive/receipt"},
+		{ids: flaggedMessageIDs, destFolder: accountMgr.archiveSubfolder("flagged")},
+		{ids: attachmentMessageIDs, destFolder: accountMgr.archiveSubfolder("has-attachment")},
+		{ids: receiptMessageIDs, destFolder: accountMgr.archiveSubfolder("receipt")},
 	}
 
 	for _, op := range ops {
@@ -163,7 +163,7 @@ func CustomPrune2() {
 	var messageIDs []uint32
 
 	// Move all incorrectly moved messages back to inbox
-	folderUnderUse = "Inbox/z-archive"
+	folderUnderUse = accountMgr.folders.Archive
 	mailboxInfo, ok = lo.Find(accountMgr.mailboxes, func(m imap.MailboxInfo) bool {
 		return m.Name == folderUnderUse
 	})
@@ -171,7 +171,7 @@ func CustomPrune2() {
 		log.Error().Msg("Inbox folder not found")
 		return
 	}
-	mailbox, err = NewMailbox(&mailboxInfo, accountMgr.client)
+	mailbox, err = NewMailbox(&mailboxInfo, &accountMgr)
 	if err != nil {
 		log.Err(err).Msg("Failed to initalize mailbox for folder")
 		return
@@ -189,7 +189,7 @@ func CustomPrune2() {
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(messageIDs...)
 	log.Info().Msg("Moving messages back to Inbox")
-	err = mailbox.client.Move(seqSet, "INBOX")
+	err = mailbox.client.Move(seqSet, accountMgr.folders.Inbox)
 	if err != nil {
 		log.Err(err).Msg("Failed to move messages back to inbox")
 		return
@@ -211,7 +211,7 @@ func CustomPrune1() {
 		return
 	}
 
-	folderUnderUse := "INBOX"
+	folderUnderUse := accountMgr.folders.Inbox
 
 	mailboxInfo, ok := lo.Find(accountMgr.mailboxes, func(m imap.MailboxInfo) bool {
 		return m.Name == folderUnderUse
@@ -221,7 +221,7 @@ func CustomPrune1() {
 		return
 	}
 
-	mailbox, err := NewMailbox(&mailboxInfo, accountMgr.client)
+	mailbox, err := NewMailbox(&mailboxInfo, &accountMgr)
 	if err != nil {
 		log.Err(err).Msg("Failed to initalize mailbox for folder")
 		return
@@ -250,7 +250,7 @@ func CustomPrune1() {
 	)
 
 	log.Info().Msg("Moving messages to review folder")
-	err = mailbox.client.Move(seqSet, "Inbox/z-archive")
+	err = mailbox.client.Move(seqSet, accountMgr.folders.Archive)
 	if err != nil {
 		log.Err(err).Msg("Failed to move messages")
 		return
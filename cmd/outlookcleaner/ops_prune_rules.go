@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/raokrutarth/golang-playspace/pkg/logger"
+	"github.com/samber/lo"
+)
+
+const (
+	pruneScopeMessage = "message"
+	pruneScopeThread  = "thread"
+
+	pruneActionArchive = "archive"
+	pruneActionDelete  = "delete"
+)
+
+// pruneRules returns the configured prune rules with the legacy prune
+// threshold and folders turned into a message scoped rule.
+func (account MailAccountConfig) pruneRules() []PruneRuleConfig {
+	rules := slices.Clone(account.PruneRules)
+	if len(account.Prune.Folders) > 0 && account.Prune.ThresholdDays > 0 {
+		rules = append(rules, PruneRuleConfig{
+			Name:          "stale",
+			Folders:       account.Prune.Folders,
+			OlderThanDays: account.Prune.ThresholdDays,
+			Scope:         pruneScopeMessage,
+			SkipFlagged:   true,
+		})
+	}
+	return rules
+}
+
+func validatePruneRule(rule PruneRuleConfig, folderNames []string) error {
+	if rule.Name == "" {
+		return fmt.Errorf("prune rule has no name")
+	}
+	if rule.OlderThanDays <= 0 {
+		return fmt.Errorf("prune rule %s must set older_than_days", rule.Name)
+	}
+	if !lo.Contains([]string{"", pruneScopeMessage, pruneScopeThread}, rule.Scope) {
+		return fmt.Errorf("prune rule %s has unknown scope %s", rule.Name, rule.Scope)
+	}
+	if !lo.Contains([]string{"", pruneActionArchive, pruneActionDelete}, rule.Action) {
+		return fmt.Errorf("prune rule %s has unknown action %s", rule.Name, rule.Action)
+	}
+	for _, fn := range rule.Folders {
+		if !slices.Contains(folderNames, fn) {
+			return fmt.Errorf("folder %s does not exist for prune rule %s", fn, rule.Name)
+		}
+	}
+	return nil
+}
+
+// pruneSource returns the folder the messages of the rule's folder were ingested from and
+// the labels they need. Label based servers are only ingested from all mail, so a folder is
+// the messages of all mail with its label, and only the messages in the inbox can be archived.
+func (conn *MailAccountConnection) pruneSource(folder string, rule PruneRuleConfig) (string, []string) {
+	if !conn.profile.UsesLabels || conn.folders.AllMail == "" {
+		return folder, nil
+	}
+	var labels []string
+	if folder != conn.folders.AllMail {
+		labels = append(labels, conn.folderLabel(folder))
+	}
+	if rule.Action != pruneActionDelete {
+		labels = append(labels, gmailInboxLabel)
+	}
+	return conn.folders.AllMail, lo.Uniq(labels)
+}
+
+// pruneCandidates returns the ingested messages in the folder with the labels that match the rule.
+func pruneCandidates(account, folder string, labels []string, rule PruneRuleConfig, now time.Time) ([]Message, error) {
+	cutoff := now.AddDate(0, 0, -rule.OlderThanDays)
+	q := GormDB.Where("account = ? AND mail_box_folder = ?", account, folder)
+	if rule.Scope == pruneScopeThread {
+		having := "MAX(received_at) < ?"
+		if rule.SkipFlagged {
+			having += " AND SUM(CASE WHEN is_flagged THEN 1 ELSE 0 END) = 0"
+		}
+		threads := GormDB.Model(&Message{}).Select("thread_id").
+			Where("account = ? AND quarantined_at IS NULL AND thread_id <> 0", account).
+			Group("thread_id").Having(having, cutoff)
+		q = q.Where("thread_id IN (?)", threads)
+	} else {
+		q = q.Where("received_at < ?", cutoff)
+		if rule.SkipFlagged {
+			q = q.Where("NOT is_flagged")
+		}
+	}
+	var messages []Message
+	if err := q.Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to query prune candidates with error %w", err)
+	}
+	if len(labels) > 0 {
+		messages = lo.Filter(messages, func(m Message, _ int) bool { return hasLabels(m, labels) })
+	}
+	return messages, nil
+}
+
+// checkUIDValidity refuses candidates whose UIDs were read under another UIDVALIDITY than the
+// selected folder has. The server may have given those UIDs to other messages since.
+func checkUIDValidity(folder string, uidValidity uint32, candidates []Message) error {
+	stale := lo.CountBy(candidates, func(m Message) bool { return m.UIDValidity != uidValidity })
+	if stale > 0 {
+		return fmt.Errorf(
+			"%d messages in %s were ingested with another UIDVALIDITY, ingest the folder again", stale, folder,
+		)
+	}
+	return nil
+}
+
+// Prune archives or deletes the messages matched by each account's prune rules and records
+// them in the run. Candidates are picked from the database so the mailbox needs to be ingested first.
+// A folder whose UIDVALIDITY changed since the ingest is not pruned.
+func Prune(ctx context.Context, run *Run, connections []MailAccountConnection, dryRun bool) error {
+	l := logger.GetLoggerFromContext(ctx)
+	now := time.Now()
+	for i := range connections {
+		conn := &connections[i]
+		for _, rule := range conn.accountConfig.pruneRules() {
+			for _, folder := range rule.Folders {
+				sl := l.With("rule", rule.Name, "folder", folder, "scope", rule.Scope, "action", rule.Action)
+				source, labels := conn.pruneSource(folder, rule)
+				candidates, err := pruneCandidates(conn.username, source, labels, rule, now)
+				if err != nil {
+					return err
+				}
+				sl.Info("found messages to prune", "numMessages", len(candidates), "dryRun", dryRun)
+				action, to := pruneActionArchive, conn.folders.Archive
+				switch {
+				case rule.Action == pruneActionDelete:
+					action, to = pruneActionDelete, conn.quarantineConfig().Folder
+				case conn.profile.UsesLabels && source == conn.folders.AllMail:
+					to = conn.folders.AllMail
+				case folder == conn.folders.Archive:
+					// already archived, nothing is moved
+					continue
+				}
+				if dryRun || len(candidates) == 0 {
+					if err = run.record(conn.username, rule.Name, action, source, to, candidates); err != nil {
+						return err
+					}
+					continue
+				}
+				status, err := conn.client.Select(source, false)
+				if err != nil {
+					return fmt.Errorf("unable to select folder %s with error %w", source, err)
+				}
+				if err = checkUIDValidity(source, status.UidValidity, candidates); err != nil {
+					return fmt.Errorf("prune rule %s aborted with error %w", rule.Name, err)
+				}
+				if action == pruneActionDelete {
+					if err = conn.Delete(ctx, source, candidates); err != nil {
+						return fmt.Errorf("prune rule %s failed with error %w", rule.Name, err)
+					}
+				} else if err = conn.archiveCandidates(source, to, candidates); err != nil {
+					return fmt.Errorf("prune rule %s failed with error %w", rule.Name, err)
+				}
+				if err = run.record(conn.username, rule.Name, action, source, to, candidates); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// archiveCandidates archives the messages from the selected folder and records where they went.
+func (conn *MailAccountConnection) archiveCandidates(folder, to string, candidates []Message) error {
+	uids := lo.Map(candidates, func(m Message, _ int) uint32 { return m.UID })
+	if err := conn.archiveUIDs(folder, uids); err != nil {
+		return err
+	}
+	if to == folder {
+		// archived by removing the inbox label, kept until the next ingest reads the labels again
+		for _, m := range candidates {
+			err := GormDB.Model(&Message{}).Where("id = ?", m.ID).Update("labels", withoutLabel(m, gmailInboxLabel)).Error
+			if err != nil {
+				return fmt.Errorf("failed to update the labels of pruned messages with error %w", err)
+			}
+		}
+		return nil
+	}
+	ids := lo.Map(candidates, func(m Message, _ int) uint { return m.ID })
+	// the UIDs in the archive are only known after it is ingested
+	moved := map[string]interface{}{"mail_box_folder": to, "uid_validity": 0}
+	if err := GormDB.Model(&Message{}).Where("id IN ?", ids).Updates(moved).Error; err != nil {
+		return fmt.Errorf("failed to update pruned messages with error %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/samber/lo"
+)
+
+func TestCheckUIDValidity(t *testing.T) {
+	tests := []struct {
+		name        string
+		uidValidity uint32
+		candidates  []Message
+		wantErr     bool
+	}{
+		{
+			name:        "same UIDVALIDITY",
+			uidValidity: 7,
+			candidates:  []Message{{UID: 1, UIDValidity: 7}, {UID: 2, UIDValidity: 7}},
+		},
+		{
+			name:        "no candidates",
+			uidValidity: 7,
+		},
+		{
+			name:        "folder was reset",
+			uidValidity: 8,
+			candidates:  []Message{{UID: 1, UIDValidity: 7}, {UID: 2, UIDValidity: 8}},
+			wantErr:     true,
+		},
+		{
+			name:        "ingested before UIDVALIDITY was stored",
+			uidValidity: 7,
+			candidates:  []Message{{UID: 1}},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUIDValidity("INBOX", tt.uidValidity, tt.candidates)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPruneCandidatesByLabel(t *testing.T) {
+	useTestDB(t)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	const allMail = "[Gmail]/All Mail"
+	for _, m := range []Message{
+		{MessageID: "<inbox>", Labels: `\Inbox#work`, ReceivedAt: now.AddDate(0, 0, -40)},
+		{MessageID: "<archived>", Labels: "work", ReceivedAt: now.AddDate(0, 0, -40)},
+		{MessageID: "<sent>", Labels: `\Sent`, ReceivedAt: now.AddDate(0, 0, -40)},
+		{MessageID: "<recent>", Labels: `\Inbox`, ReceivedAt: now.AddDate(0, 0, -1)},
+		{MessageID: "<other-account>", Account: "other@example.com", Labels: `\Inbox`, ReceivedAt: now.AddDate(0, 0, -40)},
+	} {
+		if m.Account == "" {
+			m.Account = "me@example.com"
+		}
+		m.MailBoxFolder = allMail
+		if err := GormDB.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := &MailAccountConnection{
+		username: "me@example.com",
+		mailboxes: []imap.MailboxInfo{
+			testMailbox("INBOX"), testMailbox("work"), testMailbox(allMail, imap.AllAttr),
+			testMailbox("[Gmail]/Sent Mail", imap.SentAttr),
+		},
+		profile: providerProfiles[ProviderGmail],
+		folders: SpecialFolders{Inbox: "INBOX", Archive: allMail, AllMail: allMail},
+	}
+	tests := []struct {
+		name   string
+		folder string
+		action string
+		want   []string
+	}{
+		{name: "archive the inbox", folder: "INBOX", want: []string{"<inbox>"}},
+		{name: "archive all mail", folder: allMail, want: []string{"<inbox>"}},
+		{name: "archive a label", folder: "work", want: []string{"<inbox>"}},
+		{name: "delete a label", folder: "work", action: pruneActionDelete, want: []string{"<inbox>", "<archived>"}},
+		{name: "delete sent mail", folder: "[Gmail]/Sent Mail", action: pruneActionDelete, want: []string{"<sent>"}},
+		{
+			name: "delete all mail", folder: allMail, action: pruneActionDelete,
+			want: []string{"<inbox>", "<archived>", "<sent>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := PruneRuleConfig{Name: tt.name, OlderThanDays: 30, Action: tt.action}
+			source, labels := conn.pruneSource(tt.folder, rule)
+			if source != allMail {
+				t.Fatalf("got source folder %s, want %s", source, allMail)
+			}
+			candidates, err := pruneCandidates(conn.username, source, labels, rule, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := lo.Map(candidates, func(m Message, _ int) string { return m.MessageID })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneCandidatesThreadScope(t *testing.T) {
+	useTestDB(t)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	quarantinedAt := sql.NullTime{Time: now.AddDate(0, 0, -2), Valid: true}
+	for _, m := range []Message{
+		{MessageID: "<old>", ThreadID: 1, ReceivedAt: now.AddDate(0, 0, -40)},
+		// recent messages of the thread in another account or in the quarantine do not keep it
+		{MessageID: "<other-account>", ThreadID: 1, Account: "other@example.com", ReceivedAt: now.AddDate(0, 0, -1)},
+		{
+			MessageID: "<quarantined>", ThreadID: 1, MailBoxFolder: "Archive/quarantine", QuarantinedAt: quarantinedAt,
+			ReceivedAt: now.AddDate(0, 0, -1),
+		},
+		{MessageID: "<active>", ThreadID: 2, ReceivedAt: now.AddDate(0, 0, -40)},
+		{MessageID: "<reply>", ThreadID: 2, ReceivedAt: now.AddDate(0, 0, -1)},
+	} {
+		if m.Account == "" {
+			m.Account = "me@example.com"
+		}
+		if m.MailBoxFolder == "" {
+			m.MailBoxFolder = "INBOX"
+		}
+		if err := GormDB.Create(&m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	rule := PruneRuleConfig{Name: "old threads", OlderThanDays: 30, Scope: pruneScopeThread}
+	candidates, err := pruneCandidates("me@example.com", "INBOX", nil, rule, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lo.Map(candidates, func(m Message, _ int) string { return m.MessageID })
+	if want := []string{"<old>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/raokrutarth/golang-playspace/pkg/logger"
+	"github.com/samber/lo"
+)
+
+// Deleting a message is a two step pipeline. Delete moves the message to the quarantine
+// folder and records the time in the database. Purge expunges the messages that stayed
+// in quarantine longer than the retention period and have a local copy. Until then
+// Restore moves them back to the folder they were deleted from.
+
+const defaultQuarantineRetentionDays = 30
+
+// quarantineConfig returns the quarantine settings of the account with the defaults applied.
+func (conn *MailAccountConnection) quarantineConfig() QuarantineConfig {
+	cfg := conn.accountConfig.Quarantine
+	if cfg.Folder == "" {
+		cfg.Folder = conn.archiveSubfolder("quarantine")
+	}
+	if cfg.RetentionDays <= 0 {
+		cfg.RetentionDays = defaultQuarantineRetentionDays
+	}
+	return cfg
+}
+
+func (conn *MailAccountConnection) hasFolder(name string) bool {
+	return lo.ContainsBy(conn.mailboxes, func(m imap.MailboxInfo) bool { return m.Name == name })
+}
+
+func (conn *MailAccountConnection) ensureFolder(name string) error {
+	if conn.hasFolder(name) {
+		return nil
+	}
+	if err := conn.client.Create(name); err != nil {
+		return fmt.Errorf("unable to create folder %s with error %w", name, err)
+	}
+	conn.mailboxes = append(conn.mailboxes, imap.MailboxInfo{Name: name})
+	return nil
+}
+
+// exportPath returns the file holding the exported copy of the message.
+func exportPath(dir, messageID string) string {
+	return filepath.Join(dir, fmt.Sprintf("%x.eml", sha256.Sum256([]byte(normalizeMessageID(messageID)))))
+}
+
+// exportMessages writes the full RFC822 content of the messages in the selected
+// folder to the export directory without marking them as read.
+func (conn *MailAccountConnection) exportMessages(dir string, messages []Message) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("unable to create export directory with error %w", err)
+	}
+	byUID := lo.KeyBy(messages, func(m Message) uint32 { return m.UID })
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(lo.Keys(byUID)...)
+
+	section := &imap.BodySectionName{Peek: true}
+	done := make(chan error, 1)
+	fetched := make(chan *imap.Message, 10)
+	go func() {
+		done <- conn.client.UidFetch(seqSet, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, fetched)
+	}()
+
+	var writeErr error
+	exported := 0
+	for msg := range fetched {
+		row, ok := byUID[msg.Uid]
+		body := msg.GetBody(section)
+		if !ok || body == nil || writeErr != nil {
+			continue
+		}
+		var content []byte
+		if content, writeErr = io.ReadAll(body); writeErr != nil {
+			continue
+		}
+		writeErr = os.WriteFile(exportPath(dir, row.MessageID), content, 0o600)
+		exported++
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch messages to export with error %w", err)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to export message with error %w", writeErr)
+	}
+	if exported != len(byUID) {
+		return fmt.Errorf("exported %d of %d messages", exported, len(byUID))
+	}
+	return nil
+}
+
+// Delete moves the messages from the selected folder to the quarantine folder, label based
+// servers swap the inbox label for the quarantine label instead. The messages need to be
+// ingested so the quarantine can be tracked.
+func (conn *MailAccountConnection) Delete(ctx context.Context, folder string, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	cfg := conn.quarantineConfig()
+	l := logger.GetLoggerFromContext(ctx).With("folder", folder, "quarantine", cfg.Folder)
+	if folder == cfg.Folder {
+		return fmt.Errorf("messages in %s are already quarantined", folder)
+	}
+	if err := conn.ensureFolder(cfg.Folder); err != nil {
+		return err
+	}
+	if cfg.ExportDir != "" {
+		if err := conn.exportMessages(cfg.ExportDir, messages); err != nil {
+			return err
+		}
+		l.Info("exported messages before quarantine", "numMessages", len(messages), "dir", cfg.ExportDir)
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(lo.Map(messages, func(m Message, _ int) uint32 { return m.UID })...)
+	if conn.profile.UsesLabels {
+		// moving a message out of all mail only adds the label, it would stay in the inbox
+		err := conn.client.UidStore(seqSet, imap.StoreItem("+"+gmailLabelsItem), []interface{}{cfg.Folder}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to add quarantine label with error %w", err)
+		}
+		err = conn.client.UidStore(
+			seqSet, imap.StoreItem("-"+gmailLabelsItem), []interface{}{imap.RawString(gmailInboxLabel)}, nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove inbox label with error %w", err)
+		}
+	} else if err := conn.client.UidMove(seqSet, cfg.Folder); err != nil {
+		return fmt.Errorf("failed to move messages to quarantine with error %w", err)
+	}
+	if err := conn.recordQuarantined(folder, messages, time.Now()); err != nil {
+		return err
+	}
+	l.Info("quarantined messages", "numMessages", len(messages))
+	return nil
+}
+
+// recordQuarantined records the messages moved from the folder to the quarantine. On label
+// based servers the messages that had the inbox label are restored to the inbox.
+func (conn *MailAccountConnection) recordQuarantined(folder string, messages []Message, now time.Time) error {
+	cfg := conn.quarantineConfig()
+	byFrom := lo.GroupBy(messages, func(m Message) string {
+		if conn.profile.UsesLabels && hasLabels(m, []string{gmailInboxLabel}) {
+			return conn.folders.Inbox
+		}
+		return folder
+	})
+	for from, group := range byFrom {
+		err := GormDB.Model(&Message{}).
+			Where("id IN ?", lo.Map(group, func(m Message, _ int) uint { return m.ID })).
+			Updates(map[string]interface{}{
+				"mail_box_folder":  cfg.Folder,
+				"quarantined_from": from,
+				"quarantined_at":   sql.NullTime{Time: now, Valid: true},
+				"uid_validity":     0,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to record quarantined messages with error %w", err)
+		}
+	}
+	return nil
+}
+
+// folderMessages fetches the messages in the selected folder and matches them
+// with their database rows by message ID.
+func (conn *MailAccountConnection) folderMessages() (map[uint32]Message, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(1, 0)
+	done := make(chan error, 1)
+	fetched := make(chan *imap.Message, 10)
+	go func() {
+		done <- conn.client.Fetch(seqSet, []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}, fetched)
+	}()
+	uidByMessageID := map[string]uint32{}
+	for msg := range fetched {
+		if msg.Envelope != nil && msg.Envelope.MessageId != "" {
+			uidByMessageID[msg.Envelope.MessageId] = msg.Uid
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch folder messages with error %w", err)
+	}
+	if len(uidByMessageID) == 0 {
+		return map[uint32]Message{}, nil
+	}
+
+	var rows []Message
+	err := GormDB.Where("account = ? AND message_id IN ?", conn.username, lo.Keys(uidByMessageID)).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read folder messages with error %w", err)
+	}
+	// messages that were not ingested keep an empty row
+	out := lo.SliceToMap(lo.Values(uidByMessageID), func(uid uint32) (uint32, Message) { return uid, Message{} })
+	for _, row := range rows {
+		out[uidByMessageID[row.MessageID]] = row
+	}
+	return out, nil
+}
+
+// hasLocalCopy reports if the message can be recovered without the mail server. The
+// database only keeps the headers so the exported .eml file is the only copy.
+func hasLocalCopy(cfg QuarantineConfig, row Message) bool {
+	if cfg.ExportDir == "" {
+		return false
+	}
+	info, err := os.Stat(exportPath(cfg.ExportDir, row.MessageID))
+	return err == nil && info.Size() > 0
+}
+
+// purgeCandidates picks the UIDs of the quarantined messages to purge in UID order and
+// counts the skipped messages by reason.
+func purgeCandidates(
+	cfg QuarantineConfig, quarantined map[uint32]Message, now time.Time,
+) ([]uint32, []Message, map[string]int) {
+	cutoff := now.AddDate(0, 0, -cfg.RetentionDays)
+	var uids []uint32
+	var purged []Message
+	skipped := map[string]int{}
+	keys := lo.Keys(quarantined)
+	slices.Sort(keys)
+	for _, uid := range keys {
+		switch row := quarantined[uid]; {
+		case row.ID == 0:
+			skipped["notIngested"]++
+		case !row.QuarantinedAt.Valid:
+			skipped["notQuarantined"]++
+		case row.QuarantinedAt.Time.After(cutoff):
+			skipped["inRetention"]++
+		case !hasLocalCopy(cfg, row):
+			skipped["noLocalCopy"]++
+		default:
+			uids = append(uids, uid)
+			purged = append(purged, row)
+		}
+	}
+	return uids, purged, skipped
+}
+
+// Purge permanently deletes the quarantined messages older than the retention period and
+// records them in the run. Messages without a database row, a quarantine timestamp or a
+// local copy are kept, and accounts without an export directory are not purged.
+func (conn *MailAccountConnection) Purge(ctx context.Context, run *Run, now time.Time, dryRun bool) error {
+	cfg := conn.quarantineConfig()
+	l := logger.GetLoggerFromContext(ctx).With("account", conn.username, "quarantine", cfg.Folder)
+	if cfg.ExportDir == "" {
+		return fmt.Errorf("refusing to purge account %s without quarantine export_dir", conn.username)
+	}
+	if !conn.hasFolder(cfg.Folder) {
+		l.Info("no quarantine folder, nothing to purge")
+		return nil
+	}
+	status, err := conn.client.Select(cfg.Folder, false)
+	if err != nil {
+		return fmt.Errorf("unable to select folder %s with error %w", cfg.Folder, err)
+	}
+	if status.Messages == 0 {
+		l.Info("quarantine is empty")
+		return nil
+	}
+	quarantined, err := conn.folderMessages()
+	if err != nil {
+		return err
+	}
+
+	uids, purged, skipped := purgeCandidates(cfg, quarantined, now)
+	skipped["noMessageID"] = int(status.Messages) - len(quarantined)
+	l.Info("found messages to purge", "numMessages", len(uids), "skipped", skipped, "dryRun", dryRun)
+	if dryRun || len(uids) == 0 {
+		return run.record(conn.username, purgeRuleName, runActionPurge, cfg.Folder, "", purged)
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	if conn.profile.UsesLabels {
+		// removing a gmail label does not delete the message, trash deletes it after 30 days
+		if err = conn.client.UidMove(seqSet, conn.folders.Trash); err != nil {
+			return fmt.Errorf("failed to move messages to trash with error %w", err)
+		}
+	} else {
+		err = conn.client.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil)
+		if err != nil {
+			return fmt.Errorf("mark as deleted failed: %w", err)
+		}
+		if err = conn.expungeUIDs(uids); err != nil {
+			return err
+		}
+	}
+	ids := lo.Map(purged, func(m Message, _ int) uint { return m.ID })
+	err = GormDB.Model(&Message{}).Where("id IN ?", ids).Update("remote_deleted_at", now).Error
+	if err != nil {
+		return fmt.Errorf("failed to record purged messages with error %w", err)
+	}
+	if err = run.record(conn.username, purgeRuleName, runActionPurge, cfg.Folder, "", purged); err != nil {
+		return err
+	}
+	l.Info("purged messages", "numMessages", len(uids))
+	return nil
+}
+
+// uidExpunge is the UID EXPUNGE command from UIDPLUS (RFC 4315), wrapped by commands.Uid.
+type uidExpunge struct {
+	seqSet *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.seqSet}}
+}
+
+// expungeUIDs removes only the given messages from the selected folder. Servers without
+// UIDPLUS expunge every message marked deleted so the expunge is refused when the
+// folder has other messages marked deleted.
+func (conn *MailAccountConnection) expungeUIDs(uids []uint32) error {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	if hasUIDPlus, _ := conn.client.Support("UIDPLUS"); hasUIDPlus {
+		status, err := conn.client.Execute(&commands.Uid{Cmd: &uidExpunge{seqSet: seqSet}}, nil)
+		if err != nil {
+			return fmt.Errorf("uid expunge failed: %w", err)
+		}
+		return status.Err()
+	}
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := conn.client.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search deleted messages with error %w", err)
+	}
+	if err = checkPlainExpunge(deleted, uids); err != nil {
+		return err
+	}
+	if err = conn.client.Expunge(nil); err != nil {
+		return fmt.Errorf("expunge failed: %w", err)
+	}
+	return nil
+}
+
+// checkPlainExpunge refuses an EXPUNGE without UIDPLUS when messages other than the
+// purged ones are marked deleted in the folder, the expunge would remove them too.
+func checkPlainExpunge(deleted, uids []uint32) error {
+	if others := lo.Without(deleted, uids...); len(others) > 0 {
+		return fmt.Errorf("refusing to expunge, %d other messages are marked deleted", len(others))
+	}
+	return nil
+}
+
+// Restore moves quarantined messages back to the folder they were deleted from.
+// All quarantined messages are restored when no message IDs are given. It returns the
+// Message-IDs of the restored messages.
+func (conn *MailAccountConnection) Restore(ctx context.Context, messageIDs []string) ([]string, error) {
+	cfg := conn.quarantineConfig()
+	l := logger.GetLoggerFromContext(ctx).With("account", conn.username, "quarantine", cfg.Folder)
+	if !conn.hasFolder(cfg.Folder) {
+		return nil, nil
+	}
+	if _, err := conn.client.Select(cfg.Folder, false); err != nil {
+		return nil, fmt.Errorf("unable to select folder %s with error %w", cfg.Folder, err)
+	}
+	quarantined, err := conn.folderMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	byFolder := map[string][]uint32{}
+	idsByFolder := map[string][]uint{}
+	messageIDsByFolder := map[string][]string{}
+	for uid, row := range quarantined {
+		if row.QuarantinedFrom == "" || (len(messageIDs) > 0 && !lo.Contains(messageIDs, row.MessageID)) {
+			continue
+		}
+		byFolder[row.QuarantinedFrom] = append(byFolder[row.QuarantinedFrom], uid)
+		idsByFolder[row.QuarantinedFrom] = append(idsByFolder[row.QuarantinedFrom], row.ID)
+		messageIDsByFolder[row.QuarantinedFrom] = append(messageIDsByFolder[row.QuarantinedFrom], row.MessageID)
+	}
+	restored := []string{}
+	for folder, uids := range byFolder {
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uids...)
+		restoredTo := folder
+		if conn.profile.UsesLabels {
+			if err = conn.restoreLabels(seqSet, cfg.Folder, folder); err != nil {
+				return restored, err
+			}
+			// the message never left all mail, which is where it is ingested from
+			restoredTo = conn.folders.AllMail
+		} else if err = conn.client.UidMove(seqSet, folder); err != nil {
+			return restored, fmt.Errorf("failed to restore messages to %s with error %w", folder, err)
+		}
+		err = GormDB.Model(&Message{}).Where("id IN ?", idsByFolder[folder]).
+			Updates(map[string]interface{}{
+				"mail_box_folder":  restoredTo,
+				"quarantined_from": "",
+				"quarantined_at":   sql.NullTime{},
+				"uid_validity":     0,
+			}).Error
+		if err != nil {
+			return restored, fmt.Errorf("failed to record restored messages with error %w", err)
+		}
+		restored = append(restored, messageIDsByFolder[folder]...)
+		l.Info("restored messages", "folder", folder, "numMessages", len(uids))
+	}
+	return restored, nil
+}
+
+// restoreLabels gives the quarantined messages in the selected quarantine folder their inbox
+// label back when they came from the inbox and removes the quarantine label.
+func (conn *MailAccountConnection) restoreLabels(seqSet *imap.SeqSet, quarantine, from string) error {
+	if from == conn.folders.Inbox {
+		err := conn.client.UidStore(
+			seqSet, imap.StoreItem("+"+gmailLabelsItem), []interface{}{imap.RawString(gmailInboxLabel)}, nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add inbox label with error %w", err)
+		}
+	}
+	err := conn.client.UidStore(seqSet, imap.StoreItem("-"+gmailLabelsItem), []interface{}{quarantine}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to remove quarantine label with error %w", err)
+	}
+	return nil
+}
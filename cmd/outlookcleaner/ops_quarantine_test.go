@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestPurgeCandidates(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	exportDir := t.TempDir()
+	for messageID, content := range map[string]string{"<exported>": "Subject: kept\r\n\r\nbody", "<empty>": ""} {
+		if err := os.WriteFile(exportPath(exportDir, messageID), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	quarantined := func(id uint, messageID string, daysAgo int) Message {
+		return Message{
+			Model:         gorm.Model{ID: id},
+			MessageID:     messageID,
+			QuarantinedAt: sql.NullTime{Time: now.AddDate(0, 0, -daysAgo), Valid: true},
+		}
+	}
+	tests := []struct {
+		name        string
+		exportDir   string
+		messages    map[uint32]Message
+		wantUIDs    []uint32
+		wantSkipped map[string]int
+	}{
+		{
+			name:      "past the retention with an export",
+			exportDir: exportDir,
+			messages: map[uint32]Message{
+				12: quarantined(2, "<exported>", 31),
+				11: quarantined(1, "<exported>", 30),
+			},
+			wantUIDs:    []uint32{11, 12},
+			wantSkipped: map[string]int{},
+		},
+		{
+			name:        "within the retention",
+			exportDir:   exportDir,
+			messages:    map[uint32]Message{11: quarantined(1, "<exported>", 29)},
+			wantSkipped: map[string]int{"inRetention": 1},
+		},
+		{
+			name:      "no database row",
+			exportDir: exportDir,
+			messages:  map[uint32]Message{11: {}, 12: quarantined(2, "<exported>", 40)},
+			wantUIDs:  []uint32{12},
+			wantSkipped: map[string]int{
+				"notIngested": 1,
+			},
+		},
+		{
+			name:      "no quarantine timestamp",
+			exportDir: exportDir,
+			messages: map[uint32]Message{
+				11: {Model: gorm.Model{ID: 1}, MessageID: "<exported>"},
+			},
+			wantSkipped: map[string]int{"notQuarantined": 1},
+		},
+		{
+			name:      "no exported copy",
+			exportDir: exportDir,
+			messages: map[uint32]Message{
+				11: quarantined(1, "<missing>", 40),
+				12: quarantined(2, "<empty>", 40),
+			},
+			wantSkipped: map[string]int{"noLocalCopy": 2},
+		},
+		{
+			name:        "ingested row without an export directory",
+			messages:    map[uint32]Message{11: quarantined(1, "<exported>", 40)},
+			wantSkipped: map[string]int{"noLocalCopy": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := QuarantineConfig{RetentionDays: 30, ExportDir: tt.exportDir}
+			uids, purged, skipped := purgeCandidates(cfg, tt.messages, now)
+			if !reflect.DeepEqual(uids, tt.wantUIDs) {
+				t.Errorf("got UIDs %v, want %v", uids, tt.wantUIDs)
+			}
+			if len(purged) != len(uids) {
+				t.Errorf("got %d purged rows for %d UIDs", len(purged), len(uids))
+			}
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("got skipped %v, want %v", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestPurgeRequiresExportDir(t *testing.T) {
+	conn := &MailAccountConnection{username: "someone@example.com"}
+	if err := conn.Purge(context.Background(), nil, time.Now(), true); err == nil {
+		t.Fatal("expected purge without an export directory to fail")
+	}
+}
+
+func TestCheckPlainExpunge(t *testing.T) {
+	tests := []struct {
+		name    string
+		deleted []uint32
+		uids    []uint32
+		wantErr bool
+	}{
+		{name: "only the purged messages", deleted: []uint32{3, 5}, uids: []uint32{3, 5}},
+		{name: "purged messages not marked yet", deleted: []uint32{3}, uids: []uint32{3, 5}},
+		{name: "nothing marked deleted", uids: []uint32{3}},
+		{name: "other messages marked deleted", deleted: []uint32{3, 4, 5}, uids: []uint32{3, 5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPlainExpunge(tt.deleted, tt.uids)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuarantineKeptOnIngest(t *testing.T) {
+	useTestDB(t)
+	const allMail = "[Gmail]/All Mail"
+	conn := &MailAccountConnection{
+		username: "me@example.com",
+		profile:  providerProfiles[ProviderGmail],
+		folders:  SpecialFolders{Inbox: "INBOX", Archive: allMail, AllMail: allMail},
+	}
+	ingest := func(messageID, labels string) {
+		t.Helper()
+		record := &Message{
+			MessageID: messageID, Account: conn.username, MailBoxFolder: allMail, Labels: labels, UIDValidity: 7,
+		}
+		if err := saveIngestedMessage(record).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ingest("<inbox>", `\Inbox`)
+	ingest("<archived>", "work")
+	ingest("<kept>", `\Inbox`)
+
+	var rows []Message
+	if err := GormDB.Where("message_id IN ?", []string{"<inbox>", "<archived>"}).Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := conn.recordQuarantined(allMail, rows, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next ingest reads the quarantined messages again from all mail
+	quarantine := conn.quarantineConfig().Folder
+	ingest("<inbox>", quarantine)
+	ingest("<archived>", "work#"+quarantine)
+	ingest("<kept>", "")
+
+	tests := []struct {
+		messageID       string
+		wantFolder      string
+		wantFrom        string
+		wantQuarantined bool
+		wantLabels      string
+	}{
+		{"<inbox>", quarantine, "INBOX", true, quarantine},
+		{"<archived>", quarantine, allMail, true, "work#" + quarantine},
+		{"<kept>", allMail, "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.messageID, func(t *testing.T) {
+			var row Message
+			if err := GormDB.Where("message_id = ?", tt.messageID).First(&row).Error; err != nil {
+				t.Fatal(err)
+			}
+			if row.MailBoxFolder != tt.wantFolder {
+				t.Errorf("got folder %s, want %s", row.MailBoxFolder, tt.wantFolder)
+			}
+			if row.QuarantinedFrom != tt.wantFrom {
+				t.Errorf("got quarantined from %q, want %q", row.QuarantinedFrom, tt.wantFrom)
+			}
+			if row.QuarantinedAt.Valid != tt.wantQuarantined {
+				t.Errorf("got quarantined at %v, want quarantined %v", row.QuarantinedAt, tt.wantQuarantined)
+			}
+			if row.Labels != tt.wantLabels {
+				t.Errorf("got labels %q, want %q", row.Labels, tt.wantLabels)
+			}
+		})
+	}
+}
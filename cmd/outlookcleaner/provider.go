@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/samber/lo"
+)
+
+// MailProvider identifies the folder conventions of a mail server.
+type MailProvider string
+
+const (
+	ProviderOutlook  MailProvider = "outlook"
+	ProviderGmail    MailProvider = "gmail"
+	ProviderFastmail MailProvider = "fastmail"
+	ProviderGeneric  MailProvider = "generic"
+)
+
+// gmailLabelsItem is the gmail IMAP extension to fetch and store message labels.
+// https://developers.google.com/gmail/imap/imap-extensions
+const gmailLabelsItem imap.FetchItem = "X-GM-LABELS"
+
+// gmailInboxLabel is the label of the messages in the inbox, archiving a message removes it.
+const gmailInboxLabel = `\Inbox`
+
+// ProviderProfile holds the defaults used when the server does not advertise
+// the folders with SPECIAL-USE attributes (RFC 6154).
+type ProviderProfile struct {
+	Provider    MailProvider
+	DefaultHost string
+	DefaultPort int
+	Archive     string
+	Trash       string
+	Junk        string
+	AllMail     string
+	// UsesLabels is set for servers where every folder is a label view over
+	// a single message store and one message can show up in many folders.
+	UsesLabels bool
+}
+
+// SpecialFolders are the resolved folder names for an account.
+type SpecialFolders struct {
+	Inbox   string
+	Archive string
+	Trash   string
+	Junk    string
+	AllMail string
+}
+
+//nolint:gochecknoglobals // read only lookup table
+var providerProfiles = map[MailProvider]ProviderProfile{
+	ProviderOutlook: {
+		Provider:    ProviderOutlook,
+		DefaultHost: "outlook.office365.com",
+		DefaultPort: 993,
+		Archive:     "Archive",
+		Trash:       "Deleted",
+		Junk:        "Junk",
+	},
+	ProviderGmail: {
+		Provider:    ProviderGmail,
+		DefaultHost: "imap.gmail.com",
+		DefaultPort: 993,
+		Trash:       "[Gmail]/Trash",
+		Junk:        "[Gmail]/Spam",
+		AllMail:     "[Gmail]/All Mail",
+		UsesLabels:  true,
+	},
+	ProviderFastmail: {
+		Provider:    ProviderFastmail,
+		DefaultHost: "imap.fastmail.com",
+		DefaultPort: 993,
+		Archive:     "Archive",
+		Trash:       "Trash",
+		Junk:        "Spam",
+	},
+	ProviderGeneric: {
+		Provider:    ProviderGeneric,
+		DefaultPort: 993,
+		Archive:     "Archive",
+		Trash:       "Trash",
+		Junk:        "Junk",
+	},
+}
+
+// profileForAccount returns the provider profile of the account. Accounts without
+// a provider are treated as outlook since that was the only supported server.
+func profileForAccount(account MailAccountConfig) (ProviderProfile, error) {
+	provider := MailProvider(strings.ToLower(account.Provider))
+	if provider == "" {
+		provider = ProviderOutlook
+	}
+	profile, ok := providerProfiles[provider]
+	if !ok {
+		return ProviderProfile{}, fmt.Errorf("unknown mail provider %q for account %s", account.Provider, account.EncUser)
+	}
+	return profile, nil
+}
+
+// serverAddress returns the host:port to dial with the provider defaults applied.
+func (profile ProviderProfile) serverAddress(account MailAccountConfig) string {
+	host, port := account.Hostname, account.Port
+	if host == "" {
+		host = profile.DefaultHost
+	}
+	if port == 0 {
+		port = profile.DefaultPort
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// resolveSpecialFolders maps the archive, trash, junk and all-mail roles to folders
+// in the account. Folders set in the config win, then the SPECIAL-USE attributes
+// listed by the server and finally the provider's well known folder names.
+// A role is left empty when the account has no folder for it.
+func resolveSpecialFolders(
+	profile ProviderProfile,
+	mailboxes []imap.MailboxInfo,
+	overrides SpecialFoldersConfig,
+) SpecialFolders {
+	byAttr := func(attr string) string {
+		if attr == "" {
+			return ""
+		}
+		m, ok := lo.Find(mailboxes, func(m imap.MailboxInfo) bool {
+			return lo.Contains(m.Attributes, attr)
+		})
+		if !ok {
+			return ""
+		}
+		return m.Name
+	}
+	byName := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		m, ok := lo.Find(mailboxes, func(m imap.MailboxInfo) bool {
+			return strings.EqualFold(m.Name, name)
+		})
+		if !ok {
+			return ""
+		}
+		return m.Name
+	}
+	resolve := func(override, attr, fallback string) string {
+		if override != "" {
+			return override
+		}
+		if name := byAttr(attr); name != "" {
+			return name
+		}
+		return byName(fallback)
+	}
+
+	folders := SpecialFolders{
+		Inbox:   resolve(overrides.Inbox, "", imap.InboxName),
+		Archive: resolve(overrides.Archive, imap.ArchiveAttr, profile.Archive),
+		Trash:   resolve(overrides.Trash, imap.TrashAttr, profile.Trash),
+		Junk:    resolve(overrides.Junk, imap.JunkAttr, profile.Junk),
+		AllMail: resolve(overrides.AllMail, imap.AllAttr, profile.AllMail),
+	}
+	if folders.Inbox == "" {
+		folders.Inbox = imap.InboxName
+	}
+	// gmail has no archive folder, archiving a message drops its inbox label
+	// and leaves it in all mail.
+	if folders.Archive == "" && profile.UsesLabels {
+		folders.Archive = folders.AllMail
+	}
+	return folders
+}
+
+// ingestFolders returns the folders to read messages from. On label based servers
+// all mail already holds every message (outside spam and trash) with its labels,
+// so reading each label folder would ingest the same message many times.
+func (conn *MailAccountConnection) ingestFolders() []imap.MailboxInfo {
+	selectable := lo.Filter(conn.mailboxes, func(m imap.MailboxInfo, _ int) bool {
+		return !lo.Contains(m.Attributes, imap.NoSelectAttr)
+	})
+	if !conn.profile.UsesLabels || conn.folders.AllMail == "" {
+		return selectable
+	}
+	return lo.Filter(selectable, func(m imap.MailboxInfo, _ int) bool {
+		return m.Name == conn.folders.AllMail
+	})
+}
+
+// archiveSubfolder returns the name of a review folder kept under the archive.
+// Label based servers do not allow folders under all mail so a top level label is used.
+func (conn *MailAccountConnection) archiveSubfolder(name string) string {
+	if conn.profile.UsesLabels || conn.folders.Archive == "" {
+		return "z-archive/" + name
+	}
+	delimiter := "/"
+	if m, ok := lo.Find(conn.mailboxes, func(m imap.MailboxInfo) bool {
+		return m.Name == conn.folders.Archive
+	}); ok && m.Delimiter != "" {
+		delimiter = m.Delimiter
+	}
+	return conn.folders.Archive + delimiter + name
+}
+
+// archiveUIDs archives the messages with the given UIDs from the selected folder.
+func (conn *MailAccountConnection) archiveUIDs(folder string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	if conn.folders.Archive == "" {
+		return fmt.Errorf("account %s has no archive folder configured", conn.username)
+	}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	if conn.profile.UsesLabels && folder == conn.folders.AllMail {
+		// the message is already in all mail, archiving it means removing the inbox label
+		err := conn.client.UidStore(
+			seqSet, imap.StoreItem("-"+gmailLabelsItem), []interface{}{imap.RawString(gmailInboxLabel)}, nil,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove inbox label with error %w", err)
+		}
+		return nil
+	}
+	if folder == conn.folders.Archive {
+		return nil
+	}
+	if err := conn.client.UidMove(seqSet, conn.folders.Archive); err != nil {
+		return fmt.Errorf("failed to move messages from %s to %s with error %w", folder, conn.folders.Archive, err)
+	}
+	return nil
+}
+
+// parseGmailLabels reads the X-GM-LABELS item of a fetched message.
+func parseGmailLabels(msg *imap.Message) []string {
+	raw, ok := msg.Items[gmailLabelsItem].([]interface{})
+	if !ok {
+		return nil
+	}
+	labels := []string{}
+	for _, f := range raw {
+		if label, err := imap.ParseString(f); err == nil {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// gmailSystemLabels are the X-GM-LABELS names of the gmail folders listed with a SPECIAL-USE attribute.
+//
+//nolint:gochecknoglobals // read only lookup table
+var gmailSystemLabels = map[string]string{
+	imap.SentAttr:      `\Sent`,
+	imap.DraftsAttr:    `\Draft`,
+	imap.FlaggedAttr:   `\Starred`,
+	imap.ImportantAttr: `\Important`,
+}
+
+// folderLabel returns the label whose messages a folder of a label based server shows.
+func (conn *MailAccountConnection) folderLabel(folder string) string {
+	if strings.EqualFold(folder, conn.folders.Inbox) {
+		return gmailInboxLabel
+	}
+	if m, ok := lo.Find(conn.mailboxes, func(m imap.MailboxInfo) bool { return m.Name == folder }); ok {
+		for _, attr := range m.Attributes {
+			if label, ok := gmailSystemLabels[attr]; ok {
+				return label
+			}
+		}
+	}
+	return folder
+}
+
+// hasLabels reports if the message has every one of the labels.
+func hasLabels(m Message, labels []string) bool {
+	own := strings.Split(m.Labels, "#")
+	return lo.EveryBy(labels, func(label string) bool {
+		return lo.ContainsBy(own, func(l string) bool { return strings.EqualFold(l, label) })
+	})
+}
+
+// withLabel returns the labels of the message with the label added.
+func withLabel(m Message, label string) string {
+	if hasLabels(m, []string{label}) {
+		return m.Labels
+	}
+	return strings.Join(append(lo.Compact(strings.Split(m.Labels, "#")), label), "#")
+}
+
+// withoutLabel returns the labels of the message without the label.
+func withoutLabel(m Message, label string) string {
+	return strings.Join(lo.Reject(strings.Split(m.Labels, "#"), func(l string, _ int) bool {
+		return l == "" || strings.EqualFold(l, label)
+	}), "#")
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func testMailbox(name string, attributes ...string) imap.MailboxInfo {
+	return imap.MailboxInfo{Name: name, Delimiter: "/", Attributes: attributes}
+}
+
+func TestResolveSpecialFolders(t *testing.T) {
+	outlookMailboxes := []imap.MailboxInfo{
+		testMailbox("INBOX"),
+		testMailbox("Inbox/z-archive"),
+		testMailbox("Archive"),
+		testMailbox("Deleted"),
+		testMailbox("Junk"),
+	}
+	gmailMailboxes := []imap.MailboxInfo{
+		testMailbox("INBOX"),
+		testMailbox("[Gmail]", imap.NoSelectAttr),
+		testMailbox("[Gmail]/All Mail", imap.AllAttr),
+		testMailbox("[Gmail]/Bin", imap.TrashAttr),
+		testMailbox("[Gmail]/Spam", imap.JunkAttr),
+	}
+	tests := []struct {
+		name      string
+		provider  MailProvider
+		mailboxes []imap.MailboxInfo
+		overrides SpecialFoldersConfig
+		want      SpecialFolders
+	}{
+		{
+			name:      "outlook defaults",
+			provider:  ProviderOutlook,
+			mailboxes: outlookMailboxes,
+			want:      SpecialFolders{Inbox: "INBOX", Archive: "Archive", Trash: "Deleted", Junk: "Junk"},
+		},
+		{
+			name:      "override keeps the old outlook archive",
+			provider:  ProviderOutlook,
+			mailboxes: outlookMailboxes,
+			overrides: SpecialFoldersConfig{Archive: "Inbox/z-archive"},
+			want:      SpecialFolders{Inbox: "INBOX", Archive: "Inbox/z-archive", Trash: "Deleted", Junk: "Junk"},
+		},
+		{
+			name:     "special use before the provider default",
+			provider: ProviderOutlook,
+			mailboxes: []imap.MailboxInfo{
+				testMailbox("INBOX"),
+				testMailbox("Archive"),
+				testMailbox("Archives", imap.ArchiveAttr),
+				testMailbox("Deleted Items", imap.TrashAttr),
+				testMailbox("Junk"),
+			},
+			want: SpecialFolders{Inbox: "INBOX", Archive: "Archives", Trash: "Deleted Items", Junk: "Junk"},
+		},
+		{
+			name:     "override before special use",
+			provider: ProviderGeneric,
+			mailboxes: []imap.MailboxInfo{
+				testMailbox("INBOX"),
+				testMailbox("Archives", imap.ArchiveAttr),
+				testMailbox("Trash", imap.TrashAttr),
+			},
+			overrides: SpecialFoldersConfig{Inbox: "Mail/Inbox", Archive: "Mail/Kept", Trash: "Mail/Bin"},
+			want:      SpecialFolders{Inbox: "Mail/Inbox", Archive: "Mail/Kept", Trash: "Mail/Bin"},
+		},
+		{
+			name:      "default names match without case",
+			provider:  ProviderFastmail,
+			mailboxes: []imap.MailboxInfo{testMailbox("inbox"), testMailbox("archive"), testMailbox("TRASH")},
+			want:      SpecialFolders{Inbox: "inbox", Archive: "archive", Trash: "TRASH"},
+		},
+		{
+			name:      "missing folders are left empty",
+			provider:  ProviderGeneric,
+			mailboxes: []imap.MailboxInfo{testMailbox("INBOX")},
+			want:      SpecialFolders{Inbox: "INBOX"},
+		},
+		{
+			name:      "inbox without a listed mailbox",
+			provider:  ProviderGeneric,
+			mailboxes: nil,
+			want:      SpecialFolders{Inbox: imap.InboxName},
+		},
+		{
+			name:      "gmail archives to all mail",
+			provider:  ProviderGmail,
+			mailboxes: gmailMailboxes,
+			want: SpecialFolders{
+				Inbox: "INBOX", Archive: "[Gmail]/All Mail", Trash: "[Gmail]/Bin",
+				Junk: "[Gmail]/Spam", AllMail: "[Gmail]/All Mail",
+			},
+		},
+		{
+			name:      "gmail archive override",
+			provider:  ProviderGmail,
+			mailboxes: gmailMailboxes,
+			overrides: SpecialFoldersConfig{Archive: "Archived"},
+			want: SpecialFolders{
+				Inbox: "INBOX", Archive: "Archived", Trash: "[Gmail]/Bin",
+				Junk: "[Gmail]/Spam", AllMail: "[Gmail]/All Mail",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveSpecialFolders(providerProfiles[tt.provider], tt.mailboxes, tt.overrides)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveSubfolder(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  MailProvider
+		mailboxes []imap.MailboxInfo
+		archive   string
+		want      string
+	}{
+		{
+			name:      "slash delimiter",
+			provider:  ProviderOutlook,
+			mailboxes: []imap.MailboxInfo{{Name: "Archive", Delimiter: "/"}},
+			archive:   "Archive",
+			want:      "Archive/to-delete",
+		},
+		{
+			name:      "dot delimiter",
+			provider:  ProviderFastmail,
+			mailboxes: []imap.MailboxInfo{{Name: "INBOX.Archive", Delimiter: "."}},
+			archive:   "INBOX.Archive",
+			want:      "INBOX.Archive.to-delete",
+		},
+		{
+			name:     "archive that was not listed",
+			provider: ProviderOutlook,
+			archive:  "Inbox/z-archive",
+			want:     "Inbox/z-archive/to-delete",
+		},
+		{
+			name:     "no archive folder",
+			provider: ProviderGeneric,
+			want:     "z-archive/to-delete",
+		},
+		{
+			name:      "labels",
+			provider:  ProviderGmail,
+			mailboxes: []imap.MailboxInfo{{Name: "[Gmail]/All Mail", Delimiter: "/"}},
+			archive:   "[Gmail]/All Mail",
+			want:      "z-archive/to-delete",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &MailAccountConnection{
+				mailboxes: tt.mailboxes,
+				profile:   providerProfiles[tt.provider],
+				folders:   SpecialFolders{Archive: tt.archive},
+			}
+			if got := conn.archiveSubfolder("to-delete"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGmailLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		items map[imap.FetchItem]interface{}
+		want  []string
+	}{
+		{
+			name: "labels",
+			items: map[imap.FetchItem]interface{}{
+				gmailLabelsItem: []interface{}{`\Inbox`, imap.RawString(`\Important`), "Receipts/2024"},
+			},
+			want: []string{`\Inbox`, `\Important`, "Receipts/2024"},
+		},
+		{
+			name:  "no labels",
+			items: map[imap.FetchItem]interface{}{gmailLabelsItem: []interface{}{}},
+			want:  []string{},
+		},
+		{
+			name:  "labels not fetched",
+			items: map[imap.FetchItem]interface{}{},
+			want:  nil,
+		},
+		{
+			name:  "values that are not strings",
+			items: map[imap.FetchItem]interface{}{gmailLabelsItem: []interface{}{"Work", uint32(3)}},
+			want:  []string{"Work"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseGmailLabels(&imap.Message{Items: tt.items})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels string
+		want   string
+	}{
+		{name: "no labels", labels: "", want: `\Inbox`},
+		{name: "other labels", labels: "work#Receipts/2024", want: `work#Receipts/2024#\Inbox`},
+		{name: "already labeled", labels: `work#\inbox`, want: `work#\inbox`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withLabel(Message{Labels: tt.labels}, gmailInboxLabel); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
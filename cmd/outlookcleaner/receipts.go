@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReceiptExtractor pulls the purchase details out of the text of a receipt message.
+type ReceiptExtractor interface {
+	Extract(msg *Message, body string) (Receipt, bool)
+}
+
+// regexReceiptExtractor reads each field from the first capture group of its pattern.
+type regexReceiptExtractor struct {
+	merchant    string
+	orderNumber *regexp.Regexp
+	amount      *regexp.Regexp
+	date        *regexp.Regexp
+	dateLayout  string
+}
+
+//nolint:gochecknoglobals // read only defaults
+var (
+	genericOrderNumberRe = regexp.MustCompile(`(?i)\border\s*(?:number|no\.?|id|#)\s*[:#]?\s*([A-Z0-9][A-Z0-9-]{4,})`)
+	genericAmountRe      = regexp.MustCompile(
+		`(?i)\b(?:grand\s+total|order\s+total|total\s+charged|total\s+paid|amount\s+paid|amount\s+charged|total)\b[^\d$€£]{0,20}([$€£]\s?[\d,]+(?:\.\d{2})?)`,
+	)
+	currencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP"}
+
+	// builtinReceiptExtractors are used for the domains without a configured extractor
+	builtinReceiptExtractors = []ReceiptExtractorConfig{
+		{
+			Domain:      "amazon.com",
+			Merchant:    "Amazon",
+			OrderNumber: `(?i)order\s*#\s*(\d{3}-\d{7}-\d{7})`,
+			Amount:      `(?i)(?:order|grand)\s+total:?\s*([$€£]\s?[\d,]+\.\d{2})`,
+		},
+	}
+)
+
+func newRegexReceiptExtractor(cfg ReceiptExtractorConfig) (*regexReceiptExtractor, error) {
+	e := &regexReceiptExtractor{
+		merchant:    cfg.Merchant,
+		orderNumber: genericOrderNumberRe,
+		amount:      genericAmountRe,
+		dateLayout:  cfg.DateLayout,
+	}
+	var err error
+	for _, p := range []struct {
+		pattern string
+		target  **regexp.Regexp
+	}{
+		{pattern: cfg.OrderNumber, target: &e.orderNumber},
+		{pattern: cfg.Amount, target: &e.amount},
+		{pattern: cfg.Date, target: &e.date},
+	} {
+		if p.pattern == "" {
+			continue
+		}
+		if *p.target, err = regexp.Compile(p.pattern); err != nil {
+			return nil, fmt.Errorf("invalid receipt pattern %s for %s with error %w", p.pattern, cfg.Domain, err)
+		}
+	}
+	if e.date != nil && e.dateLayout == "" {
+		return nil, fmt.Errorf("receipt extractor for %s sets a date pattern without date_layout", cfg.Domain)
+	}
+	return e, nil
+}
+
+func firstGroup(re *regexp.Regexp, s string) string {
+	if re == nil {
+		return ""
+	}
+	m := re.FindStringSubmatch(s)
+	if len(m) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(m[1])
+}
+
+// parseAmount turns "$1,234.50" into 123450 cents and USD.
+func parseAmount(s string) (int64, string, bool) {
+	currency := ""
+	for symbol, code := range currencySymbols {
+		if strings.HasPrefix(s, symbol) {
+			currency = code
+			s = strings.TrimPrefix(s, symbol)
+		}
+	}
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	whole, fraction, _ := strings.Cut(s, ".")
+	cents, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	cents *= 100
+	if fraction != "" {
+		f, err := strconv.ParseInt((fraction + "0")[:2], 10, 64)
+		if err != nil {
+			return 0, "", false
+		}
+		cents += f
+	}
+	return cents, currency, true
+}
+
+func (e *regexReceiptExtractor) Extract(msg *Message, body string) (Receipt, bool) {
+	r := Receipt{
+		Merchant:    e.merchant,
+		OrderNumber: firstGroup(e.orderNumber, body),
+		PurchasedAt: msg.ReceivedAt,
+	}
+	if r.Merchant == "" {
+		r.Merchant = msg.FromName
+	}
+	if amount := firstGroup(e.amount, body); amount != "" {
+		r.AmountCents, r.Currency, _ = parseAmount(amount)
+	}
+	if date := firstGroup(e.date, body); date != "" {
+		if t, err := time.Parse(e.dateLayout, date); err == nil {
+			r.PurchasedAt = t
+		}
+	}
+	return r, r.OrderNumber != "" || r.AmountCents != 0
+}
+
+// receiptExtractors picks the extractor by the sender's domain.
+type receiptExtractors struct {
+	byDomain map[string]ReceiptExtractor
+	fallback ReceiptExtractor
+}
+
+// newReceiptExtractors builds the configured extractors on top of the built in ones.
+func newReceiptExtractors(configs []ReceiptExtractorConfig) (*receiptExtractors, error) {
+	fallback, err := newRegexReceiptExtractor(ReceiptExtractorConfig{})
+	if err != nil {
+		return nil, err
+	}
+	r := &receiptExtractors{byDomain: map[string]ReceiptExtractor{}, fallback: fallback}
+	for _, cfg := range slices.Concat(builtinReceiptExtractors, configs) {
+		e, err := newRegexReceiptExtractor(cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.Register(cfg.Domain, e)
+	}
+	return r, nil
+}
+
+// Register sets the extractor for the domain and its subdomains.
+func (r *receiptExtractors) Register(domain string, e ReceiptExtractor) {
+	r.byDomain[strings.ToLower(domain)] = e
+}
+
+// forSender returns the extractor of the closest parent domain of the sender,
+// e.g. shipment-tracking.amazon.com uses the amazon.com extractor.
+func (r *receiptExtractors) forSender(address string) (string, ReceiptExtractor) {
+	_, domain, _ := strings.Cut(strings.ToLower(address), "@")
+	for d := domain; d != ""; {
+		if e, ok := r.byDomain[d]; ok {
+			return domain, e
+		}
+		_, parent, found := strings.Cut(d, ".")
+		if !found {
+			break
+		}
+		d = parent
+	}
+	return domain, r.fallback
+}
+
+// Extract returns the receipt in the message body if the sender's extractor finds one.
+func (r *receiptExtractors) Extract(msg *Message, body string) (Receipt, bool) {
+	domain, e := r.forSender(msg.From)
+	receipt, ok := e.Extract(msg, body)
+	receipt.SenderDomain = domain
+	if receipt.Merchant == "" {
+		receipt.Merchant = domain
+	}
+	return receipt, ok
+}
+
+func saveReceipt(db *gorm.DB, messageRowID uint, receipt Receipt) error {
+	receipt.MessageRowID = messageRowID
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "message_row_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"sender_domain", "merchant", "order_number", "amount_cents", "currency", "purchased_at", "updated_at",
+		}),
+	}).Create(&receipt).Error
+}
+
+// ExportReceiptsCSV writes the extracted receipts ordered by purchase date.
+func ExportReceiptsCSV(w io.Writer, since time.Time) error {
+	var rows []struct {
+		Receipt
+		MessageID string
+		Subject   string
+	}
+	err := GormDB.Model(&Receipt{}).
+		Select("outlookcleaner_receipts.*, m.message_id, m.subject").
+		Joins("JOIN outlookcleaner_messages m ON m.id = outlookcleaner_receipts.message_row_id").
+		Where("outlookcleaner_receipts.purchased_at >= ?", since).
+		Order("outlookcleaner_receipts.purchased_at").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to read receipts with error %w", err)
+	}
+
+	cw := csv.NewWriter(w)
+	if err = cw.Write([]string{"date", "merchant", "order_number", "amount", "currency", "sender_domain", "subject", "message_id"}); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		err = cw.Write([]string{
+			r.PurchasedAt.Format(time.DateOnly), r.Merchant, r.OrderNumber,
+			fmt.Sprintf("%d.%02d", r.AmountCents/100, r.AmountCents%100), r.Currency,
+			r.SenderDomain, r.Subject, r.MessageID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
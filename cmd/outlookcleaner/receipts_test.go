@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReceiptExtractors(t *testing.T) {
+	extractors, err := newReceiptExtractors([]ReceiptExtractorConfig{
+		{
+			Domain:     "coffee.example",
+			Merchant:   "Corner Coffee",
+			Amount:     `Charged\s+(€[\d.,]+)`,
+			Date:       `Visit on (\d{2}/\d{2}/\d{4})`,
+			DateLayout: "02/01/2006",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receivedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		from   string
+		body   string
+		want   Receipt
+		wantOK bool
+	}{
+		{
+			name: "built in extractor for a subdomain",
+			from: "auto-confirm@shipment.amazon.com",
+			body: "Your Order #112-1234567-1234567 has shipped. Order Total: $1,024.50",
+			want: Receipt{
+				SenderDomain: "shipment.amazon.com", Merchant: "Amazon", OrderNumber: "112-1234567-1234567",
+				AmountCents: 102450, Currency: "USD", PurchasedAt: receivedAt,
+			},
+			wantOK: true,
+		},
+		{
+			name: "configured extractor",
+			from: "hello@coffee.example",
+			body: "Visit on 03/04/2024. Charged €4.5",
+			want: Receipt{
+				SenderDomain: "coffee.example", Merchant: "Corner Coffee",
+				AmountCents: 450, Currency: "EUR", PurchasedAt: time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC),
+			},
+			wantOK: true,
+		},
+		{
+			name: "generic extractor",
+			from: "orders@shop.example",
+			body: "Order number: A1B2C3D4\nSubtotal $10.00\nGrand total $12.34",
+			want: Receipt{
+				SenderDomain: "shop.example", Merchant: "Shop", OrderNumber: "A1B2C3D4",
+				AmountCents: 1234, Currency: "USD", PurchasedAt: receivedAt,
+			},
+			wantOK: true,
+		},
+		{
+			name:   "no details",
+			from:   "news@shop.example",
+			body:   "Your reservation is confirmed",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{From: tt.from, FromName: "Shop", ReceivedAt: receivedAt}
+			got, ok := extractors.Extract(msg, tt.body)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
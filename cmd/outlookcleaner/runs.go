@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/raokrutarth/golang-playspace/pkg/logger"
+	"github.com/samber/lo"
+)
+
+// Every prune and purge is recorded as a run with one action per message it
+// moved. The digest summarizes a run and Undo moves its messages back.
+
+const (
+	runKindPrune = "prune"
+	runKindPurge = "purge"
+
+	// runActionPurge marks messages expunged from the quarantine, they can not be undone
+	runActionPurge = "purge"
+	// purgeRuleName is the rule recorded for the messages purged after the retention period
+	purgeRuleName = "retention"
+)
+
+// startRun creates the run that the actions of a prune or purge are recorded under.
+func startRun(kind string, dryRun bool) (*Run, error) {
+	run := &Run{Kind: kind, DryRun: dryRun}
+	if err := GormDB.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create %s run with error %w", kind, err)
+	}
+	return run, nil
+}
+
+// record adds an action for each message moved by the rule.
+func (run *Run) record(account, rule, action, from, to string, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	actions := lo.Map(messages, func(m Message, _ int) RunAction {
+		return RunAction{
+			RunID:        run.ID,
+			MessageRowID: m.ID,
+			MessageID:    m.MessageID,
+			Account:      account,
+			Rule:         rule,
+			Action:       action,
+			FromFolder:   from,
+			ToFolder:     to,
+		}
+	})
+	if err := GormDB.CreateInBatches(&actions, 500).Error; err != nil {
+		return fmt.Errorf("failed to record actions of rule %s with error %w", rule, err)
+	}
+	return nil
+}
+
+func (run *Run) finish() error {
+	run.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return GormDB.Model(run).Update("finished_at", run.FinishedAt).Error
+}
+
+// LatestRun returns the most recent run of the kind, or of any kind when kind is empty.
+func LatestRun(kind string) (*Run, error) {
+	q := GormDB.Order("id DESC")
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var run Run
+	if err := q.First(&run).Error; err != nil {
+		return nil, fmt.Errorf("failed to find the latest run with error %w", err)
+	}
+	return &run, nil
+}
+
+// uidsByMessageID searches the selected folder for the messages by their Message-ID header.
+func (conn *MailAccountConnection) uidsByMessageID(messageIDs []string) (map[string]uint32, error) {
+	out := map[string]uint32{}
+	for _, id := range messageIDs {
+		criteria := imap.NewSearchCriteria()
+		criteria.Header.Add("Message-Id", id)
+		uids, err := conn.client.UidSearch(criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search message %s with error %w", id, err)
+		}
+		if len(uids) > 0 {
+			out[id] = uids[0]
+		}
+	}
+	return out, nil
+}
+
+// undoArchive moves the archived messages back to the folder they were pruned from and
+// returns the Message-IDs of the ones it found.
+func (conn *MailAccountConnection) undoArchive(from, to string, actions []RunAction) ([]string, error) {
+	if _, err := conn.client.Select(to, false); err != nil {
+		return nil, fmt.Errorf("unable to select folder %s with error %w", to, err)
+	}
+	seqSet := new(imap.SeqSet)
+	if conn.profile.UsesLabels && from == conn.folders.AllMail {
+		// archived by removing the inbox label so the UIDs did not change
+		// messages quarantined or gone since the prune are not in all mail with a UID
+		var rows []Message
+		err := GormDB.Where("id IN ? AND mail_box_folder = ? AND uid <> 0",
+			lo.Map(actions, func(a RunAction, _ int) uint { return a.MessageRowID }), from,
+		).Find(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archived messages with error %w", err)
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		seqSet.AddNum(lo.Map(rows, func(m Message, _ int) uint32 { return m.UID })...)
+		err = conn.client.UidStore(
+			seqSet, imap.StoreItem("+"+gmailLabelsItem), []interface{}{imap.RawString(gmailInboxLabel)}, nil,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add inbox label with error %w", err)
+		}
+		for _, m := range rows {
+			err = GormDB.Model(&Message{}).Where("id = ?", m.ID).Update("labels", withLabel(m, gmailInboxLabel)).Error
+			if err != nil {
+				return nil, fmt.Errorf("failed to record restored messages with error %w", err)
+			}
+		}
+		return lo.Map(rows, func(m Message, _ int) string { return m.MessageID }), nil
+	}
+
+	uids, err := conn.uidsByMessageID(lo.Map(actions, func(a RunAction, _ int) string { return a.MessageID }))
+	if err != nil {
+		return nil, err
+	}
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	seqSet.AddNum(lo.Values(uids)...)
+	if err = conn.client.UidMove(seqSet, from); err != nil {
+		return nil, fmt.Errorf("failed to move messages back to %s with error %w", from, err)
+	}
+	err = GormDB.Model(&Message{}).
+		Where("account = ? AND message_id IN ?", conn.username, lo.Keys(uids)).
+		Update("mail_box_folder", from).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to record restored messages with error %w", err)
+	}
+	return lo.Keys(uids), nil
+}
+
+// undoneActions splits the actions into the ones whose messages were moved back and
+// the ones whose messages were not found.
+func undoneActions(actions []RunAction, moved []string) (undone []uint, missing []string) {
+	for _, a := range actions {
+		if lo.Contains(moved, a.MessageID) {
+			undone = append(undone, a.ID)
+		} else {
+			missing = append(missing, a.MessageID)
+		}
+	}
+	return undone, missing
+}
+
+// Undo reverts the archives and deletes of a prune run. Purged messages are gone
+// from the server and are skipped. Messages that are no longer where the run left
+// them are reported and their actions stay open, so undo can be run again.
+func Undo(ctx context.Context, connections []MailAccountConnection, runID uint) error {
+	l := logger.GetLoggerFromContext(ctx).With("run", runID)
+	var run Run
+	if err := GormDB.Preload("Actions", "undone_at IS NULL").First(&run, runID).Error; err != nil {
+		return fmt.Errorf("failed to find run %d with error %w", runID, err)
+	}
+	if run.DryRun {
+		return fmt.Errorf("run %d was a dry run, there is nothing to undo", runID)
+	}
+	for i := range connections {
+		conn := &connections[i]
+		actions := lo.Filter(run.Actions, func(a RunAction, _ int) bool { return a.Account == conn.username })
+		skipped := lo.CountBy(actions, func(a RunAction) bool { return a.Action == runActionPurge })
+		if skipped > 0 {
+			l.Warn("purged messages can not be restored", "username", conn.username, "numMessages", skipped)
+		}
+
+		var undone []uint
+		deleted := lo.Filter(actions, func(a RunAction, _ int) bool { return a.Action == pruneActionDelete })
+		if len(deleted) > 0 {
+			restored, err := conn.Restore(ctx, lo.Map(deleted, func(a RunAction, _ int) string { return a.MessageID }))
+			if err != nil {
+				return err
+			}
+			ids, missing := undoneActions(deleted, restored)
+			if len(missing) > 0 {
+				l.Warn("deleted messages were not found in the quarantine",
+					"username", conn.username, "messageIDs", missing)
+			}
+			undone = append(undone, ids...)
+		}
+		archived := lo.GroupBy(
+			lo.Filter(actions, func(a RunAction, _ int) bool { return a.Action == pruneActionArchive }),
+			func(a RunAction) [2]string { return [2]string{a.FromFolder, a.ToFolder} },
+		)
+		for folders, group := range archived {
+			moved, err := conn.undoArchive(folders[0], folders[1], group)
+			if err != nil {
+				return err
+			}
+			ids, missing := undoneActions(group, moved)
+			if len(missing) > 0 {
+				l.Warn("archived messages were not found", "username", conn.username,
+					"folder", folders[1], "messageIDs", missing)
+			}
+			undone = append(undone, ids...)
+			l.Info("moved archived messages back", "folder", folders[0], "numMessages", len(ids))
+		}
+		if len(undone) == 0 {
+			continue
+		}
+		err := GormDB.Model(&RunAction{}).Where("id IN ?", undone).
+			Update("undone_at", sql.NullTime{Time: time.Now(), Valid: true}).Error
+		if err != nil {
+			return fmt.Errorf("failed to record undone actions with error %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestUndoneActions(t *testing.T) {
+	actions := []RunAction{
+		{Model: gorm.Model{ID: 1}, MessageID: "<a>"},
+		{Model: gorm.Model{ID: 2}, MessageID: "<b>"},
+		{Model: gorm.Model{ID: 3}, MessageID: "<c>"},
+	}
+	tests := []struct {
+		name        string
+		moved       []string
+		wantUndone  []uint
+		wantMissing []string
+	}{
+		{name: "all moved", moved: []string{"<c>", "<a>", "<b>"}, wantUndone: []uint{1, 2, 3}},
+		{name: "some moved", moved: []string{"<b>"}, wantUndone: []uint{2}, wantMissing: []string{"<a>", "<c>"}},
+		{name: "none moved", wantMissing: []string{"<a>", "<b>", "<c>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			undone, missing := undoneActions(actions, tt.moved)
+			if !reflect.DeepEqual(undone, tt.wantUndone) {
+				t.Errorf("got undone %v, want %v", undone, tt.wantUndone)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("got missing %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/ozgio/strutil"
+	"github.com/raokrutarth/golang-playspace/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Message threading with the JWZ algorithm.
+// https://www.jwz.org/doc/threading.html
+
+//nolint:gochecknoglobals // compiled once
+var replyPrefixRe = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv)(\[\d+\])?\s*:\s*)+`)
+
+// threadContainer is a node in the thread tree. A container without a message
+// stands in for a message that is referenced but was never ingested.
+type threadContainer struct {
+	id       string
+	message  *Message
+	parent   *threadContainer
+	children []*threadContainer
+}
+
+func (c *threadContainer) addChild(child *threadContainer) {
+	if child.parent != nil {
+		child.parent.removeChild(child)
+	}
+	child.parent = c
+	c.children = append(c.children, child)
+}
+
+func (c *threadContainer) removeChild(child *threadContainer) {
+	c.children = slices.DeleteFunc(c.children, func(o *threadContainer) bool { return o == child })
+	child.parent = nil
+}
+
+// hasDescendant reports if other is c or is reachable from c.
+func (c *threadContainer) hasDescendant(other *threadContainer) bool {
+	if c == other {
+		return true
+	}
+	for _, child := range c.children {
+		if child.hasDescendant(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// messages returns all the messages in the tree under c.
+func (c *threadContainer) messages() []*Message {
+	out := []*Message{}
+	if c.message != nil {
+		out = append(out, c.message)
+	}
+	for _, child := range c.children {
+		out = append(out, child.messages()...)
+	}
+	return out
+}
+
+// subject returns the subject of the container or of its first child with a message.
+func (c *threadContainer) subject() string {
+	if c.message != nil {
+		return c.message.Subject
+	}
+	for _, child := range c.children {
+		if s := child.subject(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// normalizeMessageID strips the angle brackets and whitespace around a message ID.
+func normalizeMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// baseSubject strips the reply and forward prefixes from a subject.
+func baseSubject(subject string) string {
+	return strings.ToLower(strings.TrimSpace(replyPrefixRe.ReplaceAllString(subject, "")))
+}
+
+func isReplySubject(subject string) bool {
+	return replyPrefixRe.MatchString(subject)
+}
+
+// messageReferences returns the IDs of the ancestors of the message, oldest first.
+func messageReferences(msg *Message) []string {
+	refs := strings.Fields(msg.References)
+	if inReplyTo := normalizeMessageID(msg.InReplyTo); inReplyTo != "" &&
+		(len(refs) == 0 || refs[len(refs)-1] != inReplyTo) {
+		refs = append(refs, inReplyTo)
+	}
+	return refs
+}
+
+// buildThreads groups the messages into threads and returns the root of each thread.
+func buildThreads(messages []Message) []*threadContainer {
+	idTable := map[string]*threadContainer{}
+	// containers in creation order to keep the output stable
+	ordered := []*threadContainer{}
+	getContainer := func(id string) *threadContainer {
+		c, ok := idTable[id]
+		if !ok {
+			c = &threadContainer{id: id}
+			idTable[id] = c
+			ordered = append(ordered, c)
+		}
+		return c
+	}
+
+	// 1. link the messages to their references
+	for i := range messages {
+		msg := &messages[i]
+		id := normalizeMessageID(msg.MessageID)
+		if id == "" {
+			id = fmt.Sprintf("no-message-id-%d", msg.ID)
+		}
+		if c, ok := idTable[id]; ok && c.message != nil {
+			// same message ID on two messages, keep both
+			id = fmt.Sprintf("%s-duplicate-%d", id, msg.ID)
+		}
+		c := getContainer(id)
+		c.message = msg
+
+		var prev *threadContainer
+		for _, ref := range messageReferences(msg) {
+			rc := getContainer(ref)
+			if prev != nil && rc.parent == nil && !rc.hasDescendant(prev) {
+				prev.addChild(rc)
+			}
+			prev = rc
+		}
+		if c.parent != nil {
+			c.parent.removeChild(c)
+		}
+		if prev != nil && !c.hasDescendant(prev) {
+			prev.addChild(c)
+		}
+	}
+
+	// 2. find the root set
+	roots := []*threadContainer{}
+	for _, c := range ordered {
+		if c.parent == nil {
+			roots = append(roots, c)
+		}
+	}
+
+	// 4. prune containers without messages
+	roots = pruneEmptyContainers(nil, roots)
+
+	// 5. group the roots by subject
+	roots = groupBySubject(roots)
+
+	// 7. sort the children by date
+	for _, root := range roots {
+		sortThreadChildren(root)
+	}
+	return roots
+}
+
+// pruneEmptyContainers drops empty leaves and promotes the children of empty containers.
+// An empty root is kept if it holds more than one child since it ties the children together.
+func pruneEmptyContainers(parent *threadContainer, containers []*threadContainer) []*threadContainer {
+	out := []*threadContainer{}
+	for _, c := range containers {
+		c.children = pruneEmptyContainers(c, c.children)
+		switch {
+		case c.message == nil && len(c.children) == 0:
+			continue
+		case c.message == nil && (parent != nil || len(c.children) == 1):
+			for _, child := range c.children {
+				child.parent = parent
+			}
+			out = append(out, c.children...)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// subjectMergeWindow is how far apart in time two roots with the same subject can be
+// and still be merged, so years of mails like "Your order" stay separate threads.
+const subjectMergeWindow = 14 * 24 * time.Hour
+
+// participants returns the lower cased addresses that sent or received the messages under c.
+func (c *threadContainer) participants() map[string]bool {
+	out := map[string]bool{}
+	for _, m := range c.messages() {
+		for _, address := range []string{m.From, m.To} {
+			if address = strings.ToLower(strings.TrimSpace(address)); address != "" {
+				out[address] = true
+			}
+		}
+	}
+	return out
+}
+
+// sharesParticipant reports if the participants overlap. Roots without addresses
+// are only matched by time.
+func sharesParticipant(a, b map[string]bool) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for address := range a {
+		if b[address] {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectKeys returns the key each root is grouped by: its base subject and the
+// conversation it belongs to. Roots with the same subject are one conversation when
+// each starts within subjectMergeWindow of the previous one ending and they share
+// a sender or recipient.
+func subjectKeys(roots []*threadContainer) map[*threadContainer]string {
+	bySubject := map[string][]*threadContainer{}
+	for _, root := range roots {
+		if base := baseSubject(root.subject()); base != "" {
+			bySubject[base] = append(bySubject[base], root)
+		}
+	}
+	keys := map[*threadContainer]string{}
+	for base, group := range bySubject {
+		slices.SortStableFunc(group, func(a, b *threadContainer) int {
+			return threadStart(a).Compare(threadStart(b))
+		})
+		conversation := 0
+		var end time.Time
+		var participants map[string]bool
+		for i, root := range group {
+			rootParticipants := root.participants()
+			if i > 0 && (threadStart(root).Sub(end) > subjectMergeWindow ||
+				!sharesParticipant(participants, rootParticipants)) {
+				conversation++
+				end, participants = time.Time{}, nil
+			}
+			if participants == nil {
+				participants = map[string]bool{}
+			}
+			for address := range rootParticipants {
+				participants[address] = true
+			}
+			if e := threadEnd(root); e.After(end) {
+				end = e
+			}
+			keys[root] = fmt.Sprintf("%s#%d", base, conversation)
+		}
+	}
+	return keys
+}
+
+// groupBySubject merges the roots that share a subject, e.g. replies from clients
+// that drop the References header.
+func groupBySubject(roots []*threadContainer) []*threadContainer {
+	keys := subjectKeys(roots)
+	subjectTable := map[string]*threadContainer{}
+	for _, root := range roots {
+		key, ok := keys[root]
+		if !ok {
+			continue
+		}
+		old, ok := subjectTable[key]
+		if !ok ||
+			(root.message == nil && old.message != nil) ||
+			(old.message != nil && root.message != nil &&
+				isReplySubject(old.message.Subject) && !isReplySubject(root.message.Subject)) {
+			subjectTable[key] = root
+		}
+	}
+
+	// the table prefers empty containers so the root being merged is never
+	// empty while the subject's container has a message
+	dropped := map[*threadContainer]bool{}
+	replacement := map[*threadContainer]*threadContainer{}
+	for _, root := range roots {
+		key := keys[root]
+		other, ok := subjectTable[key]
+		if !ok || other == root || root.parent != nil {
+			continue
+		}
+		switch {
+		case root.message == nil && other.message == nil:
+			for _, child := range slices.Clone(root.children) {
+				other.addChild(child)
+			}
+		case other.message == nil:
+			other.addChild(root)
+		case isReplySubject(root.message.Subject) && !isReplySubject(other.message.Subject):
+			other.addChild(root)
+		default:
+			dummy := &threadContainer{id: "subject-" + other.id}
+			dummy.addChild(other)
+			dummy.addChild(root)
+			subjectTable[key] = dummy
+			replacement[other] = dummy
+		}
+		dropped[root] = true
+	}
+
+	out := []*threadContainer{}
+	for _, root := range roots {
+		if r, ok := replacement[root]; ok {
+			root = r
+		} else if dropped[root] {
+			continue
+		}
+		if !slices.Contains(out, root) {
+			out = append(out, root)
+		}
+	}
+	return out
+}
+
+func sortThreadChildren(c *threadContainer) {
+	slices.SortStableFunc(c.children, func(a, b *threadContainer) int {
+		return threadStart(a).Compare(threadStart(b))
+	})
+	for _, child := range c.children {
+		sortThreadChildren(child)
+	}
+}
+
+func threadStart(c *threadContainer) time.Time {
+	if c.message != nil {
+		return c.message.ReceivedAt
+	}
+	start := time.Time{}
+	for _, child := range c.children {
+		if t := threadStart(child); start.IsZero() || t.Before(start) {
+			start = t
+		}
+	}
+	return start
+}
+
+func threadEnd(c *threadContainer) time.Time {
+	end := time.Time{}
+	if c.message != nil {
+		end = c.message.ReceivedAt
+	}
+	for _, child := range c.children {
+		if t := threadEnd(child); t.After(end) {
+			end = t
+		}
+	}
+	return end
+}
+
+// parseThreadHeaders reads the In-Reply-To and References headers from the fetched RFC822 header.
+func parseThreadHeaders(msg *imap.Message) (inReplyTo string, references []string, err error) {
+	// msg.GetBody does not match RFC822.HEADER since it is parsed as a BODY.PEEK section
+	var r imap.Literal
+	for section, literal := range msg.Body {
+		if len(section.Path) != 0 || literal == nil {
+			continue
+		}
+		if section.Specifier == imap.HeaderSpecifier || (r == nil && section.Specifier == imap.EntireSpecifier) {
+			r = literal
+		}
+	}
+	if r == nil {
+		return "", nil, fmt.Errorf("message has no RFC822 header")
+	}
+	h, err := textproto.ReadHeader(bufio.NewReader(r))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read message header with error %w", err)
+	}
+	header := mail.Header{Header: message.Header{Header: h}}
+	inReplyToIDs, err := header.MsgIDList("In-Reply-To")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse In-Reply-To with error %w", err)
+	}
+	if len(inReplyToIDs) > 0 {
+		inReplyTo = inReplyToIDs[0]
+	}
+	references, err = header.MsgIDList("References")
+	if err != nil {
+		return inReplyTo, nil, fmt.Errorf("failed to parse References with error %w", err)
+	}
+	return inReplyTo, references, nil
+}
+
+// RebuildThreads threads all the ingested messages and saves a thread row per conversation.
+func RebuildThreads(ctx context.Context) error {
+	l := logger.GetLoggerFromContext(ctx)
+	var messages []Message
+	err := GormDB.Select(
+		"id", "message_id", "in_reply_to", "references", "from", "to", "subject", "received_at", "is_flagged",
+	).Find(&messages).Error
+	if err != nil {
+		return fmt.Errorf("failed to read messages with error %w", err)
+	}
+	roots := buildThreads(messages)
+	l.Info("built message threads", "numMessages", len(messages), "numThreads", len(roots))
+
+	return GormDB.Transaction(func(tx *gorm.DB) error {
+		for _, root := range roots {
+			threadMessages := root.messages()
+			thread := Thread{
+				RootMessageID: root.id,
+				Subject:       root.subject(),
+				MessageCount:  len(threadMessages),
+			}
+			ids := []uint{}
+			for _, m := range threadMessages {
+				ids = append(ids, m.ID)
+				if m.IsFlagged {
+					thread.FlaggedCount++
+				}
+				if thread.FirstReceivedAt.IsZero() || m.ReceivedAt.Before(thread.FirstReceivedAt) {
+					thread.FirstReceivedAt = m.ReceivedAt
+				}
+				if m.ReceivedAt.After(thread.LastReceivedAt) {
+					thread.LastReceivedAt = m.ReceivedAt
+				}
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "root_message_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"subject", "message_count", "flagged_count", "first_received_at", "last_received_at", "updated_at"}),
+			}).Create(&thread).Error
+			if err != nil {
+				return fmt.Errorf("failed to save thread %s with error %w", root.id, err)
+			}
+			if thread.ID == 0 {
+				if err = tx.Where("root_message_id = ?", root.id).Select("id").First(&thread).Error; err != nil {
+					return fmt.Errorf("failed to read thread %s with error %w", root.id, err)
+				}
+			}
+			err = tx.Model(&Message{}).Where("id IN ?", ids).Update("thread_id", thread.ID).Error
+			if err != nil {
+				return fmt.Errorf("failed to set thread on messages with error %w", err)
+			}
+		}
+		// threads merged into others by this run have no messages left
+		return tx.Unscoped().
+			Where("id NOT IN (?)", tx.Model(&Message{}).Distinct("thread_id")).
+			Delete(&Thread{}).Error
+	})
+}
+
+// ListThreads returns the threads ordered by the given column, largest first.
+func ListThreads(orderBy string, limit int) ([]Thread, error) {
+	var threads []Thread
+	err := GormDB.Order(clause.OrderByColumn{Column: clause.Column{Name: orderBy}, Desc: true}).
+		Limit(limit).Find(&threads).Error
+	return threads, err
+}
+
+func printThreads(w io.Writer, title string, threads []Thread) {
+	fmt.Fprintf(w, "\n%s\n", title)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MESSAGES\tFLAGGED\tFIRST\tLAST\tSUBJECT")
+	for _, t := range threads {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n",
+			t.MessageCount, t.FlaggedCount,
+			t.FirstReceivedAt.Format(time.DateOnly), t.LastReceivedAt.Format(time.DateOnly),
+			strutil.Summary(t.Subject, 80, "..."),
+		)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"gorm.io/gorm"
+)
+
+func testMessage(id uint, messageID, subject string, day int, references ...string) Message {
+	m := Message{
+		Model:      gorm.Model{ID: id},
+		MessageID:  "<" + messageID + ">",
+		Subject:    subject,
+		ReceivedAt: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC),
+	}
+	for i, ref := range references {
+		if i == len(references)-1 {
+			m.InReplyTo = "<" + ref + ">"
+		} else {
+			m.References += ref + " "
+		}
+	}
+	return m
+}
+
+func withParticipants(m Message, from, to string) Message {
+	m.From, m.To = from, to
+	return m
+}
+
+func TestBuildThreads(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+		// sizes of the threads in the order of the roots
+		want []int
+	}{
+		{
+			name: "reply chain",
+			messages: []Message{
+				testMessage(3, "c", "Re: plans", 3, "a", "b"),
+				testMessage(1, "a", "plans", 1),
+				testMessage(2, "b", "Re: plans", 2, "a"),
+			},
+			want: []int{3},
+		},
+		{
+			name: "siblings of a message that was not ingested",
+			messages: []Message{
+				testMessage(1, "b", "Re: dinner", 2, "missing"),
+				testMessage(2, "c", "Re: dinner", 3, "missing"),
+			},
+			want: []int{2},
+		},
+		{
+			name: "grouped by subject without headers",
+			messages: []Message{
+				testMessage(1, "a", "Lunch", 1),
+				testMessage(2, "b", "RE: lunch", 2),
+				testMessage(3, "c", "Fwd: Re: Lunch", 3),
+				testMessage(4, "d", "Invoice", 4),
+			},
+			want: []int{3, 1},
+		},
+		{
+			name: "same subject months apart",
+			messages: []Message{
+				testMessage(1, "a", "Your order", 1),
+				testMessage(2, "b", "Your order", 60),
+				testMessage(3, "c", "Your order", 120),
+			},
+			want: []int{1, 1, 1},
+		},
+		{
+			name: "same subject chained within the window",
+			messages: []Message{
+				testMessage(1, "a", "Trip", 1),
+				testMessage(2, "b", "Re: trip", 12),
+				testMessage(3, "c", "Re: trip", 24),
+			},
+			want: []int{3},
+		},
+		{
+			name: "same subject between other participants",
+			messages: []Message{
+				withParticipants(testMessage(1, "a", "Meeting", 1), "me@example.com", "alice@example.com"),
+				withParticipants(testMessage(2, "b", "Re: meeting", 2), "carol@example.com", "dave@example.com"),
+			},
+			want: []int{1, 1},
+		},
+		{
+			name: "reply without headers from a participant",
+			messages: []Message{
+				withParticipants(testMessage(1, "a", "Meeting", 1), "me@example.com", "alice@example.com"),
+				withParticipants(testMessage(2, "b", "Re: meeting", 10), "Alice@example.com", "me@example.com"),
+			},
+			want: []int{2},
+		},
+		{
+			name: "reference loop",
+			messages: []Message{
+				testMessage(1, "a", "x", 1, "b"),
+				testMessage(2, "b", "y", 2, "a"),
+			},
+			want: []int{2},
+		},
+		{
+			name: "duplicate message IDs",
+			messages: []Message{
+				testMessage(1, "a", "report", 1),
+				testMessage(2, "a", "report", 1),
+			},
+			want: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := buildThreads(tt.messages)
+			if len(roots) != len(tt.want) {
+				t.Fatalf("got %d threads, want %d", len(roots), len(tt.want))
+			}
+			for i, root := range roots {
+				if got := len(root.messages()); got != tt.want[i] {
+					t.Errorf("thread %d has %d messages, want %d", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildThreadsOrdersReplies(t *testing.T) {
+	roots := buildThreads([]Message{
+		testMessage(1, "a", "plans", 1),
+		testMessage(3, "c", "Re: plans", 5, "a"),
+		testMessage(2, "b", "Re: plans", 2, "a"),
+	})
+	if len(roots) != 1 || roots[0].message.ID != 1 {
+		t.Fatalf("expected message 1 to be the only root")
+	}
+	children := roots[0].children
+	if len(children) != 2 || children[0].message.ID != 2 || children[1].message.ID != 3 {
+		t.Errorf("expected replies ordered by date")
+	}
+}
+
+func TestParseThreadHeaders(t *testing.T) {
+	section, err := imap.ParseBodySectionName(imap.FetchRFC822Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := "Message-Id: <c@example.com>\r\n" +
+		"In-Reply-To: <b@example.com>\r\n" +
+		"References: <a@example.com>\r\n <b@example.com>\r\n" +
+		"Subject: Re: plans\r\n\r\n"
+	msg := &imap.Message{Body: map[*imap.BodySectionName]imap.Literal{
+		section: bytes.NewBufferString(header),
+	}}
+
+	inReplyTo, references, err := parseThreadHeaders(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inReplyTo != "b@example.com" {
+		t.Errorf("got In-Reply-To %q", inReplyTo)
+	}
+	if len(references) != 2 || references[0] != "a@example.com" || references[1] != "b@example.com" {
+		t.Errorf("got References %v", references)
+	}
+}